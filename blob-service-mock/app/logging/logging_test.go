@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONWriter_Write(t *testing.T) {
+	var tbl = []struct {
+		line string
+		json string
+	}{
+		{"[INFO] job is dispatched upstream=worker latency=12ms job_id=5 tenant_id=1 request_id=abc\n",
+			`{"level":"INFO","msg":"job is dispatched","upstream":"worker","latency":"12ms","job_id":"5","tenant_id":"1","request_id":"abc"}`},
+		{"[WARN] can't remove job 5, key=value <nil>\n", `{"level":"WARN","msg":"can't remove job 5, key=value <nil>"}`},
+		{"[DEBUG] job_id=5\n", `{"level":"DEBUG","msg":"job_id=5"}`},
+		{"line without level\n", `{"level":"INFO","msg":"line without level"}`},
+		{"[INFO] \"quoted\" <b> job_id=1 job_id=2\n", `{"level":"INFO","msg":"\"quoted\" <b>","job_id":"2"}`},
+	}
+	for i, tt := range tbl {
+		t.Run(fmt.Sprintf("test case #%d", i), func(t *testing.T) {
+			buf := bytes.Buffer{}
+			w := JSONWriter{Out: &buf, now: func() time.Time { return time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC) }}
+			n, err := w.Write([]byte(tt.line))
+			if err != nil || n != len(tt.line) {
+				t.Fatalf("write returned %d, %v", n, err)
+			}
+			var got, exp map[string]string
+			if err = json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("record %q is not json, %v", buf.String(), err)
+			}
+			if err = json.Unmarshal([]byte(strings.TrimSuffix(tt.json, "}")+`,"ts":"2021-03-01T10:00:00Z"}`), &exp); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(exp, got) {
+				t.Errorf("expected record %v, got %v", exp, got)
+			}
+			if strings.Count(buf.String(), "\n") != 1 {
+				t.Errorf("record %q is not a single line", buf.String())
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := bytes.Buffer{}
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	var got string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v1/job", nil)
+	req.Header.Set(HeaderRequestID, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if got != "req-1" || rec.Header().Get(HeaderRequestID) != "req-1" {
+		t.Errorf("request id of caller is not kept, context %q, header %q", got, rec.Header().Get(HeaderRequestID))
+	}
+	if !strings.Contains(buf.String(), "method=POST path=/api/v1/job status=201 latency=") ||
+		!strings.Contains(buf.String(), " request_id=req-1\n") {
+		t.Errorf("unexpected log %q", buf.String())
+	}
+
+	for i, id := range []string{"", "bad id", strings.Repeat("a", 129)} {
+		req = httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set(HeaderRequestID, id)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if len(got) != 32 || rec.Header().Get(HeaderRequestID) != got {
+			t.Errorf("test case #%d, new request id is not made, context %q, header %q", i, got, rec.Header().Get(HeaderRequestID))
+		}
+	}
+}
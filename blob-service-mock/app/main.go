@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"github.com/theshamuel/jobs-dispatcher/blob-service-mock/app/logging"
 	"github.com/theshamuel/jobs-dispatcher/blob-service-mock/app/rest"
+	"github.com/theshamuel/jobs-dispatcher/blob-service-mock/app/tracing"
 	"log"
 	"os"
 	"os/signal"
@@ -13,6 +15,7 @@ var version = "unknown"
 
 type application struct {
 	rest        *rest.Rest
+	tracer      *tracing.Tracer
 	terminated  chan struct{}
 }
 
@@ -28,10 +31,20 @@ func (app *application) run(ctx context.Context) {
 }
 
 func main()  {
-	rest := &rest.Rest{}
+	if os.Getenv("LOG_FORMAT") == "json" {
+		log.SetFlags(0)
+		log.SetOutput(&logging.JSONWriter{Out: os.Stderr})
+	}
+	tracer, err := tracing.FromEnv("blob-service-mock")
+	if err != nil {
+		log.Printf("[ERROR] can not set up tracing, %v", err)
+		os.Exit(1)
+	}
+	rest := &rest.Rest{SignSecret: os.Getenv("BLOB_SIGN_SECRET"), Tracer: tracer}
 
 	app := &application{
 		rest:          rest,
+		tracer:        tracer,
 		terminated:    make(chan struct{}),
 	}
 	log.Printf("[INFO] starting Blob Service API mock server version %s", version)
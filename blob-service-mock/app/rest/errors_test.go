@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/theshamuel/jobs-dispatcher/blob-service-mock/app/logging"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSendErrorJSON(t *testing.T) {
+	tbl := []struct {
+		send func(w http.ResponseWriter, r *http.Request)
+		exp  Problem
+	}{
+		{func(w http.ResponseWriter, r *http.Request) {
+			SendErrorJSON(w, r, http.StatusNotFound, errors.New("no blob 1"), ErrorBlobNotFound, "blob is not found")
+		}, Problem{Type: "urn:problem:image-jobs-dispatcher:blob-not-found", Title: "Blob is not found", Status: http.StatusNotFound,
+			Detail: "blob is not found", Instance: "/blob/1", Code: ErrorBlobNotFound, Error: "no blob 1", RequestID: "req-1"}},
+		{func(w http.ResponseWriter, r *http.Request) {
+			SendErrorJSON(w, r, http.StatusInternalServerError, nil, ErrorCode("UNKNOWN"), "")
+		}, Problem{Type: "urn:problem:image-jobs-dispatcher:unknown", Title: "Internal server error",
+			Status: http.StatusInternalServerError, Instance: "/blob/1", Code: "UNKNOWN", RequestID: "req-1"}},
+		{notFound, Problem{Type: "urn:problem:image-jobs-dispatcher:not-found", Title: "Resource is not found",
+			Status: http.StatusNotFound, Detail: "route is not found", Instance: "/blob/1", Code: ErrorNotFound,
+			Error: "no route /blob/1", RequestID: "req-1"}},
+		{methodNotAllowed, Problem{Type: "urn:problem:image-jobs-dispatcher:method-not-allowed",
+			Title: "Method is not allowed", Status: http.StatusMethodNotAllowed, Detail: "route doesn't support method",
+			Instance: "/blob/1", Code: ErrorMethodNotAllowed, Error: "method GET is not allowed", RequestID: "req-1"}},
+	}
+	for i, tt := range tbl {
+		t.Run(fmt.Sprintf("test case #%d", i), func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/blob/1", nil)
+			req.Header.Set(logging.HeaderRequestID, "req-1")
+			rec := httptest.NewRecorder()
+			logging.Middleware(http.HandlerFunc(tt.send)).ServeHTTP(rec, req)
+			if rec.Code != tt.exp.Status {
+				t.Errorf("expected status %d, got %d", tt.exp.Status, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("unexpected content type %s", ct)
+			}
+			var got Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("body %q is not json, %v", rec.Body.String(), err)
+			}
+			if !reflect.DeepEqual(tt.exp, got) {
+				t.Errorf("expected %+v, got %+v", tt.exp, got)
+			}
+		})
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	lmt := newLimiter(1)
+	if lmt.GetMessageContentType() != "application/problem+json" {
+		t.Errorf("unexpected content type %s", lmt.GetMessageContentType())
+	}
+	var got Problem
+	if err := json.Unmarshal([]byte(lmt.GetMessage()), &got); err != nil {
+		t.Fatal(err)
+	}
+	exp := Problem{Type: "urn:problem:image-jobs-dispatcher:rate-limited", Title: "Rate limit is exceeded",
+		Status: http.StatusTooManyRequests, Detail: "too many requests, retry later", Code: ErrorRateLimited}
+	if !reflect.DeepEqual(exp, got) {
+		t.Errorf("expected %+v, got %+v", exp, got)
+	}
+}
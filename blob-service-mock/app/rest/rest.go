@@ -1,19 +1,26 @@
 package rest
 
 import (
-	"bufio"
 	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/didip/tollbooth/v6"
 	"github.com/didip/tollbooth_chi"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
+	"github.com/go-chi/render"
+	"github.com/theshamuel/jobs-dispatcher/blob-service-mock/app/logging"
+	"github.com/theshamuel/jobs-dispatcher/blob-service-mock/app/tracing"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"sync"
@@ -21,8 +28,17 @@ import (
 )
 
 type Rest struct {
-	httpServer *http.Server
-	lock       sync.Mutex
+	SignSecret   string
+	Tracer       *tracing.Tracer
+	httpServer   *http.Server
+	lock         sync.Mutex
+	payloadsLock sync.Mutex
+	payloads     map[int]int64 //sizes of submitted images by id, content of images is not kept by mock
+	lastPayload  int
+}
+
+type DeletedBlob struct {
+	Size int64 `json:"size"`
 }
 
 type PayloadLocation struct {
@@ -66,13 +82,18 @@ func (r *Rest) buildHTTPServer(port int, router http.Handler) *http.Server {
 
 func (r *Rest) routes() chi.Router {
 	router := chi.NewRouter()
-	router.Use(middleware.Throttle(1000), middleware.RealIP, middleware.Recoverer, middleware.Logger)
+	router.NotFound(notFound)
+	router.MethodNotAllowed(methodNotAllowed)
+	router.Use(middleware.Throttle(1000), middleware.RealIP, middleware.Recoverer, logging.Middleware)
+	if r.Tracer != nil {
+		router.Use(r.Tracer.Middleware)
+	}
 
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Content-Length", "X-XSRF-Token"},
-		ExposedHeaders:   []string{"Authorization"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Content-Length", "X-XSRF-Token", "Range", "If-None-Match", logging.HeaderRequestID, tracing.HeaderTraceParent},
+		ExposedHeaders:   []string{"Authorization", "Content-Range", "ETag", logging.HeaderRequestID},
 		AllowCredentials: true,
 		MaxAge:           300,
 	})
@@ -80,7 +101,7 @@ func (r *Rest) routes() chi.Router {
 	//health check api
 	router.Use(corsMiddleware.Handler)
 	router.Route("/", func(api chi.Router) {
-		api.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(5, nil)))
+		api.Use(tollbooth_chi.LimitHandler(newLimiter(5)))
 		api.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 			_, err := w.Write([]byte(fmt.Sprintln("pong")))
 			if err != nil {
@@ -92,10 +113,21 @@ func (r *Rest) routes() chi.Router {
 	router.Route("/api/v1/", func(endpoints chi.Router) {
 		endpoints.Group(func(api chi.Router) {
 			api.Use(middleware.Timeout(30 * time.Second))
-			api.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(50, nil)))
+			api.Use(tollbooth_chi.LimitHandler(newLimiter(50)))
 			api.Use(middleware.NoCache)
 			api.Post("/blob", r.submitBlob)
+			api.Delete("/blob/{id}", r.deleteBlob)
+			api.Head("/images/blob/{id}", r.getPayload)
+			api.Delete("/images/blob/{id}", r.deletePayload)
+		})
+
+		//NoCache middleware drops conditional headers, so blobs are served from separate group
+		endpoints.Group(func(api chi.Router) {
+			api.Use(middleware.Timeout(120 * time.Second))
+			api.Use(tollbooth_chi.LimitHandler(newLimiter(50)))
 			api.Get("/blob/{id}", r.getBlob)
+			api.Head("/blob/{id}", r.getBlob)
+			api.Get("/signed/blob/{id}", r.getSignedBlob)
 		})
 	})
 
@@ -103,46 +135,169 @@ func (r *Rest) routes() chi.Router {
 }
 
 func (r *Rest) submitBlob(w http.ResponseWriter, req *http.Request) {
-	jsr := PayloadLocation{PayloadLocation: "/images/blob/1"}
-	w.Header().Set("Content-Type", "application/json")
+	size, err := io.Copy(ioutil.Discard, req.Body)
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "error reading image")
+		return
+	}
+	r.payloadsLock.Lock()
+	if r.payloads == nil {
+		r.payloads = map[int]int64{}
+	}
+	r.lastPayload++
+	id := r.lastPayload
+	r.payloads[id] = size
+	r.payloadsLock.Unlock()
+	log.Printf("[INFO] image %d is stored, %d bytes request_id=%s", id, size, logging.RequestID(req.Context()))
+
+	jsr := PayloadLocation{PayloadLocation: fmt.Sprintf("/images/blob/%d", id)}
 	data, err := json.Marshal(jsr)
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during marshal response")
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "error during marshal response")
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 	if _, err := w.Write(data); err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error saving image to blob store")
-		return
+		log.Printf("[ERROR] can not write response, %v request_id=%s", err, logging.RequestID(req.Context()))
 	}
 }
 
 func (r *Rest) getBlob(w http.ResponseWriter, req *http.Request) {
 	blobID, err := strconv.Atoi(chi.URLParam(req, "id"))
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, errors.New("store is overloaded"), ErrorServerInternal, "error getting image to id is not int32")
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "error getting image to id is not int32")
 		return
 	}
-	if blobID == 1 || blobID == 2 || blobID == 3 {
-		w.Header().Set("Content-Type", "image/png")
-		f, err := os.Open(fmt.Sprintf("/data/%d", blobID))
-		if err != nil {
-			SendErrorJSON(w, req, http.StatusBadRequest, errors.New("store is overloaded"), ErrorServerInternal, "error open image from file system")
-			return
-		}
-		reader := bufio.NewReader(f)
-		content, err := ioutil.ReadAll(reader)
-		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
-		if err != nil {
-			SendErrorJSON(w, req, http.StatusBadRequest, errors.New("store is overloaded"), ErrorServerInternal, "error read image from file system")
-			return
-		}
-		if _, err := w.Write(content); err != nil {
-			SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error saving image to blob store")
-			return
-		}
-	}else {
-		SendErrorJSON(w, req, http.StatusBadRequest, errors.New("store is overloaded"), ErrorServerInternal, "error getting image to blob store")
+	r.serveBlob(w, req, blobID)
+}
+
+//deleteBlob acknowledges removing of result blob. Results of mock are shared by all jobs, so file is kept
+func (r *Rest) deleteBlob(w http.ResponseWriter, req *http.Request) {
+	blobID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "error getting image to id is not int32")
+		return
+	}
+	if blobID != 1 && blobID != 2 && blobID != 3 {
+		SendErrorJSON(w, req, http.StatusNotFound, fmt.Errorf("there is no blob with id %d", blobID), ErrorBlobNotFound, "error removing image from blob store")
+		return
+	}
+	info, err := os.Stat(fmt.Sprintf("/data/%d", blobID))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusInternalServerError, errors.New("store is overloaded"), ErrorStorage, "error read image from file system")
+		return
+	}
+	log.Printf("[INFO] blob %d is removed, %d bytes request_id=%s", blobID, info.Size(), logging.RequestID(req.Context()))
+	render.JSON(w, req, DeletedBlob{Size: info.Size()})
+}
+
+//getPayload returns size of submitted image in Content-Length header
+func (r *Rest) getPayload(w http.ResponseWriter, req *http.Request) {
+	size, ok := r.payloadSize(w, req)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+//deletePayload removes submitted image
+func (r *Rest) deletePayload(w http.ResponseWriter, req *http.Request) {
+	size, ok := r.payloadSize(w, req)
+	if !ok {
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(req, "id"))
+	r.payloadsLock.Lock()
+	delete(r.payloads, id)
+	r.payloadsLock.Unlock()
+	log.Printf("[INFO] image %d is removed, %d bytes request_id=%s", id, size, logging.RequestID(req.Context()))
+	render.JSON(w, req, DeletedBlob{Size: size})
+}
+
+func (r *Rest) payloadSize(w http.ResponseWriter, req *http.Request) (int64, bool) {
+	id, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "error getting image to id is not int32")
+		return 0, false
+	}
+	r.payloadsLock.Lock()
+	size, ok := r.payloads[id]
+	r.payloadsLock.Unlock()
+	if !ok {
+		SendErrorJSON(w, req, http.StatusNotFound, fmt.Errorf("there is no image with id %d", id), ErrorBlobNotFound, "error getting image from blob store")
+		return 0, false
+	}
+	return size, true
+}
+
+//getSignedBlob serves blob without JWT by url signed in dispatcher with shared secret
+func (r *Rest) getSignedBlob(w http.ResponseWriter, req *http.Request) {
+	blobID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "error getting image to id is not int32")
+		return
+	}
+	if r.SignSecret == "" {
+		SendErrorJSON(w, req, http.StatusForbidden, errors.New("signed urls are disabled"), ErrorFeatureDisabled, "secret for signed urls is not set up")
+		return
+	}
+	if err = verifySignature(r.SignSecret, fmt.Sprintf("/blob/%d", blobID), req.URL.Query(), time.Now()); err != nil {
+		SendErrorJSON(w, req, http.StatusForbidden, err, ErrorSignature, "signed url is invalid")
 		return
 	}
+	r.serveBlob(w, req, blobID)
 }
 
+func (r *Rest) serveBlob(w http.ResponseWriter, req *http.Request, blobID int) {
+	if blobID != 1 && blobID != 2 && blobID != 3 {
+		SendErrorJSON(w, req, http.StatusNotFound, fmt.Errorf("there is no blob with id %d", blobID), ErrorBlobNotFound, "error getting image from blob store")
+		return
+	}
+	f, err := os.Open(fmt.Sprintf("/data/%d", blobID))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusInternalServerError, errors.New("store is overloaded"), ErrorStorage, "error open image from file system")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusInternalServerError, errors.New("store is overloaded"), ErrorStorage, "error read image from file system")
+		return
+	}
+	hash := md5.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		SendErrorJSON(w, req, http.StatusInternalServerError, errors.New("store is overloaded"), ErrorStorage, "error read image from file system")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("ETag", fmt.Sprintf("\"%x\"", hash.Sum(nil)))
+	//ServeContent handles Range, If-Range and If-None-Match requests
+	http.ServeContent(w, req, "", info.ModTime(), f)
+}
+
+//verifySignature checks tid, exp and sig query params issued by URLSigner of dispatcher, sig is hex HMAC-SHA256 of
+//"<location>\n<tid>\n<exp>"
+func verifySignature(secret, location string, q url.Values, now time.Time) error {
+	tenantID, err := strconv.Atoi(q.Get("tid"))
+	if err != nil {
+		return fmt.Errorf("invalid tid: %w", err)
+	}
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid exp: %w", err)
+	}
+	sig, err := hex.DecodeString(q.Get("sig"))
+	if err != nil {
+		return fmt.Errorf("invalid sig: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s\n%d\n%d", location, tenantID, exp)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("signature mismatch")
+	}
+	if now.Unix() > exp {
+		return errors.New("signature expired")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/theshamuel/jobs-dispatcher/blob-service-mock/app/logging"
+	"net/http"
+)
+
+//Middleware starts server span of request, span is child of span from traceparent header of caller.
+//Span is named by route pattern, so ids in urls don't make new span names
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parent, _ := ParseTraceParent(req.Header.Get(HeaderTraceParent))
+		span := t.StartSpan(parent, req.Method, KindServer)
+		if span == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		defer span.End()
+		ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+		next.ServeHTTP(ww, req.WithContext(WithSpanContext(req.Context(), span.Context())))
+		route := "unmatched"
+		if rctx := chi.RouteContext(req.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.data.Name = req.Method + " " + route
+		span.SetAttr("http.method", req.Method)
+		span.SetAttr("http.route", route)
+		span.SetAttr("http.target", req.URL.Path)
+		span.SetAttr("http.status_code", status)
+		if id := logging.RequestID(req.Context()); id != "" {
+			span.SetAttr("request_id", id)
+		}
+		if status >= http.StatusInternalServerError {
+			span.Fail(fmt.Errorf("response status %d", status))
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/theshamuel/jobs-dispatcher/blob-service-mock/app/logging"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+//spanFile makes tracer writing spans to temporary file, returned func reads the written span records
+func spanFile(t *testing.T, service string) (*Tracer, func() []map[string]interface{}) {
+	f, err := ioutil.TempFile("", "spans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+	})
+	return &Tracer{Service: service, file: f}, func() []map[string]interface{} {
+		rf, err := os.Open(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rf.Close()
+		res := []map[string]interface{}{}
+		scanner := bufio.NewScanner(rf)
+		for scanner.Scan() {
+			rec := map[string]interface{}{}
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				t.Fatalf("span record %q is not json, %v", scanner.Text(), err)
+			}
+			res = append(res, rec)
+		}
+		return res
+	}
+}
+
+func TestTracer_Middleware(t *testing.T) {
+	tracer, spans := spanFile(t, "blob")
+	var handlerSpan SpanContext
+	router := chi.NewRouter()
+	router.Use(logging.Middleware, tracer.Middleware)
+	router.Get("/job/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = FromContext(r.Context())
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest("GET", "/job/1", nil)
+	req.Header.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(logging.HeaderRequestID, "req-1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+	recs := spans()
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 spans, got %v", recs)
+	}
+	for k, v := range map[string]interface{}{"name": "GET /job/{id}", "kind": "server", "service": "blob",
+		"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736", "parent_span_id": "00f067aa0ba902b7",
+		"span_id": handlerSpan.SpanID, "error": "response status 502"} {
+		if recs[0][k] != v {
+			t.Errorf("expected %s %v, got %v", k, v, recs[0][k])
+		}
+	}
+	attrs := map[string]interface{}{"http.method": "GET", "http.route": "/job/{id}", "http.target": "/job/1",
+		"http.status_code": float64(http.StatusBadGateway), "request_id": "req-1"}
+	if !reflect.DeepEqual(attrs, recs[0]["attributes"]) {
+		t.Errorf("expected attributes %v, got %v", attrs, recs[0]["attributes"])
+	}
+	if _, ok := recs[0]["start"]; !ok {
+		t.Error("span has no start time")
+	}
+
+	if recs[1]["name"] != "GET unmatched" || recs[1]["parent_span_id"] != nil || recs[1]["trace_id"] == recs[0]["trace_id"] {
+		t.Errorf("request without traceparent doesn't start new trace, %v", recs[1])
+	}
+}
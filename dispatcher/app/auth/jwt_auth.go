@@ -3,14 +3,21 @@ package auth
 import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
+	"sync"
+	"time"
 )
 
 type Service struct {
 	Opts
+	lock          sync.RWMutex
+	previous      string    //key replaced by RotateSecret, tokens signed by it are accepted till previousUntil
+	previousUntil time.Time
+	now           func() time.Time
 }
 
 type Opts struct {
 	JWTHeader   string
+	Secret      string //HMAC key of tokens
 }
 
 type Claims struct {
@@ -24,6 +31,7 @@ type Claims struct {
 
 const (
 	defaultJWTHeader   = "Authorization"
+	defaultSecret      = "your-256-bit-secret"
 )
 
 func NewService(opts Opts) *Service {
@@ -36,15 +44,18 @@ func NewService(opts Opts) *Service {
 	}
 
 	setDefault(&res.JWTHeader, defaultJWTHeader)
+	setDefault(&res.Secret, defaultSecret)
 
 	return &res
 }
 
 func (s *Service) Parse(tokenStr string) (*Claims, error) {
-	parser := jwt.Parser{SkipClaimsValidation: true}
-	token, err := parser.ParseWithClaims(tokenStr, &Claims{} , func(token *jwt.Token) (interface{}, error) {
-		return []byte("your-256-bit-secret"), nil
-	})
+	token, err := parse(tokenStr, s.key())
+	if prev := s.previousKey(); err != nil && prev != nil {
+		if prevToken, prevErr := parse(tokenStr, prev); prevErr == nil {
+			token, err = prevToken, nil
+		}
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "can't parse token")
 	}
@@ -57,6 +68,58 @@ func (s *Service) Parse(tokenStr string) (*Claims, error) {
 	return claims, s.validate(claims)
 }
 
+//SetSecret replaces HMAC key of tokens, empty secret sets default one
+func (s *Service) SetSecret(secret string) {
+	if secret == "" {
+		secret = defaultSecret
+	}
+	s.lock.Lock()
+	s.Secret = secret
+	s.lock.Unlock()
+}
+
+//RotateSecret replaces HMAC key of tokens, tokens signed by replaced key are accepted for grace period,
+//so clients have time to get tokens signed by new key. Zero grace revokes replaced keys at once
+func (s *Service) RotateSecret(secret string, grace time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.previous, s.previousUntil = "", time.Time{}
+	if grace > 0 && secret != s.Secret {
+		s.previous, s.previousUntil = s.Secret, s.time().Add(grace)
+	}
+	s.Secret = secret
+}
+
+func (s *Service) key() []byte {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return []byte(s.Secret)
+}
+
+//previousKey returns key replaced by RotateSecret or nil if its grace period is passed
+func (s *Service) previousKey() []byte {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.previous == "" || !s.time().Before(s.previousUntil) {
+		return nil
+	}
+	return []byte(s.previous)
+}
+
+func (s *Service) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func parse(tokenStr string, key []byte) (*jwt.Token, error) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	return parser.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+}
+
 func (s *Service) validate(claims *Claims) error {
 	err := claims.Valid()
 
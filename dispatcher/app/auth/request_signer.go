@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderWorkerTimestamp     = "X-Worker-Timestamp"
+	HeaderWorkerSignature     = "X-Worker-Signature"
+	HeaderDispatcherSignature = "X-Dispatcher-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+//RequestSigner authenticates internal calls between worker service and dispatcher by HMAC-SHA256 with shared secret.
+//Worker signs request timestamp, method, path and body. Dispatcher signs response with the same timestamp,
+//so worker can check that status was accepted by dispatcher and not by somebody in the middle. Each request
+//signature is accepted once, so captured request can't be replayed while its timestamp is in allowed window
+type RequestSigner struct {
+	Secret  []byte
+	MaxSkew time.Duration
+	now     func() time.Time
+	lock    sync.RWMutex
+
+	seenLock sync.Mutex
+	seen     map[string]time.Time //expiration of accepted signatures by signature
+	pruneAt  time.Time            //expired signatures are dropped not more often than once per second
+}
+
+const defaultMaxSkew = 5 * time.Minute
+
+//NewRequestSigner makes signer with secret and max allowed difference between request timestamp and current time
+func NewRequestSigner(secret string, maxSkew time.Duration) *RequestSigner {
+	if maxSkew <= 0 {
+		maxSkew = defaultMaxSkew
+	}
+	return &RequestSigner{Secret: []byte(secret), MaxSkew: maxSkew, now: time.Now, seen: map[string]time.Time{}}
+}
+
+//SetSecret replaces shared secret, requests signed by old secret are rejected since then
+func (s *RequestSigner) SetSecret(secret string) {
+	s.lock.Lock()
+	s.Secret = []byte(secret)
+	s.lock.Unlock()
+}
+
+//SignRequest returns value of signature header for request
+func (s *RequestSigner) SignRequest(timestamp, method, path string, body []byte) string {
+	return signaturePrefix + s.signature(timestamp, method, path, body)
+}
+
+//VerifyRequest checks signature and timestamp of request and rejects signature which was already accepted
+func (s *RequestSigner) VerifyRequest(timestamp, signature, method, path string, body []byte) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid timestamp")
+	}
+	if err = s.verify(signature, s.signature(timestamp, method, path, body)); err != nil {
+		return err
+	}
+	if skew := s.now().Sub(time.Unix(ts, 0)); skew > s.MaxSkew || skew < -s.MaxSkew {
+		return errors.Errorf("timestamp is out of allowed %s window", s.MaxSkew)
+	}
+	return s.remember(signature, time.Unix(ts, 0).Add(s.MaxSkew))
+}
+
+//remember keeps accepted signature till its timestamp leaves allowed window, error is returned for seen one
+func (s *RequestSigner) remember(signature string, expires time.Time) error {
+	s.seenLock.Lock()
+	defer s.seenLock.Unlock()
+	if s.seen == nil {
+		s.seen = map[string]time.Time{}
+	}
+	now := s.now()
+	if now.After(s.pruneAt) {
+		for sig, exp := range s.seen {
+			if now.After(exp) {
+				delete(s.seen, sig)
+			}
+		}
+		s.pruneAt = now.Add(time.Second)
+	}
+	if _, ok := s.seen[signature]; ok {
+		return errors.New("signature is already used")
+	}
+	s.seen[signature] = expires
+	return nil
+}
+
+//SignResponse returns value of signature header for response on request with timestamp
+func (s *RequestSigner) SignResponse(timestamp string, statusCode int, path string, body []byte) string {
+	return signaturePrefix + s.signature(timestamp, strconv.Itoa(statusCode), path, body)
+}
+
+//VerifyResponse checks signature of response on request with timestamp
+func (s *RequestSigner) VerifyResponse(timestamp, signature string, statusCode int, path string, body []byte) error {
+	return s.verify(signature, s.signature(timestamp, strconv.Itoa(statusCode), path, body))
+}
+
+func (s *RequestSigner) verify(signature, expected string) error {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return errors.New("invalid signature format")
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return errors.Wrap(err, "invalid signature")
+	}
+	exp, _ := hex.DecodeString(expected)
+	if !hmac.Equal(sig, exp) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func (s *RequestSigner) signature(timestamp, method, path string, body []byte) string {
+	s.lock.RLock()
+	mac := hmac.New(sha256.New, s.Secret)
+	s.lock.RUnlock()
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%s\n", timestamp, method, path)
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRequestSigner_Request(t *testing.T) {
+	s := NewRequestSigner("secret", time.Minute)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	ts := "1614592800"
+	body := []byte(`{"status":"SUCCESS"}`)
+	path := "/internal/v1/job/1/status"
+
+	sig := s.SignRequest(ts, "POST", path, body)
+	assert.Equal(t, 71, len(sig))
+	require.NoError(t, s.VerifyRequest(ts, sig, "POST", path, body))
+	assert.EqualError(t, s.VerifyRequest(ts, sig, "POST", path, body), "signature is already used", "request is not replayed")
+
+	tbl := []struct {
+		ts, sig, method, path, body, err string
+	}{
+		{ts, sig, "POST", "/internal/v1/job/2/status", string(body), "signature mismatch"},
+		{ts, sig, "PUT", path, string(body), "signature mismatch"},
+		{ts, sig, "POST", path, `{"status":"FAILED"}`, "signature mismatch"},
+		{"1614592801", sig, "POST", path, string(body), "signature mismatch"},
+		{"abc", sig, "POST", path, string(body), `invalid timestamp: strconv.ParseInt: parsing "abc": invalid syntax`},
+		{ts, sig[7:], "POST", path, string(body), "invalid signature format"},
+		{ts, "sha256=xyz", "POST", path, string(body), "invalid signature: encoding/hex: invalid byte: U+0078 'x'"},
+	}
+	for i, tt := range tbl {
+		assert.EqualError(t, s.VerifyRequest(tt.ts, tt.sig, tt.method, tt.path, []byte(tt.body)), tt.err, "test case #%d", i)
+	}
+
+	assert.EqualError(t, NewRequestSigner("other", time.Minute).VerifyRequest(ts, sig, "POST", path, body), "signature mismatch")
+
+	now = now.Add(2 * time.Minute)
+	assert.EqualError(t, s.VerifyRequest(ts, sig, "POST", path, body), "timestamp is out of allowed 1m0s window")
+	now = now.Add(-4 * time.Minute)
+	assert.EqualError(t, s.VerifyRequest(ts, sig, "POST", path, body), "timestamp is out of allowed 1m0s window")
+}
+
+func TestRequestSigner_SeenSignatures(t *testing.T) {
+	s := NewRequestSigner("secret", time.Minute)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	path := "/internal/v1/job/1/status"
+
+	for i := 0; i < 3; i++ {
+		ts := strconv.FormatInt(now.Unix()+int64(i), 10)
+		require.NoError(t, s.VerifyRequest(ts, s.SignRequest(ts, "POST", path, nil), "POST", path, nil), "request #%d", i)
+	}
+	assert.Len(t, s.seen, 3)
+
+	now = now.Add(62 * time.Second)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	require.NoError(t, s.VerifyRequest(ts, s.SignRequest(ts, "POST", path, nil), "POST", path, nil))
+	assert.Len(t, s.seen, 2, "signatures out of window are dropped")
+}
+
+func TestRequestSigner_Response(t *testing.T) {
+	s := NewRequestSigner("secret", 0)
+	assert.Equal(t, defaultMaxSkew, s.MaxSkew)
+	body := []byte(`{"id":"1"}`)
+	sig := s.SignResponse("1614592800", 200, "/internal/v1/job/1/status", body)
+	require.NoError(t, s.VerifyResponse("1614592800", sig, 200, "/internal/v1/job/1/status", body))
+	assert.EqualError(t, s.VerifyResponse("1614592800", sig, 409, "/internal/v1/job/1/status", body), "signature mismatch")
+}
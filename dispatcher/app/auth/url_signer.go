@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//URLSigner issues HMAC-SHA256 signatures for time-limited blob download urls, they are verified by blob service.
+//Signature is bound to a single blob location and tenant
+type URLSigner struct {
+	Secret []byte
+	TTL    time.Duration
+	now    func() time.Time
+	lock   sync.RWMutex
+}
+
+const defaultURLTTL = 15 * time.Minute
+
+//NewURLSigner makes signer with secret and lifetime of signed urls
+func NewURLSigner(secret string, ttl time.Duration) *URLSigner {
+	if ttl <= 0 {
+		ttl = defaultURLTTL
+	}
+	return &URLSigner{Secret: []byte(secret), TTL: ttl, now: time.Now}
+}
+
+//SetSecret replaces secret of signatures, urls signed by old secret are rejected since then
+func (s *URLSigner) SetSecret(secret string) {
+	s.lock.Lock()
+	s.Secret = []byte(secret)
+	s.lock.Unlock()
+}
+
+//Sign returns query params tid, exp and sig for blob location and expiration time of them
+func (s *URLSigner) Sign(location string, tenantID int) (url.Values, time.Time) {
+	expires := s.now().Add(s.TTL).Truncate(time.Second)
+	q := url.Values{}
+	q.Set("tid", strconv.Itoa(tenantID))
+	q.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", s.signature(location, tenantID, expires.Unix()))
+	return q, expires
+}
+
+func (s *URLSigner) signature(location string, tenantID int, expires int64) string {
+	s.lock.RLock()
+	mac := hmac.New(sha256.New, s.Secret)
+	s.lock.RUnlock()
+	_, _ = fmt.Fprintf(mac, "%s\n%d\n%d", location, tenantID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
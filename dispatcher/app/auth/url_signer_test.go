@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+//TestURLSigner_Sign pins format of signed url params, blob service verifies them by the same format:
+//sig is hex HMAC-SHA256 of "<location>\n<tid>\n<exp>"
+func TestURLSigner_Sign(t *testing.T) {
+	s := NewURLSigner("secret", time.Minute)
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	q, expires := s.Sign("/blob/1", 1)
+	assert.Equal(t, now.Add(time.Minute), expires)
+	assert.Equal(t, "1", q.Get("tid"))
+	assert.Equal(t, "1614592860", q.Get("exp"))
+	assert.Equal(t, "02972921aed8895714b5c1769524be10cb4942772f2ec028eea765fc333c6d95", q.Get("sig"))
+
+	q2, _ := s.Sign("/blob/2", 1)
+	assert.NotEqual(t, q.Get("sig"), q2.Get("sig"), "signature is bound to location")
+	q2, _ = s.Sign("/blob/1", 2)
+	assert.NotEqual(t, q.Get("sig"), q2.Get("sig"), "signature is bound to tenant")
+
+	s.SetSecret("other")
+	q2, _ = s.Sign("/blob/1", 1)
+	assert.NotEqual(t, q.Get("sig"), q2.Get("sig"))
+
+	assert.Equal(t, defaultURLTTL, NewURLSigner("secret", 0).TTL)
+}
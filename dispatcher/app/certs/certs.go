@@ -0,0 +1,170 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultInterval = 10 * time.Second
+
+//Reloader keeps certificate and key pair loaded from files and loads them again when any of files is modified
+type Reloader struct {
+	CertFile string
+	KeyFile  string
+	Interval time.Duration //interval of checking modification time of files, 10s by default
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time //latest modification time of files loaded pair
+}
+
+//NewReloader loads certificate and key pair from PEM files
+func NewReloader(certFile, keyFile string, interval time.Duration) (*Reloader, error) {
+	res := &Reloader{CertFile: certFile, KeyFile: keyFile, Interval: interval}
+	if _, err := res.Reload(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+//Run checks files every Interval till context is canceled, pair which can't be loaded is logged and current one is kept
+func (r *Reloader) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				log.Printf("[ERROR] certificate %s is not reloaded, current one is kept, %v", r.CertFile, err)
+				continue
+			}
+			if reloaded {
+				log.Printf("[INFO] certificate %s is reloaded", r.CertFile)
+			}
+		}
+	}
+}
+
+//Reload loads pair if files are modified since the last load and returns true if pair is replaced
+func (r *Reloader) Reload() (bool, error) {
+	modTime, err := latestModTime(r.CertFile, r.KeyFile)
+	if err != nil {
+		return false, err
+	}
+	r.lock.RLock()
+	loaded := r.cert != nil && modTime.Equal(r.modTime)
+	r.lock.RUnlock()
+	if loaded {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "can't load certificate %s and key %s", r.CertFile, r.KeyFile)
+	}
+	r.lock.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.lock.Unlock()
+	return true, nil
+}
+
+//GetCertificate returns current pair, it is used as GetCertificate of server TLS config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+//GetClientCertificate returns current pair, it is used as GetClientCertificate of client TLS config
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+//LoadCertPool reads bundle of PEM CA certificates
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read CA bundle %s", file)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("CA bundle %s has no PEM certificates", file)
+	}
+	return pool, nil
+}
+
+//ServerConfig returns TLS config of server with certificate of reloader. Client certificates are verified by CA
+//bundle if it is set, certificate is requested but not required, so routes decide which clients need it
+func ServerConfig(r *Reloader, clientCA string) (*tls.Config, error) {
+	cfg := &tls.Config{GetCertificate: r.GetCertificate, MinVersion: tls.VersionTLS12}
+	if clientCA == "" {
+		return cfg, nil
+	}
+	pool, err := LoadCertPool(clientCA)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
+
+//ClientConfig returns TLS config of client, CA bundle replaces system roots if it is set and certificate of
+//reloader is sent to server if reloader is not nil
+func ClientConfig(ca string, r *Reloader) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca != "" {
+		pool, err := LoadCertPool(ca)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if r != nil {
+		cfg.GetClientCertificate = r.GetClientCertificate
+	}
+	return cfg, nil
+}
+
+//Transport returns copy of default http transport with TLS config
+func Transport(cfg *tls.Config) *http.Transport {
+	res := http.DefaultTransport.(*http.Transport).Clone()
+	res.TLSClientConfig = cfg
+	return res
+}
+
+//Verified returns true if request is made over TLS with client certificate verified by CA bundle of server
+func Verified(req *http.Request) bool {
+	return req.TLS != nil && len(req.TLS.VerifiedChains) > 0
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	res := time.Time{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return res, errors.Wrapf(err, "can't stat %s", file)
+		}
+		if info.ModTime().After(res) {
+			res = info.ModTime()
+		}
+	}
+	return res, nil
+}
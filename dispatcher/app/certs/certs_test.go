@@ -0,0 +1,162 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//writePair writes new self-signed certificate and key to dir and sets their modification time
+func writePair(t *testing.T, dir string, modTime time.Time) (certFile, keyFile string) {
+	certPEM, keyPEM, err := SelfSigned(time.Hour, "localhost", "127.0.0.1")
+	require.NoError(t, err)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+	return certFile, keyFile
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	modTime := time.Now().Add(-time.Hour)
+	certFile, keyFile := writePair(t, dir, modTime)
+
+	r, err := NewReloader(certFile, keyFile, 0)
+	require.NoError(t, err)
+	first, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+
+	reloaded, err := r.Reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "files are not modified")
+
+	writePair(t, dir, modTime.Add(time.Minute))
+	reloaded, err = r.Reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	second, err := r.GetClientCertificate(nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Certificate, second.Certificate)
+
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("broken"), 0600))
+	_, err = r.Reload()
+	assert.Contains(t, err.Error(), "can't load certificate")
+	cur, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second, cur, "current pair is kept")
+
+	_, err = NewReloader(filepath.Join(dir, "missing.pem"), keyFile, 0)
+	assert.Contains(t, err.Error(), "can't stat")
+}
+
+func TestReloader_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	modTime := time.Now().Add(-time.Hour)
+	certFile, keyFile := writePair(t, dir, modTime)
+
+	r, err := NewReloader(certFile, keyFile, 10*time.Millisecond)
+	require.NoError(t, err)
+	first, _ := r.GetCertificate(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+
+	writePair(t, dir, modTime.Add(time.Minute))
+	assert.Eventually(t, func() bool {
+		cur, _ := r.GetCertificate(nil)
+		return cur != first
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writePair(t, dir, time.Now())
+
+	pool, err := LoadCertPool(certFile)
+	require.NoError(t, err)
+	assert.NotNil(t, pool)
+
+	_, err = LoadCertPool(keyFile)
+	assert.Contains(t, err.Error(), "has no PEM certificates")
+	_, err = LoadCertPool(filepath.Join(dir, "missing.pem"))
+	assert.Contains(t, err.Error(), "can't read CA bundle")
+}
+
+func TestServerClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	serverCert, serverKey := writePair(t, dir, time.Now())
+	clientDir := filepath.Join(dir, "client")
+	require.NoError(t, os.Mkdir(clientDir, 0700))
+	clientCert, clientKey := writePair(t, clientDir, time.Now())
+
+	server, err := NewReloader(serverCert, serverKey, 0)
+	require.NoError(t, err)
+	serverCfg, err := ServerConfig(server, clientCert)
+	require.NoError(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, serverCfg.ClientAuth)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if Verified(req) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	ts.Listener = tls.NewListener(ts.Listener, serverCfg)
+	ts.Start()
+	defer ts.Close()
+	url := "https://" + ts.Listener.Addr().String()
+
+	client, err := NewReloader(clientCert, clientKey, 0)
+	require.NoError(t, err)
+	tbl := []struct {
+		client *Reloader
+		status int
+	}{
+		{client, http.StatusOK},
+		{nil, http.StatusUnauthorized},
+		{server, 0}, //certificate which is not signed by client CA is rejected by handshake
+	}
+	for i, tt := range tbl {
+		clientCfg, err := ClientConfig(serverCert, tt.client)
+		require.NoError(t, err, "test case #%d", i)
+		httpClient := &http.Client{Transport: Transport(clientCfg)}
+		resp, err := httpClient.Get(url)
+		if tt.status == 0 {
+			assert.Error(t, err, "test case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "test case #%d", i)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.status, resp.StatusCode, "test case #%d", i)
+	}
+
+	clientCfg, err := ClientConfig("", nil)
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: Transport(clientCfg)}).Get(url)
+	assert.Error(t, err, "self-signed server certificate is not trusted by system roots")
+}
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/quota"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/retry"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Config is yaml config file of server command, its values override flags and env, zero values keep them.
+//Limits, keys and tenant settings are applied again on SIGHUP, other changes need restart
+type Config struct {
+	Server  ServerConfig         `yaml:"server"`
+	Auth    AuthConfig           `yaml:"auth"`
+	Engine  EngineConfig         `yaml:"engine"`
+	Limits  LimitsConfig         `yaml:"limits"`
+	Retry   RetryConfig          `yaml:"retry"`
+	Tenants map[int]TenantConfig `yaml:"tenants"`
+}
+
+//ServerConfig is http server settings, they need restart
+type ServerConfig struct {
+	Port              int           `yaml:"port"`
+	MaxBodySize       int64         `yaml:"max_body_size"`
+	Throttle          int           `yaml:"throttle"`
+	StreamThrottle    int           `yaml:"stream_throttle"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+}
+
+//AuthConfig is keys and tokens, apis disabled at start are not enabled by reload
+type AuthConfig struct {
+	JWTSecret      string `yaml:"jwt_secret"`
+	AdminToken     string `yaml:"admin_token"`
+	PushSecret     string `yaml:"push_secret"`
+	BlobSignSecret string `yaml:"blob_sign_secret"`
+	WebhookSecret  string `yaml:"webhook_secret"`
+}
+
+//EngineConfig is upstreams settings, they need restart
+type EngineConfig struct {
+	WorkerServiceURL string        `yaml:"worker_service_url"`
+	BlobServiceURL   string        `yaml:"blob_service_url"`
+	RequestTimeout   time.Duration `yaml:"request_timeout"`
+	RetryInterval    time.Duration `yaml:"retry_interval"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+}
+
+//LimitsConfig is rates of requests from ip and quotas of tenants, quotas are set inline or by quota file
+type LimitsConfig struct {
+	APIRate   float64       `yaml:"api_rate"`
+	PingRate  float64       `yaml:"ping_rate"`
+	QuotaFile string        `yaml:"quota_file"`
+	Quotas    *quota.Config `yaml:"quotas"`
+}
+
+//RetryConfig is default retry policy of jobs, max backoff needs restart
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+	Codes       []string      `yaml:"codes"`
+}
+
+//TenantConfig is settings of tenant, retry policy has format of retry.tenant flag maxAttempts/backoff/CODE|CODE
+type TenantConfig struct {
+	CallbackURL string        `yaml:"callback_url"`
+	Retention   time.Duration `yaml:"retention"`
+	Retry       string        `yaml:"retry"`
+}
+
+//LoadConfig reads and validates config from yaml file, unknown fields are rejected
+func LoadConfig(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, errors.Wrapf(err, "can't read config file %s", path)
+	}
+	cfg := Config{}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, errors.Wrapf(err, "invalid config file %s", path)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, errors.Wrapf(err, "invalid config file %s", path)
+	}
+	return cfg, nil
+}
+
+//Validate checks that values of config are not negative, urls are absolute and policies are parsed
+func (c Config) Validate() error {
+	s := c.Server
+	if s.Port < 0 || s.Port > 65535 {
+		return errors.Errorf("server: port %d is out of range", s.Port)
+	}
+	if s.MaxBodySize < 0 || s.Throttle < 0 || s.StreamThrottle < 0 || s.ReadHeaderTimeout < 0 || s.WriteTimeout < 0 || s.IdleTimeout < 0 {
+		return errors.New("server: limits and timeouts can't be negative")
+	}
+
+	e := c.Engine
+	if err := validateURL(e.WorkerServiceURL); err != nil {
+		return errors.Wrap(err, "engine: worker_service_url")
+	}
+	if err := validateURL(e.BlobServiceURL); err != nil {
+		return errors.Wrap(err, "engine: blob_service_url")
+	}
+	if e.RequestTimeout < 0 || e.RetryInterval < 0 || e.BreakerThreshold < 0 || e.BreakerCooldown < 0 {
+		return errors.New("engine: timeouts and breaker settings can't be negative")
+	}
+
+	if c.Limits.APIRate < 0 || c.Limits.PingRate < 0 {
+		return errors.New("limits: rates can't be negative")
+	}
+	if c.Limits.Quotas != nil {
+		if c.Limits.QuotaFile != "" {
+			return errors.New("limits: quotas and quota_file can't be set both")
+		}
+		if err := c.Limits.Quotas.Validate(); err != nil {
+			return errors.Wrap(err, "limits: quotas")
+		}
+	}
+
+	if c.Retry.MaxAttempts < 0 || c.Retry.Backoff < 0 || c.Retry.MaxBackoff < 0 {
+		return errors.New("retry: values can't be negative")
+	}
+
+	for id, tenant := range c.Tenants {
+		if err := validateURL(tenant.CallbackURL); err != nil {
+			return errors.Wrapf(err, "tenant %d: callback_url", id)
+		}
+		if tenant.Retention < 0 {
+			return errors.Errorf("tenant %d: retention can't be negative", id)
+		}
+		if tenant.Retry != "" {
+			if _, err := retry.ParsePolicy(tenant.Retry); err != nil {
+				return errors.Wrapf(err, "tenant %d: retry", id)
+			}
+		}
+	}
+	return nil
+}
+
+//apply returns options overridden by values set in config, maps of options are copied before merging tenants
+func (c Config) apply(sc ServerCommand) ServerCommand {
+	setInt(&sc.Port, c.Server.Port)
+
+	setString(&sc.Admin.Token, c.Auth.AdminToken)
+	setString(&sc.Push.Secret, c.Auth.PushSecret)
+	setString(&sc.BlobSign.Secret, c.Auth.BlobSignSecret)
+	setString(&sc.Webhook.Secret, c.Auth.WebhookSecret)
+
+	setString(&sc.WorkerServiceURL, strings.TrimSuffix(c.Engine.WorkerServiceURL, "/"))
+	setString(&sc.BlobServiceURL, strings.TrimSuffix(c.Engine.BlobServiceURL, "/"))
+	setInt(&sc.Breaker.Threshold, c.Engine.BreakerThreshold)
+	setDuration(&sc.Breaker.Cooldown, c.Engine.BreakerCooldown)
+
+	setString(&sc.Quota.File, c.Limits.QuotaFile)
+
+	setInt(&sc.Retry.MaxAttempts, c.Retry.MaxAttempts)
+	setDuration(&sc.Retry.Backoff, c.Retry.Backoff)
+	setDuration(&sc.Retry.MaxBackoff, c.Retry.MaxBackoff)
+	if len(c.Retry.Codes) > 0 {
+		sc.Retry.Codes = c.Retry.Codes
+	}
+
+	tenantURLs := map[int]string{}
+	for id, u := range sc.Webhook.TenantURLs {
+		tenantURLs[id] = u
+	}
+	tenantTTL := map[int]time.Duration{}
+	for id, ttl := range sc.TTL.Tenants {
+		tenantTTL[id] = ttl
+	}
+	tenantRetry := map[int]string{}
+	for id, policy := range sc.Retry.Tenants {
+		tenantRetry[id] = policy
+	}
+	for id, tenant := range c.Tenants {
+		if tenant.CallbackURL != "" {
+			tenantURLs[id] = tenant.CallbackURL
+		}
+		if tenant.Retention > 0 {
+			tenantTTL[id] = tenant.Retention
+		}
+		if tenant.Retry != "" {
+			tenantRetry[id] = tenant.Retry
+		}
+	}
+	sc.Webhook.TenantURLs, sc.TTL.Tenants, sc.Retry.Tenants = tenantURLs, tenantTTL, tenantRetry
+
+	sc.config = c
+	return sc
+}
+
+//restartRequired returns names of changed settings which are not reloaded
+func restartRequired(cur, next ServerCommand) []string {
+	res := []string{}
+	if cur.Port != next.Port || cur.config.Server != next.config.Server {
+		res = append(res, "server")
+	}
+	if cur.WorkerServiceURL != next.WorkerServiceURL || cur.BlobServiceURL != next.BlobServiceURL ||
+		cur.Breaker != next.Breaker || cur.config.Engine != next.config.Engine {
+		res = append(res, "engine")
+	}
+	if cur.Retry.MaxBackoff != next.Retry.MaxBackoff {
+		res = append(res, "retry.max_backoff")
+	}
+	return res
+}
+
+//reload applies limits, keys and tenant settings of config and quota files, nothing is applied if they are invalid
+func (app *application) reload() error {
+	opts := app.flags
+	if app.ConfigFile != "" {
+		cfg, err := LoadConfig(app.ConfigFile)
+		if err != nil {
+			return err
+		}
+		opts = cfg.apply(opts)
+	}
+	retryDefault, retryTenants, err := opts.retryPolicies()
+	if err != nil {
+		return err
+	}
+	quotas, err := opts.loadQuotas()
+	if err != nil {
+		return errors.Wrap(err, "failed to load quotas")
+	}
+
+	app.reloadJWTSecret(opts.config.Auth.JWTSecret)
+	app.rest.SetRates(opts.config.Limits.APIRate, opts.config.Limits.PingRate)
+	reloadSecret("admin api token", app.Admin.Token, opts.Admin.Token, app.rest.SetAdminToken)
+	if app.rest.WorkerSigner != nil {
+		reloadSecret("push secret", app.Push.Secret, opts.Push.Secret, app.rest.WorkerSigner.SetSecret)
+	}
+	if app.rest.URLSigner != nil {
+		reloadSecret("blob sign secret", app.BlobSign.Secret, opts.BlobSign.Secret, app.rest.URLSigner.SetSecret)
+	}
+	app.webhooks.Configure(opts.Webhook.Secret, opts.Webhook.TenantURLs)
+	app.retrier.SetPolicies(retryDefault, retryTenants)
+	app.janitor.SetRetention(opts.TTL.Default, opts.TTL.Tenants)
+
+	switch {
+	case app.rest.Quotas != nil && quotas != nil:
+		app.rest.Quotas.Update(*quotas)
+	case app.rest.Quotas != nil:
+		app.rest.Quotas.Update(quota.Config{})
+		log.Printf("[WARN] quotas are removed, tenants are not limited till restart")
+	case quotas != nil:
+		log.Printf("[WARN] quotas are not enabled at start, restart is required to apply them")
+	}
+
+	if changed := restartRequired(*app.ServerCommand, opts); len(changed) > 0 {
+		log.Printf("[WARN] changes of %s are applied on restart", strings.Join(changed, ", "))
+	}
+	return nil
+}
+
+//reloadSecret sets secret of api enabled at start, api can't be disabled by reload so empty secret keeps current one
+func reloadSecret(name, cur, next string, set func(string)) {
+	if cur == "" {
+		if next != "" {
+			log.Printf("[WARN] %s is not enabled at start, restart is required to apply it", name)
+		}
+		return
+	}
+	if next == "" {
+		log.Printf("[WARN] %s can't be removed by reload, current one is kept", name)
+		return
+	}
+	set(next)
+}
+
+//reloadJWTSecret sets jwt secret only if it's changed in config, so key rotated by admin api isn't replaced
+//by the same config on each reload. Empty secret keeps current one as tokens can't be left unsigned
+func (app *application) reloadJWTSecret(next string) {
+	if next == app.jwtSecret {
+		return
+	}
+	app.jwtSecret = next
+	if next == "" {
+		log.Printf("[WARN] jwt secret can't be removed by reload, current one is kept")
+		return
+	}
+	app.rest.Auth.SetSecret(next)
+}
+
+func validateURL(u string) error {
+	if u == "" {
+		return nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return errors.Errorf("%q is not absolute url", u)
+	}
+	return nil
+}
+
+func setString(v *string, s string) {
+	if s != "" {
+		*v = s
+	}
+}
+
+func setInt(v *int, i int) {
+	if i != 0 {
+		*v = i
+	}
+}
+
+func setDuration(v *time.Duration, d time.Duration) {
+	if d != 0 {
+		*v = d
+	}
+}
@@ -2,21 +2,175 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/pkg/errors"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/certs"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/health"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/janitor"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/metrics"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/quota"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/reconciler"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/rest"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/retry"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/scheduler"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/tracing"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/upload"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/webhook"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/workflow"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 type ServerCommand struct {
 	Version      string
-	RemoteEngine EngineGroup `group:"engine" namespace:"engine" env-namespace:"ENGINE"`
-	Port         int         `long:"port" env:"SERVER_PORT" default:"9000" description:"Dispatcher server port"`
+	RemoteEngine EngineGroup      `group:"engine" namespace:"engine" env-namespace:"ENGINE"`
+	Port         int              `long:"port" env:"SERVER_PORT" default:"9000" description:"Dispatcher server port"`
+	BlobSign     BlobSignGroup    `group:"blobSign" namespace:"blobSign" env-namespace:"BLOB_SIGN"`
+	Upload       UploadGroup      `group:"upload" namespace:"upload" env-namespace:"UPLOAD"`
+	Webhook      WebhookGroup     `group:"webhook" namespace:"webhook" env-namespace:"WEBHOOK"`
+	Watch        WatchGroup       `group:"watch" namespace:"watch" env-namespace:"WATCH"`
+	Reconcile    ReconcileGroup   `group:"reconcile" namespace:"reconcile" env-namespace:"RECONCILE"`
+	Push         PushGroup        `group:"push" namespace:"push" env-namespace:"PUSH"`
+	Retry        RetryGroup       `group:"retry" namespace:"retry" env-namespace:"RETRY"`
+	Admin        AdminGroup       `group:"admin" namespace:"admin" env-namespace:"ADMIN"`
+	TTL          TTLGroup         `group:"ttl" namespace:"ttl" env-namespace:"TTL"`
+	Batch        BatchGroup       `group:"batch" namespace:"batch" env-namespace:"BATCH"`
+	Workflow     WorkflowGroup    `group:"workflow" namespace:"workflow" env-namespace:"WORKFLOW"`
+	Scheduler    SchedulerGroup   `group:"scheduler" namespace:"scheduler" env-namespace:"SCHEDULER"`
+	Trace        TraceGroup       `group:"trace" namespace:"trace" env-namespace:"TRACE"`
+	Health       HealthGroup      `group:"health" namespace:"health" env-namespace:"HEALTH"`
+	Breaker      BreakerGroup     `group:"breaker" namespace:"breaker" env-namespace:"BREAKER"`
+	Quota        QuotaGroup       `group:"quota" namespace:"quota" env-namespace:"QUOTA"`
+	TLS          TLSGroup         `group:"tls" namespace:"tls" env-namespace:"TLS"`
+	UpstreamTLS  UpstreamTLSGroup `group:"upstreamTls" namespace:"upstreamTls" env-namespace:"UPSTREAM_TLS"`
+	Drain        DrainGroup       `group:"drain" namespace:"drain" env-namespace:"DRAIN"`
+	ConfigFile   string           `long:"config" env:"CONFIG_FILE" description:"yaml config file overriding flags and env, its limits, keys and tenant settings are reloaded on SIGHUP"`
 	CommonOptions
+
+	config Config //config file applied to options
+}
+
+type BlobSignGroup struct {
+	Secret    string        `long:"secret" env:"SECRET" description:"secret for signing result download urls, signed urls are disabled if empty"`
+	PublicURL string        `long:"publicUrl" env:"PUBLIC_URL" description:"blob service api url reachable by clients, blob service url by default"`
+	TTL       time.Duration `long:"ttl" env:"TTL" default:"15m" description:"lifetime of signed result download url"`
+}
+
+type UploadGroup struct {
+	MaxSize       int64         `long:"maxSize" env:"MAX_SIZE" default:"52428800" description:"max size in bytes of resumable upload"`
+	TTL           time.Duration `long:"ttl" env:"TTL" default:"24h" description:"lifetime of inactive upload session"`
+	MaxSessions   int           `long:"maxSessions" env:"MAX_SESSIONS" default:"10" description:"max open upload sessions of tenant"`
+	MaxTenantSize int64         `long:"maxTenantSize" env:"MAX_TENANT_SIZE" default:"209715200" description:"max total size in bytes of open upload sessions of tenant"`
+}
+
+type WebhookGroup struct {
+	Secret       string         `long:"secret" env:"SECRET" description:"secret for HMAC-SHA256 signature of callback payloads"`
+	TenantURLs   map[int]string `long:"tenantUrl" env:"TENANT_URL" env-delim:"," description:"default callback url of tenant as tenantId:url"`
+	MaxAttempts  int            `long:"maxAttempts" env:"MAX_ATTEMPTS" default:"5" description:"max number of callback delivery attempts"`
+	Backoff      time.Duration  `long:"backoff" env:"BACKOFF" default:"1s" description:"delay before second delivery attempt, doubled for each next one"`
+	AllowPrivate bool           `long:"allowPrivate" env:"ALLOW_PRIVATE" description:"allow callbacks to loopback, private and link-local addresses"`
+}
+
+type WatchGroup struct {
+	PollInterval time.Duration `long:"pollInterval" env:"POLL_INTERVAL" default:"2s" description:"interval of checking watched job in dispatcher store, store updates are published at once"`
+}
+
+type ReconcileGroup struct {
+	Interval  time.Duration `long:"interval" env:"INTERVAL" default:"5s" description:"interval of syncing unfinished jobs status from worker"`
+	BatchSize int           `long:"batchSize" env:"BATCH_SIZE" default:"10" description:"number of parallel worker status calls"`
+	Deadline  time.Duration `long:"deadline" env:"DEADLINE" default:"10m" description:"job is marked as TIMED_OUT if worker can't report its status longer than deadline"`
+}
+
+type PushGroup struct {
+	Secret  string        `long:"secret" env:"SECRET" description:"shared secret of worker status push api, the api is disabled if empty"`
+	MaxSkew time.Duration `long:"maxSkew" env:"MAX_SKEW" default:"5m" description:"max difference between worker request timestamp and dispatcher time"`
+}
+
+type RetryGroup struct {
+	MaxAttempts int            `long:"maxAttempts" env:"MAX_ATTEMPTS" default:"3" description:"max number of job processing attempts, retries are disabled if less than 2"`
+	Backoff     time.Duration  `long:"backoff" env:"BACKOFF" default:"5s" description:"delay before second attempt, doubled for each next one"`
+	MaxBackoff  time.Duration  `long:"maxBackoff" env:"MAX_BACKOFF" default:"5m" description:"max delay between attempts"`
+	Codes       []string       `long:"code" env:"CODES" env-delim:"," description:"retryable error code, retryable flag of worker error is used if not set"`
+	Tenants     map[int]string `long:"tenant" env:"TENANT" env-delim:"," description:"retry policy of tenant as tenantId:maxAttempts/backoff/CODE|CODE"`
+	Interval    time.Duration  `long:"interval" env:"INTERVAL" default:"1s" description:"interval of checking jobs waiting for retry"`
+}
+
+type TTLGroup struct {
+	Default  time.Duration         `long:"default" env:"DEFAULT" description:"retention of finished job and its blobs, jobs are kept forever if zero"`
+	Tenants  map[int]time.Duration `long:"tenant" env:"TENANT" env-delim:"," description:"retention of tenant jobs as tenantId:duration, overrides default one"`
+	Interval time.Duration         `long:"interval" env:"INTERVAL" default:"1m" description:"interval of removing expired jobs"`
+	DryRun   bool                  `long:"dryRun" env:"DRY_RUN" description:"only log expired jobs and their size without removing"`
+}
+
+type BatchGroup struct {
+	MaxItems    int   `long:"maxItems" env:"MAX_ITEMS" default:"100" description:"max number of images in batch submission"`
+	MaxSize     int64 `long:"maxSize" env:"MAX_SIZE" default:"16777216" description:"max size in bytes of batch body"`
+	Concurrency int   `long:"concurrency" env:"CONCURRENCY" default:"10" description:"number of parallel job submissions of batch"`
+}
+
+type WorkflowGroup struct {
+	Interval time.Duration `long:"interval" env:"INTERVAL" default:"1s" description:"interval of checking jobs waiting for dependencies, finished jobs are checked at once"`
+}
+
+type SchedulerGroup struct {
+	Interval time.Duration `long:"interval" env:"INTERVAL" default:"1s" description:"interval of releasing scheduled jobs"`
+}
+
+type TraceGroup struct {
+	Exporter string        `long:"exporter" env:"EXPORTER" choice:"none" choice:"file" choice:"otlp" default:"none" description:"exporter of spans, tracing is disabled by none"`
+	File     string        `long:"file" env:"FILE" default:"traces.ndjson" description:"file of spans written by file exporter, one JSON span per line"`
+	Endpoint string        `long:"endpoint" env:"ENDPOINT" default:"http://localhost:4318" description:"OTLP/HTTP collector url, spans are posted to /v1/traces"`
+	Interval time.Duration `long:"interval" env:"INTERVAL" default:"5s" description:"interval of sending spans to OTLP collector"`
+	Service  string        `long:"service" env:"SERVICE" default:"image-jobs-dispatcher" description:"service name of spans"`
+}
+
+type HealthGroup struct {
+	CacheTTL time.Duration `long:"cacheTtl" env:"CACHE_TTL" default:"5s" description:"lifetime of readiness checks result, probes get cached result"`
+	Timeout  time.Duration `long:"timeout" env:"TIMEOUT" default:"2s" description:"timeout of each readiness check"`
+	MaxQueue int           `long:"maxQueue" env:"MAX_QUEUE" default:"1000" description:"service is not ready if number of jobs processed by worker reaches max, check is disabled if zero"`
+}
+
+type BreakerGroup struct {
+	Threshold int           `long:"threshold" env:"THRESHOLD" default:"5" description:"number of failed upstream requests in a row which opens circuit breaker, breakers are disabled if zero"`
+	Cooldown  time.Duration `long:"cooldown" env:"COOLDOWN" default:"30s" description:"time of rejecting upstream requests by open breaker before trial request"`
+}
+
+type QuotaGroup struct {
+	File string `long:"file" env:"FILE" description:"yaml file of per-tenant rate limits and daily quotas, requests are limited only by ip if empty"`
+}
+
+type TLSGroup struct {
+	Cert           string        `long:"cert" env:"CERT" description:"PEM certificate file of server, https is served if set"`
+	Key            string        `long:"key" env:"KEY" description:"PEM private key file of server certificate"`
+	ClientCA       string        `long:"clientCa" env:"CLIENT_CA" description:"PEM CA bundle of client certificates, internal api requires verified client certificate if set"`
+	ReloadInterval time.Duration `long:"reloadInterval" env:"RELOAD_INTERVAL" default:"10s" description:"interval of checking certificate files, modified files are loaded without restart"`
+}
+
+type UpstreamTLSGroup struct {
+	CA   string `long:"ca" env:"CA" description:"PEM CA bundle verifying certificates of worker and blob services, system roots are used if empty"`
+	Cert string `long:"cert" env:"CERT" description:"PEM client certificate file sent to worker and blob services"`
+	Key  string `long:"key" env:"KEY" description:"PEM private key file of client certificate"`
+}
+
+type DrainGroup struct {
+	Timeout time.Duration `long:"timeout" env:"TIMEOUT" default:"30s" description:"max time of finishing in-flight requests and dispatching due jobs on shutdown"`
+	Delay   time.Duration `long:"delay" env:"DELAY" description:"time of failing readiness before server stops accepting connections, so load balancer removes instance"`
+}
+
+type AdminGroup struct {
+	Token string `long:"token" env:"TOKEN" description:"bearer token of admin api, the api is disabled if empty"`
 }
 
 type EngineGroup struct {
@@ -30,7 +184,19 @@ type RestAPIGroup struct {
 
 type application struct {
 	*ServerCommand
+	jobs       *store.Store
+	flags      ServerCommand //options from flags and env, config file is applied to them on reload
+	jwtSecret  string        //jwt secret of last loaded config, key rotated by admin api is kept till it's changed
 	rest       *rest.Rest
+	webhooks   *webhook.Service
+	watcher    *watcher.Watcher
+	reconciler *reconciler.Reconciler
+	retrier    *retry.Retrier
+	janitor    *janitor.Janitor
+	workflow   *workflow.Workflow
+	scheduler  *scheduler.Scheduler
+	tracer     *tracing.Tracer
+	reloaders  []*certs.Reloader //reloaders of server and client certificates
 	terminated chan struct{}
 }
 
@@ -62,52 +228,498 @@ func (sc *ServerCommand) Execute(_ []string) error {
 }
 
 func (app *application) run(ctx context.Context) error {
+	//background workers and tracer are stopped by drain, so jobs accepted by in-flight requests are dispatched
+	workCtx, stopWork := context.WithCancel(context.Background())
+	defer stopWork()
+	traceCtx, stopTrace := context.WithCancel(context.Background())
+	defer stopTrace()
+	workers := &sync.WaitGroup{}
+	for _, run := range []func(context.Context){app.reconciler.Run, app.retrier.Run, app.janitor.Run, app.workflow.Run,
+		app.scheduler.Run} {
+		workers.Add(1)
+		go func(run func(context.Context)) {
+			defer workers.Done()
+			run(workCtx)
+		}(run)
+	}
+	go app.tracer.Run(traceCtx)
+	go app.watchReload(ctx)
+	for _, reloader := range app.reloaders {
+		go reloader.Run(workCtx)
+	}
+	drained := make(chan struct{})
 	go func() {
 		<-ctx.Done()
-		app.rest.Shutdown()
+		app.drain(stopWork, workers)
+		stopTrace()
 		log.Print("[INFO] shutdown is completed")
+		close(drained)
 	}()
 	app.rest.Run(app.Port)
+	if ctx.Err() != nil {
+		<-drained
+	}
 	close(app.terminated)
 	return nil
 }
 
-func (sc *ServerCommand) buildEngine() (result engine.Interface, err error) {
+//drain stops service gracefully. Readiness fails and new jobs are rejected, server stops accepting connections
+//after Drain.Delay and in-flight requests are finished. Then background workers are stopped, due jobs of dispatch
+//queue and all retries, regardless of backoff, are sent to worker. Steps after delay are limited by Drain.Timeout,
+//jobs left in queue are lost with store
+func (app *application) drain(stopWork context.CancelFunc, workers *sync.WaitGroup) {
+	log.Printf("[INFO] drain is started, delay=%s timeout=%s", app.Drain.Delay, app.Drain.Timeout)
+	app.rest.Drain()
+	time.Sleep(app.Drain.Delay)
+	deadline := time.Now().Add(app.Drain.Timeout)
+	app.rest.Shutdown()
+
+	stopWork()
+	flushed := waitUntil(deadline, func() {
+		workers.Wait()
+		app.scheduler.Release()
+		app.workflow.Release()
+		app.retrier.Flush()
+	})
+	if !flushed {
+		log.Printf("[WARN] dispatch queue is not flushed in drain timeout")
+	}
+	queued := app.jobs.Find(func(job model.Job) bool {
+		switch job.Status {
+		case model.JobStatus(model.WAITING).ToString(), model.JobStatus(model.SCHEDULED).ToString(),
+			model.JobStatus(model.RETRYING).ToString():
+			return true
+		}
+		return false
+	})
+	if len(queued) > 0 {
+		log.Printf("[WARN] jobs are left in dispatch queue and lost with in-memory store, count=%d", len(queued))
+	}
+
+	app.webhooks.Close()
+	app.watcher.Close()
+}
+
+//waitUntil runs fn and waits for it till deadline, false is returned if fn is not completed in time
+func waitUntil(deadline time.Time, fn func()) bool {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+//watchReload reloads limits, keys and tenant settings on SIGHUP, current settings are kept if files are invalid
+func (app *application) watchReload(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := app.reload(); err != nil {
+				log.Printf("[ERROR] failed to reload config, current settings are kept, %v", err)
+				continue
+			}
+			log.Printf("[INFO] config is reloaded")
+		}
+	}
+}
+
+func (sc *ServerCommand) buildEngine(jobs *store.Store, m *metrics.Metrics, t *tracing.Tracer,
+	b upstreamBreakers, transport http.RoundTripper) (engine.Interface, error) {
 	log.Printf("[INFO] build engine. Type=%s", sc.RemoteEngine.Type)
 
 	switch sc.RemoteEngine.Type {
 	case "RemoteRest":
-		r := &engine.RestAPI{WorkerServiceURL: sc.WorkerServiceURL}
+		r := &engine.RestAPI{WorkerServiceURL: sc.WorkerServiceURL, BlobServiceURL: sc.BlobServiceURL, Store: jobs,
+			WorkerObserver: m.Upstream("worker"), Tracer: t, WorkerBreaker: b.worker,
+			RequestTimeout: sc.config.Engine.RequestTimeout, RetryInterval: sc.config.Engine.RetryInterval,
+			WorkerTransport: transport,
+			BlobClient:      &http.Client{Transport: t.Transport("blob", b.blob.Transport(m.Upstream("blob").Transport(transport)))}}
 		return r, nil
 	default:
 		return nil, errors.Errorf("unsupported engine type %s", sc.RemoteEngine.Type)
 	}
-	return result, errors.Wrap(err, "can't initialize remote engine")
+}
+
+//upstreamBreakers are circuit breakers of worker and blob services, nil breakers allow all requests
+type upstreamBreakers struct {
+	worker *utils.Breaker
+	blob   *utils.Breaker
+}
+
+func (sc *ServerCommand) buildBreakers() upstreamBreakers {
+	if sc.Breaker.Threshold <= 0 {
+		return upstreamBreakers{}
+	}
+	return upstreamBreakers{
+		worker: utils.NewBreaker(sc.Breaker.Threshold, sc.Breaker.Cooldown),
+		blob:   utils.NewBreaker(sc.Breaker.Threshold, sc.Breaker.Cooldown),
+	}
+}
+
+//buildHealth makes readiness checker of store, upstreams, their breakers and job queue. Upstreams are pinged
+//without breakers, so recovered upstream is reported before breaker lets requests through
+func (sc *ServerCommand) buildHealth(jobs *store.Store, b upstreamBreakers, transport http.RoundTripper) (*health.Checker, error) {
+	checker := health.NewChecker(sc.Health.CacheTTL, sc.Health.Timeout)
+	checker.Add("store", health.Store(jobs))
+	checker.Add("queue", health.Queue(jobs, sc.Health.MaxQueue))
+	upstreams := []struct {
+		name    string
+		url     string
+		breaker *utils.Breaker
+	}{{"worker", sc.WorkerServiceURL, b.worker}, {"blob", sc.BlobServiceURL, b.blob}}
+	for _, u := range upstreams {
+		pingURL, err := health.PingURL(u.url)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can not check %s service", u.name)
+		}
+		checker.Add(u.name, health.Ping(&http.Client{Transport: transport}, pingURL))
+		if u.breaker != nil {
+			checker.Add(u.name+"_breaker", health.Breaker(u.breaker))
+		}
+	}
+	return checker, nil
+}
+
+//buildServerTLS returns TLS config of server and reloader of its certificate, https is disabled if config is nil
+func (sc *ServerCommand) buildServerTLS() (*tls.Config, *certs.Reloader, error) {
+	if sc.TLS.Cert == "" && sc.TLS.Key == "" {
+		if sc.TLS.ClientCA != "" {
+			return nil, nil, errors.New("client CA requires server certificate")
+		}
+		return nil, nil, nil
+	}
+	if sc.TLS.Cert == "" || sc.TLS.Key == "" {
+		return nil, nil, errors.New("server certificate and key must be set both")
+	}
+	reloader, err := certs.NewReloader(sc.TLS.Cert, sc.TLS.Key, sc.TLS.ReloadInterval)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg, err := certs.ServerConfig(reloader, sc.TLS.ClientCA)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, reloader, nil
+}
+
+//buildUpstreamTransport returns transport of requests to worker and blob services with CA bundle and client
+//certificate, nil transport is default one. Reloader of client certificate is nil if it is not set
+func (sc *ServerCommand) buildUpstreamTransport() (http.RoundTripper, *certs.Reloader, error) {
+	u := sc.UpstreamTLS
+	if u.CA == "" && u.Cert == "" && u.Key == "" {
+		return nil, nil, nil
+	}
+	if (u.Cert == "") != (u.Key == "") {
+		return nil, nil, errors.New("client certificate and key must be set both")
+	}
+	var reloader *certs.Reloader
+	if u.Cert != "" {
+		var err error
+		if reloader, err = certs.NewReloader(u.Cert, u.Key, sc.TLS.ReloadInterval); err != nil {
+			return nil, nil, err
+		}
+	}
+	cfg, err := certs.ClientConfig(u.CA, reloader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certs.Transport(cfg), reloader, nil
+}
+
+//buildTracer makes tracer with exporter from options, tracer without exporter makes no spans
+func (sc *ServerCommand) buildTracer() (*tracing.Tracer, error) {
+	tracer := &tracing.Tracer{Service: sc.Trace.Service}
+	switch sc.Trace.Exporter {
+	case "file":
+		exporter, err := tracing.OpenFile(sc.Trace.File)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can not open trace file %s", sc.Trace.File)
+		}
+		tracer.Exporter = exporter
+	case "otlp":
+		exporter := tracing.NewOTLPExporter(sc.Trace.Endpoint)
+		exporter.Interval = sc.Trace.Interval
+		tracer.Exporter = exporter
+	}
+	if tracer.Exporter != nil {
+		log.Printf("[INFO] spans are exported by %s exporter", sc.Trace.Exporter)
+	}
+	return tracer, nil
+}
+
+func (sc *ServerCommand) buildRetrier(jobs *store.Store, dispatcher retry.Dispatcher) (*retry.Retrier, error) {
+	def, tenants, err := sc.retryPolicies()
+	if err != nil {
+		return nil, err
+	}
+	return &retry.Retrier{
+		Store:      jobs,
+		Dispatcher: dispatcher,
+		Default:    def,
+		Tenants:    tenants,
+		MaxBackoff: sc.Retry.MaxBackoff,
+		Interval:   sc.Retry.Interval,
+	}, nil
+}
+
+//retryPolicies returns default retry policy and policies of tenants from options
+func (sc *ServerCommand) retryPolicies() (model.RetryPolicy, map[int]model.RetryPolicy, error) {
+	def := model.RetryPolicy{
+		MaxAttempts:    sc.Retry.MaxAttempts,
+		BackoffSeconds: int((sc.Retry.Backoff + time.Second - 1) / time.Second),
+		RetryableCodes: sc.Retry.Codes,
+	}
+	if err := def.Validate(); err != nil {
+		return def, nil, errors.Wrap(err, "invalid default retry policy")
+	}
+	tenants := map[int]model.RetryPolicy{}
+	for tenantID, s := range sc.Retry.Tenants {
+		policy, err := retry.ParsePolicy(s)
+		if err != nil {
+			return def, nil, errors.Wrapf(err, "invalid retry policy of tenant %d", tenantID)
+		}
+		tenants[tenantID] = policy
+	}
+	return def, tenants, nil
+}
+
+//loadQuotas returns quotas set in config file or loaded from quota file, nil if none is set
+func (sc *ServerCommand) loadQuotas() (*quota.Config, error) {
+	if sc.config.Limits.Quotas != nil {
+		return sc.config.Limits.Quotas, nil
+	}
+	if sc.Quota.File == "" {
+		return nil, nil
+	}
+	cfg, err := quota.Load(sc.Quota.File)
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
 }
 
 func (sc *ServerCommand) bootstrapApp() (*application, error) {
+	flagOpts := *sc
+	if sc.ConfigFile != "" {
+		cfg, err := LoadConfig(sc.ConfigFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load config")
+		}
+		*sc = cfg.apply(*sc)
+		log.Printf("[INFO] config is loaded from %s", sc.ConfigFile)
+	}
 
-	engine, err := sc.buildEngine()
+	jobs := engine.NewStore()
+	jobsMetrics := metrics.New()
+	tracer, err := sc.buildTracer()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build tracer")
+	}
+	breakers := sc.buildBreakers()
+	serverTLS, serverCert, err := sc.buildServerTLS()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set up server tls")
+	}
+	transport, clientCert, err := sc.buildUpstreamTransport()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set up upstream tls")
+	}
+	if serverTLS != nil {
+		log.Printf("[INFO] https is enabled, certificate=%s client_ca=%s", sc.TLS.Cert, sc.TLS.ClientCA)
+	}
+	reloaders := []*certs.Reloader{}
+	for _, reloader := range []*certs.Reloader{serverCert, clientCert} {
+		if reloader != nil {
+			reloaders = append(reloaders, reloader)
+		}
+	}
+
+	engine, err := sc.buildEngine(jobs, jobsMetrics, tracer, breakers, transport)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to build remote engine")
 	}
 
-	authService := auth.NewService(auth.Opts{})
+	checker, err := sc.buildHealth(jobs, breakers, transport)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build readiness checks")
+	}
+
+	retrier, err := sc.buildRetrier(jobs, engine)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build job retrier")
+	}
+	jobs.BeforeUpdate(retrier.Intercept)
+	jobsMetrics.WatchStore(jobs)
+	jobsWorkflow := &workflow.Workflow{Store: jobs, Dispatcher: engine, Interval: sc.Workflow.Interval}
+	jobs.OnUpdate(jobsWorkflow.Notify)
+
+	batches := store.NewBatches()
+	jobsJanitor := &janitor.Janitor{
+		Store:     jobs,
+		Batches:   batches,
+		Blobs:     engine,
+		TTL:       sc.TTL.Default,
+		TenantTTL: sc.TTL.Tenants,
+		Interval:  sc.TTL.Interval,
+		DryRun:    sc.TTL.DryRun,
+	}
+	watchJanitor(jobsMetrics, jobsJanitor)
+	watchBreakers(jobsMetrics, breakers)
+
+	authService := auth.NewService(auth.Opts{Secret: sc.config.Auth.JWTSecret})
+
+	if sc.Webhook.Secret == "" {
+		log.Printf("[WARN] webhook secret is not set up, callbacks are signed with empty key")
+	}
+	jobWatcher := watcher.New(engine, sc.Watch.PollInterval)
+	jobs.OnUpdate(jobWatcher.Notify)
+	webhooks := webhook.NewService(jobWatcher, webhook.Opts{
+		Secret:       sc.Webhook.Secret,
+		TenantURLs:   sc.Webhook.TenantURLs,
+		MaxAttempts:  sc.Webhook.MaxAttempts,
+		Backoff:      sc.Webhook.Backoff,
+		AllowPrivate: sc.Webhook.AllowPrivate,
+	})
+	jobsJanitor.OnDelete = webhooks.Forget
+
+	jobsScheduler := &scheduler.Scheduler{
+		Store:    jobs,
+		Workflow: jobsWorkflow,
+		Interval: sc.Scheduler.Interval,
+		OnSpawn:  func(job model.Job) { webhooks.Watch(job.ID, job.CallbackURL) },
+	}
+
+	uploads := upload.NewService(sc.Upload.MaxSize, sc.Upload.TTL)
+	uploads.MaxSessions, uploads.MaxTenantSize = sc.Upload.MaxSessions, sc.Upload.MaxTenantSize
 
 	rest := &rest.Rest{
-		Version:          sc.Version,
-		WorkerServiceURI: sc.WorkerServiceURL,
-		RemoteService:    engine,
-		Auth:             authService,
+		Version:           sc.Version,
+		WorkerServiceURI:  sc.WorkerServiceURL,
+		RemoteService:     engine,
+		Auth:              authService,
+		BlobPublicURL:     strings.TrimSuffix(sc.BlobServiceURL, "/"),
+		Uploads:           uploads,
+		Webhooks:          webhooks,
+		Watcher:           jobWatcher,
+		Retrier:           retrier,
+		Janitor:           jobsJanitor,
+		AdminToken:        sc.Admin.Token,
+		Batches:           batches,
+		Workflow:          jobsWorkflow,
+		BatchMaxItems:     sc.Batch.MaxItems,
+		BatchMaxSize:      sc.Batch.MaxSize,
+		BatchConcurrency:  sc.Batch.Concurrency,
+		Scheduler:         jobsScheduler,
+		Metrics:           jobsMetrics,
+		Tracer:            tracer,
+		Health:            checker,
+		Jobs:              jobs,
+		Breakers:          map[string]*utils.Breaker{"worker": breakers.worker, "blob": breakers.blob},
+		MaxBodySize:       sc.config.Server.MaxBodySize,
+		Throttle:          sc.config.Server.Throttle,
+		StreamThrottle:    sc.config.Server.StreamThrottle,
+		APIRate:           sc.config.Limits.APIRate,
+		PingRate:          sc.config.Limits.PingRate,
+		ReadHeaderTimeout: sc.config.Server.ReadHeaderTimeout,
+		WriteTimeout:      sc.config.Server.WriteTimeout,
+		IdleTimeout:       sc.config.Server.IdleTimeout,
+		TLSConfig:         serverTLS,
+		ShutdownTimeout:   sc.Drain.Timeout,
+	}
+	if sc.Push.Secret != "" {
+		rest.WorkerSigner = auth.NewRequestSigner(sc.Push.Secret, sc.Push.MaxSkew)
+	}
+	if sc.BlobSign.Secret != "" {
+		rest.URLSigner = auth.NewURLSigner(sc.BlobSign.Secret, sc.BlobSign.TTL)
+	}
+	if sc.BlobSign.PublicURL != "" {
+		rest.BlobPublicURL = strings.TrimSuffix(sc.BlobSign.PublicURL, "/")
+	}
+	quotas, err := sc.loadQuotas()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load quotas")
+	}
+	if quotas != nil {
+		rest.Quotas = quota.New(*quotas)
+		jobsScheduler.Quota = func(job model.Job) error {
+			return rest.Quotas.Consume(job.TenantID, 1, int64(job.PayloadSize))
+		}
+		log.Printf("[INFO] quotas are set up, tenants=%d", len(quotas.Tenants))
 	}
 
 	return &application{
 		ServerCommand: sc,
+		jobs:          jobs,
+		flags:         flagOpts,
+		jwtSecret:     sc.config.Auth.JWTSecret,
 		rest:          rest,
-		terminated:    make(chan struct{}),
+		webhooks:      webhooks,
+		watcher:       jobWatcher,
+		reconciler: &reconciler.Reconciler{
+			Store:     jobs,
+			Worker:    engine,
+			Interval:  sc.Reconcile.Interval,
+			BatchSize: sc.Reconcile.BatchSize,
+			Deadline:  sc.Reconcile.Deadline,
+		},
+		retrier:    retrier,
+		janitor:    jobsJanitor,
+		workflow:   jobsWorkflow,
+		scheduler:  jobsScheduler,
+		tracer:     tracer,
+		reloaders:  reloaders,
+		terminated: make(chan struct{}),
 	}, nil
 }
 
+//watchJanitor exports janitor counters, reclaimed bytes are reclaimable ones in dry run mode
+func watchJanitor(m *metrics.Metrics, j *janitor.Janitor) {
+	m.Registry.CounterFunc("dispatcher_janitor_runs_total", "Number of janitor passes.", "", func() map[string]float64 {
+		return map[string]float64{"": float64(j.Stats().Runs)}
+	})
+	m.Registry.CounterFunc("dispatcher_janitor_deleted_total", "Number of items removed by janitor by kind.", "kind",
+		func() map[string]float64 {
+			stats := j.Stats()
+			return map[string]float64{"jobs": float64(stats.DeletedJobs), "blobs": float64(stats.DeletedBlobs),
+				"batches": float64(stats.DeletedBatches)}
+		})
+	m.Registry.CounterFunc("dispatcher_janitor_reclaimed_bytes_total", "Size of blobs removed by janitor.", "",
+		func() map[string]float64 {
+			return map[string]float64{"": float64(j.Stats().ReclaimedBytes)}
+		})
+	m.Registry.CounterFunc("dispatcher_janitor_errors_total", "Number of janitor errors.", "", func() map[string]float64 {
+		return map[string]float64{"": float64(j.Stats().Errors)}
+	})
+}
+
+//watchBreakers exports state of upstream circuit breakers as 1 for open and 0 for closed or half-open breaker
+func watchBreakers(m *metrics.Metrics, b upstreamBreakers) {
+	m.Registry.GaugeFunc("dispatcher_upstream_breaker_open", "Circuit breaker of upstream is open.", "upstream",
+		func() map[string]float64 {
+			res := map[string]float64{}
+			for upstream, breaker := range map[string]*utils.Breaker{"worker": b.worker, "blob": b.blob} {
+				res[upstream] = 0
+				if breaker.State() == utils.BreakerOpen {
+					res[upstream] = 1
+				}
+			}
+			return res
+		})
+}
+
 func (app *application) Wait() {
 	<-app.terminated
-}
\ No newline at end of file
+}
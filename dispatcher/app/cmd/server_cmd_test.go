@@ -6,11 +6,15 @@ import (
 	"github.com/jessevdk/go-flags"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/certs"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
 	"go.uber.org/goleak"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -31,10 +35,55 @@ func TestServerApp(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "pong\n", string(body))
 
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/healthz", app.Port))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/readyz", app.Port))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "upstreams are not running")
+
 	cancel()
 	app.Wait()
 }
 
+func TestServerApp_Quota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "quota.yml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("default: {rate: 10, daily_jobs: 100}"), 0600))
+
+	app, _, cancel := buildListCmdOpts(t, func(o ServerCommand) ServerCommand {
+		o.Quota.File = file
+		return o
+	})
+	defer cancel()
+	require.NotNil(t, app.rest.Quotas)
+	assert.Equal(t, int64(100), app.rest.Quotas.Usage(1).Limits.DailyJobs)
+
+	cmd := app.ServerCommand
+	cmd.Quota.File = filepath.Join(dir, "missing.yml")
+	_, err = cmd.bootstrapApp()
+	assert.Contains(t, err.Error(), "failed to load quotas")
+}
+
+func TestServerApp_BlobPublicURL(t *testing.T) {
+	app, _, cancel := buildListCmdOpts(t, func(o ServerCommand) ServerCommand {
+		return o
+	})
+	cancel()
+	assert.Equal(t, "http://localhost:8080/api/v1", app.rest.BlobPublicURL, "blob service url is used by default")
+
+	app, _, cancel = buildListCmdOpts(t, func(o ServerCommand) ServerCommand {
+		o.BlobSign.PublicURL = "https://cdn.example.com/api/v1/"
+		return o
+	})
+	cancel()
+	assert.Equal(t, "https://cdn.example.com/api/v1", app.rest.BlobPublicURL)
+}
+
 func createAppFromCmd(t *testing.T, cmd ServerCommand) (*application, context.Context, context.CancelFunc) {
 	app, err := cmd.bootstrapApp()
 	require.NoError(t, err)
@@ -68,4 +117,89 @@ func buildListCmdOpts(t *testing.T, fn func(o ServerCommand) ServerCommand) (*ap
 func TestMain(m *testing.M) {
 	//Unknown reasons
 	goleak.VerifyTestMain(m, goleak.IgnoreTopFunction("net/http.(*Server).Shutdown"))
-}
\ No newline at end of file
+}
+
+func TestServerApp_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certPEM, keyPEM, err := certs.SelfSigned(time.Hour, "localhost")
+	require.NoError(t, err)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+
+	app, ctx, cancel := buildListCmdOpts(t, func(o ServerCommand) ServerCommand {
+		o.TLS.Cert, o.TLS.Key, o.TLS.ClientCA = certFile, keyFile, certFile
+		o.UpstreamTLS.CA, o.UpstreamTLS.Cert, o.UpstreamTLS.Key = certFile, certFile, keyFile
+		return o
+	})
+	require.NotNil(t, app.rest.TLSConfig)
+	assert.Len(t, app.reloaders, 2)
+
+	go func() { _ = app.run(ctx) }()
+	waitHTTPServer(app.Port)
+
+	cfg, err := certs.ClientConfig(certFile, nil)
+	require.NoError(t, err)
+	client := &http.Client{Transport: certs.Transport(cfg)}
+	resp, err := client.Get(fmt.Sprintf("https://localhost:%d/ping", app.Port))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	client.CloseIdleConnections()
+
+	cancel()
+	app.Wait()
+
+	tbl := []struct {
+		fn  func(o *ServerCommand)
+		err string
+	}{
+		{func(o *ServerCommand) { o.TLS.Cert = certFile }, "failed to set up server tls: server certificate and key must be set both"},
+		{func(o *ServerCommand) { o.TLS.ClientCA = certFile }, "failed to set up server tls: client CA requires server certificate"},
+		{func(o *ServerCommand) { o.TLS.Cert, o.TLS.Key = certFile, certFile }, "can't load certificate"},
+		{func(o *ServerCommand) { o.UpstreamTLS.Key = keyFile }, "failed to set up upstream tls: client certificate and key must be set both"},
+		{func(o *ServerCommand) { o.UpstreamTLS.CA = keyFile }, "has no PEM certificates"},
+	}
+	for i, tt := range tbl {
+		cmd := ServerCommand{}
+		tt.fn(&cmd)
+		_, err := cmd.bootstrapApp()
+		require.Error(t, err, "test case #%d", i)
+		assert.Contains(t, err.Error(), tt.err, "test case #%d", i)
+	}
+}
+
+type dispatcherFunc func(job model.Job) error
+
+func (f dispatcherFunc) DispatchJob(job model.Job) error { return f(job) }
+
+func TestServerApp_Drain(t *testing.T) {
+	app, ctx, cancel := buildListCmdOpts(t, func(o ServerCommand) ServerCommand {
+		o.Retry.Interval = time.Hour
+		o.Drain.Timeout = 5 * time.Second
+		return o
+	})
+	dispatched := make(chan string, 1)
+	app.retrier.Dispatcher = dispatcherFunc(func(job model.Job) error {
+		dispatched <- job.ID
+		return nil
+	})
+	later := time.Now().Add(time.Hour)
+	job := app.jobs.Create(model.Job{TenantID: 1, Status: "RETRYING", NextAttemptAt: &later})
+
+	go func() { _ = app.run(ctx) }()
+	waitHTTPServer(app.Port)
+	cancel()
+	app.Wait()
+
+	select {
+	case id := <-dispatched:
+		assert.Equal(t, job.ID, id, "retry is dispatched by drain before its backoff is passed")
+	default:
+		t.Fatal("job is not dispatched")
+	}
+	_, err := http.Get(fmt.Sprintf("http://localhost:%d/ping", app.Port))
+	assert.Error(t, err, "server is stopped")
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//maxRecordSize limits size of single NDJSON record, it is bigger than body limit of submit job api
+const maxRecordSize = 4 * 1024 * 1024
+
+//SubmitCommand submits NDJSON file of submit job requests to dispatcher, for backfills and load reproduction
+type SubmitCommand struct {
+	DispatcherURL string        `long:"url" env:"DISPATCHER_URL" default:"http://localhost:9000" description:"dispatcher url"`
+	Token         string        `long:"token" env:"SUBMIT_TOKEN" required:"true" description:"client JWT for submitting jobs"`
+	Input         string        `long:"input" short:"i" env:"SUBMIT_INPUT" required:"true" description:"NDJSON file with submit job request per line, stdin if -"`
+	Output        string        `long:"output" short:"o" env:"SUBMIT_OUTPUT" default:"results.ndjson" description:"NDJSON file of submit results, stdout if -"`
+	Concurrency   int           `long:"concurrency" short:"c" env:"SUBMIT_CONCURRENCY" default:"10" description:"number of parallel submit requests"`
+	Timeout       time.Duration `long:"timeout" env:"SUBMIT_TIMEOUT" default:"30s" description:"timeout of single submit request"`
+	CommonOptions
+}
+
+//submitResult is record of results file, it has either job id or error of input line
+type submitResult struct {
+	Line       int    `json:"line"`
+	ID         string `json:"id,omitempty"`
+	Status     string `json:"status,omitempty"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Code       string `json:"code,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+//Execute is the entry point for submit command
+func (sc *SubmitCommand) Execute(_ []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		select {
+		case <-stop:
+			log.Printf("[WARN] get interrupt signal, stop submitting")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	in, err := sc.openInput()
+	if err != nil {
+		return err
+	}
+	defer closeFile(in)
+	out, err := sc.openOutput()
+	if err != nil {
+		return err
+	}
+	defer closeFile(out)
+
+	total, failed, err := sc.submit(ctx, in, out)
+	log.Printf("[INFO] submitted %d records, failed %d", total, failed)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d records are failed, see %s", failed, total, sc.Output)
+	}
+	return nil
+}
+
+//submit posts every not blank line of input to dispatcher and writes results to output in order of input lines.
+//Returns number of submitted and failed records
+func (sc *SubmitCommand) submit(ctx context.Context, in io.Reader, out io.Writer) (total, failed int, err error) {
+	client := &http.Client{Timeout: sc.Timeout}
+	concurrency := sc.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	//results are queued in order of lines, so writer waits for the oldest one while others are in flight
+	pending := make(chan chan submitResult, concurrency)
+	sem := make(chan struct{}, concurrency)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(pending)
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+		line := 0
+		for scanner.Scan() {
+			line++
+			record := bytes.TrimSpace(scanner.Bytes())
+			if len(record) == 0 {
+				continue
+			}
+			if ctx.Err() != nil {
+				readErr <- ctx.Err()
+				return
+			}
+			res := make(chan submitResult, 1)
+			pending <- res
+			sem <- struct{}{}
+			go func(line int, record []byte) {
+				defer func() { <-sem }()
+				res <- sc.submitRecord(ctx, client, line, record)
+			}(line, append([]byte(nil), record...))
+		}
+		readErr <- scanner.Err()
+	}()
+
+	enc := json.NewEncoder(out)
+	for res := range pending {
+		result := <-res
+		total++
+		if result.Error != "" {
+			failed++
+		}
+		if err = enc.Encode(result); err != nil {
+			//drain in flight records to let reader finish
+			for res := range pending {
+				<-res
+			}
+			return total, failed, errors.Wrap(err, "can't write result")
+		}
+	}
+	if err = <-readErr; err != nil {
+		return total, failed, errors.Wrap(err, "can't read input")
+	}
+	return total, failed, nil
+}
+
+//submitRecord posts single record to submit job api
+func (sc *SubmitCommand) submitRecord(ctx context.Context, client *http.Client, line int, record []byte) submitResult {
+	res := submitResult{Line: line}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(sc.DispatcherURL, "/")+"/api/v1/job",
+		bytes.NewReader(record))
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+sc.Token)
+	resp, err := client.Do(req)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Printf("[WARN] can't close response body, %v", errClose)
+		}
+	}()
+	res.HTTPStatus = resp.StatusCode
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		errResp := struct {
+			Error  string `json:"error"`
+			Code   string `json:"code"`
+			Detail string `json:"detail"`
+		}{}
+		if err = json.Unmarshal(body, &errResp); err != nil || errResp.Error == "" {
+			errResp.Error = strings.TrimSpace(string(body))
+		}
+		if errResp.Error == "" {
+			errResp.Error = http.StatusText(resp.StatusCode)
+		}
+		res.Error, res.Code, res.Detail = errResp.Error, errResp.Code, errResp.Detail
+		return res
+	}
+
+	job := struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}{}
+	if err = json.Unmarshal(body, &job); err != nil {
+		res.Error = errors.Wrap(err, "can't decode job").Error()
+		return res
+	}
+	res.ID, res.Status = job.ID, job.Status
+	return res
+}
+
+func (sc *SubmitCommand) openInput() (*os.File, error) {
+	if sc.Input == "-" {
+		return os.Stdin, nil
+	}
+	f, err := os.Open(sc.Input)
+	return f, errors.Wrap(err, "can't open input")
+}
+
+func (sc *SubmitCommand) openOutput() (*os.File, error) {
+	if sc.Output == "-" {
+		return os.Stdout, nil
+	}
+	f, err := os.Create(sc.Output)
+	return f, errors.Wrap(err, "can't create output")
+}
+
+func closeFile(f *os.File) {
+	if f == os.Stdin || f == os.Stdout {
+		return
+	}
+	if err := f.Close(); err != nil {
+		log.Printf("[WARN] can't close %s, %v", f.Name(), err)
+	}
+}
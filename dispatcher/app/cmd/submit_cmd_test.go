@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startSubmitServer(t *testing.T) (*httptest.Server, *int32) {
+	var inFlight, maxInFlight int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		assert.Equal(t, "/api/v1/job", r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"title":"JWT is invalid","status":401,"error":"token is invalid","code":"INVALID_TOKEN","detail":"JWT is invalid"}`))
+			return
+		}
+		msg := struct {
+			MD5 string `json:"md5"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil || msg.MD5 == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"title":"MD5 hash sum of payload is not valid","status":400,"error":"MD5 hash sum is not valid","code":"MD5_MISMATCH","detail":"Error during md5 validation"}`))
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, `{"id":"%s","status":"RUNNING"}`, msg.MD5)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &maxInFlight
+}
+
+func decodeResults(t *testing.T, data []byte) []submitResult {
+	res := []submitResult{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		r := submitResult{}
+		require.NoError(t, json.Unmarshal([]byte(line), &r), line)
+		res = append(res, r)
+	}
+	return res
+}
+
+func TestSubmitCommand_Submit(t *testing.T) {
+	ts, maxInFlight := startSubmitServer(t)
+	input := &bytes.Buffer{}
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(input, "{\"encoding\":\"base64\",\"content\":\"MQo=\",\"md5\":\"%d\"}\n", i)
+		if i == 5 {
+			input.WriteString("\n{\"md5\":\"bad\"}\n{broken\n")
+		}
+	}
+	out := &bytes.Buffer{}
+	sc := SubmitCommand{DispatcherURL: ts.URL + "/", Token: "secret-token", Concurrency: 4, Timeout: time.Second}
+
+	total, failed, err := sc.submit(context.Background(), input, out)
+	require.NoError(t, err)
+	assert.Equal(t, 22, total)
+	assert.Equal(t, 2, failed)
+	assert.LessOrEqual(t, atomic.LoadInt32(maxInFlight), int32(4), "requests are limited by concurrency")
+
+	res := decodeResults(t, out.Bytes())
+	require.Equal(t, 22, len(res))
+	assert.Equal(t, submitResult{Line: 1, ID: "1", Status: "RUNNING", HTTPStatus: 201}, res[0])
+	assert.Equal(t, submitResult{Line: 7, HTTPStatus: 400, Error: "MD5 hash sum is not valid", Code: "MD5_MISMATCH",
+		Detail: "Error during md5 validation"}, res[5], "blank line is skipped")
+	assert.Equal(t, 8, res[6].Line)
+	assert.Equal(t, 400, res[6].HTTPStatus)
+	for i, r := range res[1:] {
+		assert.True(t, r.Line > res[i].Line, "results are in order of input")
+	}
+	assert.Equal(t, submitResult{Line: 23, ID: "20", Status: "RUNNING", HTTPStatus: 201}, res[21])
+}
+
+func TestSubmitCommand_Execute(t *testing.T) {
+	ts, _ := startSubmitServer(t)
+	dir, err := ioutil.TempDir("", "submit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "requests.jsonl")
+	require.NoError(t, ioutil.WriteFile(input, []byte(`{"md5":"1"}`+"\n"+`{"md5":"2"}`), 0600))
+
+	sc := SubmitCommand{DispatcherURL: ts.URL, Token: "secret-token", Input: input, Output: filepath.Join(dir, "res.ndjson"),
+		Concurrency: 2, Timeout: time.Second}
+	require.NoError(t, sc.Execute(nil))
+	data, err := ioutil.ReadFile(sc.Output)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(decodeResults(t, data)))
+
+	sc.Token = "wrong"
+	err = sc.Execute(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 of 2 records are failed")
+	data, err = ioutil.ReadFile(sc.Output)
+	require.NoError(t, err)
+	res := decodeResults(t, data)
+	assert.Equal(t, "token is invalid", res[0].Error)
+	assert.Equal(t, http.StatusUnauthorized, res[1].HTTPStatus)
+
+	sc.Input = filepath.Join(dir, "missing.jsonl")
+	assert.Error(t, sc.Execute(nil))
+}
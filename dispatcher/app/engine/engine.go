@@ -1,11 +1,26 @@
 package engine
 
 import (
+	"context"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"io"
+	"net/http"
 )
 
 type Interface interface {
 	SubmitJob(job model.Job) (*model.Job, error)
 	GetJob(id string) (*model.Job, error)
-	GetStatusJob(id string) (model.JobStatus, error)
+	GetStatusJob(id string) (model.WorkerStatus, error)
+	ReportJobStatus(id string, report model.StatusReport) (*model.Job, error)
+	DispatchJob(job model.Job) error
+	GetJobResult(ctx context.Context, location string, header http.Header) (*JobResult, error)
+	DeleteBlob(ctx context.Context, location string) (int64, error)
+	BlobSize(ctx context.Context, location string) (int64, error)
+}
+
+//JobResult is result blob of job streamed from blob service
+type JobResult struct {
+	Body       io.ReadCloser
+	StatusCode int
+	Header     http.Header
 }
@@ -4,7 +4,9 @@
 package engine
 
 import (
+	"context"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"net/http"
 	"sync"
 )
 
@@ -18,12 +20,27 @@ var _ Interface = &InterfaceMock{}
 //
 // 		// make and configure a mocked Interface
 // 		mockedInterface := &InterfaceMock{
+// 			BlobSizeFunc: func(ctx context.Context, location string) (int64, error) {
+// 				panic("mock out the BlobSize method")
+// 			},
+// 			DeleteBlobFunc: func(ctx context.Context, location string) (int64, error) {
+// 				panic("mock out the DeleteBlob method")
+// 			},
+// 			DispatchJobFunc: func(job model.Job) error {
+// 				panic("mock out the DispatchJob method")
+// 			},
 // 			GetJobFunc: func(id string) (*model.Job, error) {
 // 				panic("mock out the GetJob method")
 // 			},
-// 			GetStatusJobFunc: func(id string) (model.JobStatus, error) {
+// 			GetJobResultFunc: func(ctx context.Context, location string, header http.Header) (*JobResult, error) {
+// 				panic("mock out the GetJobResult method")
+// 			},
+// 			GetStatusJobFunc: func(id string) (model.WorkerStatus, error) {
 // 				panic("mock out the GetStatusJob method")
 // 			},
+// 			ReportJobStatusFunc: func(id string, report model.StatusReport) (*model.Job, error) {
+// 				panic("mock out the ReportJobStatus method")
+// 			},
 // 			SubmitJobFunc: func(job model.Job) (*model.Job, error) {
 // 				panic("mock out the SubmitJob method")
 // 			},
@@ -34,36 +51,192 @@ var _ Interface = &InterfaceMock{}
 //
 // 	}
 type InterfaceMock struct {
+	// BlobSizeFunc mocks the BlobSize method.
+	BlobSizeFunc func(ctx context.Context, location string) (int64, error)
+
+	// DeleteBlobFunc mocks the DeleteBlob method.
+	DeleteBlobFunc func(ctx context.Context, location string) (int64, error)
+
+	// DispatchJobFunc mocks the DispatchJob method.
+	DispatchJobFunc func(job model.Job) error
+
 	// GetJobFunc mocks the GetJob method.
 	GetJobFunc func(id string) (*model.Job, error)
 
+	// GetJobResultFunc mocks the GetJobResult method.
+	GetJobResultFunc func(ctx context.Context, location string, header http.Header) (*JobResult, error)
+
 	// GetStatusJobFunc mocks the GetStatusJob method.
-	GetStatusJobFunc func(id string) (model.JobStatus, error)
+	GetStatusJobFunc func(id string) (model.WorkerStatus, error)
+
+	// ReportJobStatusFunc mocks the ReportJobStatus method.
+	ReportJobStatusFunc func(id string, report model.StatusReport) (*model.Job, error)
 
 	// SubmitJobFunc mocks the SubmitJob method.
 	SubmitJobFunc func(job model.Job) (*model.Job, error)
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// BlobSize holds details about calls to the BlobSize method.
+		BlobSize []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Location is the location argument value.
+			Location string
+		}
+		// DeleteBlob holds details about calls to the DeleteBlob method.
+		DeleteBlob []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Location is the location argument value.
+			Location string
+		}
+		// DispatchJob holds details about calls to the DispatchJob method.
+		DispatchJob []struct {
+			// Job is the job argument value.
+			Job model.Job
+		}
 		// GetJob holds details about calls to the GetJob method.
 		GetJob []struct {
 			// ID is the id argument value.
 			ID string
 		}
+		// GetJobResult holds details about calls to the GetJobResult method.
+		GetJobResult []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Location is the location argument value.
+			Location string
+			// Header is the header argument value.
+			Header http.Header
+		}
 		// GetStatusJob holds details about calls to the GetStatusJob method.
 		GetStatusJob []struct {
 			// ID is the id argument value.
 			ID string
 		}
+		// ReportJobStatus holds details about calls to the ReportJobStatus method.
+		ReportJobStatus []struct {
+			// ID is the id argument value.
+			ID string
+			// Report is the report argument value.
+			Report model.StatusReport
+		}
 		// SubmitJob holds details about calls to the SubmitJob method.
 		SubmitJob []struct {
 			// Job is the job argument value.
 			Job model.Job
 		}
 	}
-	lockGetJob       sync.RWMutex
-	lockGetStatusJob sync.RWMutex
-	lockSubmitJob    sync.RWMutex
+	lockBlobSize        sync.RWMutex
+	lockDeleteBlob      sync.RWMutex
+	lockDispatchJob     sync.RWMutex
+	lockGetJob          sync.RWMutex
+	lockGetJobResult    sync.RWMutex
+	lockGetStatusJob    sync.RWMutex
+	lockReportJobStatus sync.RWMutex
+	lockSubmitJob       sync.RWMutex
+}
+
+// BlobSize calls BlobSizeFunc.
+func (mock *InterfaceMock) BlobSize(ctx context.Context, location string) (int64, error) {
+	if mock.BlobSizeFunc == nil {
+		panic("InterfaceMock.BlobSizeFunc: method is nil but Interface.BlobSize was just called")
+	}
+	callInfo := struct {
+		Ctx      context.Context
+		Location string
+	}{
+		Ctx:      ctx,
+		Location: location,
+	}
+	mock.lockBlobSize.Lock()
+	mock.calls.BlobSize = append(mock.calls.BlobSize, callInfo)
+	mock.lockBlobSize.Unlock()
+	return mock.BlobSizeFunc(ctx, location)
+}
+
+// BlobSizeCalls gets all the calls that were made to BlobSize.
+// Check the length with:
+//     len(mockedInterface.BlobSizeCalls())
+func (mock *InterfaceMock) BlobSizeCalls() []struct {
+	Ctx      context.Context
+	Location string
+} {
+	var calls []struct {
+		Ctx      context.Context
+		Location string
+	}
+	mock.lockBlobSize.RLock()
+	calls = mock.calls.BlobSize
+	mock.lockBlobSize.RUnlock()
+	return calls
+}
+
+// DeleteBlob calls DeleteBlobFunc.
+func (mock *InterfaceMock) DeleteBlob(ctx context.Context, location string) (int64, error) {
+	if mock.DeleteBlobFunc == nil {
+		panic("InterfaceMock.DeleteBlobFunc: method is nil but Interface.DeleteBlob was just called")
+	}
+	callInfo := struct {
+		Ctx      context.Context
+		Location string
+	}{
+		Ctx:      ctx,
+		Location: location,
+	}
+	mock.lockDeleteBlob.Lock()
+	mock.calls.DeleteBlob = append(mock.calls.DeleteBlob, callInfo)
+	mock.lockDeleteBlob.Unlock()
+	return mock.DeleteBlobFunc(ctx, location)
+}
+
+// DeleteBlobCalls gets all the calls that were made to DeleteBlob.
+// Check the length with:
+//     len(mockedInterface.DeleteBlobCalls())
+func (mock *InterfaceMock) DeleteBlobCalls() []struct {
+	Ctx      context.Context
+	Location string
+} {
+	var calls []struct {
+		Ctx      context.Context
+		Location string
+	}
+	mock.lockDeleteBlob.RLock()
+	calls = mock.calls.DeleteBlob
+	mock.lockDeleteBlob.RUnlock()
+	return calls
+}
+
+// DispatchJob calls DispatchJobFunc.
+func (mock *InterfaceMock) DispatchJob(job model.Job) error {
+	if mock.DispatchJobFunc == nil {
+		panic("InterfaceMock.DispatchJobFunc: method is nil but Interface.DispatchJob was just called")
+	}
+	callInfo := struct {
+		Job model.Job
+	}{
+		Job: job,
+	}
+	mock.lockDispatchJob.Lock()
+	mock.calls.DispatchJob = append(mock.calls.DispatchJob, callInfo)
+	mock.lockDispatchJob.Unlock()
+	return mock.DispatchJobFunc(job)
+}
+
+// DispatchJobCalls gets all the calls that were made to DispatchJob.
+// Check the length with:
+//     len(mockedInterface.DispatchJobCalls())
+func (mock *InterfaceMock) DispatchJobCalls() []struct {
+	Job model.Job
+} {
+	var calls []struct {
+		Job model.Job
+	}
+	mock.lockDispatchJob.RLock()
+	calls = mock.calls.DispatchJob
+	mock.lockDispatchJob.RUnlock()
+	return calls
 }
 
 // GetJob calls GetJobFunc.
@@ -97,8 +270,47 @@ func (mock *InterfaceMock) GetJobCalls() []struct {
 	return calls
 }
 
+// GetJobResult calls GetJobResultFunc.
+func (mock *InterfaceMock) GetJobResult(ctx context.Context, location string, header http.Header) (*JobResult, error) {
+	if mock.GetJobResultFunc == nil {
+		panic("InterfaceMock.GetJobResultFunc: method is nil but Interface.GetJobResult was just called")
+	}
+	callInfo := struct {
+		Ctx      context.Context
+		Location string
+		Header   http.Header
+	}{
+		Ctx:      ctx,
+		Location: location,
+		Header:   header,
+	}
+	mock.lockGetJobResult.Lock()
+	mock.calls.GetJobResult = append(mock.calls.GetJobResult, callInfo)
+	mock.lockGetJobResult.Unlock()
+	return mock.GetJobResultFunc(ctx, location, header)
+}
+
+// GetJobResultCalls gets all the calls that were made to GetJobResult.
+// Check the length with:
+//     len(mockedInterface.GetJobResultCalls())
+func (mock *InterfaceMock) GetJobResultCalls() []struct {
+	Ctx      context.Context
+	Location string
+	Header   http.Header
+} {
+	var calls []struct {
+		Ctx      context.Context
+		Location string
+		Header   http.Header
+	}
+	mock.lockGetJobResult.RLock()
+	calls = mock.calls.GetJobResult
+	mock.lockGetJobResult.RUnlock()
+	return calls
+}
+
 // GetStatusJob calls GetStatusJobFunc.
-func (mock *InterfaceMock) GetStatusJob(id string) (model.JobStatus, error) {
+func (mock *InterfaceMock) GetStatusJob(id string) (model.WorkerStatus, error) {
 	if mock.GetStatusJobFunc == nil {
 		panic("InterfaceMock.GetStatusJobFunc: method is nil but Interface.GetStatusJob was just called")
 	}
@@ -128,6 +340,41 @@ func (mock *InterfaceMock) GetStatusJobCalls() []struct {
 	return calls
 }
 
+// ReportJobStatus calls ReportJobStatusFunc.
+func (mock *InterfaceMock) ReportJobStatus(id string, report model.StatusReport) (*model.Job, error) {
+	if mock.ReportJobStatusFunc == nil {
+		panic("InterfaceMock.ReportJobStatusFunc: method is nil but Interface.ReportJobStatus was just called")
+	}
+	callInfo := struct {
+		ID     string
+		Report model.StatusReport
+	}{
+		ID:     id,
+		Report: report,
+	}
+	mock.lockReportJobStatus.Lock()
+	mock.calls.ReportJobStatus = append(mock.calls.ReportJobStatus, callInfo)
+	mock.lockReportJobStatus.Unlock()
+	return mock.ReportJobStatusFunc(id, report)
+}
+
+// ReportJobStatusCalls gets all the calls that were made to ReportJobStatus.
+// Check the length with:
+//     len(mockedInterface.ReportJobStatusCalls())
+func (mock *InterfaceMock) ReportJobStatusCalls() []struct {
+	ID     string
+	Report model.StatusReport
+} {
+	var calls []struct {
+		ID     string
+		Report model.StatusReport
+	}
+	mock.lockReportJobStatus.RLock()
+	calls = mock.calls.ReportJobStatus
+	mock.lockReportJobStatus.RUnlock()
+	return calls
+}
+
 // SubmitJob calls SubmitJobFunc.
 func (mock *InterfaceMock) SubmitJob(job model.Job) (*model.Job, error) {
 	if mock.SubmitJobFunc == nil {
@@ -2,129 +2,478 @@ package engine
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/tracing"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
+	"io/ioutil"
 	"log"
-	"strconv"
+	"net/http"
 	"sync"
+	"time"
 )
 
 type RestAPI struct {
 	WorkerServiceURL string
+	BlobServiceURL   string
 	lock             sync.Mutex
 	Client           utils.RepeaterInterface
+	BlobClient       *http.Client
+	Store            *store.Store
+	WorkerObserver   utils.RequestObserver //observes requests to worker service made by default client
+	Tracer           *tracing.Tracer       //traces store operations and requests to worker service made by default client
+	WorkerBreaker    *utils.Breaker        //breaker of requests to worker service made by default client
+	RequestTimeout   time.Duration         //timeout of request to worker service made by default client, 10s by default
+	RetryInterval    time.Duration         //interval of repeating failed request to worker service, 10s by default
+	WorkerTransport  http.RoundTripper     //transport of requests to worker service made by default client, e.g. with TLS config
 }
 
+//JobStatusResponse is status of job or problem details of error responded by worker or blob service
 type JobStatusResponse struct {
-	Status  int    `json:"status,omitempty"`
-	Error   string `json:"error,omitempty"`
-	Details string `json:"details,omitempty"`
+	Status         int    `json:"status,omitempty"`
+	ResultLocation string `json:"result_location,omitempty"`
+	Error          string `json:"error,omitempty"`
+	Detail         string `json:"detail,omitempty"`
 }
 
 type JobResponse struct {
 	model.Job
-	Error   string `json:"error"`
-	Details string `json:"details"`
+	Error  string `json:"error"`
+	Detail string `json:"detail"`
 }
 
-var store = map[string]model.Job{
-	"1": {ID: "1", TenantID: 1, ClientID: 1, PayloadLocation: "/blob/api/v1/1"},
-	"2": {ID: "2", TenantID: 2, ClientID: 2},
-	"3": {ID: "3", TenantID: 3, ClientID: 3},
+//dispatchRequest is job dispatched to worker service by retry or after its dependencies, job which was not
+//dispatched before has payload instead of its location
+type dispatchRequest struct {
+	ID              string            `json:"id"`
+	TenantID        int               `json:"tenant_id,omitempty"`
+	ClientID        int               `json:"client_id,omitempty"`
+	Payload         string            `json:"payload,omitempty"`
+	PayloadLocation string            `json:"payload_location"`
+	Operations      []model.Operation `json:"operations,omitempty"`
+	Attempt         int               `json:"attempt"`
 }
 
-//SubmitJob submit new image job
+//ErrJobFinished returned on attempt to change status of finished job
+var ErrJobFinished = errors.New("job is finished")
+
+//ErrStaleReport returned for status report with progress lower than progress of job, e.g. replayed one
+var ErrStaleReport = errors.New("status report is older than job state")
+
+var errNotChanged = errors.New("job is not changed")
+
+//ErrUpstreamUnavailable returned if request to worker or blob service can't be made
+var ErrUpstreamUnavailable = errors.New("upstream service is unavailable")
+
+//ErrUpstreamFailed returned if worker or blob service responded with error or invalid response
+var ErrUpstreamFailed = errors.New("upstream service failed")
+
+//upstreamError keeps message of failed request to worker or blob service, its kind is matched by errors.Is
+type upstreamError struct {
+	kind error
+	err  error
+}
+
+func (e *upstreamError) Error() string        { return e.err.Error() }
+func (e *upstreamError) Unwrap() error        { return e.err }
+func (e *upstreamError) Is(target error) bool { return target == e.kind }
+
+func unavailable(err error) error {
+	return &upstreamError{kind: ErrUpstreamUnavailable, err: err}
+}
+
+func failed(err error) error {
+	return &upstreamError{kind: ErrUpstreamFailed, err: err}
+}
+
+//seedJobs are jobs known by worker service mock
+var seedJobs = []model.Job{
+	{ID: "1", TenantID: 1, ClientID: 1, PayloadLocation: "/blob/api/v1/1", ResultLocation: "/blob/1"},
+	{ID: "2", TenantID: 2, ClientID: 2},
+	{ID: "3", TenantID: 3, ClientID: 3},
+}
+
+//SubmitJob submit new image job. Job is stored before submitting to pass its id to worker service,
+//so worker can push status of the job. Job with dependencies is only stored as WAITING with its payload
+//and dispatched later, scheduled job is stored as SCHEDULED till its not_before time
 func (r *RestAPI) SubmitJob(job model.Job) (*model.Job, error) {
+	if len(job.DependsOn) > 0 || job.NotBefore != nil || job.Cron != "" {
+		status := model.JobStatus(model.WAITING).ToString()
+		if job.NotBefore != nil {
+			status = model.JobStatus(model.SCHEDULED).ToString()
+		}
+		span := r.storeSpan(job, "create")
+		held := r.jobs().Create(model.Job{TenantID: job.TenantID, ClientID: job.ClientID, CallbackURL: job.CallbackURL,
+			PayloadSize: job.PayloadSize, Retry: job.Retry, Operations: job.Operations, DependsOn: job.DependsOn,
+			NotBefore: job.NotBefore, Cron: job.Cron, RequestID: job.RequestID, TraceParent: job.TraceParent,
+			HeldPayload: job.Payload, Status: status})
+		span.SetAttr("job_id", held.ID)
+		span.End()
+		log.Printf("[INFO] job is held as %s job_id=%s tenant_id=%d request_id=%s", held.Status, held.ID, held.TenantID, held.RequestID)
+		return &model.Job{ID: held.ID, Status: held.Status}, nil
+	}
+	span := r.storeSpan(job, "create")
+	created := r.jobs().Create(model.Job{TenantID: job.TenantID, ClientID: job.ClientID, CallbackURL: job.CallbackURL,
+		PayloadSize: job.PayloadSize, Retry: job.Retry, Operations: job.Operations, RequestID: job.RequestID,
+		TraceParent: job.TraceParent})
+	job.ID = created.ID
+	span.SetAttr("job_id", job.ID)
+	span.End()
 	body, err := json.Marshal(job)
 	if err != nil {
 		log.Printf("[ERROR] can not encode response body %#v", err)
-		return nil, nil
-	}
-	if r.Client == nil {
-		r.Client = &utils.Repeater{
-			ClientTimeout: 10,
-			Attempts:      10,
-			URI:           r.WorkerServiceURL + "/job",
-			Count:         3,
-		}
+		r.discard(job.ID)
+		return nil, err
 	}
-	defer func() {
-		r.Client = nil
-	}()
-	res, err := r.Client.MakeRequest(utils.POST, bytes.NewBuffer(body))
+	start := time.Now()
+	res, err := r.client(r.WorkerServiceURL+"/job", job).MakeRequest(utils.POST, bytes.NewBuffer(body))
 	if err != nil {
 		log.Printf("[ERROR] can not make request to get status with error: %#v", err)
-		return nil, err
+		r.discard(job.ID)
+		return nil, requestError(err)
 	}
 	jsr := &JobResponse{}
 	if err = json.NewDecoder(bytes.NewReader(res)).Decode(&jsr); err != nil {
 		log.Printf("[ERROR] can not decode response body %#v", err)
-		return nil, err
+		r.discard(job.ID)
+		return nil, failed(err)
 	}
 
 	if jsr.Error != "" {
 		err := errors.New(jsr.Error)
-		return nil, errors.Wrap(err, jsr.Details)
+		r.discard(job.ID)
+		return nil, failed(errors.Wrap(err, jsr.Detail))
 	}
-	r.lock.Lock() //Workaround only for PoC do not get collision in case of parallel request
-	jsr.Job.ID = strconv.Itoa(len(store) + 1)
-	jsr.Job.TenantID = job.TenantID
-	jsr.Job.ClientID = job.ClientID
-	store[jsr.Job.ID] = jsr.Job
-	r.lock.Unlock()
-	return &model.Job{ID: jsr.ID}, nil
+	if jsr.PayloadLocation != "" {
+		span = r.storeSpan(job, "update")
+		_, err = r.jobs().Update(job.ID, func(j *model.Job) error {
+			j.PayloadLocation = jsr.PayloadLocation
+			return nil
+		})
+		span.Fail(err)
+		span.End()
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("[INFO] job is submitted to worker upstream=worker latency=%s job_id=%s tenant_id=%d request_id=%s",
+		time.Since(start), job.ID, job.TenantID, job.RequestID)
+	return &model.Job{ID: job.ID}, nil
 }
 
-//GetJob get job object
-func (r *RestAPI) GetJob(id string) (*model.Job, error) {
-	status, err := r.GetStatusJob(id)
-	//In case if job created by API put status as static RUNNING
+//DispatchJob submits stored job to worker service once more. Job payload is not kept by dispatcher,
+//so worker gets location of payload stored on the first submit. Held payload of job which was never
+//dispatched is sent instead and dropped once worker stored it
+func (r *RestAPI) DispatchJob(job model.Job) error {
+	body, err := json.Marshal(dispatchRequest{ID: job.ID, TenantID: job.TenantID, ClientID: job.ClientID,
+		Payload: job.HeldPayload, PayloadLocation: job.PayloadLocation, Operations: job.Operations,
+		Attempt: len(job.Attempts) + 1})
 	if err != nil {
-		log.Printf("[ERROR] can not make request to get status with id: %s, error: %#v", id, err)
-		status = 1
+		return errors.Wrapf(err, "can not encode job %s", job.ID)
 	}
-	if _, ok := store[id]; !ok {
-		log.Printf("[ERROR] no job with id: %s, error: %#v", id, ok)
-		return nil, fmt.Errorf("no job with id: %s", id)
+	start := time.Now()
+	res, err := r.client(r.WorkerServiceURL+"/job", job).MakeRequest(utils.POST, bytes.NewBuffer(body))
+	if err != nil {
+		return errors.Wrapf(requestError(err), "can not dispatch job %s to worker", job.ID)
 	}
-	job := store[id]
-	if job.Status != status.ToString() {
-		job.Status = status.ToString()
+	jsr := &JobResponse{}
+	if err = json.NewDecoder(bytes.NewReader(res)).Decode(&jsr); err != nil {
+		return errors.Wrapf(failed(err), "can not decode worker response for job %s", job.ID)
 	}
-	return &job, nil
+	if jsr.Error != "" {
+		return failed(errors.Wrap(errors.New(jsr.Error), jsr.Detail))
+	}
+	log.Printf("[INFO] job is dispatched to worker, attempt %d upstream=worker latency=%s job_id=%s tenant_id=%d request_id=%s",
+		len(job.Attempts)+1, time.Since(start), job.ID, job.TenantID, job.RequestID)
+	if job.HeldPayload != "" {
+		span := r.storeSpan(job, "update")
+		defer span.End()
+		_, err = r.jobs().Update(job.ID, func(j *model.Job) error {
+			j.PayloadLocation = jsr.PayloadLocation
+			j.HeldPayload = ""
+			return nil
+		})
+		span.Fail(err)
+		return errors.Wrapf(err, "can not save payload location of job %s", job.ID)
+	}
+	return nil
 }
 
-//GetStatusJob get job status
-func (r *RestAPI) GetStatusJob(id string) (model.JobStatus, error) {
-	if r.Client == nil {
-		r.Client = &utils.Repeater{
-			ClientTimeout: 10,
-			Attempts:      10,
-			URI:           r.WorkerServiceURL + "/job/" + id + "/status",
-			Count:         3,
+//ReportJobStatus writes job state pushed by worker service to store. Finished job can't be changed,
+//repeated report of the same finished status is accepted to make retries of worker safe
+func (r *RestAPI) ReportJobStatus(id string, report model.StatusReport) (*model.Job, error) {
+	job, err := r.jobs().Update(id, func(j *model.Job) error {
+		if model.IsFinished(j.Status) {
+			if j.Status == report.Status {
+				return errNotChanged
+			}
+			return errors.Wrapf(ErrJobFinished, "job %s is %s", id, j.Status)
 		}
+		if j.Status == model.JobStatus(model.RETRYING).ToString() {
+			//report of the failed attempt came after it was already written
+			return errors.Wrapf(ErrJobFinished, "attempt of job %s is finished, job is %s", id, j.Status)
+		}
+		if !model.IsFinished(report.Status) && report.Progress < j.Progress {
+			return errors.Wrapf(ErrStaleReport, "progress %d of job %s is lower than current %d", report.Progress, id, j.Progress)
+		}
+		j.Status = report.Status
+		j.Progress = report.Progress
+		j.Stage = report.Stage
+		j.Error = report.JobError()
+		if report.ResultLocation != "" {
+			j.ResultLocation = report.ResultLocation
+		}
+		if j.Status == model.JobStatus(model.SUCCESS).ToString() {
+			j.Progress = 100
+		}
+		return nil
+	})
+	if err == errNotChanged {
+		job, err = r.jobs().Get(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+//discard removes job which was not accepted by worker
+func (r *RestAPI) discard(id string) {
+	if err := r.jobs().Delete(id); err != nil {
+		log.Printf("[WARN] can not remove not submitted job %s, %v", id, err)
+	}
+}
+
+//GetJob get job object from dispatcher store, its status is synced with worker by reconciler
+func (r *RestAPI) GetJob(id string) (*model.Job, error) {
+	job, err := r.jobs().Get(id)
+	if err != nil {
+		log.Printf("[ERROR] no job with id: %s, error: %v", id, err)
+		return nil, err
+	}
+	return &job, nil
+}
+
+//GetStatusJob get job status and result location from worker service, request has id and span of request which
+//submitted the job
+func (r *RestAPI) GetStatusJob(id string) (model.WorkerStatus, error) {
+	job, _ := r.jobs().Get(id)
+	res, err := r.client(r.WorkerServiceURL+"/job/"+id+"/status", job).MakeRequest(utils.GET, nil)
+	if err == nil && res == nil {
+		err = errors.New("empty response")
 	}
-	defer func() {
-		r.Client = nil
-	}()
-	res, err := r.Client.MakeRequest(utils.GET, nil)
-	if err != nil || res == nil {
+	if err != nil {
 		log.Printf("[ERROR] can not make request to get status with id: %s, error: %#v", id, err)
-		return -1, err
+		return model.WorkerStatus{Status: -1}, errors.Wrapf(requestError(err), "can not get status of job %s from worker", id)
 	}
 
 	jsr := &JobStatusResponse{}
 	if err = json.NewDecoder(bytes.NewReader(res)).Decode(&jsr); err != nil {
 		log.Printf("[ERROR] can not decode response body %#v", err)
-		return -1, err
+		return model.WorkerStatus{Status: -1}, failed(err)
 	}
 
 	if jsr.Error != "" {
 		err := errors.New(jsr.Error)
-		return -1, errors.Wrap(err, jsr.Details)
+		return model.WorkerStatus{Status: -1}, failed(errors.Wrap(err, jsr.Detail))
+	}
+	return model.WorkerStatus{Status: model.JobStatus(jsr.Status), ResultLocation: jsr.ResultLocation}, nil
+}
+
+//client returns Client if it is set or new repeater for uri passing request id and span of job. Client is never reset,
+//so calls can be made concurrently
+func (r *RestAPI) client(uri string, job model.Job) utils.RepeaterInterface {
+	if r.Client != nil {
+		return r.Client
+	}
+	repeater := &utils.Repeater{
+		ClientTimeout: repeaterSeconds(r.RequestTimeout),
+		Attempts:      repeaterSeconds(r.RetryInterval),
+		URI:           uri,
+		Count:         3,
+		Observer:      r.WorkerObserver,
+		Tracer:        r.Tracer,
+		Breaker:       r.WorkerBreaker,
+		Transport:     r.WorkerTransport,
+	}
+	if job.RequestID != "" {
+		repeater.Headers = http.Header{logging.HeaderRequestID: []string{job.RequestID}}
+	}
+	repeater.Trace, _ = tracing.ParseTraceParent(job.TraceParent)
+	return repeater
+}
+
+//repeaterSeconds converts duration to whole seconds which Repeater expects, 10 seconds by default
+func repeaterSeconds(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 10
+	}
+	return (d + time.Second - 1) / time.Second
+}
+
+//storeSpan starts span of store operation on job, it is child of span which submitted the job
+func (r *RestAPI) storeSpan(job model.Job, operation string) *tracing.Span {
+	parent, _ := tracing.ParseTraceParent(job.TraceParent)
+	span := r.Tracer.StartSpan(parent, "store."+operation, tracing.KindInternal)
+	span.SetAttr("tenant_id", job.TenantID)
+	if job.ID != "" {
+		span.SetAttr("job_id", job.ID)
+	}
+	return span
+}
+
+//NewStore makes job store with jobs known by worker service mock
+func NewStore() *store.Store {
+	return store.New(seedJobs...)
+}
+
+//jobs returns Store, it is initialised by seed jobs if not set
+func (r *RestAPI) jobs() *store.Store {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.Store == nil {
+		r.Store = NewStore()
+	}
+	return r.Store
+}
+
+//resultHeaders are request headers passed through to blob service for conditional and partial requests
+var resultHeaders = []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"}
+
+//GetJobResult open stream of job result blob from blob service. Caller must close JobResult.Body
+func (r *RestAPI) GetJobResult(ctx context.Context, location string, header http.Header) (*JobResult, error) {
+	client := r.BlobClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.BlobServiceURL+location, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can not create request to blob service for %s", location)
+	}
+	for _, h := range resultHeaders {
+		if v := header.Get(h); v != "" {
+			request.Header.Set(h, v)
+		}
+	}
+	setRequestID(ctx, request)
+
+	response, err := client.Do(request)
+	if err != nil {
+		log.Printf("[ERROR] can not make request to get result blob %s, error: %#v", location, err)
+		return nil, errors.Wrapf(unavailable(err), "can not get result blob %s", location)
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified, http.StatusRequestedRangeNotSatisfiable:
+		return &JobResult{Body: response.Body, StatusCode: response.StatusCode, Header: response.Header}, nil
+	}
+
+	return nil, blobError(response, location)
+}
+
+//ErrBlobNotFound returned for location unknown to blob service
+var ErrBlobNotFound = errors.New("blob not found")
+
+//DeleteBlob removes blob from blob service and returns its size in bytes
+func (r *RestAPI) DeleteBlob(ctx context.Context, location string) (int64, error) {
+	response, err := r.blobRequest(ctx, http.MethodDelete, location)
+	if err != nil {
+		return 0, errors.Wrapf(unavailable(err), "can not delete blob %s", location)
+	}
+	if response.StatusCode == http.StatusNotFound {
+		closeBody(response)
+		return 0, errors.Wrapf(ErrBlobNotFound, "no blob %s", location)
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, blobError(response, location)
+	}
+	defer closeBody(response)
+	res := struct {
+		Size int64 `json:"size"`
+	}{}
+	if err = json.NewDecoder(response.Body).Decode(&res); err != nil {
+		return 0, errors.Wrapf(err, "can not decode blob service response for %s", location)
+	}
+	return res.Size, nil
+}
+
+//BlobSize returns size of blob in bytes from blob service without downloading it
+func (r *RestAPI) BlobSize(ctx context.Context, location string) (int64, error) {
+	response, err := r.blobRequest(ctx, http.MethodHead, location)
+	if err != nil {
+		return 0, errors.Wrapf(unavailable(err), "can not get size of blob %s", location)
+	}
+	if response.StatusCode == http.StatusNotFound {
+		closeBody(response)
+		return 0, errors.Wrapf(ErrBlobNotFound, "no blob %s", location)
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, blobError(response, location)
+	}
+	closeBody(response)
+	return response.ContentLength, nil
+}
+
+func (r *RestAPI) blobRequest(ctx context.Context, method, location string) (*http.Response, error) {
+	client := r.BlobClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	request, err := http.NewRequestWithContext(ctx, method, r.BlobServiceURL+location, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRequestID(ctx, request)
+	return client.Do(request)
+}
+
+//setRequestID passes id of request from context to blob service
+func setRequestID(ctx context.Context, request *http.Request) {
+	if id := logging.RequestID(ctx); id != "" {
+		request.Header.Set(logging.HeaderRequestID, id)
+	}
+}
+
+func closeBody(response *http.Response) {
+	if errClose := response.Body.Close(); errClose != nil {
+		log.Printf("[ERROR] can not close response body %#v", errClose)
+	}
+}
+
+//blobError closes not successful response of blob service and makes error from it
+func blobError(response *http.Response, location string) error {
+	body, err := ioutil.ReadAll(response.Body)
+	closeBody(response)
+	if err != nil {
+		return failed(errors.Wrapf(err, "can not read blob service response for %s", location))
+	}
+	return statusError(response.StatusCode, body,
+		errors.Errorf("blob service responded with status %d for %s", response.StatusCode, location))
+}
+
+//requestError classifies error of request made by repeater, failed request and 5xx response mean upstream
+//is unavailable, other error status means upstream rejected the request
+func requestError(err error) error {
+	statusErr := &utils.StatusError{}
+	if !errors.As(err, &statusErr) {
+		return unavailable(err)
+	}
+	return statusError(statusErr.Code, statusErr.Body, err)
+}
+
+//statusError makes error of upstream response with error status, message is taken from its problem details
+//if response has them. 5xx response means upstream is unavailable and other statuses mean it failed the request
+func statusError(code int, body []byte, err error) error {
+	jsr := &JobStatusResponse{}
+	if json.Unmarshal(body, jsr) == nil && jsr.Error != "" {
+		err = errors.Wrap(errors.New(jsr.Error), jsr.Detail)
+	}
+	if code >= http.StatusInternalServerError {
+		return unavailable(err)
 	}
-	return model.JobStatus(jsr.Status), nil
+	return failed(err)
 }
@@ -1,23 +1,35 @@
 package engine
 
 import (
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/tracing"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRestAPI_GetStatusJob(t *testing.T) {
 	repeaterMock := &utils.RepeaterInterfaceMock{
 		MakeRequestFunc: func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
-			return []byte(`{"status": 1}`), nil
+			return []byte(`{"status": 1, "result_location": "/blob/1"}`), nil
 		},
 	}
 	c := RestAPI{WorkerServiceURL: "http://localhost", Client: repeaterMock,}
 	res, err := c.GetStatusJob("1")
 	assert.NoError(t, err)
-	assert.Equal(t, model.JobStatus(1), res)
+	assert.Equal(t, model.WorkerStatus{Status: model.JobStatus(1), ResultLocation: "/blob/1"}, res)
 	if len(repeaterMock.MakeRequestCalls()) != 1 {
 		t.Errorf("[ERROR] makeRequest was called %d times", len(repeaterMock.MakeRequestCalls()))
 	}
@@ -34,6 +46,10 @@ func TestRestAPI_SubmitJob(t *testing.T) {
 	res, err := c.SubmitJob(model.Job{TenantID:1, ClientID:2, Payload:"123", PayloadSize:3})
 	assert.NoError(t, err)
 	assert.Equal(t, &model.Job{ID:"4"}, res)
+	stored, err := c.GetJob("4")
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNING", stored.Status)
+	assert.Equal(t, 1, stored.TenantID)
 	if len(repeaterMock.MakeRequestCalls()) != 1 {
 		t.Errorf("[ERROR] makeRequest was called %d times", len(repeaterMock.MakeRequestCalls()))
 	}
@@ -49,9 +65,337 @@ func TestRestAPI_GetJob(t *testing.T) {
 	c := RestAPI{WorkerServiceURL: "http://localhost", Client: repeaterMock}
 	res, err := c.GetJob("3")
 	assert.NoError(t, err)
-	assert.Equal(t, &model.Job{ID: "3", TenantID:3, ClientID:3, Status:"FAILED"}, res)
-	if len(repeaterMock.MakeRequestCalls()) != 1 {
+	assert.NotNil(t, res.CreatedAt)
+	res.CreatedAt, res.UpdatedAt = nil, nil
+	assert.Equal(t, &model.Job{ID: "3", TenantID:3, ClientID:3, Status:"RUNNING"}, res)
+	if len(repeaterMock.MakeRequestCalls()) != 0 {
 		t.Errorf("[ERROR] makeRequest was called %d times", len(repeaterMock.MakeRequestCalls()))
 	}
 	t.Logf("%v %T", res, res)
-}
\ No newline at end of file
+
+	_, err = c.GetJob("10")
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+}
+
+func TestRestAPI_GetJobResult(t *testing.T) {
+	content := []byte("0123456789")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/blob/1", r.URL.Path)
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"etag1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	c := RestAPI{BlobServiceURL: ts.URL}
+
+	tbl := []struct {
+		header http.Header
+		code   int
+		body   string
+	}{
+		{http.Header{}, http.StatusOK, "0123456789"},
+		{http.Header{"Range": []string{"bytes=2-4"}}, http.StatusPartialContent, "234"},
+		{http.Header{"If-None-Match": []string{`"etag1"`}}, http.StatusNotModified, ""},
+		{http.Header{"Range": []string{"bytes=20-30"}}, http.StatusRequestedRangeNotSatisfiable, ""},
+	}
+	for i, tt := range tbl {
+		res, err := c.GetJobResult(context.Background(), "/blob/1", tt.header)
+		require.NoError(t, err, "test case #%d", i)
+		body, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.NoError(t, res.Body.Close())
+		assert.Equal(t, tt.code, res.StatusCode, "test case #%d", i)
+		assert.Equal(t, `"etag1"`, res.Header.Get("ETag"), "test case #%d", i)
+		if tt.code != http.StatusRequestedRangeNotSatisfiable {
+			assert.Equal(t, tt.body, string(body), "test case #%d", i)
+		}
+	}
+}
+
+func TestRestAPI_RequestID(t *testing.T) {
+	var lock sync.Mutex
+	ids := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ids[r.Method+" "+r.URL.Path] = r.Header.Get(logging.HeaderRequestID)
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/job":
+			_, _ = w.Write([]byte(`{"payload_location":"/images/blob/1"}`))
+		case "/job/1/status":
+			_, _ = w.Write([]byte(`{"status":1}`))
+		default:
+			w.Header().Set("Content-Length", "3")
+		}
+	}))
+	defer ts.Close()
+	c := RestAPI{WorkerServiceURL: ts.URL, BlobServiceURL: ts.URL, Store: store.New()}
+
+	res, err := c.SubmitJob(model.Job{TenantID: 1, Payload: "MQo=", RequestID: "req-1"})
+	require.NoError(t, err)
+	stored, err := c.GetJob(res.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "req-1", stored.RequestID)
+	require.NoError(t, c.DispatchJob(*stored))
+	_, err = c.GetStatusJob("1")
+	require.NoError(t, err)
+	_, err = c.BlobSize(logging.WithRequestID(context.Background(), "req-2"), "/blob/1")
+	require.NoError(t, err)
+	_, err = c.BlobSize(context.Background(), "/blob/2")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"POST /job": "req-1", "GET /job/1/status": "req-1", "HEAD /blob/1": "req-2",
+		"HEAD /blob/2": ""}, ids)
+}
+
+func TestRestAPI_Trace(t *testing.T) {
+	var lock sync.Mutex
+	parents := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		parents[r.Method+" "+r.URL.Path] = r.Header.Get(tracing.HeaderTraceParent)
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/job":
+			_, _ = w.Write([]byte(`{"payload_location":"/images/blob/1"}`))
+		default:
+			w.Header().Set("Content-Length", "3")
+		}
+	}))
+	defer ts.Close()
+	exporter := &tracing.MemoryExporter{}
+	tracer := &tracing.Tracer{Exporter: exporter}
+	c := RestAPI{WorkerServiceURL: ts.URL, BlobServiceURL: ts.URL, Store: store.New(), Tracer: tracer,
+		BlobClient: &http.Client{Transport: tracer.Transport("blob", nil)}}
+
+	ctx, submit := tracer.Start(context.Background(), "POST /api/v1/job")
+	res, err := c.SubmitJob(model.Job{TenantID: 1, Payload: "MQo=", TraceParent: submit.Context().TraceParent()})
+	require.NoError(t, err)
+	_, err = c.BlobSize(ctx, "/blob/1")
+	require.NoError(t, err)
+	submit.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 5)
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+		assert.Equal(t, submit.Context().TraceID, span.TraceID, span.Name)
+	}
+	assert.Equal(t, []string{"store.create", "POST", "store.update", "HEAD blob", "POST /api/v1/job"}, names)
+	assert.Equal(t, res.ID, spans[0].Attributes["job_id"])
+	assert.Equal(t, 1, spans[1].Attributes["attempt"])
+	assert.Equal(t, http.StatusOK, spans[1].Attributes["http.status_code"])
+	assert.Equal(t, "00-"+spans[1].TraceID+"-"+spans[1].SpanID+"-01", parents["POST /job"], "worker gets span of attempt")
+	assert.Equal(t, "00-"+spans[3].TraceID+"-"+spans[3].SpanID+"-01", parents["HEAD /blob/1"], "blob gets span of request")
+
+	stored, err := c.GetJob(res.ID)
+	require.NoError(t, err)
+	require.NoError(t, c.DispatchJob(*stored))
+	spans = exporter.Spans()
+	require.Len(t, spans, 6)
+	assert.Equal(t, submit.Context().SpanID, spans[5].ParentSpanID, "dispatch of stored job is child of submit span")
+}
+
+func TestRestAPI_GetJobResultError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"no blob","code":"BLOB_NOT_FOUND","detail":"error getting image"}`))
+	}))
+	defer ts.Close()
+	c := RestAPI{BlobServiceURL: ts.URL}
+	_, err := c.GetJobResult(context.Background(), "/blob/5", http.Header{})
+	require.Error(t, err)
+	assert.Equal(t, "error getting image: no blob", err.Error())
+	assert.True(t, errors.Is(err, ErrUpstreamFailed))
+
+	ts.Close()
+	_, err = c.GetJobResult(context.Background(), "/blob/5", http.Header{})
+	assert.True(t, errors.Is(err, ErrUpstreamUnavailable))
+}
+
+func TestRestAPI_DeleteBlob(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/blob/1" && r.Method == http.MethodDelete:
+			_, _ = w.Write([]byte(`{"size":1024}`))
+		case r.URL.Path == "/blob/1" && r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "1024")
+		case r.URL.Path == "/blob/2":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"store is overloaded","code":"INTERNAL","detail":"error removing image"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	c := RestAPI{BlobServiceURL: ts.URL}
+
+	size, err := c.DeleteBlob(context.Background(), "/blob/1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), size)
+	size, err = c.BlobSize(context.Background(), "/blob/1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), size)
+
+	_, err = c.DeleteBlob(context.Background(), "/blob/2")
+	assert.EqualError(t, err, "error removing image: store is overloaded")
+	assert.True(t, errors.Is(err, ErrUpstreamUnavailable), "5xx response means blob service is unavailable")
+	_, err = c.BlobSize(context.Background(), "/blob/2")
+	assert.EqualError(t, err, "blob service responded with status 500 for /blob/2")
+
+	_, err = c.DeleteBlob(context.Background(), "/blob/3")
+	assert.True(t, errors.Is(err, ErrBlobNotFound))
+	_, err = c.BlobSize(context.Background(), "/blob/3")
+	assert.True(t, errors.Is(err, ErrBlobNotFound))
+}
+
+func TestRestAPI_SubmitJobError(t *testing.T) {
+	repeaterMock := &utils.RepeaterInterfaceMock{
+		MakeRequestFunc: func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+			body, err := ioutil.ReadAll(data)
+			require.NoError(t, err)
+			assert.Contains(t, string(body), `"id":"4"`, "job id is sent to worker")
+			return []byte(`{"error":"blob service is unavailable","detail":"error during request to blob service"}`), nil
+		},
+	}
+	c := RestAPI{WorkerServiceURL: "http://localhost", Client: repeaterMock}
+	_, err := c.SubmitJob(model.Job{TenantID: 1, ClientID: 2, Payload: "123", PayloadSize: 3})
+	assert.EqualError(t, err, "error during request to blob service: blob service is unavailable")
+	assert.True(t, errors.Is(err, ErrUpstreamFailed), "error of worker is upstream failure")
+	_, err = c.GetJob("4")
+	assert.True(t, errors.Is(err, store.ErrNotFound), "not submitted job is removed")
+}
+
+func TestRestAPI_DispatchJob(t *testing.T) {
+	var body []byte
+	repeaterMock := &utils.RepeaterInterfaceMock{
+		MakeRequestFunc: func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+			var err error
+			body, err = ioutil.ReadAll(data)
+			require.NoError(t, err)
+			return []byte(`{"payload_location":"/blob/api/v1/5"}`), nil
+		},
+	}
+	c := RestAPI{WorkerServiceURL: "http://localhost", Client: repeaterMock}
+	job := model.Job{ID: "5", TenantID: 1, ClientID: 2, PayloadLocation: "/blob/api/v1/5", Attempts: []model.Attempt{{Number: 1}},
+		Operations: []model.Operation{{Type: "thumbnail", Params: map[string]interface{}{"size": 64}}}}
+	require.NoError(t, c.DispatchJob(job))
+	assert.JSONEq(t, `{"id":"5","tenant_id":1,"client_id":2,"payload_location":"/blob/api/v1/5","attempt":2,
+		"operations":[{"type":"thumbnail","params":{"size":64}}]}`, string(body))
+	assert.Equal(t, utils.Method(utils.POST), repeaterMock.MakeRequestCalls()[0].HttpMethod)
+
+	repeaterMock.MakeRequestFunc = func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+		return []byte(`{"error":"blob service is unavailable","detail":"error during request to blob service"}`), nil
+	}
+	assert.EqualError(t, c.DispatchJob(job), "error during request to blob service: blob service is unavailable")
+	repeaterMock.MakeRequestFunc = func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+		return nil, errors.New("connection refused")
+	}
+	err := c.DispatchJob(job)
+	assert.EqualError(t, err, "can not dispatch job 5 to worker: connection refused")
+	assert.True(t, errors.Is(err, ErrUpstreamUnavailable))
+
+	repeaterMock.MakeRequestFunc = func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+		return nil, &utils.StatusError{Code: http.StatusServiceUnavailable,
+			Body: []byte(`{"error":"worker is draining","code":"UPSTREAM_UNAVAILABLE","detail":"retry later"}`)}
+	}
+	err = c.DispatchJob(job)
+	assert.EqualError(t, err, "can not dispatch job 5 to worker: retry later: worker is draining")
+	assert.True(t, errors.Is(err, ErrUpstreamUnavailable), "5xx response means worker is unavailable")
+
+	repeaterMock.MakeRequestFunc = func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+		return nil, &utils.StatusError{Code: http.StatusBadRequest, Body: []byte(`bad request`)}
+	}
+	err = c.DispatchJob(job)
+	assert.EqualError(t, err, "can not dispatch job 5 to worker: upstream responded with status 400")
+	assert.True(t, errors.Is(err, ErrUpstreamFailed), "4xx response means worker rejected the job")
+}
+
+func TestRestAPI_SubmitJobWithDependencies(t *testing.T) {
+	var body []byte
+	repeaterMock := &utils.RepeaterInterfaceMock{
+		MakeRequestFunc: func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+			var err error
+			body, err = ioutil.ReadAll(data)
+			require.NoError(t, err)
+			return []byte(`{"payload_location":"/images/blob/5"}`), nil
+		},
+	}
+	c := RestAPI{WorkerServiceURL: "http://localhost", Client: repeaterMock, Store: store.New(model.Job{ID: "4"})}
+	res, err := c.SubmitJob(model.Job{TenantID: 1, Payload: "MQo=", PayloadSize: 4, DependsOn: []string{"4"}})
+	require.NoError(t, err)
+	assert.Equal(t, &model.Job{ID: "5", Status: "WAITING"}, res)
+	assert.Empty(t, repeaterMock.MakeRequestCalls(), "waiting job is not sent to worker")
+	stored, err := c.GetJob("5")
+	require.NoError(t, err)
+	assert.Equal(t, "MQo=", stored.HeldPayload)
+	assert.Equal(t, []string{"4"}, stored.DependsOn)
+
+	require.NoError(t, c.DispatchJob(*stored))
+	assert.JSONEq(t, `{"id":"5","tenant_id":1,"payload":"MQo=","payload_location":"","attempt":1}`, string(body))
+	stored, err = c.GetJob("5")
+	require.NoError(t, err)
+	assert.Empty(t, stored.HeldPayload, "payload is dropped after dispatch")
+	assert.Equal(t, "/images/blob/5", stored.PayloadLocation)
+}
+
+func TestRestAPI_SubmitScheduledJob(t *testing.T) {
+	repeaterMock := &utils.RepeaterInterfaceMock{
+		MakeRequestFunc: func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+			return []byte(`{"payload_location":"/images/blob/1"}`), nil
+		},
+	}
+	c := RestAPI{WorkerServiceURL: "http://localhost", Client: repeaterMock, Store: store.New()}
+	notBefore := time.Date(2030, 1, 2, 3, 0, 0, 0, time.UTC)
+	res, err := c.SubmitJob(model.Job{TenantID: 1, Payload: "MQo=", PayloadSize: 4, NotBefore: &notBefore, Cron: "0 3 * * *"})
+	require.NoError(t, err)
+	assert.Equal(t, &model.Job{ID: "1", Status: "SCHEDULED"}, res)
+	assert.Empty(t, repeaterMock.MakeRequestCalls(), "scheduled job is not sent to worker")
+	stored, err := c.GetJob("1")
+	require.NoError(t, err)
+	assert.Equal(t, "MQo=", stored.HeldPayload)
+	assert.Equal(t, notBefore, *stored.NotBefore)
+	assert.Equal(t, "0 3 * * *", stored.Cron)
+}
+
+func TestRestAPI_ReportJobStatus(t *testing.T) {
+	c := RestAPI{Store: store.New(model.Job{ID: "1"}, model.Job{ID: "2", Status: "SUCCESS"}, model.Job{ID: "4", Status: "RETRYING"})}
+
+	job, err := c.ReportJobStatus("1", model.StatusReport{Status: "RUNNING", Progress: 30})
+	require.NoError(t, err)
+	assert.Equal(t, "RUNNING", job.Status)
+	assert.Equal(t, 30, job.Progress)
+
+	job, err = c.ReportJobStatus("1", model.StatusReport{Status: "RUNNING", Progress: 30, Stage: "resize"})
+	require.NoError(t, err)
+	assert.Equal(t, "resize", job.Stage)
+	assert.Nil(t, job.Error)
+
+	_, err = c.ReportJobStatus("1", model.StatusReport{Status: "RUNNING", Progress: 10})
+	assert.True(t, errors.Is(err, ErrStaleReport), "progress is not moved back")
+	job, err = c.GetJob("1")
+	require.NoError(t, err)
+	assert.Equal(t, 30, job.Progress)
+
+	jobErr := &model.JobError{Code: "IMAGE_DECODE_FAILED", Message: "corrupted image", WorkerNode: "w1"}
+	job, err = c.ReportJobStatus("1", model.StatusReport{Status: "FAILED", Progress: 30, Stage: "decode", Error: jobErr})
+	require.NoError(t, err)
+	assert.Equal(t, "FAILED", job.Status)
+	assert.Equal(t, "decode", job.Stage)
+	assert.Equal(t, jobErr, job.Error)
+
+	job, err = c.ReportJobStatus("1", model.StatusReport{Status: "FAILED"})
+	require.NoError(t, err, "repeated report is accepted")
+	assert.Equal(t, 30, job.Progress, "repeated report doesn't change job")
+
+	_, err = c.ReportJobStatus("2", model.StatusReport{Status: "FAILED"})
+	assert.True(t, errors.Is(err, ErrJobFinished))
+	assert.EqualError(t, err, "job 2 is SUCCESS: job is finished")
+
+	_, err = c.ReportJobStatus("3", model.StatusReport{Status: "RUNNING"})
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+
+	_, err = c.ReportJobStatus("4", model.StatusReport{Status: "FAILED"})
+	assert.True(t, errors.Is(err, ErrJobFinished), "late report of failed attempt is rejected")
+	assert.EqualError(t, err, "attempt of job 4 is finished, job is RETRYING: job is finished")
+}
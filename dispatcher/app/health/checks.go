@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
+	"net/url"
+)
+
+//Store returns check of job store which is ready if it serves reads
+func Store(jobs *store.Store) Check {
+	return func(ctx context.Context) error {
+		if _, err := jobs.Get(""); err != nil && errors.Cause(err) != store.ErrNotFound {
+			return err
+		}
+		return nil
+	}
+}
+
+//Breaker returns check which fails while circuit breaker of upstream is open
+func Breaker(b *utils.Breaker) Check {
+	return func(ctx context.Context) error {
+		if state := b.State(); state == utils.BreakerOpen {
+			return fmt.Errorf("circuit breaker is %s", state)
+		}
+		return nil
+	}
+}
+
+//Queue returns check which fails if number of jobs processed by worker reaches max, jobs waiting for their
+//dependencies or schedule are not counted. Check is disabled if max is zero
+func Queue(jobs *store.Store, max int) Check {
+	return func(ctx context.Context) error {
+		if max <= 0 {
+			return nil
+		}
+		queued := len(jobs.Find(func(job model.Job) bool {
+			return !model.IsFinished(job.Status) && job.Status != model.JobStatus(model.WAITING).ToString() &&
+				job.Status != model.JobStatus(model.SCHEDULED).ToString()
+		}))
+		if queued >= max {
+			return fmt.Errorf("queue is saturated, %d jobs of max %d", queued, max)
+		}
+		return nil
+	}
+}
+
+//PingURL returns url of ping api of upstream by its api url
+func PingURL(serviceURL string) (string, error) {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid service url %s", serviceURL)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", errors.Errorf("invalid service url %s", serviceURL)
+	}
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = "/ping", "", "", ""
+	return u.String(), nil
+}
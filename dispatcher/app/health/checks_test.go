@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	assert.NoError(t, Store(store.New())(context.Background()))
+}
+
+func TestQueue(t *testing.T) {
+	jobs := store.New(
+		model.Job{ID: "1", Status: model.JobStatus(model.RUNNING).ToString()},
+		model.Job{ID: "2", Status: model.JobStatus(model.RETRYING).ToString()},
+		model.Job{ID: "3", Status: model.JobStatus(model.SUCCESS).ToString()},
+		model.Job{ID: "4", Status: model.JobStatus(model.WAITING).ToString()},
+		model.Job{ID: "5", Status: model.JobStatus(model.SCHEDULED).ToString()},
+	)
+	assert.NoError(t, Queue(jobs, 3)(context.Background()))
+	assert.NoError(t, Queue(jobs, 0)(context.Background()), "check is disabled")
+	err := Queue(jobs, 2)(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "queue is saturated, 2 jobs of max 2", err.Error())
+}
+
+func TestBreaker(t *testing.T) {
+	b := utils.NewBreaker(1, time.Hour)
+	assert.NoError(t, Breaker(b)(context.Background()))
+	b.Done(false)
+	err := Breaker(b)(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "circuit breaker is open", err.Error())
+	assert.NoError(t, Breaker(nil)(context.Background()))
+}
+
+func TestPingURL(t *testing.T) {
+	tbl := []struct {
+		url string
+		res string
+		err bool
+	}{
+		{"http://worker-cloud-net:8080/api/v1/", "http://worker-cloud-net:8080/ping", false},
+		{"https://blob:8081/api/v1?x=1", "https://blob:8081/ping", false},
+		{"http://localhost:8080", "http://localhost:8080/ping", false},
+		{"/api/v1", "", true},
+		{"", "", true},
+		{"http://%zz", "", true},
+	}
+	for i, tt := range tbl {
+		res, err := PingURL(tt.url)
+		if tt.err {
+			assert.Error(t, err, "test case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "test case #%d", i)
+		assert.Equal(t, tt.res, res, "test case #%d", i)
+	}
+}
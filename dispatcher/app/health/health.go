@@ -0,0 +1,153 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+//statuses of check
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+//Check returns error if dependency is not ready
+type Check func(ctx context.Context) error
+
+//Result is result of dependency check
+type Result struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Latency   string    `json:"latency"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+//Report is readiness of service with results of all checks
+type Report struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]Result `json:"checks"`
+}
+
+//Checker runs dependency checks in parallel with Timeout and keeps their report for TTL, so probes don't
+//hammer upstreams. Concurrent probes wait for the same run of checks
+type Checker struct {
+	TTL     time.Duration
+	Timeout time.Duration
+	lock    sync.Mutex
+	checks  map[string]Check
+	report  *Report
+	expires time.Time
+	now     func() time.Time
+}
+
+//NewChecker makes checker without checks
+func NewChecker(ttl, timeout time.Duration) *Checker {
+	return &Checker{TTL: ttl, Timeout: timeout, checks: map[string]Check{}}
+}
+
+//Add adds named check, check with the same name is replaced
+func (c *Checker) Add(name string, check Check) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.checks == nil {
+		c.checks = map[string]Check{}
+	}
+	c.checks[name] = check
+	c.report = nil
+}
+
+//Report returns cached report or runs all checks if it is expired
+func (c *Checker) Report(ctx context.Context) Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.report != nil && c.time().Before(c.expires) {
+		return *c.report
+	}
+	report := c.run(ctx)
+	c.report, c.expires = &report, c.time().Add(c.TTL)
+	return report
+}
+
+func (c *Checker) run(ctx context.Context) Report {
+	names := make([]string, 0, len(c.checks))
+	for name := range c.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.check(ctx, check)
+		}(i, c.checks[name])
+	}
+	wg.Wait()
+	report := Report{Ready: true, Checks: map[string]Result{}}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Ready = false
+		}
+	}
+	return report
+}
+
+//check runs check with timeout, check which doesn't return in time is failed
+func (c *Checker) check(ctx context.Context, check Check) Result {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	start := c.time()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = fmt.Errorf("check is not completed, %v", ctx.Err())
+	}
+	res := Result{Status: StatusOK, Latency: c.time().Sub(start).String(), CheckedAt: start.UTC()}
+	if err != nil {
+		res.Status, res.Error = StatusFail, err.Error()
+	}
+	return res
+}
+
+func (c *Checker) time() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
+
+//Ping returns check of upstream which is ready if GET request to url responds with 2xx status
+func Ping(client *http.Client, url string) Check {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s responded with status %d", url, resp.StatusCode)
+		}
+		return nil
+	}
+}
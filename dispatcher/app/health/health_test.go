@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChecker_Report(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	checker := NewChecker(5*time.Second, 50*time.Millisecond)
+	checker.now = func() time.Time { return now }
+	var calls int32
+	checker.Add("store", func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	checker.Add("worker", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	})
+	checker.Add("blob", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	report := checker.Report(context.Background())
+	assert.False(t, report.Ready)
+	require.Len(t, report.Checks, 3)
+	assert.Equal(t, StatusOK, report.Checks["store"].Status)
+	assert.Empty(t, report.Checks["store"].Error)
+	assert.Equal(t, Result{Status: StatusFail, Error: "connection refused", Latency: "0s", CheckedAt: now},
+		report.Checks["worker"])
+	assert.Equal(t, StatusFail, report.Checks["blob"].Status)
+	assert.Equal(t, "check is not completed, context deadline exceeded", report.Checks["blob"].Error)
+
+	checker.Report(context.Background())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "report is cached")
+	now = now.Add(5 * time.Second)
+	checker.Report(context.Background())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "expired report is refreshed")
+
+	checker = NewChecker(time.Second, time.Second)
+	checker.Add("store", func(ctx context.Context) error { return nil })
+	assert.True(t, checker.Report(context.Background()).Ready)
+	assert.True(t, NewChecker(time.Second, time.Second).Report(context.Background()).Ready, "no checks is ready")
+}
+
+func TestPing(t *testing.T) {
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/ping", r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	check := Ping(nil, ts.URL+"/ping")
+	assert.NoError(t, check(context.Background()))
+	status = http.StatusServiceUnavailable
+	err := check(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, ts.URL+"/ping responded with status 503", err.Error())
+	assert.Error(t, Ping(nil, "http://localhost:1/ping")(context.Background()))
+}
@@ -0,0 +1,232 @@
+package janitor
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"log"
+	"sync"
+	"time"
+)
+
+//Blobs removes payload and result blobs of jobs
+type Blobs interface {
+	DeleteBlob(ctx context.Context, location string) (int64, error)
+	BlobSize(ctx context.Context, location string) (int64, error)
+}
+
+//Janitor periodically removes finished jobs older than retention of their tenant from store together with
+//their payload and result blobs. In DryRun mode nothing is removed, expired jobs and their size are only logged.
+//Batches are removed when all their jobs are removed
+type Janitor struct {
+	Store     *store.Store
+	Batches   *store.Batches
+	Blobs     Blobs
+	TTL       time.Duration         //default retention of finished job, jobs are kept forever if zero
+	TenantTTL map[int]time.Duration //retention of tenant jobs, overrides TTL
+	Interval  time.Duration
+	DryRun    bool
+	OnDelete  func(jobID string) //called for each removed job, e.g. to forget its callback deliveries
+
+	lock      sync.Mutex
+	stats     Stats
+	retention sync.RWMutex //guards TTL and TenantTTL replaced by SetRetention
+	now       func() time.Time
+}
+
+//Stats are counters of janitor passes since start
+type Stats struct {
+	Runs           int64      `json:"runs"`
+	ExpiredJobs    int64      `json:"expired_jobs"`
+	DeletedJobs    int64      `json:"deleted_jobs"`
+	DeletedBlobs   int64      `json:"deleted_blobs"`
+	DeletedBatches int64      `json:"deleted_batches"`
+	ReclaimedBytes int64      `json:"reclaimed_bytes"` //reclaimable bytes in dry run mode
+	Errors         int64      `json:"errors"`
+	DryRun         bool       `json:"dry_run"`
+	LastRun        *time.Time `json:"last_run,omitempty"`
+}
+
+//Run cleans expired jobs every Interval till context is canceled
+func (j *Janitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	log.Printf("[INFO] start janitor, interval=%s, ttl=%s, tenants=%v, dryRun=%v", interval, j.TTL, j.TenantTTL, j.DryRun)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] janitor terminated")
+			return
+		case <-ticker.C:
+			j.Cleanup(ctx)
+		}
+	}
+}
+
+//Cleanup makes single pass over finished jobs and removes expired ones, returns counters of the pass.
+//Job is kept in store if any of its blobs can't be removed, so it is tried again on the next pass
+func (j *Janitor) Cleanup(ctx context.Context) Stats {
+	now := j.timeNow()
+	pass := Stats{DryRun: j.DryRun, LastRun: &now}
+	//blob can be shared by jobs, e.g. the same result of equal payloads, so it is kept while any live job refers it.
+	//Job is kept while unfinished job depends on it
+	live := map[string]bool{}
+	dependencies := map[string]bool{}
+	for _, job := range j.Store.Find(func(job model.Job) bool { return !j.expired(job, now) }) {
+		live[job.PayloadLocation] = true
+		live[job.ResultLocation] = true
+		if !model.IsFinished(job.Status) {
+			for _, id := range job.DependsOn {
+				dependencies[id] = true
+			}
+		}
+	}
+	expired := j.Store.Find(func(job model.Job) bool { return j.expired(job, now) && !dependencies[job.ID] })
+	removed := map[string]bool{}
+
+	for _, job := range expired {
+		if ctx.Err() != nil {
+			break
+		}
+		pass.ExpiredJobs++
+		ok := true
+		for _, location := range []string{job.PayloadLocation, job.ResultLocation} {
+			if location == "" || live[location] || removed[location] {
+				continue
+			}
+			size, err := j.removeBlob(ctx, location)
+			if err != nil {
+				log.Printf("[WARN] can't remove blob %s of expired job %s, %v", location, job.ID, err)
+				pass.Errors++
+				ok = false
+				continue
+			}
+			removed[location] = true
+			pass.DeletedBlobs++
+			pass.ReclaimedBytes += size
+		}
+		if !ok {
+			continue
+		}
+		if j.DryRun {
+			log.Printf("[INFO] dry run, expired job %s of tenant %d finished at %s would be removed", job.ID, job.TenantID, updatedAt(job).Format(time.RFC3339))
+			continue
+		}
+		if err := j.Store.Delete(job.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("[WARN] can't remove expired job %s, %v", job.ID, err)
+			pass.Errors++
+			continue
+		}
+		pass.DeletedJobs++
+		if j.OnDelete != nil {
+			j.OnDelete(job.ID)
+		}
+	}
+	if j.Batches != nil && !j.DryRun {
+		pass.DeletedBatches = int64(j.Batches.DeleteFunc(func(batch model.Batch) bool { return j.batchExpired(batch, now) }))
+	}
+	if pass.ExpiredJobs > 0 {
+		log.Printf("[INFO] janitor pass: expired=%d, deleted jobs=%d, deleted blobs=%d, reclaimed=%d bytes, errors=%d, dryRun=%v",
+			pass.ExpiredJobs, pass.DeletedJobs, pass.DeletedBlobs, pass.ReclaimedBytes, pass.Errors, j.DryRun)
+	}
+
+	j.lock.Lock()
+	j.stats.Runs++
+	j.stats.ExpiredJobs += pass.ExpiredJobs
+	j.stats.DeletedJobs += pass.DeletedJobs
+	j.stats.DeletedBlobs += pass.DeletedBlobs
+	j.stats.DeletedBatches += pass.DeletedBatches
+	j.stats.ReclaimedBytes += pass.ReclaimedBytes
+	j.stats.Errors += pass.Errors
+	j.stats.DryRun = j.DryRun
+	j.stats.LastRun = &now
+	j.lock.Unlock()
+	return pass
+}
+
+//Stats returns counters of all passes
+func (j *Janitor) Stats() Stats {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.stats.DryRun = j.DryRun
+	return j.stats
+}
+
+//removeBlob deletes blob and returns its size, in dry run mode size is only got from blob service.
+//Blob which is already removed from blob service has zero size
+func (j *Janitor) removeBlob(ctx context.Context, location string) (int64, error) {
+	var size int64
+	var err error
+	if j.DryRun {
+		size, err = j.Blobs.BlobSize(ctx, location)
+	} else {
+		size, err = j.Blobs.DeleteBlob(ctx, location)
+	}
+	if errors.Is(err, engine.ErrBlobNotFound) {
+		return 0, nil
+	}
+	return size, err
+}
+
+//expired returns true for finished job whose retention is passed
+func (j *Janitor) expired(job model.Job, now time.Time) bool {
+	if !model.IsFinished(job.Status) {
+		return false
+	}
+	ttl := j.ttl(job.TenantID)
+	return ttl > 0 && now.Sub(updatedAt(job)) > ttl
+}
+
+//batchExpired returns true for batch whose jobs are all removed, batch without accepted jobs expires by retention
+func (j *Janitor) batchExpired(batch model.Batch, now time.Time) bool {
+	if len(batch.JobIDs) == 0 {
+		ttl := j.ttl(batch.TenantID)
+		return ttl > 0 && batch.CreatedAt != nil && now.Sub(*batch.CreatedAt) > ttl
+	}
+	for _, id := range batch.JobIDs {
+		if _, err := j.Store.Get(id); !errors.Is(err, store.ErrNotFound) {
+			return false
+		}
+	}
+	return true
+}
+
+func updatedAt(job model.Job) time.Time {
+	if job.UpdatedAt != nil {
+		return *job.UpdatedAt
+	}
+	if job.CreatedAt != nil {
+		return *job.CreatedAt
+	}
+	return time.Time{}
+}
+
+//SetRetention replaces default retention and retention of tenants, it is used since the next pass
+func (j *Janitor) SetRetention(ttl time.Duration, tenants map[int]time.Duration) {
+	j.retention.Lock()
+	defer j.retention.Unlock()
+	j.TTL, j.TenantTTL = ttl, tenants
+}
+
+//ttl returns retention of tenant jobs
+func (j *Janitor) ttl(tenantID int) time.Duration {
+	j.retention.RLock()
+	defer j.retention.RUnlock()
+	if tenantTTL, ok := j.TenantTTL[tenantID]; ok {
+		return tenantTTL
+	}
+	return j.TTL
+}
+
+func (j *Janitor) timeNow() time.Time {
+	if j.now != nil {
+		return j.now()
+	}
+	return time.Now()
+}
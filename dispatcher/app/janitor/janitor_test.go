@@ -0,0 +1,188 @@
+package janitor
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type blobsMock struct {
+	lock    sync.Mutex
+	sizes   map[string]int64
+	deleted []string
+	sized   []string
+}
+
+func (b *blobsMock) DeleteBlob(_ context.Context, location string) (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.deleted = append(b.deleted, location)
+	return b.size(location)
+}
+
+func (b *blobsMock) BlobSize(_ context.Context, location string) (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.sized = append(b.sized, location)
+	return b.size(location)
+}
+
+func (b *blobsMock) size(location string) (int64, error) {
+	size, ok := b.sizes[location]
+	if !ok && location == "/blob/broken" {
+		return 0, errors.New("store is overloaded")
+	}
+	if !ok {
+		return 0, errors.Wrapf(engine.ErrBlobNotFound, "no blob %s", location)
+	}
+	return size, nil
+}
+
+func testStore(now time.Time) *store.Store {
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(-d)
+		return &t
+	}
+	return store.New(
+		model.Job{ID: "1", TenantID: 1, Status: "SUCCESS", PayloadLocation: "/images/blob/1", ResultLocation: "/blob/1", UpdatedAt: at(48 * time.Hour)},
+		model.Job{ID: "2", TenantID: 1, Status: "FAILED", PayloadLocation: "/images/blob/2", UpdatedAt: at(48 * time.Hour)},
+		model.Job{ID: "3", TenantID: 1, Status: "SUCCESS", PayloadLocation: "/images/blob/3", ResultLocation: "/blob/3", UpdatedAt: at(time.Hour)},
+		model.Job{ID: "4", TenantID: 1, Status: "RUNNING", PayloadLocation: "/images/blob/4", UpdatedAt: at(48 * time.Hour)},
+		model.Job{ID: "5", TenantID: 2, Status: "SUCCESS", PayloadLocation: "/images/blob/5", ResultLocation: "/blob/3", UpdatedAt: at(48 * time.Hour)},
+		model.Job{ID: "6", TenantID: 3, Status: "SUCCESS", PayloadLocation: "/images/blob/6", ResultLocation: "/blob/6", UpdatedAt: at(2 * time.Hour)},
+		model.Job{ID: "7", TenantID: 1, Status: "DEAD_LETTERED", PayloadLocation: "/images/blob/7", ResultLocation: "/blob/broken", UpdatedAt: at(48 * time.Hour)},
+	)
+}
+
+func ids(jobs []model.Job) []string {
+	res := []string{}
+	for _, job := range jobs {
+		res = append(res, job.ID)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestJanitor_Cleanup(t *testing.T) {
+	now := time.Date(2021, 3, 10, 10, 0, 0, 0, time.UTC)
+	s := testStore(now)
+	blobs := &blobsMock{sizes: map[string]int64{"/images/blob/1": 100, "/blob/1": 200, "/images/blob/5": 50,
+		"/images/blob/6": 10, "/blob/6": 20, "/images/blob/7": 70}}
+	forgotten := []string{}
+	j := Janitor{Store: s, Blobs: blobs, TTL: 24 * time.Hour, TenantTTL: map[int]time.Duration{3: time.Hour},
+		OnDelete: func(id string) { forgotten = append(forgotten, id) }, now: func() time.Time { return now }}
+
+	pass := j.Cleanup(context.Background())
+	assert.Equal(t, int64(5), pass.ExpiredJobs)
+	assert.Equal(t, int64(4), pass.DeletedJobs, "job with not removed blob is kept")
+	assert.Equal(t, int64(7), pass.DeletedBlobs)
+	assert.Equal(t, int64(100+200+50+10+20+70), pass.ReclaimedBytes, "missing blob has zero size")
+	assert.Equal(t, int64(1), pass.Errors)
+	assert.Equal(t, []string{"3", "4", "7"}, ids(s.Find(nil)), "running and not expired jobs are kept")
+	sort.Strings(forgotten)
+	assert.Equal(t, []string{"1", "2", "5", "6"}, forgotten, "removed jobs are passed to hook")
+	assert.NotContains(t, blobs.deleted, "/blob/3", "result shared with live job is kept")
+	assert.Empty(t, blobs.sized)
+
+	blobs.sizes["/blob/broken"] = 1
+	delete(blobs.sizes, "/images/blob/7")
+	now = now.Add(time.Minute)
+	pass = j.Cleanup(context.Background())
+	assert.Equal(t, int64(1), pass.ExpiredJobs)
+	assert.Equal(t, int64(1), pass.DeletedJobs)
+	assert.Equal(t, []string{"3", "4"}, ids(s.Find(nil)))
+
+	stats := j.Stats()
+	assert.Equal(t, int64(2), stats.Runs)
+	assert.Equal(t, int64(5), stats.DeletedJobs)
+	assert.Equal(t, int64(451), stats.ReclaimedBytes)
+	assert.Equal(t, now, *stats.LastRun)
+}
+
+func TestJanitor_CleanupDryRun(t *testing.T) {
+	now := time.Date(2021, 3, 10, 10, 0, 0, 0, time.UTC)
+	s := testStore(now)
+	blobs := &blobsMock{sizes: map[string]int64{"/images/blob/1": 100, "/blob/1": 200}}
+	j := Janitor{Store: s, Blobs: blobs, TTL: 24 * time.Hour, DryRun: true, now: func() time.Time { return now }}
+
+	pass := j.Cleanup(context.Background())
+	assert.True(t, pass.DryRun)
+	assert.Equal(t, int64(4), pass.ExpiredJobs)
+	assert.Equal(t, int64(0), pass.DeletedJobs)
+	assert.Equal(t, int64(300), pass.ReclaimedBytes, "reclaimable bytes are counted")
+	assert.Empty(t, blobs.deleted, "nothing is removed in dry run")
+	assert.Equal(t, 7, len(s.Find(nil)))
+	assert.True(t, j.Stats().DryRun)
+}
+
+func TestJanitor_CleanupBatches(t *testing.T) {
+	now := time.Now()
+	s := testStore(now)
+	batches := store.NewBatches()
+	batches.Create(model.Batch{TenantID: 1, JobIDs: []string{"1", "2"}})
+	batches.Create(model.Batch{TenantID: 1, JobIDs: []string{"1", "4"}})
+	batches.Create(model.Batch{TenantID: 1})
+	j := Janitor{Store: s, Batches: batches, Blobs: &blobsMock{}, TTL: 24 * time.Hour, now: func() time.Time { return now }}
+
+	pass := j.Cleanup(context.Background())
+	assert.Equal(t, int64(1), pass.DeletedBatches, "batch with live job and batch without jobs are kept")
+	_, err := batches.Get("1")
+	assert.True(t, errors.Is(err, store.ErrBatchNotFound))
+
+	now = now.Add(25 * time.Hour)
+	pass = j.Cleanup(context.Background())
+	assert.Equal(t, int64(1), pass.DeletedBatches, "batch without jobs is expired by retention")
+	_, err = batches.Get("2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), j.Stats().DeletedBatches)
+}
+
+func TestJanitor_CleanupDependencies(t *testing.T) {
+	now := time.Date(2021, 3, 10, 10, 0, 0, 0, time.UTC)
+	old := now.Add(-48 * time.Hour)
+	s := store.New(
+		model.Job{ID: "1", Status: "SUCCESS", UpdatedAt: &old},
+		model.Job{ID: "2", Status: "SUCCESS", UpdatedAt: &old},
+		model.Job{ID: "3", Status: "WAITING", DependsOn: []string{"1"}},
+		model.Job{ID: "4", Status: "CANCELED", DependsOn: []string{"2"}},
+	)
+	j := Janitor{Store: s, Blobs: &blobsMock{}, TTL: 24 * time.Hour, now: func() time.Time { return now }}
+	pass := j.Cleanup(context.Background())
+	assert.Equal(t, int64(1), pass.DeletedJobs)
+	assert.Equal(t, []string{"1", "3", "4"}, ids(s.Find(nil)), "dependency of waiting job is kept")
+}
+
+func TestJanitor_Disabled(t *testing.T) {
+	now := time.Date(2021, 3, 10, 10, 0, 0, 0, time.UTC)
+	s := testStore(now)
+	j := Janitor{Store: s, Blobs: &blobsMock{}, TenantTTL: map[int]time.Duration{2: time.Hour}, now: func() time.Time { return now }}
+	pass := j.Cleanup(context.Background())
+	assert.Equal(t, int64(1), pass.DeletedJobs, "jobs are kept forever without ttl")
+	_, err := s.Get("5")
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+}
+
+func TestJanitor_Run(t *testing.T) {
+	now := time.Now()
+	s := testStore(now)
+	j := Janitor{Store: s, Blobs: &blobsMock{}, TTL: 24 * time.Hour, Interval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		j.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return j.Stats().Runs > 0 }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	_, err := s.Get("1")
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+}
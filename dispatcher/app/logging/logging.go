@@ -0,0 +1,133 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-chi/chi/middleware"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//HeaderRequestID is header with id of request, it is passed to worker and blob services to correlate their logs
+const HeaderRequestID = "X-Request-ID"
+
+//Keys are names of fields written as `key=value` at the end of log message, JSON writer moves them to record fields
+var Keys = []string{"request_id", "job_id", "tenant_id", "upstream", "method", "path", "status", "latency"}
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type ctxKey struct{}
+
+//NewRequestID makes random request id
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Replace(time.Now().UTC().Format("20060102150405.000000000"), ".", "", 1)
+	}
+	return hex.EncodeToString(b)
+}
+
+//WithRequestID returns context with request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+//RequestID returns request id from context, empty if it is not set
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+//Middleware takes request id from X-Request-ID header or makes new one, returns it in response header and logs
+//completed request with its status and latency
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		id := req.Header.Get(HeaderRequestID)
+		if !validRequestID.MatchString(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+		next.ServeHTTP(ww, req.WithContext(WithRequestID(req.Context(), id)))
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		log.Printf("[INFO] request completed from %s method=%s path=%s status=%d latency=%s request_id=%s",
+			req.RemoteAddr, req.Method, req.URL.Path, status, time.Since(start), id)
+	})
+}
+
+//JSONWriter writes lines of standard logger as JSON records with level, ts, msg and fields from `key=value`
+//at the end of message. Logger must have no flags, time of record is set by writer
+type JSONWriter struct {
+	Out  io.Writer
+	lock sync.Mutex
+	now  func() time.Time
+}
+
+//Write converts log line to JSON record
+func (w *JSONWriter) Write(p []byte) (int, error) {
+	rec := parseLine(strings.TrimSuffix(string(p), "\n"))
+	now := time.Now
+	if w.now != nil {
+		now = w.now
+	}
+	rec["ts"] = now().UTC().Format(time.RFC3339Nano)
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(rec); err != nil {
+		return 0, err
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, err := w.Out.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//parseLine splits `[LEVEL] message key=value` line to record fields, line without level is INFO
+func parseLine(line string) map[string]string {
+	rec := map[string]string{"level": "INFO"}
+	if strings.HasPrefix(line, "[") {
+		if i := strings.Index(line, "] "); i > 0 && i < 8 {
+			rec["level"], line = line[1:i], line[i+2:]
+		}
+	}
+	tokens := strings.Split(line, " ")
+	end := len(tokens)
+	for end > 1 {
+		kv := strings.SplitN(tokens[end-1], "=", 2)
+		if len(kv) != 2 || !isKey(kv[0]) {
+			break
+		}
+		if _, ok := rec[kv[0]]; !ok {
+			rec[kv[0]] = kv[1]
+		}
+		end--
+	}
+	rec["msg"] = strings.Join(tokens[:end], " ")
+	return rec
+}
+
+func isKey(key string) bool {
+	for _, k := range Keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONWriter_Write(t *testing.T) {
+	var tbl = []struct {
+		line string
+		json string
+	}{
+		{"[INFO] job is dispatched upstream=worker latency=12ms job_id=5 tenant_id=1 request_id=abc\n",
+			`{"level":"INFO","msg":"job is dispatched","upstream":"worker","latency":"12ms","job_id":"5","tenant_id":"1","request_id":"abc"}`},
+		{"[WARN] can't remove job 5, key=value <nil>\n", `{"level":"WARN","msg":"can't remove job 5, key=value <nil>"}`},
+		{"[DEBUG] job_id=5\n", `{"level":"DEBUG","msg":"job_id=5"}`},
+		{"line without level\n", `{"level":"INFO","msg":"line without level"}`},
+		{"[ERROR] stack [\ngoroutine 1\n", `{"level":"ERROR","msg":"stack [\ngoroutine 1"}`},
+		{"[INFO] \"quoted\" <b> job_id=1 job_id=2\n", `{"level":"INFO","msg":"\"quoted\" <b>","job_id":"2"}`},
+	}
+	for i, tt := range tbl {
+		t.Run(fmt.Sprintf("test case #%d", i), func(t *testing.T) {
+			buf := bytes.Buffer{}
+			w := JSONWriter{Out: &buf, now: func() time.Time { return time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC) }}
+			n, err := w.Write([]byte(tt.line))
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.line), n)
+			assert.JSONEq(t, strings.TrimSuffix(tt.json, "}")+`,"ts":"2021-03-01T10:00:00Z"}`, buf.String())
+			assert.True(t, strings.HasSuffix(buf.String(), "}\n"), "record is a single line")
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	buf := bytes.Buffer{}
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	var got string
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest("POST", "/api/v1/job", nil)
+	req.Header.Set(HeaderRequestID, "req-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, "req-1", got)
+	assert.Equal(t, "req-1", rec.Header().Get(HeaderRequestID))
+	assert.Contains(t, buf.String(), "[INFO] request completed from 192.0.2.1:1234 method=POST path=/api/v1/job status=201 latency=")
+	assert.Contains(t, buf.String(), " request_id=req-1\n")
+
+	for _, id := range []string{"", "bad id", strings.Repeat("a", 129)} {
+		req = httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set(HeaderRequestID, id)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Len(t, got, 32, "new request id is made instead of %q", id)
+		assert.Equal(t, got, rec.Header().Get(HeaderRequestID))
+	}
+	assert.NotEqual(t, NewRequestID(), NewRequestID())
+}
@@ -4,6 +4,8 @@ import (
 	"github.com/hashicorp/logutils"
 	"github.com/jessevdk/go-flags"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/cmd"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -15,20 +17,24 @@ var version = "unknown"
 
 type Opts struct {
 	ServerCmd        cmd.ServerCommand `command:"server"`
+	SubmitCmd        cmd.SubmitCommand `command:"submit"`
 	WorkerServiceURL string            `long:"workerServiceUrl" env:"WORKER_SERVICE_URL" default:"http://worker-service:8080/api/v1/" description:"url to worker service api"`
+	BlobServiceURL   string            `long:"blobServiceUrl" env:"BLOB_SERVICE_URL" default:"http://worker-blob-net:8081/api/v1/" description:"url to blob service api"`
 	Debug            bool              `long:"debug" env:"DEBUG" description:"debug mode"`
+	LogFormat        string            `long:"logFormat" env:"LOG_FORMAT" choice:"text" choice:"json" default:"text" description:"format of log records"`
 }
 
 func main() {
-	setupLogLevel(false)
-	log.Printf("[INFO] starting Dispatcher Service API server version:%s ...\n", version)
+	setupLogLevel(false, os.Getenv("LOG_FORMAT"), os.Stdout)
 	var opts Opts
 	p := flags.NewParser(&opts, flags.Default)
 	p.CommandHandler = func(command flags.Commander, args []string) error {
-		setupLogLevel(opts.Debug)
+		setupLogLevel(opts.Debug, opts.LogFormat, logOutput(command))
+		log.Printf("[INFO] starting Dispatcher Service API server version:%s ...\n", version)
 		c := command.(cmd.CommonOptionsCommander)
 		c.SetCommon(cmd.CommonOptions{
 			WorkerServiceURL: opts.WorkerServiceURL,
+			BlobServiceURL:   opts.BlobServiceURL,
 		})
 		err := c.Execute(args)
 		if err != nil {
@@ -45,11 +51,19 @@ func main() {
 	}
 }
 
-func setupLogLevel(debug bool) {
+//logOutput returns writer of logs for command, submit command can write its results to stdout, so it logs to stderr
+func logOutput(command flags.Commander) io.Writer {
+	if _, ok := command.(*cmd.SubmitCommand); ok {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func setupLogLevel(debug bool, format string, out io.Writer) {
 	filter := &logutils.LevelFilter{
 		Levels:   []logutils.LogLevel{"DEBUG", "INFO", "WARN", "ERROR"},
 		MinLevel: logutils.LogLevel("INFO"),
-		Writer:   os.Stdout,
+		Writer:   out,
 	}
 	log.SetFlags(log.Ldate | log.Ltime)
 
@@ -57,6 +71,11 @@ func setupLogLevel(debug bool) {
 		log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
 		filter.MinLevel = logutils.LogLevel("DEBUG")
 	}
+	//JSON record has its own time and level, so standard logger flags are dropped
+	if format == "json" {
+		log.SetFlags(0)
+		filter.Writer = &logging.JSONWriter{Out: out}
+	}
 	log.SetOutput(filter)
 }
 
@@ -71,7 +90,7 @@ func getStackTrace() string {
 }
 
 func init() {
-	sigChan := make(chan os.Signal)
+	sigChan := make(chan os.Signal, 1)
 	go func() {
 		for range sigChan {
 			log.Printf("[INFO] Singal QUITE is cought, stacktrace [\n%s", getStackTrace())
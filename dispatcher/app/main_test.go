@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/cmd"
 	"go.uber.org/goleak"
 	"io/ioutil"
 	"math/rand"
@@ -56,6 +57,11 @@ func Test_Main(t *testing.T) {
 	assert.Equal(t, "pong\n", string(body))
 }
 
+func TestLogOutput(t *testing.T) {
+	assert.Equal(t, os.Stdout, logOutput(&cmd.ServerCommand{}))
+	assert.Equal(t, os.Stderr, logOutput(&cmd.SubmitCommand{}), "stdout is left for results of submit")
+}
+
 func TestGetStackTrace(t *testing.T) {
 	stackTrace := getStackTrace()
 	assert.True(t, strings.Contains(stackTrace, "goroutine"))
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//Metrics are dispatcher metrics exposed by /metrics in prometheus text format
+type Metrics struct {
+	Registry         *Registry
+	Requests         *CounterVec   //route, method, status
+	RequestDuration  *HistogramVec //route, method
+	JobsSubmitted    *CounterVec   //tenant
+	JobsFailed       *CounterVec   //tenant, status
+	JobRetries       *CounterVec   //tenant
+	PayloadSize      *HistogramVec
+	UpstreamDuration *HistogramVec //service, method, status
+	UpstreamRetries  *CounterVec   //service, method
+	AuthFailures     *CounterVec   //reason
+	RateLimited      *CounterVec   //tenant, reason
+}
+
+//payloadBuckets are buckets of payload size in bytes, from 1KB to 16MB
+var payloadBuckets = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+//New makes dispatcher metrics
+func New() *Metrics {
+	r := NewRegistry()
+	return &Metrics{
+		Registry: r,
+		Requests: r.Counter("dispatcher_http_requests_total",
+			"Number of http requests by route, method and status.", "route", "method", "status"),
+		RequestDuration: r.Histogram("dispatcher_http_request_duration_seconds",
+			"Duration of http requests by route and method.", DefBuckets, "route", "method"),
+		JobsSubmitted: r.Counter("dispatcher_jobs_submitted_total",
+			"Number of jobs submitted by tenant.", "tenant"),
+		JobsFailed: r.Counter("dispatcher_jobs_failed_total",
+			"Number of jobs finished unsuccessfully by tenant and status.", "tenant", "status"),
+		JobRetries: r.Counter("dispatcher_job_retries_total",
+			"Number of job attempts scheduled for retry by tenant.", "tenant"),
+		PayloadSize: r.Histogram("dispatcher_job_payload_bytes",
+			"Size of encoded payload of submitted jobs.", payloadBuckets),
+		UpstreamDuration: r.Histogram("dispatcher_upstream_request_duration_seconds",
+			"Duration of requests to worker and blob services by method and status, 0 status for failed request.",
+			DefBuckets, "service", "method", "status"),
+		UpstreamRetries: r.Counter("dispatcher_upstream_retries_total",
+			"Number of repeated requests to upstream services.", "service", "method"),
+		AuthFailures: r.Counter("dispatcher_auth_failures_total",
+			"Number of rejected requests by reason.", "reason"),
+		RateLimited: r.Counter("dispatcher_rate_limited_total",
+			"Number of requests rejected by rate limit or daily quota of tenant.", "tenant", "reason"),
+	}
+}
+
+//Handler writes all metrics
+func (m *Metrics) Handler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Registry.Expose(w)
+}
+
+//Middleware counts requests and their durations by route pattern, so ids in urls don't make new series
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+		next.ServeHTTP(ww, req)
+		route := "unmatched"
+		if rctx := chi.RouteContext(req.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.Requests.Inc(route, req.Method, strconv.Itoa(status))
+		m.RequestDuration.Observe(time.Since(start).Seconds(), route, req.Method)
+	})
+}
+
+//ObserveSubmit counts job submitted by client
+func (m *Metrics) ObserveSubmit(job model.Job) {
+	m.JobsSubmitted.Inc(strconv.Itoa(job.TenantID))
+	m.PayloadSize.Observe(float64(job.PayloadSize))
+}
+
+//ObserveJob counts failed and retried jobs by status transition. It is store hook called under store lock
+func (m *Metrics) ObserveJob(prev model.Job, job *model.Job) {
+	if prev.Status == job.Status {
+		return
+	}
+	tenant := strconv.Itoa(job.TenantID)
+	switch job.Status {
+	case model.JobStatus(model.RETRYING).ToString():
+		m.JobRetries.Inc(tenant)
+	case model.JobStatus(model.FAILED).ToString(), model.JobStatus(model.TIMED_OUT).ToString(),
+		model.JobStatus(model.DEAD_LETTERED).ToString():
+		m.JobsFailed.Inc(tenant, job.Status)
+	}
+}
+
+//WatchStore registers store hook counting failed jobs and gauge of jobs by status, unfinished jobs are queue depth
+func (m *Metrics) WatchStore(jobs *store.Store) {
+	jobs.BeforeUpdate(m.ObserveJob)
+	m.Registry.GaugeFunc("dispatcher_jobs", "Number of jobs in store by status.", "status", func() map[string]float64 {
+		res := map[string]float64{}
+		for _, job := range jobs.Find(nil) {
+			res[job.Status]++
+		}
+		return res
+	})
+}
+
+//Upstream returns observer of requests to service
+func (m *Metrics) Upstream(service string) *Upstream {
+	return &Upstream{metrics: m, service: service}
+}
+
+//Upstream observes requests to upstream service
+type Upstream struct {
+	metrics *Metrics
+	service string
+}
+
+//ObserveRequest counts request with its response status, 0 status is failed request
+func (u *Upstream) ObserveRequest(method string, status int, duration time.Duration) {
+	u.metrics.UpstreamDuration.Observe(duration.Seconds(), u.service, method, strconv.Itoa(status))
+}
+
+//ObserveRetry counts repeated request
+func (u *Upstream) ObserveRetry(method string) {
+	u.metrics.UpstreamRetries.Inc(u.service, method)
+}
+
+//Transport returns http transport observing requests made by next, default transport is used if next is nil
+func (u *Upstream) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(req)
+		status := 0
+		if err == nil {
+			status = resp.StatusCode
+		}
+		u.ObserveRequest(req.Method, status, time.Since(start))
+		return resp, err
+	})
+}
+
+type roundTripper func(req *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
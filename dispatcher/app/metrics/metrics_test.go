@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Middleware(t *testing.T) {
+	m := New()
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Route("/api/v1/", func(api chi.Router) {
+		api.Get("/job/{id}", func(w http.ResponseWriter, r *http.Request) {
+			if chi.URLParam(r, "id") == "100" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+	})
+	for _, url := range []string{"/api/v1/job/1", "/api/v1/job/2", "/api/v1/job/100", "/unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	assert.Equal(t, float64(2), m.Requests.Value("/api/v1/job/{id}", "GET", "200"))
+	assert.Equal(t, float64(1), m.Requests.Value("/api/v1/job/{id}", "GET", "404"))
+	assert.Equal(t, float64(1), m.Requests.Value("unmatched", "GET", "404"))
+	assert.Equal(t, uint64(3), m.RequestDuration.Count("/api/v1/job/{id}", "GET"))
+}
+
+func TestMetrics_WatchStore(t *testing.T) {
+	m := New()
+	jobs := store.New(model.Job{ID: "1", TenantID: 1}, model.Job{ID: "2", TenantID: 2}, model.Job{ID: "3", TenantID: 2})
+	m.WatchStore(jobs)
+	setStatus := func(id, status string) {
+		_, err := jobs.Update(id, func(job *model.Job) error {
+			job.Status = status
+			return nil
+		})
+		require.NoError(t, err)
+	}
+	setStatus("1", "RETRYING")
+	setStatus("1", "RUNNING")
+	setStatus("1", "FAILED")
+	setStatus("1", "FAILED")
+	setStatus("2", "DEAD_LETTERED")
+	setStatus("3", "SUCCESS")
+	assert.Equal(t, float64(1), m.JobRetries.Value("1"))
+	assert.Equal(t, float64(1), m.JobsFailed.Value("1", "FAILED"), "job is counted once")
+	assert.Equal(t, float64(1), m.JobsFailed.Value("2", "DEAD_LETTERED"))
+	assert.Equal(t, float64(0), m.JobsFailed.Value("2", "SUCCESS"))
+
+	m.ObserveSubmit(model.Job{TenantID: 1, PayloadSize: 2048})
+	assert.Equal(t, float64(1), m.JobsSubmitted.Value("1"))
+	assert.Equal(t, uint64(1), m.PayloadSize.Count())
+
+	buf := bytes.Buffer{}
+	m.Registry.Expose(&buf)
+	assert.Contains(t, buf.String(), "dispatcher_jobs{status=\"FAILED\"} 1\n")
+	assert.Contains(t, buf.String(), "dispatcher_jobs{status=\"SUCCESS\"} 1\n")
+	assert.Contains(t, buf.String(), "dispatcher_job_payload_bytes_bucket{le=\"4096\"} 1\n")
+}
+
+func TestUpstream_Transport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+	m := New()
+	client := http.Client{Transport: m.Upstream("blob").Transport(nil)}
+	resp, err := client.Head(ts.URL + "/blob/1")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	_, err = client.Get("http://127.0.0.1:1/blob/1")
+	require.Error(t, err)
+	assert.Equal(t, uint64(1), m.UpstreamDuration.Count("blob", "HEAD", "404"))
+	assert.Equal(t, uint64(1), m.UpstreamDuration.Count("blob", "GET", "0"))
+
+	worker := m.Upstream("worker")
+	worker.ObserveRetry("POST")
+	worker.ObserveRequest("POST", 200, time.Second)
+	assert.Equal(t, float64(1), m.UpstreamRetries.Value("worker", "POST"))
+	assert.Equal(t, uint64(1), m.UpstreamDuration.Count("worker", "POST", "200"))
+}
@@ -0,0 +1,286 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefBuckets are default histogram buckets of durations in seconds
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//Registry keeps metrics and writes them in prometheus text exposition format
+type Registry struct {
+	lock       sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+//desc is name, help and label names of metric
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+//NewRegistry makes empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+//Counter registers counter with label names
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, typ: "counter", labels: labels}, values: map[string]*sample{}}
+	r.register(name, c)
+	return c
+}
+
+//Histogram registers histogram with upper bounds of buckets and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+	h := &HistogramVec{desc: desc{name: name, help: help, typ: "histogram", labels: labels}, buckets: b,
+		values: map[string]*histogram{}}
+	r.register(name, h)
+	return h
+}
+
+//GaugeFunc registers gauge with values by label got from fn on each write, single value has empty label
+func (r *Registry) GaugeFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(name, &funcCollector{desc: desc{name: name, help: help, typ: "gauge", labels: labelNames(label)}, fn: fn})
+}
+
+//CounterFunc registers counter with values by label got from fn on each write, single value has empty label
+func (r *Registry) CounterFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(name, &funcCollector{desc: desc{name: name, help: help, typ: "counter", labels: labelNames(label)}, fn: fn})
+}
+
+//Expose writes all metrics in order of registration
+func (r *Registry) Expose(w io.Writer) {
+	r.lock.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.lock.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s is already registered", name))
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+//CounterVec is counter partitioned by label values
+type CounterVec struct {
+	desc
+	lock   sync.Mutex
+	values map[string]*sample
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+//Inc adds one to counter with label values
+func (c *CounterVec) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+//Add adds not negative v to counter with label values
+func (c *CounterVec) Add(v float64, labels ...string) {
+	if v < 0 {
+		return
+	}
+	key := c.key(labels)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	s, ok := c.values[key]
+	if !ok {
+		s = &sample{labels: append([]string{}, labels...)}
+		c.values[key] = s
+	}
+	s.value += v
+}
+
+//Value returns current value of counter with label values
+func (c *CounterVec) Value(labels ...string) float64 {
+	key := c.key(labels)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s, ok := c.values[key]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.lock.Lock()
+	samples := make([]sample, 0, len(c.values))
+	for _, s := range c.values {
+		samples = append(samples, *s)
+	}
+	c.lock.Unlock()
+	c.header(w)
+	sortSamples(samples)
+	for _, s := range samples {
+		writeSample(w, c.name, c.labels, s.labels, s.value)
+	}
+}
+
+//HistogramVec is histogram partitioned by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	lock    sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	labels []string
+	counts []uint64 //not cumulative count of each bucket
+	count  uint64
+	sum    float64
+}
+
+//Observe adds v to histogram with label values
+func (h *HistogramVec) Observe(v float64, labels ...string) {
+	key := h.key(labels)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{labels: append([]string{}, labels...), counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		hist.counts[i]++
+	}
+	hist.count++
+	hist.sum += v
+}
+
+//Count returns number of observations of histogram with label values
+func (h *HistogramVec) Count(labels ...string) uint64 {
+	key := h.key(labels)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if hist, ok := h.values[key]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.lock.Lock()
+	hists := make([]histogram, 0, len(h.values))
+	for _, hist := range h.values {
+		c := *hist
+		c.counts = append([]uint64{}, hist.counts...)
+		hists = append(hists, c)
+	}
+	h.lock.Unlock()
+	sort.Slice(hists, func(i, j int) bool { return labelsLess(hists[i].labels, hists[j].labels) })
+	h.header(w)
+	names := append(append([]string{}, h.labels...), "le")
+	for _, hist := range hists {
+		var cumulative uint64
+		for i, b := range h.buckets {
+			cumulative += hist.counts[i]
+			writeSample(w, h.name+"_bucket", names, append(append([]string{}, hist.labels...), formatFloat(b)), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", names, append(append([]string{}, hist.labels...), "+Inf"), float64(hist.count))
+		writeSample(w, h.name+"_sum", h.labels, hist.labels, hist.sum)
+		writeSample(w, h.name+"_count", h.labels, hist.labels, float64(hist.count))
+	}
+}
+
+type funcCollector struct {
+	desc
+	fn func() map[string]float64
+}
+
+func (f *funcCollector) write(w io.Writer) {
+	values := f.fn()
+	samples := make([]sample, 0, len(values))
+	for label, v := range values {
+		s := sample{value: v}
+		if len(f.labels) > 0 {
+			s.labels = []string{label}
+		}
+		samples = append(samples, s)
+	}
+	sortSamples(samples)
+	f.header(w)
+	for _, s := range samples {
+		writeSample(w, f.name, f.labels, s.labels, s.value)
+	}
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help), d.name, d.typ)
+}
+
+//key returns map key of label values, label values must match label names
+func (d *desc) key(labels []string) string {
+	if len(labels) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", d.name, len(d.labels), len(labels)))
+	}
+	return strings.Join(labels, "\xff")
+}
+
+func labelNames(label string) []string {
+	if label == "" {
+		return nil
+	}
+	return []string{label}
+}
+
+func writeSample(w io.Writer, name string, names, values []string, v float64) {
+	if len(names) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+		return
+	}
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = n + `="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(values[i]) + `"`
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(pairs, ","), formatFloat(v))
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortSamples(samples []sample) {
+	sort.Slice(samples, func(i, j int) bool { return labelsLess(samples[i].labels, samples[j].labels) })
+}
+
+func labelsLess(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegistry_Expose(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_requests_total", "Number of requests.", "route", "status")
+	c.Inc("/b", "200")
+	c.Inc("/a", "500")
+	c.Add(2, "/a", "200")
+	c.Add(-1, "/a", "200")
+	c.Inc(`/"x"`, "200")
+	h := r.Histogram("test_duration_seconds", "Duration\nof requests.", []float64{1, 0.1}, "route")
+	h.Observe(0.05, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(1, "/a")
+	h.Observe(3, "/a")
+	r.GaugeFunc("test_jobs", "Number of jobs.", "status", func() map[string]float64 {
+		return map[string]float64{"RUNNING": 2, "FAILED": 1}
+	})
+	r.CounterFunc("test_bytes_total", "Reclaimed bytes.", "", func() map[string]float64 {
+		return map[string]float64{"": 1.5e9}
+	})
+	empty := r.Counter("test_empty_total", "Empty counter.")
+
+	buf := bytes.Buffer{}
+	r.Expose(&buf)
+	assert.Equal(t, `# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/\"x\"",status="200"} 1
+test_requests_total{route="/a",status="200"} 2
+test_requests_total{route="/a",status="500"} 1
+test_requests_total{route="/b",status="200"} 1
+# HELP test_duration_seconds Duration\nof requests.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/a",le="0.1"} 1
+test_duration_seconds_bucket{route="/a",le="1"} 3
+test_duration_seconds_bucket{route="/a",le="+Inf"} 4
+test_duration_seconds_sum{route="/a"} 4.55
+test_duration_seconds_count{route="/a"} 4
+# HELP test_jobs Number of jobs.
+# TYPE test_jobs gauge
+test_jobs{status="FAILED"} 1
+test_jobs{status="RUNNING"} 2
+# HELP test_bytes_total Reclaimed bytes.
+# TYPE test_bytes_total counter
+test_bytes_total 1.5e+09
+# HELP test_empty_total Empty counter.
+# TYPE test_empty_total counter
+`, buf.String())
+
+	assert.Equal(t, float64(2), c.Value("/a", "200"))
+	assert.Equal(t, float64(0), c.Value("/c", "200"))
+	assert.Equal(t, uint64(4), h.Count("/a"))
+	assert.Equal(t, float64(0), empty.Value())
+	assert.Panics(t, func() { c.Inc("/a") }, "label values must match label names")
+	assert.Panics(t, func() { r.Counter("test_jobs", "duplicated") }, "name must be unique")
+}
@@ -0,0 +1,45 @@
+package model
+
+import "time"
+
+//Batch is group of jobs submitted by single request
+type Batch struct {
+	ID        string     `json:"id"`
+	TenantID  int        `json:"tenant_id,omitempty"`
+	ClientID  int        `json:"client_id,omitempty"`
+	Items     int        `json:"items"`   //number of submitted items including rejected ones
+	JobIDs    []string   `json:"job_ids"` //ids of accepted jobs
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+//Batch aggregate statuses
+const (
+	BatchStatusRunning = "RUNNING"
+	BatchStatusSuccess = "SUCCESS"
+	BatchStatusPartial = "PARTIAL"
+	BatchStatusFailed  = "FAILED"
+
+	BatchJobExpired = "EXPIRED" //status of batch job which is removed from store by retention
+)
+
+//BatchStatus returns aggregate status of batch jobs: RUNNING till all jobs are finished, then SUCCESS if all
+//jobs are SUCCESS, FAILED if none of them is SUCCESS and PARTIAL otherwise. Expired jobs are finished unsuccessfully
+func BatchStatus(statuses []string) string {
+	success := 0
+	for _, status := range statuses {
+		if !IsFinished(status) && status != BatchJobExpired {
+			return BatchStatusRunning
+		}
+		if status == JobStatus(SUCCESS).ToString() {
+			success++
+		}
+	}
+	switch {
+	case success > 0 && success == len(statuses):
+		return BatchStatusSuccess
+	case success > 0:
+		return BatchStatusPartial
+	default:
+		return BatchStatusFailed
+	}
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBatchStatus(t *testing.T) {
+	tbl := []struct {
+		statuses []string
+		res      string
+	}{
+		{[]string{"SUCCESS", "RUNNING"}, "RUNNING"},
+		{[]string{"SUCCESS", "RETRYING"}, "RUNNING"},
+		{[]string{"SUCCESS", "SUCCESS"}, "SUCCESS"},
+		{[]string{"SUCCESS", "DEAD_LETTERED"}, "PARTIAL"},
+		{[]string{"SUCCESS", "EXPIRED"}, "PARTIAL"},
+		{[]string{"FAILED", "TIMED_OUT"}, "FAILED"},
+		{[]string{}, "FAILED"},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, BatchStatus(tt.statuses), "test case #%d", i)
+	}
+}
@@ -2,16 +2,168 @@ package model
 
 import (
 	"fmt"
+	"time"
 )
 
 type Job struct {
-	ID              string `json:"id"`
-	TenantID        int    `json:"tenant_id,omitempty"`
-	ClientID        int    `json:"client_id,omitempty"`
-	Payload         string `json:"payload,omitempty"`
-	PayloadLocation string `json:"payload_location,omitempty"`
-	PayloadSize     int    `json:"payload_size,omitempty"`
-	Status          string `json:"status,omitempty"`
+	ID              string       `json:"id"`
+	TenantID        int          `json:"tenant_id,omitempty"`
+	ClientID        int          `json:"client_id,omitempty"`
+	Payload         string       `json:"payload,omitempty"`
+	PayloadLocation string       `json:"payload_location,omitempty"`
+	PayloadSize     int          `json:"payload_size,omitempty"`
+	ResultLocation  string       `json:"result_location,omitempty"`
+	CallbackURL     string       `json:"callback_url,omitempty"`
+	Operations      []Operation  `json:"operations,omitempty"`
+	DependsOn       []string     `json:"depends_on,omitempty"`
+	HeldPayload     string       `json:"-"`                      //payload of WAITING job kept till it is dispatched to worker
+	NotBefore       *time.Time   `json:"not_before,omitempty"`   //time of the next run of SCHEDULED job
+	Cron            string       `json:"cron,omitempty"`         //recurrence of SCHEDULED job
+	Runs            int          `json:"runs,omitempty"`         //number of jobs spawned by recurring job
+	ScheduledBy     string       `json:"scheduled_by,omitempty"` //id of recurring job which spawned the job
+	RequestID       string       `json:"request_id,omitempty"`   //id of submit request passed to worker and blob services
+	TraceParent     string       `json:"-"`                      //span of submit request, requests of job are its children
+	Status          string       `json:"status,omitempty"`
+	Progress        int          `json:"progress,omitempty"`
+	Stage           string       `json:"stage,omitempty"`
+	Error           *JobError    `json:"error,omitempty"`
+	Retry           *RetryPolicy `json:"retry,omitempty"`
+	Attempts        []Attempt    `json:"attempts,omitempty"`
+	Requeues        int          `json:"requeues,omitempty"`
+	NextAttemptAt   *time.Time   `json:"next_attempt_at,omitempty"`
+	CreatedAt       *time.Time   `json:"created_at,omitempty"`
+	UpdatedAt       *time.Time   `json:"updated_at,omitempty"`
+}
+
+//JobError explains why job is failed
+type JobError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Retryable  bool   `json:"retryable"`
+	WorkerNode string `json:"worker_node,omitempty"`
+}
+
+//Attempt is finished unsuccessful attempt of job processing
+type Attempt struct {
+	Number     int       `json:"number"`
+	Requeue    int       `json:"requeue,omitempty"` //number of admin requeues of job before the attempt
+	Status     string    `json:"status"`
+	Error      *JobError `json:"error,omitempty"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+//RetryPolicy defines how failed job is dispatched again. Empty fields of job policy are taken from tenant
+//or default policy
+type RetryPolicy struct {
+	MaxAttempts    int      `json:"max_attempts,omitempty"`
+	BackoffSeconds int      `json:"backoff_seconds,omitempty"`
+	RetryableCodes []string `json:"retryable_codes,omitempty"`
+}
+
+//Validate checks that policy has no negative values
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 || p.MaxAttempts > 100 {
+		return fmt.Errorf("max_attempts %d is out of 0..100", p.MaxAttempts)
+	}
+	if p.BackoffSeconds < 0 {
+		return fmt.Errorf("backoff_seconds %d is negative", p.BackoffSeconds)
+	}
+	for _, code := range p.RetryableCodes {
+		if code == "" {
+			return fmt.Errorf("retryable code is empty")
+		}
+	}
+	return nil
+}
+
+//Merge returns policy with empty fields taken from base
+func (p RetryPolicy) Merge(base RetryPolicy) RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = base.MaxAttempts
+	}
+	if p.BackoffSeconds == 0 {
+		p.BackoffSeconds = base.BackoffSeconds
+	}
+	if len(p.RetryableCodes) == 0 {
+		p.RetryableCodes = base.RetryableCodes
+	}
+	return p
+}
+
+//Retryable returns true if job can be dispatched again after err. Error is retryable if its code is in
+//RetryableCodes, or by worker flag if the codes are not set
+func (p RetryPolicy) Retryable(err *JobError) bool {
+	if err == nil {
+		return false
+	}
+	if len(p.RetryableCodes) == 0 {
+		return err.Retryable
+	}
+	for _, code := range p.RetryableCodes {
+		if code == err.Code {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ErrorCodeUnknown           = "UNKNOWN"
+	ErrorCodeWorkerUnreachable = "WORKER_UNREACHABLE"
+	ErrorCodeDependencyFailed  = "DEPENDENCY_FAILED"
+	ErrorCodeCanceled          = "CANCELED_BY_CLIENT"
+	ErrorCodeFailedByAdmin     = "FAILED_BY_ADMIN"
+	ErrorCodeQuotaExceeded     = "QUOTA_EXCEEDED"
+)
+
+//WorkerStatus is job status polled from worker service, result location is set for SUCCESS job
+type WorkerStatus struct {
+	Status         JobStatus
+	ResultLocation string
+}
+
+//StatusReport is job state pushed by worker service
+type StatusReport struct {
+	Status         string    `json:"status"`
+	Progress       int       `json:"progress"`
+	Stage          string    `json:"stage,omitempty"`
+	ResultLocation string    `json:"result_location,omitempty"`
+	Error          *JobError `json:"error,omitempty"`
+	FailureReason  string    `json:"failure_reason,omitempty"` //plain reason of workers without structured errors
+}
+
+//Validate checks that report has worker status and progress in percents
+func (r StatusReport) Validate() error {
+	switch r.Status {
+	case JobStatus(RUNNING).ToString(), JobStatus(SUCCESS).ToString(), JobStatus(FAILED).ToString():
+	default:
+		return fmt.Errorf("unknown status %q", r.Status)
+	}
+	if r.Progress < 0 || r.Progress > 100 {
+		return fmt.Errorf("progress %d is out of 0..100", r.Progress)
+	}
+	if r.Status == JobStatus(SUCCESS).ToString() && r.ResultLocation == "" {
+		return fmt.Errorf("result_location is required for %s status", r.Status)
+	}
+	if r.Error != nil && r.Error.Code == "" {
+		return fmt.Errorf("error code is required")
+	}
+	return nil
+}
+
+//JobError returns error of FAILED job from report, error with UNKNOWN code is made if worker didn't send it
+func (r StatusReport) JobError() *JobError {
+	if r.Status != JobStatus(FAILED).ToString() {
+		return nil
+	}
+	if r.Error != nil {
+		e := *r.Error
+		return &e
+	}
+	if r.FailureReason != "" {
+		return &JobError{Code: ErrorCodeUnknown, Message: r.FailureReason}
+	}
+	return &JobError{Code: ErrorCodeUnknown, Message: "worker reported failure without reason"}
 }
 
 type JobStatus int
@@ -20,6 +172,12 @@ const (
 	RUNNING = iota
 	SUCCESS
 	FAILED
+	TIMED_OUT
+	RETRYING
+	DEAD_LETTERED
+	WAITING
+	CANCELED
+	SCHEDULED
 )
 
 func (js JobStatus) ToString() string {
@@ -30,7 +188,30 @@ func (js JobStatus) ToString() string {
 		return "SUCCESS"
 	case FAILED:
 		return "FAILED"
+	case TIMED_OUT:
+		return "TIMED_OUT"
+	case RETRYING:
+		return "RETRYING"
+	case DEAD_LETTERED:
+		return "DEAD_LETTERED"
+	case WAITING:
+		return "WAITING"
+	case CANCELED:
+		return "CANCELED"
+	case SCHEDULED:
+		return "SCHEDULED"
 	default:
 		return fmt.Sprintf("%d", int(js))
 	}
 }
+
+//IsFinished returns true for job status after which job is not changed anymore, DEAD_LETTERED job can be
+//only requeued by admin. CANCELED job is never dispatched as its dependency is failed
+func IsFinished(status string) bool {
+	switch status {
+	case JobStatus(SUCCESS).ToString(), JobStatus(FAILED).ToString(), JobStatus(TIMED_OUT).ToString(),
+		JobStatus(DEAD_LETTERED).ToString(), JobStatus(CANCELED).ToString():
+		return true
+	}
+	return false
+}
@@ -7,16 +7,127 @@ import (
 
 func TestJobStatus_ToString(t *testing.T) {
 	tbl := []struct {
-		js   JobStatus
+		js  JobStatus
 		res string
 	}{
 		{JobStatus(0), "RUNNING"},
 		{JobStatus(1), "SUCCESS"},
 		{JobStatus(2), "FAILED"},
+		{JobStatus(3), "TIMED_OUT"},
+		{JobStatus(4), "RETRYING"},
+		{JobStatus(5), "DEAD_LETTERED"},
+		{JobStatus(6), "WAITING"},
+		{JobStatus(7), "CANCELED"},
+		{JobStatus(8), "SCHEDULED"},
 		{JobStatus(15), "15"},
-
 	}
 	for i, tt := range tbl {
 		assert.Equal(t, tt.res, tt.js.ToString(), "test case #%d", i)
 	}
 }
+
+func TestIsFinished(t *testing.T) {
+	tbl := []struct {
+		status string
+		res    bool
+	}{
+		{"RUNNING", false},
+		{"SUCCESS", true},
+		{"FAILED", true},
+		{"TIMED_OUT", true},
+		{"RETRYING", false},
+		{"DEAD_LETTERED", true},
+		{"WAITING", false},
+		{"CANCELED", true},
+		{"SCHEDULED", false},
+		{"", false},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, IsFinished(tt.status), "test case #%d", i)
+	}
+}
+
+func TestStatusReport_Validate(t *testing.T) {
+	tbl := []struct {
+		report StatusReport
+		err    string
+	}{
+		{StatusReport{Status: "RUNNING", Progress: 50}, ""},
+		{StatusReport{Status: "SUCCESS", Progress: 100, ResultLocation: "/blob/1"}, ""},
+		{StatusReport{Status: "FAILED", FailureReason: "out of memory"}, ""},
+		{StatusReport{Status: "TIMED_OUT"}, `unknown status "TIMED_OUT"`},
+		{StatusReport{Status: "RUNNING", Progress: 101}, "progress 101 is out of 0..100"},
+		{StatusReport{Status: "SUCCESS"}, "result_location is required for SUCCESS status"},
+		{StatusReport{Status: "FAILED", Error: &JobError{Message: "out of memory"}}, "error code is required"},
+	}
+	for i, tt := range tbl {
+		err := tt.report.Validate()
+		if tt.err == "" {
+			assert.NoError(t, err, "test case #%d", i)
+			continue
+		}
+		assert.EqualError(t, err, tt.err, "test case #%d", i)
+	}
+}
+
+func TestStatusReport_JobError(t *testing.T) {
+	tbl := []struct {
+		report StatusReport
+		res    *JobError
+	}{
+		{StatusReport{Status: "RUNNING", Error: &JobError{Code: "OOM"}}, nil},
+		{StatusReport{Status: "FAILED", Error: &JobError{Code: "OOM", Message: "out of memory", Retryable: true, WorkerNode: "w1"}},
+			&JobError{Code: "OOM", Message: "out of memory", Retryable: true, WorkerNode: "w1"}},
+		{StatusReport{Status: "FAILED", FailureReason: "corrupted image"}, &JobError{Code: "UNKNOWN", Message: "corrupted image"}},
+		{StatusReport{Status: "FAILED"}, &JobError{Code: "UNKNOWN", Message: "worker reported failure without reason"}},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, tt.report.JobError(), "test case #%d", i)
+	}
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	tbl := []struct {
+		policy RetryPolicy
+		err    string
+	}{
+		{RetryPolicy{}, ""},
+		{RetryPolicy{MaxAttempts: 3, BackoffSeconds: 2, RetryableCodes: []string{"PROCESSING_FAILED"}}, ""},
+		{RetryPolicy{MaxAttempts: -1}, "max_attempts -1 is out of 0..100"},
+		{RetryPolicy{MaxAttempts: 101}, "max_attempts 101 is out of 0..100"},
+		{RetryPolicy{BackoffSeconds: -5}, "backoff_seconds -5 is negative"},
+		{RetryPolicy{RetryableCodes: []string{"A", ""}}, "retryable code is empty"},
+	}
+	for i, tt := range tbl {
+		err := tt.policy.Validate()
+		if tt.err == "" {
+			assert.NoError(t, err, "test case #%d", i)
+			continue
+		}
+		assert.EqualError(t, err, tt.err, "test case #%d", i)
+	}
+}
+
+func TestRetryPolicy_Merge(t *testing.T) {
+	base := RetryPolicy{MaxAttempts: 3, BackoffSeconds: 2, RetryableCodes: []string{"A"}}
+	assert.Equal(t, base, RetryPolicy{}.Merge(base))
+	assert.Equal(t, RetryPolicy{MaxAttempts: 5, BackoffSeconds: 2, RetryableCodes: []string{"B"}},
+		RetryPolicy{MaxAttempts: 5, RetryableCodes: []string{"B"}}.Merge(base))
+}
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	tbl := []struct {
+		policy RetryPolicy
+		err    *JobError
+		res    bool
+	}{
+		{RetryPolicy{}, nil, false},
+		{RetryPolicy{}, &JobError{Code: "A", Retryable: true}, true},
+		{RetryPolicy{}, &JobError{Code: "A"}, false},
+		{RetryPolicy{RetryableCodes: []string{"A", "B"}}, &JobError{Code: "B"}, true},
+		{RetryPolicy{RetryableCodes: []string{"A"}}, &JobError{Code: "C", Retryable: true}, false},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, tt.policy.Retryable(tt.err), "test case #%d", i)
+	}
+}
@@ -0,0 +1,166 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+//Operation is single processing step of job pipeline, operations are applied to image in order
+type Operation struct {
+	Type   string                 `json:"type"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+//Operation types
+const (
+	OperationResize    = "resize"
+	OperationCrop      = "crop"
+	OperationRotate    = "rotate"
+	OperationConvert   = "convert"
+	OperationThumbnail = "thumbnail"
+	OperationWatermark = "watermark"
+)
+
+//MaxOperations limits length of job pipeline
+const MaxOperations = 20
+
+type paramKind int
+
+const (
+	paramInt paramKind = iota
+	paramNumber
+	paramString
+)
+
+//paramSchema describes allowed values of operation parameter
+type paramSchema struct {
+	kind     paramKind
+	required bool
+	min, max float64  //range of number, or length of string
+	enum     []string //allowed values of string
+}
+
+//operationSchemas are parameters of known operation types
+var operationSchemas = map[string]map[string]paramSchema{
+	OperationResize: {
+		"width":  {kind: paramInt, min: 1, max: 10000},
+		"height": {kind: paramInt, min: 1, max: 10000},
+		"fit":    {kind: paramString, enum: []string{"contain", "cover", "fill"}},
+	},
+	OperationCrop: {
+		"x":      {kind: paramInt, min: 0, max: 10000},
+		"y":      {kind: paramInt, min: 0, max: 10000},
+		"width":  {kind: paramInt, required: true, min: 1, max: 10000},
+		"height": {kind: paramInt, required: true, min: 1, max: 10000},
+	},
+	OperationRotate: {
+		"angle": {kind: paramNumber, required: true, min: -360, max: 360},
+	},
+	OperationConvert: {
+		"format":  {kind: paramString, required: true, enum: []string{"jpeg", "png", "webp", "gif"}},
+		"quality": {kind: paramInt, min: 1, max: 100},
+	},
+	OperationThumbnail: {
+		"size": {kind: paramInt, required: true, min: 1, max: 1024},
+	},
+	OperationWatermark: {
+		"text":     {kind: paramString, required: true, min: 1, max: 256},
+		"position": {kind: paramString, enum: []string{"center", "top-left", "top-right", "bottom-left", "bottom-right"}},
+		"opacity":  {kind: paramNumber, min: 0, max: 1},
+	},
+}
+
+//ValidateOperations checks pipeline length and every operation against its schema
+func ValidateOperations(ops []Operation) error {
+	if len(ops) > MaxOperations {
+		return fmt.Errorf("pipeline has %d operations, allowed %d", len(ops), MaxOperations)
+	}
+	for i, op := range ops {
+		if err := op.Validate(); err != nil {
+			return fmt.Errorf("operation #%d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+//Validate checks that operation type is known and its parameters match schema
+func (o Operation) Validate() error {
+	schema, ok := operationSchemas[o.Type]
+	if !ok {
+		return fmt.Errorf("unknown operation type %q, allowed %s", o.Type, strings.Join(OperationTypes(), ", "))
+	}
+	for name := range o.Params {
+		if _, ok := schema[name]; !ok {
+			return fmt.Errorf("unknown parameter %q of %s", name, o.Type)
+		}
+	}
+	names := make([]string, 0, len(schema))
+	for name := range schema {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		param := schema[name]
+		value, ok := o.Params[name]
+		if !ok {
+			if param.required {
+				return fmt.Errorf("parameter %q of %s is required", name, o.Type)
+			}
+			continue
+		}
+		if err := param.validate(value); err != nil {
+			return fmt.Errorf("parameter %q of %s %w", name, o.Type, err)
+		}
+	}
+	if o.Type == OperationResize && o.Params["width"] == nil && o.Params["height"] == nil {
+		return fmt.Errorf("width or height of %s is required", o.Type)
+	}
+	return nil
+}
+
+//OperationTypes returns sorted names of known operations
+func OperationTypes() []string {
+	res := make([]string, 0, len(operationSchemas))
+	for name := range operationSchemas {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+//validate checks value decoded from JSON
+func (p paramSchema) validate(value interface{}) error {
+	switch p.kind {
+	case paramInt, paramNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("is not a number")
+		}
+		if p.kind == paramInt && n != math.Trunc(n) {
+			return fmt.Errorf("is not an integer")
+		}
+		if n < p.min || n > p.max {
+			return fmt.Errorf("%v is out of %v..%v", n, p.min, p.max)
+		}
+	case paramString:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("is not a string")
+		}
+		if p.max > 0 && (float64(len(s)) < p.min || float64(len(s)) > p.max) {
+			return fmt.Errorf("length %d is out of %v..%v", len(s), p.min, p.max)
+		}
+		if len(p.enum) == 0 {
+			return nil
+		}
+		for _, e := range p.enum {
+			if s == e {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", s, strings.Join(p.enum, ", "))
+	}
+	return nil
+}
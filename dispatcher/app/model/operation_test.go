@@ -0,0 +1,55 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestOperation_Validate(t *testing.T) {
+	tbl := []struct {
+		op  string
+		err string
+	}{
+		{`{"type":"resize","params":{"width":800}}`, ""},
+		{`{"type":"resize","params":{"height":600,"fit":"contain"}}`, ""},
+		{`{"type":"crop","params":{"x":10,"y":0,"width":100,"height":100}}`, ""},
+		{`{"type":"rotate","params":{"angle":-45.5}}`, ""},
+		{`{"type":"convert","params":{"format":"png"}}`, ""},
+		{`{"type":"thumbnail","params":{"size":128}}`, ""},
+		{`{"type":"watermark","params":{"text":"(c) company","position":"bottom-right","opacity":0.5}}`, ""},
+		{`{"type":"blur"}`, `unknown operation type "blur", allowed convert, crop, resize, rotate, thumbnail, watermark`},
+		{`{"type":"resize"}`, "width or height of resize is required"},
+		{`{"type":"resize","params":{"width":0}}`, `parameter "width" of resize 0 is out of 1..10000`},
+		{`{"type":"resize","params":{"width":10.5}}`, `parameter "width" of resize is not an integer`},
+		{`{"type":"resize","params":{"width":"10"}}`, `parameter "width" of resize is not a number`},
+		{`{"type":"resize","params":{"width":10,"fit":"stretch"}}`, `parameter "fit" of resize "stretch" is not one of contain, cover, fill`},
+		{`{"type":"resize","params":{"width":10,"depth":3}}`, `unknown parameter "depth" of resize`},
+		{`{"type":"crop","params":{}}`, `parameter "height" of crop is required`},
+		{`{"type":"convert","params":{"format":"png","quality":101}}`, `parameter "quality" of convert 101 is out of 1..100`},
+		{`{"type":"watermark","params":{"text":""}}`, `parameter "text" of watermark length 0 is out of 1..256`},
+		{`{"type":"watermark","params":{"text":1}}`, `parameter "text" of watermark is not a string`},
+	}
+	for i, tt := range tbl {
+		op := Operation{}
+		require.NoError(t, json.Unmarshal([]byte(tt.op), &op), "test case #%d", i)
+		err := op.Validate()
+		if tt.err == "" {
+			assert.NoError(t, err, "test case #%d", i)
+			continue
+		}
+		assert.EqualError(t, err, tt.err, "test case #%d", i)
+	}
+}
+
+func TestValidateOperations(t *testing.T) {
+	assert.NoError(t, ValidateOperations(nil))
+	ops := []Operation{{Type: "thumbnail", Params: map[string]interface{}{"size": float64(64)}}, {Type: "rotate"}}
+	assert.EqualError(t, ValidateOperations(ops), `operation #1: parameter "angle" of rotate is required`)
+
+	ops = make([]Operation, MaxOperations+1)
+	assert.EqualError(t, ValidateOperations(ops), "pipeline has 21 operations, allowed 20")
+	assert.True(t, strings.HasPrefix(ValidateOperations(ops[:1]).Error(), "operation #0: unknown operation type"))
+}
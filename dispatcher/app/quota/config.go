@@ -0,0 +1,113 @@
+package quota
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+)
+
+//Rate is token bucket of requests, Rate tokens are added per second up to Burst. Zero Rate disables limit
+type Rate struct {
+	Rate  float64 `yaml:"rate" json:"rate"`
+	Burst int     `yaml:"burst" json:"burst"` //rate rounded up if zero
+}
+
+//Limits are rate limit of requests and daily quotas of submitted jobs and their payload bytes, zero disables limit
+type Limits struct {
+	Rate       `yaml:",inline"`
+	DailyJobs  int64 `yaml:"daily_jobs" json:"daily_jobs"`
+	DailyBytes int64 `yaml:"daily_bytes" json:"daily_bytes"`
+}
+
+//Tenant is limits of tenant and rate limits of its clients, zero limits of tenant are taken from default ones
+type Tenant struct {
+	Limits  `yaml:",inline"`
+	Clients map[int]Rate `yaml:"clients" json:"clients,omitempty"`
+}
+
+//Config is quota config of all tenants, tenant which is not listed has default limits
+type Config struct {
+	Default Limits         `yaml:"default"`
+	Tenants map[int]Tenant `yaml:"tenants"`
+}
+
+//Load reads config from yaml file
+func Load(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, errors.Wrapf(err, "can't read quota file %s", path)
+	}
+	cfg, err := Parse(data)
+	if err != nil {
+		return Config{}, errors.Wrapf(err, "invalid quota file %s", path)
+	}
+	return cfg, nil
+}
+
+//Parse decodes and validates config from yaml, unknown fields are rejected
+func Parse(data []byte) (Config, error) {
+	cfg := Config{}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+//Validate checks that limits are not negative
+func (c Config) Validate() error {
+	if err := c.Default.validate(); err != nil {
+		return errors.Wrap(err, "default")
+	}
+	for id, tenant := range c.Tenants {
+		if err := tenant.validate(); err != nil {
+			return errors.Wrapf(err, "tenant %d", id)
+		}
+		for clientID, rate := range tenant.Clients {
+			if err := rate.validate(); err != nil {
+				return errors.Wrapf(err, "tenant %d client %d", id, clientID)
+			}
+		}
+	}
+	return nil
+}
+
+//tenant returns limits of tenant, its zero limits are taken from default
+func (c Config) tenant(id int) Limits {
+	res := c.Default
+	tenant, ok := c.Tenants[id]
+	if !ok {
+		return res
+	}
+	if tenant.Rate.Rate > 0 {
+		res.Rate = tenant.Rate
+	}
+	if tenant.DailyJobs > 0 {
+		res.DailyJobs = tenant.DailyJobs
+	}
+	if tenant.DailyBytes > 0 {
+		res.DailyBytes = tenant.DailyBytes
+	}
+	return res
+}
+
+//client returns rate limit of client of tenant, client without own limit has only limit of its tenant
+func (c Config) client(tenantID, clientID int) Rate {
+	return c.Tenants[tenantID].Clients[clientID]
+}
+
+func (l Limits) validate() error {
+	if l.DailyJobs < 0 || l.DailyBytes < 0 {
+		return errors.New("daily quota can't be negative")
+	}
+	return l.Rate.validate()
+}
+
+func (r Rate) validate() error {
+	if r.Rate < 0 || r.Burst < 0 {
+		return errors.New("rate and burst can't be negative")
+	}
+	return nil
+}
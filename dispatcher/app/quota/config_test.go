@@ -0,0 +1,74 @@
+package quota
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testConfig = `
+default:
+  rate: 10
+  burst: 20
+  daily_jobs: 100
+tenants:
+  1:
+    rate: 50
+    daily_bytes: 1048576
+    clients:
+      7: {rate: 1, burst: 2}
+  2:
+    daily_jobs: 5
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(testConfig))
+	require.NoError(t, err)
+
+	assert.Equal(t, Limits{Rate: Rate{Rate: 50}, DailyJobs: 100, DailyBytes: 1048576}, cfg.tenant(1))
+	assert.Equal(t, Limits{Rate: Rate{Rate: 10, Burst: 20}, DailyJobs: 5}, cfg.tenant(2))
+	assert.Equal(t, cfg.Default, cfg.tenant(3))
+	assert.Equal(t, Rate{Rate: 1, Burst: 2}, cfg.client(1, 7))
+	assert.Equal(t, Rate{}, cfg.client(1, 8))
+	assert.Equal(t, Rate{}, cfg.client(3, 7))
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tbl := []struct {
+		data string
+		err  string
+	}{
+		{"default: {rate: -1}", "default: rate and burst can't be negative"},
+		{"tenants: {1: {daily_jobs: -1}}", "tenant 1: daily quota can't be negative"},
+		{"tenants: {1: {clients: {7: {burst: -1}}}}", "tenant 1 client 7: rate and burst can't be negative"},
+		{"default: {rps: 1}", "field rps not found"},
+		{"tenants: [1]", "cannot unmarshal"},
+	}
+	for i, tt := range tbl {
+		_, err := Parse([]byte(tt.data))
+		require.Error(t, err, "test case #%d", i)
+		assert.Contains(t, err.Error(), tt.err, "test case #%d", i)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "quota")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "quota.yml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testConfig), 0600))
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Tenants, 2)
+
+	_, err = Load(filepath.Join(dir, "missing.yml"))
+	assert.Contains(t, err.Error(), "can't read quota file")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("default: {rate: -1}"), 0600))
+	_, err = Load(path)
+	assert.Contains(t, err.Error(), "invalid quota file")
+}
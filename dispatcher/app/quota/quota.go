@@ -0,0 +1,216 @@
+package quota
+
+import (
+	"github.com/pkg/errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//ErrQuotaExceeded is error of job which doesn't fit daily quota of its tenant
+var ErrQuotaExceeded = errors.New("daily quota is exceeded")
+
+//Service limits requests of tenants and their clients by token buckets and counts jobs and payload bytes
+//submitted by tenants per UTC day. Usage is kept in memory, so it starts from zero after restart
+type Service struct {
+	lock    sync.Mutex
+	config  Config
+	buckets map[string]*bucket
+	usage   map[int]*Counters
+	day     time.Time
+	now     func() time.Time
+}
+
+//Decision is result of rate limit check of request
+type Decision struct {
+	Allowed    bool
+	Limit      int           //size of bucket, zero if request is not limited
+	Remaining  int           //tokens left in bucket
+	Reset      time.Duration //time till bucket is full
+	RetryAfter time.Duration //time till next token for rejected request
+}
+
+//Counters are jobs and payload bytes submitted by tenant
+type Counters struct {
+	Jobs  int64 `json:"jobs"`
+	Bytes int64 `json:"bytes"`
+}
+
+//Usage is daily usage of tenant with its limits
+type Usage struct {
+	TenantID int       `json:"tenant_id"`
+	Limits   Limits    `json:"limits"`
+	Used     Counters  `json:"used"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+//New makes service of quota config
+func New(cfg Config) *Service {
+	return &Service{config: cfg, buckets: map[string]*bucket{}, usage: map[int]*Counters{}}
+}
+
+//Update replaces config, buckets are made again with new limits and daily usage is kept
+func (s *Service) Update(cfg Config) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.config = cfg
+	s.buckets = map[string]*bucket{}
+}
+
+//SetTenant replaces limits of tenant and its clients, buckets are made again with new limits and daily usage is kept
+func (s *Service) SetTenant(id int, tenant Tenant) error {
+	cfg := Config{Tenants: map[int]Tenant{id: tenant}}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tenants := make(map[int]Tenant, len(s.config.Tenants)+1)
+	for tid, t := range s.config.Tenants {
+		tenants[tid] = t
+	}
+	tenants[id] = tenant
+	s.config.Tenants = tenants
+	s.buckets = map[string]*bucket{}
+	return nil
+}
+
+//Allow takes token of request from bucket of tenant and from bucket of client if it has own limit.
+//Decision of the more restrictive bucket is returned, token isn't taken from any bucket if request is rejected
+func (s *Service) Allow(tenantID, clientID int) Decision {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.time()
+	res := Decision{Allowed: true}
+	var taken []*bucket
+	keys := []string{"tenant:" + strconv.Itoa(tenantID), "client:" + strconv.Itoa(tenantID) + ":" + strconv.Itoa(clientID)}
+	rates := []Rate{s.config.tenant(tenantID).Rate, s.config.client(tenantID, clientID)}
+	for i, rate := range rates {
+		if rate.Rate <= 0 {
+			continue
+		}
+		b := s.bucket(keys[i], rate, now)
+		d := b.take(now)
+		if !d.Allowed {
+			for _, t := range taken {
+				t.tokens++
+			}
+			return d
+		}
+		taken = append(taken, b)
+		if res.Limit == 0 || d.Remaining < res.Remaining {
+			res = d
+		}
+	}
+	return res
+}
+
+//Consume adds jobs and bytes to daily usage of tenant, nothing is added if any quota is exceeded
+func (s *Service) Consume(tenantID int, jobs, bytes int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	limits, used := s.config.tenant(tenantID), s.counters(tenantID)
+	if limits.DailyJobs > 0 && used.Jobs+jobs > limits.DailyJobs {
+		return errors.Wrapf(ErrQuotaExceeded, "tenant %d submitted %d of %d jobs today", tenantID, used.Jobs, limits.DailyJobs)
+	}
+	if limits.DailyBytes > 0 && used.Bytes+bytes > limits.DailyBytes {
+		return errors.Wrapf(ErrQuotaExceeded, "tenant %d submitted %d of %d bytes today", tenantID, used.Bytes, limits.DailyBytes)
+	}
+	used.Jobs += jobs
+	used.Bytes += bytes
+	return nil
+}
+
+//Refund returns jobs and bytes which are not submitted to daily usage of tenant
+func (s *Service) Refund(tenantID int, jobs, bytes int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	used := s.counters(tenantID)
+	used.Jobs = nonNegative(used.Jobs - jobs)
+	used.Bytes = nonNegative(used.Bytes - bytes)
+}
+
+//Usage returns daily usage of tenant
+func (s *Service) Usage(tenantID int) Usage {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	used := s.counters(tenantID)
+	return Usage{TenantID: tenantID, Limits: s.config.tenant(tenantID), Used: *used, ResetsAt: s.day.AddDate(0, 0, 1)}
+}
+
+//counters returns usage of tenant for today, usage of all tenants is reset at UTC midnight
+func (s *Service) counters(tenantID int) *Counters {
+	today := s.time().UTC().Truncate(24 * time.Hour)
+	if !today.Equal(s.day) {
+		s.day, s.usage = today, map[int]*Counters{}
+	}
+	if s.usage == nil {
+		s.usage = map[int]*Counters{}
+	}
+	if _, ok := s.usage[tenantID]; !ok {
+		s.usage[tenantID] = &Counters{}
+	}
+	return s.usage[tenantID]
+}
+
+func (s *Service) bucket(key string, rate Rate, now time.Time) *bucket {
+	if s.buckets == nil {
+		s.buckets = map[string]*bucket{}
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		burst := float64(rate.Burst)
+		if burst == 0 {
+			burst = math.Ceil(rate.Rate)
+		}
+		b = &bucket{rate: rate.Rate, burst: burst, tokens: burst, last: now}
+		s.buckets[key] = b
+	}
+	return b
+}
+
+func (s *Service) time() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+//bucket is token bucket refilled by rate tokens per second up to burst
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+//take refills bucket and takes one token if it has it
+func (b *bucket) take(now time.Time) Decision {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+	res := Decision{Limit: int(b.burst)}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = b.duration(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = b.duration(b.burst - b.tokens)
+	return res
+}
+
+//duration returns time of refill of n tokens
+func (b *bucket) duration(n float64) time.Duration {
+	return time.Duration(math.Ceil(n / b.rate * float64(time.Second)))
+}
+
+func nonNegative(v int64) int64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
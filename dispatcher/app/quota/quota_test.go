@@ -0,0 +1,116 @@
+package quota
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func testService(cfg Config, now *time.Time) *Service {
+	s := New(cfg)
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestService_Allow(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := testService(Config{Default: Limits{Rate: Rate{Rate: 1, Burst: 2}}}, &now)
+
+	assert.Equal(t, Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, s.Allow(1, 1))
+	assert.Equal(t, Decision{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, s.Allow(1, 2))
+	assert.Equal(t, Decision{Allowed: false, Limit: 2, Remaining: 0, Reset: 2 * time.Second, RetryAfter: time.Second},
+		s.Allow(1, 1), "bucket of tenant is shared by its clients")
+	assert.True(t, s.Allow(2, 1).Allowed, "tenants have own buckets")
+
+	now = now.Add(500 * time.Millisecond)
+	d := s.Allow(1, 1)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, s.Allow(1, 1).Allowed, "bucket is refilled")
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, 1, s.Allow(1, 1).Remaining, "bucket is refilled up to burst")
+}
+
+func TestService_AllowClient(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := testService(Config{Tenants: map[int]Tenant{
+		1: {Limits: Limits{Rate: Rate{Rate: 10}}, Clients: map[int]Rate{7: {Rate: 1}}},
+		2: {Clients: map[int]Rate{7: {Rate: 1}}},
+	}}, &now)
+
+	assert.Equal(t, Decision{Allowed: true, Limit: 1, Remaining: 0, Reset: time.Second}, s.Allow(1, 7),
+		"the more restrictive bucket of client is returned")
+	assert.False(t, s.Allow(1, 7).Allowed)
+	d := s.Allow(1, 8)
+	assert.Equal(t, 10, d.Limit)
+	assert.Equal(t, 8, d.Remaining, "rejected request of client doesn't take token from tenant bucket")
+
+	assert.True(t, s.Allow(2, 7).Allowed, "client is limited without limit of its tenant")
+	assert.False(t, s.Allow(2, 7).Allowed)
+	assert.Equal(t, Decision{Allowed: true}, s.Allow(3, 7), "tenant without limits is not limited")
+}
+
+func TestService_Update(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := testService(Config{Default: Limits{Rate: Rate{Rate: 1}, DailyJobs: 1}}, &now)
+	assert.True(t, s.Allow(1, 1).Allowed)
+	assert.False(t, s.Allow(1, 1).Allowed)
+	require.NoError(t, s.Consume(1, 1, 10))
+
+	s.Update(Config{Default: Limits{Rate: Rate{Rate: 5}, DailyJobs: 2}})
+	assert.Equal(t, 5, s.Allow(1, 1).Limit)
+	assert.Equal(t, Counters{Jobs: 1, Bytes: 10}, s.Usage(1).Used, "usage is kept")
+	assert.NoError(t, s.Consume(1, 1, 10))
+}
+
+func TestService_SetTenant(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	cfg := Config{Default: Limits{Rate: Rate{Rate: 1}}, Tenants: map[int]Tenant{2: {Limits: Limits{DailyJobs: 3}}}}
+	s := testService(cfg, &now)
+	require.NoError(t, s.Consume(1, 1, 10))
+
+	require.NoError(t, s.SetTenant(1, Tenant{Limits: Limits{Rate: Rate{Rate: 5}, DailyJobs: 1}}))
+	assert.Equal(t, 5, s.Allow(1, 1).Limit)
+	assert.Equal(t, 1, s.Allow(2, 1).Limit, "other tenants are not changed")
+	assert.Equal(t, int64(3), s.Usage(2).Limits.DailyJobs)
+	assert.Equal(t, Counters{Jobs: 1, Bytes: 10}, s.Usage(1).Used, "usage is kept")
+	assert.True(t, errors.Is(s.Consume(1, 1, 10), ErrQuotaExceeded))
+	assert.Equal(t, 1, len(cfg.Tenants), "config passed to service is not changed")
+
+	err := s.SetTenant(1, Tenant{Limits: Limits{DailyJobs: -1}})
+	assert.Contains(t, err.Error(), "daily quota can't be negative")
+	assert.Equal(t, int64(1), s.Usage(1).Limits.DailyJobs, "invalid limits are not applied")
+}
+
+func TestService_Consume(t *testing.T) {
+	now := time.Date(2021, 3, 1, 23, 0, 0, 0, time.UTC)
+	s := testService(Config{Default: Limits{DailyJobs: 3, DailyBytes: 100}, Tenants: map[int]Tenant{
+		2: {Limits: Limits{DailyBytes: 1000}},
+	}}, &now)
+
+	require.NoError(t, s.Consume(1, 1, 60))
+	err := s.Consume(1, 1, 50)
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	assert.EqualError(t, err, "tenant 1 submitted 60 of 100 bytes today: daily quota is exceeded")
+	require.NoError(t, s.Consume(1, 2, 40))
+	err = s.Consume(1, 1, 0)
+	assert.EqualError(t, err, "tenant 1 submitted 3 of 3 jobs today: daily quota is exceeded")
+	require.NoError(t, s.Consume(2, 1, 500), "tenant has own quota")
+
+	s.Refund(1, 1, 40)
+	assert.Equal(t, Usage{TenantID: 1, Limits: Limits{DailyJobs: 3, DailyBytes: 100}, Used: Counters{Jobs: 2, Bytes: 60},
+		ResetsAt: time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)}, s.Usage(1))
+	s.Refund(1, 5, 500)
+	assert.Equal(t, Counters{}, s.Usage(1).Used, "usage can't be negative")
+
+	require.NoError(t, s.Consume(1, 3, 100))
+	now = now.Add(time.Hour)
+	assert.Equal(t, Counters{}, s.Usage(1).Used, "usage is reset at midnight")
+	assert.Equal(t, time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC), s.Usage(1).ResetsAt)
+	assert.NoError(t, s.Consume(1, 3, 100))
+}
@@ -0,0 +1,163 @@
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"log"
+	"sync"
+	"time"
+)
+
+//StatusGetter provides job status from worker service
+type StatusGetter interface {
+	GetStatusJob(id string) (model.WorkerStatus, error)
+}
+
+//Reconciler periodically syncs status of unfinished jobs from worker service into dispatcher store.
+//Jobs which worker can't report for longer than Deadline are marked as TIMED_OUT
+type Reconciler struct {
+	Store     *store.Store
+	Worker    StatusGetter
+	Interval  time.Duration
+	BatchSize int
+	Deadline  time.Duration
+
+	lock     sync.Mutex
+	lastSeen map[string]time.Time
+	now      func() time.Time
+}
+
+//Run reconciles jobs every Interval till context is canceled
+func (r *Reconciler) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	log.Printf("[INFO] start status reconciler, interval=%s, batch=%d, deadline=%s", interval, r.BatchSize, r.Deadline)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] status reconciler terminated")
+			return
+		case <-ticker.C:
+			r.Reconcile()
+		}
+	}
+}
+
+//Reconcile makes single pass over RUNNING jobs, jobs waiting for retry are not known by worker.
+//Jobs are checked in batches of BatchSize parallel worker calls
+func (r *Reconciler) Reconcile() {
+	jobs := r.Store.Find(func(job model.Job) bool { return job.Status == model.JobStatus(model.RUNNING).ToString() })
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = 10
+	}
+	for start := 0; start < len(jobs); start += batchSize {
+		end := start + batchSize
+		if end > len(jobs) {
+			end = len(jobs)
+		}
+		var wg sync.WaitGroup
+		for _, job := range jobs[start:end] {
+			wg.Add(1)
+			go func(job model.Job) {
+				defer wg.Done()
+				r.sync(job)
+			}(job)
+		}
+		wg.Wait()
+	}
+	r.forget()
+}
+
+//sync gets job status from worker and writes transition to store
+func (r *Reconciler) sync(job model.Job) {
+	var jobErr *model.JobError
+	polled, err := r.Worker.GetStatusJob(job.ID)
+	status := polled.Status
+	if err != nil {
+		seen := r.seen(job)
+		if r.Deadline <= 0 || r.timeNow().Sub(seen) < r.Deadline {
+			log.Printf("[WARN] can't get status of job %s, %v", job.ID, err)
+			return
+		}
+		status = model.TIMED_OUT
+		jobErr = &model.JobError{Code: model.ErrorCodeWorkerUnreachable, Retryable: true,
+			Message: fmt.Sprintf("worker can't report job status since %s: %v", seen.Format(time.RFC3339), err)}
+		log.Printf("[WARN] job %s is unreachable since %s, mark it as %s", job.ID, seen.Format(time.RFC3339), status.ToString())
+	} else {
+		r.markSeen(job.ID)
+	}
+
+	if status.ToString() == job.Status {
+		return
+	}
+	update := func(j *model.Job) error {
+		if j.Status != job.Status {
+			return errors.Errorf("job status was changed to %s during reconciliation", j.Status)
+		}
+		j.Status = status.ToString()
+		switch status {
+		case model.SUCCESS:
+			j.Progress = 100
+			if polled.ResultLocation != "" {
+				j.ResultLocation = polled.ResultLocation
+			}
+		case model.FAILED:
+			jobErr = model.StatusReport{Status: j.Status}.JobError()
+		}
+		j.Error = jobErr
+		return nil
+	}
+	if _, err := r.Store.Update(job.ID, update); err != nil {
+		log.Printf("[WARN] can't update status of job %s, %v", job.ID, err)
+		return
+	}
+	log.Printf("[DEBUG] job %s status changed %s -> %s", job.ID, job.Status, status.ToString())
+}
+
+//seen returns time of the last successful worker call for job, job creation time if there were no such calls
+func (r *Reconciler) seen(job model.Job) time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if t, ok := r.lastSeen[job.ID]; ok {
+		return t
+	}
+	if job.CreatedAt != nil {
+		return *job.CreatedAt
+	}
+	return r.timeNow()
+}
+
+func (r *Reconciler) markSeen(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.lastSeen == nil {
+		r.lastSeen = map[string]time.Time{}
+	}
+	r.lastSeen[id] = r.timeNow()
+}
+
+//forget drops last seen time of finished jobs
+func (r *Reconciler) forget() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for id := range r.lastSeen {
+		if job, err := r.Store.Get(id); err != nil || model.IsFinished(job.Status) {
+			delete(r.lastSeen, id)
+		}
+	}
+}
+
+func (r *Reconciler) timeNow() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,124 @@
+package reconciler
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type statusGetterFunc func(id string) (model.WorkerStatus, error)
+
+func (f statusGetterFunc) GetStatusJob(id string) (model.WorkerStatus, error) { return f(id) }
+
+func TestReconciler_Reconcile(t *testing.T) {
+	s := store.New(
+		model.Job{ID: "1"},
+		model.Job{ID: "2"},
+		model.Job{ID: "3", Status: "SUCCESS"},
+		model.Job{ID: "4"},
+		model.Job{ID: "5", Status: "RETRYING"},
+	)
+	var lock sync.Mutex
+	calls := map[string]int{}
+	worker := statusGetterFunc(func(id string) (model.WorkerStatus, error) {
+		lock.Lock()
+		calls[id]++
+		lock.Unlock()
+		switch id {
+		case "1":
+			return model.WorkerStatus{Status: model.SUCCESS, ResultLocation: "/blob/1"}, nil
+		case "2":
+			return model.WorkerStatus{Status: model.RUNNING}, nil
+		default:
+			return model.WorkerStatus{Status: -1}, errors.New("worker is unreachable")
+		}
+	})
+	var updates []string
+	s.OnUpdate(func(job model.Job) { updates = append(updates, job.ID+":"+job.Status) })
+
+	r := Reconciler{Store: s, Worker: worker, BatchSize: 2, Deadline: time.Hour}
+	r.Reconcile()
+	assert.Equal(t, map[string]int{"1": 1, "2": 1, "4": 1}, calls, "finished and retrying jobs are not polled")
+	assert.Equal(t, []string{"1:SUCCESS"}, updates, "only transitions are written")
+
+	success, err := s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, 100, success.Progress)
+	assert.Equal(t, "/blob/1", success.ResultLocation, "result location is polled with status")
+
+	r.Reconcile()
+	assert.Equal(t, map[string]int{"1": 1, "2": 2, "4": 2}, calls)
+	job, err := s.Get("4")
+	require.NoError(t, err)
+	assert.Equal(t, "RUNNING", job.Status, "unreachable job is kept before deadline")
+}
+
+func TestReconciler_TimedOut(t *testing.T) {
+	s := store.New(model.Job{ID: "1"}, model.Job{ID: "2"})
+	var fail atomic.Value
+	fail.Store(false)
+	worker := statusGetterFunc(func(id string) (model.WorkerStatus, error) {
+		if id == "2" || fail.Load().(bool) {
+			return model.WorkerStatus{Status: -1}, errors.New("worker is unreachable")
+		}
+		return model.WorkerStatus{Status: model.RUNNING}, nil
+	})
+	now := time.Now()
+	r := Reconciler{Store: s, Worker: worker, Deadline: time.Minute, now: func() time.Time { return now }}
+
+	r.Reconcile()
+	fail.Store(true)
+	now = now.Add(50 * time.Second)
+	r.Reconcile()
+	job, err := s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "RUNNING", job.Status)
+
+	now = now.Add(20 * time.Second)
+	r.Reconcile()
+	job, err = s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "TIMED_OUT", job.Status, "deadline is counted from the last successful call")
+	require.NotNil(t, job.Error)
+	assert.Equal(t, "WORKER_UNREACHABLE", job.Error.Code)
+	assert.True(t, job.Error.Retryable)
+	job, err = s.Get("2")
+	require.NoError(t, err)
+	assert.Equal(t, "TIMED_OUT", job.Status, "deadline is counted from creation if worker never answered")
+	assert.Equal(t, 0, len(r.lastSeen))
+}
+
+func TestReconciler_Run(t *testing.T) {
+	s := store.New(model.Job{ID: "1"})
+	var calls int32
+	worker := statusGetterFunc(func(id string) (model.WorkerStatus, error) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return model.WorkerStatus{Status: model.RUNNING}, nil
+		}
+		return model.WorkerStatus{Status: model.FAILED}, nil
+	})
+	r := Reconciler{Store: s, Worker: worker, Interval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		job, err := s.Get("1")
+		return err == nil && job.Status == "FAILED"
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "finished job is not polled anymore")
+	job, err := s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, &model.JobError{Code: "UNKNOWN", Message: "worker reported failure without reason"}, job.Error)
+}
@@ -0,0 +1,261 @@
+package rest
+
+import (
+	"crypto/subtle"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/quota"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/retry"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//default and max number of jobs listed by admin api
+const (
+	defaultAdminJobsLimit = 100
+	maxAdminJobsLimit     = 1000
+)
+
+//serviceStatus is state of dispatcher shown by admin api
+type serviceStatus struct {
+	Queue       queueStatus       `json:"queue"`
+	Breakers    map[string]string `json:"breakers"`
+	Maintenance bool              `json:"maintenance"`
+	Draining    bool              `json:"draining"`
+}
+
+//queueStatus is number of jobs which are not finished and number of all jobs by status
+type queueStatus struct {
+	Depth    int            `json:"depth"`
+	Statuses map[string]int `json:"statuses"`
+}
+
+//secretRotation is request of JWT secret rotation, tokens signed by replaced secret are accepted for grace period
+type secretRotation struct {
+	Secret string `json:"secret"`
+	Grace  string `json:"grace,omitempty"`
+}
+
+type maintenanceMode struct {
+	Enabled bool `json:"enabled"`
+}
+
+//adminAuth passes only requests with admin bearer token
+func (r *Rest) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(r.adminToken())) != 1 {
+			r.authFailure(authAdminToken)
+			SendErrorJSON(w, req, http.StatusUnauthorized, errors.New("invalid admin token"), ErrorAdminAuth, "admin token is invalid")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+//getDeadLetteredJobs returns jobs which exhausted retry attempts
+func (r *Rest) getDeadLetteredJobs(w http.ResponseWriter, req *http.Request) {
+	render.JSON(w, req, r.Retrier.DeadLettered())
+}
+
+//requeueJob moves FAILED, TIMED_OUT or DEAD_LETTERED job back to retry
+func (r *Rest) requeueJob(w http.ResponseWriter, req *http.Request) {
+	job, err := r.Retrier.Requeue(chi.URLParam(req, "id"))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		SendErrorJSON(w, req, http.StatusNotFound, err, ErrorJobNotFound, "error during requeue of job")
+		return
+	case errors.Is(err, retry.ErrNotRequeueable):
+		SendErrorJSON(w, req, http.StatusConflict, err, ErrorJobNotRequeueable,
+			"only FAILED, TIMED_OUT or DEAD_LETTERED job can be requeued")
+		return
+	case err != nil:
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "error during requeue of job")
+		return
+	}
+	render.JSON(w, req, job)
+}
+
+//getJanitorStats returns counters of removed expired jobs and reclaimed bytes
+func (r *Rest) getJanitorStats(w http.ResponseWriter, req *http.Request) {
+	render.JSON(w, req, r.Janitor.Stats())
+}
+
+//getAllJobs returns jobs of all tenants ordered by creation time, jobs are filtered by tenant and status query
+//params and paged by limit and offset ones, total number of filtered jobs is in X-Total-Count header
+func (r *Rest) getAllJobs(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	tenant, err := intParam(query.Get("tenant"), 0)
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "tenant is invalid")
+		return
+	}
+	limit, err := intParam(query.Get("limit"), defaultAdminJobsLimit)
+	if err != nil || limit <= 0 || limit > maxAdminJobsLimit {
+		SendErrorJSON(w, req, http.StatusBadRequest, errors.Errorf("limit %q is out of 1..%d", query.Get("limit"),
+			maxAdminJobsLimit), ErrorValidation, "limit is invalid")
+		return
+	}
+	offset, err := intParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		SendErrorJSON(w, req, http.StatusBadRequest, errors.Errorf("offset %q is invalid", query.Get("offset")),
+			ErrorValidation, "offset is invalid")
+		return
+	}
+	status := strings.ToUpper(query.Get("status"))
+
+	jobs := r.Jobs.Find(func(job model.Job) bool {
+		return (tenant == 0 || job.TenantID == tenant) && (status == "" || job.Status == status)
+	})
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(jobs)))
+	res := []model.Job{}
+	for i := offset; i < len(jobs) && len(res) < limit; i++ {
+		job := jobs[i]
+		job.Payload = "" //payloads are not listed, job api returns them
+		res = append(res, job)
+	}
+	render.JSON(w, req, res)
+}
+
+//failJob moves job which is not finished to FAILED, its dependents are failed by workflow and its callback is sent.
+//Status pushed by worker for the job later is rejected as it is finished
+func (r *Rest) failJob(w http.ResponseWriter, req *http.Request) {
+	msg := struct {
+		Reason string `json:"reason"`
+	}{}
+	if req.ContentLength != 0 {
+		if err := render.DecodeJSON(http.MaxBytesReader(w, req.Body, r.maxBodySize()), &msg); err != nil {
+			status, code := readError(err)
+			SendErrorJSON(w, req, status, err, code, "can't unmarshal fail request")
+			return
+		}
+	}
+	if msg.Reason == "" {
+		msg.Reason = "job is failed by admin"
+	}
+	job, err := r.Jobs.Update(chi.URLParam(req, "id"), func(job *model.Job) error {
+		if model.IsFinished(job.Status) {
+			return errors.Wrapf(engine.ErrJobFinished, "job %s is %s", job.ID, job.Status)
+		}
+		job.Status = model.JobStatus(model.FAILED).ToString()
+		job.Error = &model.JobError{Code: model.ErrorCodeFailedByAdmin, Message: msg.Reason}
+		job.NextAttemptAt = nil
+		return nil
+	})
+	if err != nil {
+		sendEngineError(w, req, err, "error during failing job")
+		return
+	}
+	log.Printf("[WARN] job %s is failed by admin, reason=%q", job.ID, msg.Reason)
+	render.JSON(w, req, job)
+}
+
+//getServiceStatus returns queue depth, state of upstream circuit breakers and maintenance mode
+func (r *Rest) getServiceStatus(w http.ResponseWriter, req *http.Request) {
+	res := serviceStatus{
+		Queue:       queueStatus{Statuses: map[string]int{}},
+		Breakers:    map[string]string{},
+		Maintenance: r.Maintenance(),
+		Draining:    r.draining(),
+	}
+	for _, job := range r.Jobs.Find(func(model.Job) bool { return true }) {
+		res.Queue.Statuses[job.Status]++
+		if !model.IsFinished(job.Status) {
+			res.Queue.Depth++
+		}
+	}
+	for name, breaker := range r.Breakers {
+		res.Breakers[name] = breaker.State()
+	}
+	render.JSON(w, req, res)
+}
+
+//getTenantLimits returns limits and daily usage of tenant
+func (r *Rest) getTenantLimits(w http.ResponseWriter, req *http.Request) {
+	tenantID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "tenant id is invalid")
+		return
+	}
+	render.JSON(w, req, r.Quotas.Usage(tenantID))
+}
+
+//setTenantLimits replaces limits of tenant and its clients till restart or reload of config
+func (r *Rest) setTenantLimits(w http.ResponseWriter, req *http.Request) {
+	tenantID, err := strconv.Atoi(chi.URLParam(req, "id"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "tenant id is invalid")
+		return
+	}
+	tenant := quota.Tenant{}
+	if err = render.DecodeJSON(http.MaxBytesReader(w, req.Body, r.maxBodySize()), &tenant); err != nil {
+		status, code := readError(err)
+		SendErrorJSON(w, req, status, err, code, "can't unmarshal tenant limits")
+		return
+	}
+	if err = r.Quotas.SetTenant(tenantID, tenant); err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "tenant limits are invalid")
+		return
+	}
+	log.Printf("[INFO] limits of tenant %d are changed by admin, limits=%+v", tenantID, tenant)
+	render.JSON(w, req, r.Quotas.Usage(tenantID))
+}
+
+//rotateSecret replaces JWT secret till restart or reload of config, tokens signed by replaced secret are accepted
+//for grace period
+func (r *Rest) rotateSecret(w http.ResponseWriter, req *http.Request) {
+	msg := secretRotation{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, req.Body, r.maxBodySize()), &msg); err != nil {
+		status, code := readError(err)
+		SendErrorJSON(w, req, status, err, code, "can't unmarshal secret rotation")
+		return
+	}
+	if msg.Secret == "" {
+		SendErrorJSON(w, req, http.StatusBadRequest, errors.New("secret is empty"), ErrorValidation, "secret is required")
+		return
+	}
+	grace := time.Duration(0)
+	if msg.Grace != "" {
+		var err error
+		if grace, err = time.ParseDuration(msg.Grace); err != nil || grace < 0 {
+			SendErrorJSON(w, req, http.StatusBadRequest, errors.Errorf("invalid grace %q", msg.Grace), ErrorValidation,
+				"grace is invalid duration")
+			return
+		}
+	}
+	r.Auth.RotateSecret(msg.Secret, grace)
+	log.Printf("[WARN] JWT secret is rotated by admin, grace=%s", grace)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Rest) getMaintenance(w http.ResponseWriter, req *http.Request) {
+	render.JSON(w, req, maintenanceMode{Enabled: r.Maintenance()})
+}
+
+//setMaintenance turns maintenance mode on or off, mode is kept in memory, so it is off after restart
+func (r *Rest) setMaintenance(w http.ResponseWriter, req *http.Request) {
+	msg := maintenanceMode{}
+	if err := render.DecodeJSON(http.MaxBytesReader(w, req.Body, r.maxBodySize()), &msg); err != nil {
+		status, code := readError(err)
+		SendErrorJSON(w, req, status, err, code, "can't unmarshal maintenance mode")
+		return
+	}
+	r.SetMaintenance(msg.Enabled)
+	log.Printf("[WARN] maintenance mode is changed by admin, enabled=%t", msg.Enabled)
+	render.JSON(w, req, msg)
+}
+
+//intParam parses integer query param, def is returned for empty param
+func intParam(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
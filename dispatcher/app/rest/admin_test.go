@@ -0,0 +1,359 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/janitor"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/quota"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/retry"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRest_DeadLetteredJobs(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	jobs := store.New(model.Job{ID: "1", Status: "DEAD_LETTERED", Attempts: []model.Attempt{{Number: 1, Status: "FAILED"}}},
+		model.Job{ID: "2", Status: "FAILED"}, model.Job{ID: "3", Status: "TIMED_OUT"},
+		model.Job{ID: "4", Status: "SUCCESS"}, model.Job{ID: "5", Status: "CANCELED"})
+	r.Retrier = &retry.Retrier{Store: jobs}
+	r.AdminToken = "admin-secret"
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	resp := doAdminRequest(t, "GET", ts.URL+"/admin/v1/jobs/dead-lettered", "admin-secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := []model.Job{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, 1, len(res))
+	assert.Equal(t, "1", res[0].ID)
+	assert.Equal(t, 1, len(res[0].Attempts))
+
+	tbl := []struct {
+		id     string
+		code   int
+		status string
+	}{
+		{"1", http.StatusOK, "RETRYING"},
+		{"1", http.StatusConflict, "RETRYING"},
+		{"2", http.StatusOK, "RETRYING"},
+		{"3", http.StatusOK, "RETRYING"},
+		{"4", http.StatusConflict, "SUCCESS"},
+		{"5", http.StatusConflict, "CANCELED"},
+		{"6", http.StatusNotFound, ""},
+	}
+	for i, tt := range tbl {
+		resp = doAdminRequest(t, "POST", ts.URL+"/admin/v1/job/"+tt.id+"/requeue", "admin-secret")
+		assert.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		require.NoError(t, resp.Body.Close())
+		if job, err := jobs.Get(tt.id); err == nil {
+			assert.Equal(t, tt.status, job.Status, "test case #%d", i)
+		}
+	}
+}
+
+func TestRest_AdminAuth(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.Retrier = &retry.Retrier{Store: store.New()}
+	ts := httptest.NewServer(r.routes())
+	resp := doAdminRequest(t, "GET", ts.URL+"/admin/v1/jobs/dead-lettered", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "admin api is disabled without token")
+	require.NoError(t, resp.Body.Close())
+	ts.Close()
+
+	r.AdminToken = "admin-secret"
+	ts = httptest.NewServer(r.routes())
+	defer ts.Close()
+	for i, token := range []string{"", "wrong", testToken} {
+		resp = doAdminRequest(t, "GET", ts.URL+"/admin/v1/jobs/dead-lettered", token)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "test case #%d", i)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Contains(t, string(body), `"code":"INVALID_ADMIN_TOKEN"`, "test case #%d", i)
+	}
+}
+
+func TestRest_JanitorStats(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	jobs := store.New(model.Job{ID: "1", Status: "SUCCESS"})
+	r.Janitor = &janitor.Janitor{Store: jobs, DryRun: true}
+	r.AdminToken = "admin-secret"
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+	r.Janitor.Cleanup(context.Background())
+
+	resp := doAdminRequest(t, "GET", ts.URL+"/admin/v1/janitor", "admin-secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	stats := janitor.Stats{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, int64(1), stats.Runs)
+	assert.True(t, stats.DryRun)
+
+	resp = doAdminRequest(t, "GET", ts.URL+"/admin/v1/jobs/dead-lettered", "admin-secret")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "retry api is disabled without retrier")
+	require.NoError(t, resp.Body.Close())
+}
+
+func TestRest_AllJobs(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.Jobs = store.New(model.Job{ID: "1", TenantID: 1, Status: "RUNNING", Payload: "data"},
+		model.Job{ID: "2", TenantID: 2, Status: "SUCCESS"}, model.Job{ID: "3", TenantID: 1, Status: "SUCCESS"})
+	r.AdminToken = "admin-secret"
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	tbl := []struct {
+		query string
+		code  int
+		ids   []string
+		total string
+	}{
+		{"", http.StatusOK, []string{"1", "2", "3"}, "3"},
+		{"?tenant=1", http.StatusOK, []string{"1", "3"}, "2"},
+		{"?status=success", http.StatusOK, []string{"2", "3"}, "2"},
+		{"?tenant=1&status=SUCCESS", http.StatusOK, []string{"3"}, "1"},
+		{"?limit=1&offset=1", http.StatusOK, []string{"2"}, "3"},
+		{"?offset=5", http.StatusOK, []string{}, "3"},
+		{"?limit=0", http.StatusBadRequest, nil, ""},
+		{"?limit=1001", http.StatusBadRequest, nil, ""},
+		{"?offset=-1", http.StatusBadRequest, nil, ""},
+		{"?tenant=x", http.StatusBadRequest, nil, ""},
+	}
+	for i, tt := range tbl {
+		resp := doAdminRequest(t, "GET", ts.URL+"/admin/v1/jobs"+tt.query, "admin-secret")
+		require.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		if tt.code != http.StatusOK {
+			require.NoError(t, resp.Body.Close())
+			continue
+		}
+		res := []model.Job{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res), "test case #%d", i)
+		require.NoError(t, resp.Body.Close())
+		ids := []string{}
+		for _, job := range res {
+			ids = append(ids, job.ID)
+			assert.Equal(t, "", job.Payload, "test case #%d", i)
+		}
+		assert.Equal(t, tt.ids, ids, "test case #%d", i)
+		assert.Equal(t, tt.total, resp.Header.Get("X-Total-Count"), "test case #%d", i)
+	}
+}
+
+func TestRest_FailJob(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.Jobs = store.New(model.Job{ID: "1", Status: "RUNNING"}, model.Job{ID: "2", Status: "WAITING"},
+		model.Job{ID: "3", Status: "SUCCESS"})
+	retrier := &retry.Retrier{Store: r.Jobs, Default: model.RetryPolicy{MaxAttempts: 3}}
+	r.Jobs.BeforeUpdate(retrier.Intercept)
+	r.AdminToken = "admin-secret"
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	tbl := []struct {
+		id     string
+		body   string
+		code   int
+		reason string
+	}{
+		{"1", `{"reason":"worker node is lost"}`, http.StatusOK, "worker node is lost"},
+		{"2", "", http.StatusOK, "job is failed by admin"},
+		{"1", "", http.StatusConflict, ""},
+		{"3", "", http.StatusConflict, ""},
+		{"4", "", http.StatusNotFound, ""},
+		{"2", "{", http.StatusBadRequest, ""},
+	}
+	for i, tt := range tbl {
+		resp := doAdminJSONRequest(t, "POST", ts.URL+"/admin/v1/job/"+tt.id+"/fail", "admin-secret", tt.body)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		if tt.code != http.StatusOK {
+			continue
+		}
+		job, err := r.Jobs.Get(tt.id)
+		require.NoError(t, err, "test case #%d", i)
+		assert.Equal(t, "FAILED", job.Status, "test case #%d", i)
+		require.NotNil(t, job.Error, "test case #%d", i)
+		assert.Equal(t, model.JobError{Code: model.ErrorCodeFailedByAdmin, Message: tt.reason}, *job.Error,
+			"test case #%d", i)
+	}
+}
+
+func TestRest_ServiceStatus(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.Jobs = store.New(model.Job{ID: "1", Status: "RUNNING"}, model.Job{ID: "2", Status: "WAITING"},
+		model.Job{ID: "3", Status: "SUCCESS"})
+	breaker := utils.NewBreaker(1, time.Hour)
+	breaker.Done(false)
+	r.Breakers = map[string]*utils.Breaker{"worker": breaker, "blob": nil}
+	r.AdminToken = "admin-secret"
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+	r.SetMaintenance(true)
+
+	resp := doAdminRequest(t, "GET", ts.URL+"/admin/v1/status", "admin-secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := serviceStatus{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, serviceStatus{
+		Queue:       queueStatus{Depth: 2, Statuses: map[string]int{"RUNNING": 1, "WAITING": 1, "SUCCESS": 1}},
+		Breakers:    map[string]string{"worker": "open", "blob": "closed"},
+		Maintenance: true,
+	}, res)
+}
+
+func TestRest_TenantLimits(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.Quotas = quota.New(quota.Config{Default: quota.Limits{DailyJobs: 10}})
+	r.AdminToken = "admin-secret"
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	tbl := []struct {
+		method string
+		id     string
+		body   string
+		code   int
+		limits quota.Limits
+	}{
+		{"GET", "1", "", http.StatusOK, quota.Limits{DailyJobs: 10}},
+		{"PUT", "1", `{"rate":2,"burst":4,"daily_jobs":5,"clients":{"7":{"rate":1}}}`, http.StatusOK,
+			quota.Limits{Rate: quota.Rate{Rate: 2, Burst: 4}, DailyJobs: 5}},
+		{"GET", "1", "", http.StatusOK, quota.Limits{Rate: quota.Rate{Rate: 2, Burst: 4}, DailyJobs: 5}},
+		{"GET", "2", "", http.StatusOK, quota.Limits{DailyJobs: 10}},
+		{"PUT", "1", `{"daily_jobs":-1}`, http.StatusBadRequest, quota.Limits{}},
+		{"PUT", "1", `{"daily_jobs":"x"}`, http.StatusBadRequest, quota.Limits{}},
+		{"GET", "x", "", http.StatusBadRequest, quota.Limits{}},
+	}
+	for i, tt := range tbl {
+		resp := doAdminJSONRequest(t, tt.method, ts.URL+"/admin/v1/tenant/"+tt.id+"/limits", "admin-secret", tt.body)
+		require.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		if tt.code != http.StatusOK {
+			require.NoError(t, resp.Body.Close())
+			continue
+		}
+		usage := quota.Usage{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&usage), "test case #%d", i)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.limits, usage.Limits, "test case #%d", i)
+	}
+	assert.Equal(t, 1, r.Quotas.Allow(1, 7).Limit, "limit of client is set")
+}
+
+func TestRest_RotateSecret(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.AdminToken = "admin-secret"
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	tbl := []struct {
+		body      string
+		code      int
+		oldTokens bool
+	}{
+		{`{"secret":""}`, http.StatusBadRequest, true},
+		{`{"secret":"new-secret","grace":"-1s"}`, http.StatusBadRequest, true},
+		{`{"secret":"new-secret","grace":"1h"}`, http.StatusNoContent, true},
+		{`{"secret":"newer-secret"}`, http.StatusNoContent, false},
+	}
+	for i, tt := range tbl {
+		resp := doAdminJSONRequest(t, "POST", ts.URL+"/admin/v1/auth/secret", "admin-secret", tt.body)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		_, err := r.Auth.Parse(testToken)
+		assert.Equal(t, tt.oldTokens, err == nil, "test case #%d", i)
+	}
+}
+
+func TestRest_Maintenance(t *testing.T) {
+	engineMock := &engine.InterfaceMock{
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 1, Status: "RUNNING"}, nil
+		},
+		SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+			return &model.Job{ID: "1"}, nil
+		},
+	}
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.RemoteService = engineMock
+	r.AdminToken = "admin-secret"
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	tbl := []struct {
+		enabled      bool
+		code         int
+		finalizeCode int
+	}{
+		{true, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{false, http.StatusCreated, http.StatusNotFound},
+	}
+	for i, tt := range tbl {
+		resp := doAdminJSONRequest(t, "PUT", ts.URL+"/admin/v1/maintenance", "admin-secret",
+			fmt.Sprintf(`{"enabled":%t}`, tt.enabled))
+		require.Equal(t, http.StatusOK, resp.StatusCode, "test case #%d", i)
+		require.NoError(t, resp.Body.Close())
+
+		resp = doAdminRequest(t, "GET", ts.URL+"/admin/v1/maintenance", "admin-secret")
+		mode := maintenanceMode{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&mode), "test case #%d", i)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.enabled, mode.Enabled, "test case #%d", i)
+
+		resp = doRequest(t, "POST", ts.URL+"/api/v1/job", bytes.NewBufferString(validItem), nil)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		if tt.enabled {
+			assert.Contains(t, string(body), `"code":"MAINTENANCE"`, "test case #%d", i)
+		}
+
+		resp = doRequest(t, "POST", ts.URL+"/api/v1/upload/unknown/finalize", nil, nil)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.finalizeCode, resp.StatusCode, "finalize of upload, test case #%d", i)
+
+		resp = doRequest(t, "GET", ts.URL+"/api/v1/job/1", nil, nil)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "reads are served, test case #%d", i)
+	}
+}
+
+func doAdminRequest(t *testing.T, method, url, token string) *http.Response {
+	return doAdminJSONRequest(t, method, url, token, "")
+}
+
+func doAdminJSONRequest(t *testing.T, method, url, token, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
@@ -0,0 +1,328 @@
+package rest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/workflow"
+	"io"
+	"net/http"
+	"sync"
+	"unicode"
+)
+
+const (
+	defaultBatchMaxItems    = 100
+	defaultBatchMaxSize     = 16 << 20
+	defaultBatchConcurrency = 10
+)
+
+//batchItem is result of single batch item, it has either job id or error
+type batchItem struct {
+	Index  int       `json:"index"`
+	ID     string    `json:"id,omitempty"`
+	Status string    `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Code   ErrorCode `json:"code,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+type batchResponse struct {
+	BatchID  string      `json:"batch_id"`
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Items    []batchItem `json:"items"`
+}
+
+//batchInput is decoded batch item, item which can't be decoded has error and is rejected alone
+type batchInput struct {
+	msg inputMessage
+	err error
+}
+
+type batchStatusResponse struct {
+	model.Batch
+	Status string         `json:"status"`
+	Counts map[string]int `json:"counts"`
+}
+
+//submitBatch submits JSON array or NDJSON stream of inputMessages. Every item is validated and submitted independently,
+//so response is 201 if all items are accepted and 207 with per item results otherwise
+func (r *Rest) submitBatch(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	body := http.MaxBytesReader(w, req.Body, r.batchMaxSize())
+	items, err := readBatch(body, r.batchMaxItems(), int(r.maxBodySize()))
+	if err != nil {
+		status, code := readError(err)
+		SendErrorJSON(w, req, status, err, code, "can't unmarshal batch")
+		return
+	}
+	if len(items) == 0 || len(items) > r.batchMaxItems() {
+		err = fmt.Errorf("batch has no items or more than %d items", r.batchMaxItems())
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "batch size is invalid")
+		return
+	}
+
+	res := batchResponse{Items: make([]batchItem, len(items))}
+	msgs := make([]inputMessage, len(items))
+	for i, item := range items {
+		res.Items[i].Index = i
+		msgs[i] = item.msg
+		if item.err != nil {
+			res.Items[i].reject(item.err, ErrorJSONUnmarshal, "can't unmarshal inputMessage message")
+		}
+	}
+
+	//items are submitted by levels of dependency graph, so item gets ids of items it depends on
+	levels, cyclic := workflow.Levels(r.batchDependencies(msgs, res.Items))
+	for _, i := range cyclic {
+		res.Items[i].reject(errors.New("dependency cycle"), ErrorDependency, "item is in dependency cycle or depends on it")
+	}
+	sem := make(chan struct{}, r.batchConcurrency())
+	for _, level := range levels {
+		var wg sync.WaitGroup
+		for _, i := range level {
+			if res.Items[i].Error != "" {
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				res.Items[i] = r.submitBatchItem(req.Context(), i, msgs, res.Items, claims)
+			}(i)
+		}
+		wg.Wait()
+	}
+
+	batch := model.Batch{TenantID: claims.TenantID, ClientID: claims.ClientID, Items: len(items), JobIDs: []string{}}
+	for _, item := range res.Items {
+		if item.ID == "" {
+			res.Rejected++
+			continue
+		}
+		res.Accepted++
+		batch.JobIDs = append(batch.JobIDs, item.ID)
+	}
+	res.BatchID = r.Batches.Create(batch).ID
+
+	status := http.StatusCreated
+	if res.Rejected > 0 {
+		status = http.StatusMultiStatus
+	}
+	render.Status(req, status)
+	render.JSON(w, req, res)
+}
+
+//submitBatchItem validates and submits batch item i, depends_on refs of other items are replaced by their job ids
+func (r *Rest) submitBatchItem(ctx context.Context, i int, msgs []inputMessage, items []batchItem, claims *auth.Claims) batchItem {
+	res := batchItem{Index: i}
+	msg := msgs[i]
+	if code, details, err := msg.validate(ctx, r.Tracer); err != nil {
+		return res.reject(err, code, details)
+	}
+	if err := r.checkCallbackTarget(ctx, msg.CallbackURL); err != nil {
+		return res.reject(err, ErrorValidation, "callback_url is invalid")
+	}
+	refs := batchRefs(msgs)
+	dependsOn := make([]string, 0, len(msg.DependsOn))
+	for _, id := range msg.DependsOn {
+		if j, ok := refs[id]; ok {
+			if items[j].ID == "" {
+				return res.reject(errors.Errorf("dependency item #%d is rejected", j), ErrorDependency, "depends_on is invalid")
+			}
+			id = items[j].ID
+		}
+		dependsOn = append(dependsOn, id)
+	}
+	msg.DependsOn = dependsOn
+	if err := r.checkDependencies(claims.TenantID, msg.DependsOn); err != nil {
+		return res.reject(err, ErrorDependency, "depends_on is invalid")
+	}
+
+	job := r.newJob(ctx, msg, claims)
+	if err := r.scheduleJob(&job, msg); err != nil {
+		return res.reject(err, ErrorSchedule, "schedule is invalid")
+	}
+	resJob, err := r.submit(job)
+	if err != nil {
+		_, code := engineError(err)
+		return res.reject(err, code, "error during submitting job in worker service")
+	}
+	r.observeSubmit(job)
+	if job.Cron == "" {
+		r.watchCallback(resJob.ID, job.CallbackURL)
+	}
+	r.wakeWorkflow(resJob)
+	res.ID, res.Status = resJob.ID, resJob.Status
+	return res
+}
+
+//batchDependencies returns indexes of items which each item depends on by refs, item with duplicated ref is rejected
+func (r *Rest) batchDependencies(msgs []inputMessage, items []batchItem) [][]int {
+	seen := map[string]bool{}
+	for i, msg := range msgs {
+		if msg.Ref != "" && seen[msg.Ref] && items[i].Error == "" {
+			items[i].reject(errors.Errorf("ref %q is duplicated", msg.Ref), ErrorDependency, "ref is invalid")
+		}
+		seen[msg.Ref] = true
+	}
+	refs := batchRefs(msgs)
+	deps := make([][]int, len(msgs))
+	for i, msg := range msgs {
+		for _, id := range msg.DependsOn {
+			if j, ok := refs[id]; ok {
+				deps[i] = append(deps[i], j)
+			}
+		}
+	}
+	return deps
+}
+
+//batchRefs returns index of item by its ref, the first item is used for duplicated ref
+func batchRefs(msgs []inputMessage) map[string]int {
+	refs := map[string]int{}
+	for i, msg := range msgs {
+		if _, ok := refs[msg.Ref]; msg.Ref != "" && !ok {
+			refs[msg.Ref] = i
+		}
+	}
+	return refs
+}
+
+func (item *batchItem) reject(err error, code ErrorCode, details string) batchItem {
+	item.Error, item.Code, item.Detail = err.Error(), code, details
+	return *item
+}
+
+//getBatch returns batch of tenant with aggregate status of its jobs
+func (r *Rest) getBatch(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	batch, err := r.Batches.Get(chi.URLParam(req, "id"))
+	if err == nil && batch.TenantID != claims.TenantID {
+		err = errors.Wrapf(store.ErrBatchNotFound, "no batch with id: %s", batch.ID)
+	}
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusNotFound, err, ErrorJobNotFound, "error during getting batch")
+		return
+	}
+
+	res := batchStatusResponse{Batch: batch, Counts: map[string]int{}}
+	statuses := make([]string, 0, len(batch.JobIDs))
+	for _, id := range batch.JobIDs {
+		status := model.BatchJobExpired
+		if job, errJob := r.RemoteService.GetJob(id); errJob == nil {
+			status = job.Status
+		}
+		statuses = append(statuses, status)
+		res.Counts[status]++
+	}
+	res.Status = model.BatchStatus(statuses)
+	render.JSON(w, req, res)
+}
+
+//readBatch decodes items of JSON array or NDJSON stream one by one without reading whole body, blank lines of NDJSON
+//are skipped. Reading stops after maxItems+1 items, so caller can reject too large batch without decoding the rest
+func readBatch(body io.Reader, maxItems, maxItemSize int) ([]batchInput, error) {
+	br := bufio.NewReader(body)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return []batchInput{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			if err = br.UnreadByte(); err != nil {
+				return nil, err
+			}
+			if b == '[' {
+				return readBatchArray(br, maxItems)
+			}
+			break
+		}
+	}
+
+	items := []batchInput{}
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), maxItemSize+1)
+	for len(items) <= maxItems && scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			item := batchInput{}
+			item.err = json.Unmarshal(line, &item.msg)
+			items = append(items, item)
+		}
+	}
+	return items, scanner.Err()
+}
+
+//readBatchArray decodes items of JSON array, item of wrong type is rejected alone and invalid JSON fails whole batch
+func readBatchArray(body io.Reader, maxItems int) ([]batchInput, error) {
+	dec := json.NewDecoder(body)
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	items := []batchInput{}
+	for dec.More() {
+		item := batchInput{}
+		if item.err = dec.Decode(&item.msg); item.err != nil {
+			if _, ok := item.err.(*json.UnmarshalTypeError); !ok {
+				return nil, item.err
+			}
+		}
+		if items = append(items, item); len(items) > maxItems {
+			return items, nil
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after batch array")
+		}
+		return nil, err
+	}
+	return items, nil
+}
+
+func (r *Rest) batchMaxItems() int {
+	if r.BatchMaxItems > 0 {
+		return r.BatchMaxItems
+	}
+	return defaultBatchMaxItems
+}
+
+func (r *Rest) batchMaxSize() int64 {
+	if r.BatchMaxSize > 0 {
+		return r.BatchMaxSize
+	}
+	return defaultBatchMaxSize
+}
+
+func (r *Rest) batchConcurrency() int {
+	if r.BatchConcurrency > 0 {
+		return r.BatchConcurrency
+	}
+	return defaultBatchConcurrency
+}
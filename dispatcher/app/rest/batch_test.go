@@ -0,0 +1,190 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const validItem = `{"encoding":"base64","content":"MQo=","md5":"b026324c6904b2a9cb4b88d6d61c81d1"}`
+
+func startBatchServer(t *testing.T) (*httptest.Server, *Rest, *store.Store) {
+	_, r, teardown := startHTTPServer()
+	t.Cleanup(teardown)
+	jobs := store.New()
+	var lock sync.Mutex
+	r.RemoteService = &engine.InterfaceMock{
+		SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			res := jobs.Create(job)
+			return &res, nil
+		},
+		GetJobFunc: func(id string) (*model.Job, error) {
+			job, err := jobs.Get(id)
+			if err != nil {
+				return nil, err
+			}
+			return &job, nil
+		},
+	}
+	r.Batches = store.NewBatches()
+	r.BatchMaxItems = 3
+	ts := httptest.NewServer(r.routes())
+	t.Cleanup(ts.Close)
+	return ts, r, jobs
+}
+
+func TestRest_SubmitBatch(t *testing.T) {
+	ts, _, jobs := startBatchServer(t)
+
+	tbl := []struct {
+		body     string
+		code     int
+		accepted int
+		errCodes []ErrorCode
+	}{
+		{"[" + validItem + "," + validItem + "]", http.StatusCreated, 2, []ErrorCode{"", ""}},
+		{validItem + "\n\n" + `{"encoding":"base64","content":"MQo=","md5":"1"}` + "\n{\n", http.StatusMultiStatus, 1,
+			[]ErrorCode{"", ErrorMD5Validation, ErrorJSONUnmarshal}},
+		{`[{"encoding":"base64","content":"MQo=","md5":"b026324c6904b2a9cb4b88d6d61c81d1","retry":{"max_attempts":-1}}]`,
+			http.StatusMultiStatus, 0, []ErrorCode{ErrorValidation}},
+		{"[]", http.StatusBadRequest, 0, nil},
+		{"[" + strings.Repeat(validItem+",", 3) + validItem + "]", http.StatusBadRequest, 0, nil},
+		{"[" + validItem, http.StatusBadRequest, 0, nil},
+	}
+	for i, tt := range tbl {
+		body, code := postRequest(t, ts.URL+"/api/v1/jobs:batch", strings.NewReader(tt.body))
+		require.Equal(t, tt.code, code, "test case #%d, %s", i, body)
+		if tt.errCodes == nil {
+			continue
+		}
+		res := batchResponse{}
+		require.NoError(t, json.Unmarshal([]byte(body), &res), "test case #%d", i)
+		assert.NotEmpty(t, res.BatchID, "test case #%d", i)
+		assert.Equal(t, tt.accepted, res.Accepted, "test case #%d", i)
+		assert.Equal(t, len(tt.errCodes)-tt.accepted, res.Rejected, "test case #%d", i)
+		require.Equal(t, len(tt.errCodes), len(res.Items), "test case #%d", i)
+		for j, item := range res.Items {
+			assert.Equal(t, j, item.Index, "test case #%d", i)
+			assert.Equal(t, tt.errCodes[j], item.Code, "test case #%d, item %d", i, j)
+			assert.Equal(t, item.Error == "", item.ID != "", "test case #%d, item %d", i, j)
+		}
+	}
+	assert.Equal(t, 3, len(jobs.Find(nil)))
+
+	req, err := http.NewRequest("POST", ts.URL+"/api/v1/jobs:batch", strings.NewReader("["+validItem+"]"))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRest_GetBatch(t *testing.T) {
+	ts, r, jobs := startBatchServer(t)
+	body, code := postRequest(t, ts.URL+"/api/v1/jobs:batch", strings.NewReader("["+validItem+","+validItem+",{}]"))
+	require.Equal(t, http.StatusMultiStatus, code, body)
+	res := batchResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+
+	getBatch := func() batchStatusResponse {
+		body, code := getRequest(t, ts.URL+"/api/v1/batch/"+res.BatchID)
+		require.Equal(t, http.StatusOK, code, body)
+		status := batchStatusResponse{}
+		require.NoError(t, json.Unmarshal([]byte(body), &status))
+		return status
+	}
+	status := getBatch()
+	assert.Equal(t, "RUNNING", status.Status)
+	assert.Equal(t, 3, status.Items)
+	assert.Equal(t, []string{res.Items[0].ID, res.Items[1].ID}, status.JobIDs)
+	assert.Equal(t, map[string]int{"RUNNING": 2}, status.Counts)
+
+	_, err := jobs.Update(res.Items[0].ID, func(job *model.Job) error {
+		job.Status = "SUCCESS"
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, jobs.Delete(res.Items[1].ID))
+	status = getBatch()
+	assert.Equal(t, "PARTIAL", status.Status)
+	assert.Equal(t, map[string]int{"SUCCESS": 1, "EXPIRED": 1}, status.Counts)
+
+	other := r.Batches.Create(model.Batch{TenantID: 2})
+	for _, id := range []string{other.ID, "100"} {
+		_, code = getRequest(t, fmt.Sprintf("%s/api/v1/batch/%s", ts.URL, id))
+		assert.Equal(t, http.StatusNotFound, code, "batch %s", id)
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	tbl := []struct {
+		body string
+		md5s []string
+		errs int
+		err  bool
+	}{
+		{` [{"md5":"1"}, {"md5":"2"}] `, []string{"1", "2"}, 0, false},
+		{"{\"md5\":\"1\"}\n\n  {\"md5\":\"2\"}  \n", []string{"1", "2"}, 0, false},
+		{"{\"md5\":\"1\"}\n{\n{\"md5\":2}", []string{"1", "", ""}, 2, false},
+		{`[{"md5":"1"}, {"md5":2}]`, []string{"1", ""}, 1, false},
+		{`[{"md5":"1"}, {"md5":"2"}, {"md5":"3"}, {"md5":`, []string{"1", "2", "3"}, 0, false},
+		{"{\"md5\":\"1\"}\n{\"md5\":\"2\"}\n{\"md5\":\"3\"}\n{", []string{"1", "2", "3"}, 0, false},
+		{`[{"md5":"1"}, {"md5":`, nil, 0, true},
+		{`[{"md5":"1"}] {}`, nil, 0, true},
+		{"  \n", []string{}, 0, false},
+	}
+	for i, tt := range tbl {
+		items, err := readBatch(strings.NewReader(tt.body), 2, 1024)
+		if tt.err {
+			assert.Error(t, err, "test case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "test case #%d", i)
+		md5s, errs := []string{}, 0
+		for _, item := range items {
+			md5s = append(md5s, item.msg.MD5)
+			if item.err != nil {
+				errs++
+			}
+		}
+		assert.Equal(t, tt.md5s, md5s, "test case #%d", i)
+		assert.Equal(t, tt.errs, errs, "test case #%d", i)
+	}
+}
+
+func TestRest_SubmitBatchLimits(t *testing.T) {
+	ts, r, jobs := startBatchServer(t)
+	r.BatchMaxSize = 200
+
+	body, code := postRequest(t, ts.URL+"/api/v1/jobs:batch", strings.NewReader("["+validItem+","+validItem+","+validItem+"]"))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code, body)
+	assert.Contains(t, body, string(ErrorPayloadTooLarge))
+
+	//JWT is checked before body is read, so body of unauthorized request is not read
+	body = "[" + validItem + "]"
+	read := false
+	req := httptest.NewRequest("POST", "/api/v1/jobs:batch", readerFunc(func(p []byte) (int, error) {
+		read = true
+		return strings.NewReader(body).Read(p)
+	}))
+	w := httptest.NewRecorder()
+	r.submitBatch(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, read, "body of unauthorized request is read")
+	assert.Empty(t, jobs.Find(nil))
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+//Drain fails readiness and rejects new jobs while in-flight requests are finished. Event streams and long-polls
+//are ended, so clients reconnect to other instance. Uploads which are already created can be completed
+func (r *Rest) Drain() {
+	r.drainOnce.Do(func() {
+		close(r.drained())
+	})
+}
+
+//drained returns channel which is closed when drain is started
+func (r *Rest) drained() chan struct{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.drain == nil {
+		r.drain = make(chan struct{})
+	}
+	return r.drain
+}
+
+func (r *Rest) draining() bool {
+	select {
+	case <-r.drained():
+		return true
+	default:
+		return false
+	}
+}
+
+//rejectSubmissions responds with 503 to requests creating jobs after drain is started or in maintenance mode
+func (r *Rest) rejectSubmissions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.draining() {
+			w.Header().Set("Connection", "close")
+			SendErrorJSON(w, req, http.StatusServiceUnavailable, errors.New("service is draining"), ErrorShuttingDown,
+				"service is shutting down, retry on other instance")
+			return
+		}
+		r.rejectInMaintenance(next).ServeHTTP(w, req)
+	})
+}
+
+//rejectInMaintenance responds with 503 to requests creating jobs in maintenance mode
+func (r *Rest) rejectInMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if r.Maintenance() {
+			SendErrorJSON(w, req, http.StatusServiceUnavailable, errors.New("maintenance mode is on"), ErrorMaintenance,
+				"new jobs are not accepted in maintenance mode, retry later")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
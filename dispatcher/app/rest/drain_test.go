@@ -0,0 +1,128 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/health"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/upload"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRest_Drain(t *testing.T) {
+	engineMock := &engine.InterfaceMock{
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 1, Status: "RUNNING"}, nil
+		},
+		SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+			return &model.Job{ID: "1"}, nil
+		},
+	}
+	r := &Rest{
+		Version:       "test",
+		Auth:          auth.NewService(auth.Opts{}),
+		Uploads:       upload.NewService(1024, time.Minute),
+		RemoteService: engineMock,
+		Watcher:       watcher.New(engineMock, 10*time.Millisecond),
+		Health:        health.NewChecker(time.Minute, time.Second),
+	}
+	defer r.Watcher.Close()
+	r.Health.Add("store", func(ctx context.Context) error { return nil })
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	_, code := getRequest(t, ts.URL+"/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	events := doRequest(t, "GET", ts.URL+"/api/v1/job/1/events", nil, nil)
+	defer events.Body.Close()
+	require.Equal(t, http.StatusOK, events.StatusCode)
+	type pollResult struct {
+		body string
+		took time.Duration
+	}
+	poll := make(chan pollResult, 1)
+	go func() {
+		st := time.Now()
+		body, _ := getRequest(t, ts.URL+"/api/v1/job/1/status?wait=5s")
+		poll <- pollResult{body: body, took: time.Since(st)}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	r.Drain()
+	r.Drain()
+	res := <-poll
+	assert.Equal(t, `{"status":"RUNNING"}`, strings.TrimSpace(res.body))
+	assert.True(t, res.took < time.Second, "long-poll is ended by drain")
+	data, err := ioutil.ReadAll(events.Body)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "event: end", "stream is ended without end event")
+
+	for i, url := range []string{"/api/v1/job", "/api/v1/upload"} {
+		resp := doRequest(t, "POST", ts.URL+url, bytes.NewBufferString(validItem), nil)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "test case #%d", i)
+		problem := Problem{}
+		require.NoError(t, json.Unmarshal(body, &problem))
+		assert.Equal(t, ErrorShuttingDown, problem.Code, "test case #%d", i)
+	}
+
+	resp := doRequest(t, "GET", ts.URL+"/api/v1/job/1", nil, nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "requests to existing jobs are served")
+	resp = doRequest(t, "POST", ts.URL+"/api/v1/upload/unknown/finalize", nil, nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "finalize of upload isn't rejected by drain")
+
+	body, code := getRequest(t, ts.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	report := health.Report{}
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.False(t, report.Ready)
+	assert.Equal(t, health.StatusFail, report.Checks["drain"].Status)
+	assert.Equal(t, health.StatusOK, report.Checks["store"].Status)
+}
+
+func TestRest_ShutdownFinishesInFlight(t *testing.T) {
+	started := make(chan struct{})
+	srv := Rest{
+		Auth:            auth.NewService(auth.Opts{}),
+		ShutdownTimeout: 5 * time.Second,
+		RemoteService: &engine.InterfaceMock{
+			GetJobFunc: func(id string) (*model.Job, error) {
+				close(started)
+				time.Sleep(1500 * time.Millisecond)
+				return &model.Job{ID: id, TenantID: 1, Status: "SUCCESS"}, nil
+			},
+		},
+	}
+	port := generateRndPort()
+	go srv.Run(port)
+	waitHTTPServer(port)
+
+	go func() {
+		<-started
+		srv.Drain()
+		srv.Shutdown()
+	}()
+	resp := doRequest(t, "GET", fmt.Sprintf("http://localhost:%d/api/v1/job/1", port), nil, nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "request in flight longer than default shutdown timeout is finished")
+	assert.Contains(t, string(body), `"status":"SUCCESS"`)
+}
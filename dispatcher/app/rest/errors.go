@@ -1,19 +1,199 @@
 package rest
 
 import (
-	"github.com/go-chi/render"
+	"encoding/json"
+	"errors"
+	"github.com/didip/tollbooth/v6"
+	"github.com/didip/tollbooth/v6/limiter"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/quota"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
 	"log"
 	"net/http"
+	"strings"
 )
 
+//ErrorCode is stable code of error response, clients should rely on it instead of error message
+type ErrorCode string
+
+//error catalogue, each code has title and type of problem details
 const (
-	ErrorServerInternal = 0
-	ErrorJSONUnmarshal  = 1
-	ErrorMD5Validation  = 2
+	ErrorServerInternal      ErrorCode = "INTERNAL"
+	ErrorJSONUnmarshal       ErrorCode = "MALFORMED_REQUEST"
+	ErrorValidation          ErrorCode = "VALIDATION_FAILED"
+	ErrorMD5Validation       ErrorCode = "MD5_MISMATCH"
+	ErrorPayloadTooLarge     ErrorCode = "PAYLOAD_TOO_LARGE"
+	ErrorJWTValidation       ErrorCode = "INVALID_TOKEN"
+	ErrorWorkerAuth          ErrorCode = "INVALID_WORKER_SIGNATURE"
+	ErrorAdminAuth           ErrorCode = "INVALID_ADMIN_TOKEN"
+	ErrorClientCert          ErrorCode = "INVALID_CLIENT_CERTIFICATE"
+	ErrorNotFound            ErrorCode = "NOT_FOUND"
+	ErrorMethodNotAllowed    ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrorJobNotFound         ErrorCode = "JOB_NOT_FOUND"
+	ErrorJobNotFinished      ErrorCode = "JOB_NOT_FINISHED"
+	ErrorJobFinished         ErrorCode = "JOB_FINISHED"
+	ErrorJobNotRequeueable   ErrorCode = "JOB_NOT_REQUEUEABLE"
+	ErrorJobNotCancelable    ErrorCode = "JOB_NOT_CANCELABLE"
+	ErrorDependency          ErrorCode = "INVALID_DEPENDENCY"
+	ErrorSchedule            ErrorCode = "INVALID_SCHEDULE"
+	ErrorUpload              ErrorCode = "UPLOAD_FAILED"
+	ErrorCallback            ErrorCode = "CALLBACK_FAILED"
+	ErrorFeatureDisabled     ErrorCode = "FEATURE_DISABLED"
+	ErrorRateLimited         ErrorCode = "RATE_LIMITED"
+	ErrorQuotaExceeded       ErrorCode = "QUOTA_EXCEEDED"
+	ErrorUpstreamUnavailable ErrorCode = "UPSTREAM_UNAVAILABLE"
+	ErrorUpstreamFailed      ErrorCode = "UPSTREAM_FAILED"
+	ErrorShuttingDown        ErrorCode = "SHUTTING_DOWN"
+	ErrorMaintenance         ErrorCode = "MAINTENANCE"
 )
 
-func SendErrorJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, err error, errCode int, details string) {
-	log.Printf("[DEBUG] %d, %+v, %d ", httpStatusCode, err, errCode)
-	render.Status(r, httpStatusCode)
-	render.JSON(w, r, map[string]interface{}{"error": err.Error(), "code": errCode, "details": details})
+var errorTitles = map[ErrorCode]string{
+	ErrorServerInternal:      "Internal server error",
+	ErrorJSONUnmarshal:       "Request body is malformed",
+	ErrorValidation:          "Request is invalid",
+	ErrorMD5Validation:       "MD5 hash sum of payload is not valid",
+	ErrorPayloadTooLarge:     "Request body is too large",
+	ErrorJWTValidation:       "JWT is invalid",
+	ErrorWorkerAuth:          "Worker signature is invalid",
+	ErrorAdminAuth:           "Admin token is invalid",
+	ErrorClientCert:          "Client certificate is invalid",
+	ErrorNotFound:            "Resource is not found",
+	ErrorMethodNotAllowed:    "Method is not allowed",
+	ErrorJobNotFound:         "Job is not found",
+	ErrorJobNotFinished:      "Job is not finished",
+	ErrorJobFinished:         "Job is finished",
+	ErrorJobNotRequeueable:   "Job can't be requeued",
+	ErrorJobNotCancelable:    "Job can't be canceled",
+	ErrorDependency:          "Job dependencies are invalid",
+	ErrorSchedule:            "Job schedule is invalid",
+	ErrorUpload:              "Upload failed",
+	ErrorCallback:            "Callback failed",
+	ErrorFeatureDisabled:     "Feature is disabled",
+	ErrorRateLimited:         "Rate limit is exceeded",
+	ErrorQuotaExceeded:       "Daily quota is exceeded",
+	ErrorUpstreamUnavailable: "Upstream service is unavailable",
+	ErrorUpstreamFailed:      "Upstream service failed",
+	ErrorShuttingDown:        "Service is shutting down",
+	ErrorMaintenance:         "Service is in maintenance mode",
+}
+
+//Title returns short summary of error code
+func (c ErrorCode) Title() string {
+	if title, ok := errorTitles[c]; ok {
+		return title
+	}
+	return errorTitles[ErrorServerInternal]
+}
+
+//Type returns URI of problem type of error code, e.g. urn:problem:image-jobs-dispatcher:job-not-found
+func (c ErrorCode) Type() string {
+	return "urn:problem:image-jobs-dispatcher:" + strings.ReplaceAll(strings.ToLower(string(c)), "_", "-")
+}
+
+//ContentTypeProblem is content type of error responses
+const ContentTypeProblem = "application/problem+json"
+
+//Problem is RFC 7807 problem details of error response extended by error code, error message and request id
+type Problem struct {
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	Instance  string    `json:"instance,omitempty"`
+	Code      ErrorCode `json:"code"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+//SendErrorJSON sends problem details of error with http status
+func SendErrorJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, err error, errCode ErrorCode, details string) {
+	log.Printf("[DEBUG] %d, %+v, %s request_id=%s", httpStatusCode, err, errCode, logging.RequestID(r.Context()))
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(httpStatusCode)
+	if err := json.NewEncoder(w).Encode(newProblem(r, httpStatusCode, err, errCode, details)); err != nil {
+		log.Printf("[ERROR] can not write error response, %v", err)
+	}
+}
+
+//newProblem makes problem details of error of request
+func newProblem(r *http.Request, httpStatusCode int, err error, errCode ErrorCode, details string) Problem {
+	problem := Problem{
+		Type:      errCode.Type(),
+		Title:     errCode.Title(),
+		Status:    httpStatusCode,
+		Detail:    details,
+		Instance:  r.URL.Path,
+		Code:      errCode,
+		RequestID: logging.RequestID(r.Context()),
+	}
+	if err != nil {
+		problem.Error = err.Error()
+	}
+	return problem
+}
+
+//sendEngineError sends error of engine with http status and code of its cause
+func sendEngineError(w http.ResponseWriter, r *http.Request, err error, details string) {
+	status, code := engineError(err)
+	SendErrorJSON(w, r, status, err, code, details)
+}
+
+//engineError maps error of engine to http status and error code, open breaker and 5xx response of upstream mean
+//upstream is unavailable
+func engineError(err error) (int, ErrorCode) {
+	statusErr := &utils.StatusError{}
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound, ErrorJobNotFound
+	case errors.Is(err, engine.ErrJobFinished):
+		return http.StatusConflict, ErrorJobFinished
+	case errors.Is(err, engine.ErrStaleReport):
+		return http.StatusConflict, ErrorValidation
+	case errors.Is(err, quota.ErrQuotaExceeded):
+		return http.StatusTooManyRequests, ErrorQuotaExceeded
+	case errors.Is(err, engine.ErrUpstreamUnavailable), errors.Is(err, utils.ErrBreakerOpen),
+		errors.As(err, &statusErr) && statusErr.Code >= http.StatusInternalServerError:
+		return http.StatusServiceUnavailable, ErrorUpstreamUnavailable
+	case errors.Is(err, engine.ErrUpstreamFailed), errors.As(err, &statusErr):
+		return http.StatusBadGateway, ErrorUpstreamFailed
+	}
+	return http.StatusInternalServerError, ErrorServerInternal
+}
+
+//readError returns status and code of error of reading request body limited by http.MaxBytesReader
+func readError(err error) (int, ErrorCode) {
+	if bodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge, ErrorPayloadTooLarge
+	}
+	return http.StatusBadRequest, ErrorJSONUnmarshal
+}
+
+//bodyTooLarge returns true for error of http.MaxBytesReader, go 1.15 has no typed error for it
+func bodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+//newLimiter makes limiter of max requests per second by ip which rejects requests with problem details
+func newLimiter(max float64) *limiter.Limiter {
+	lmt := tollbooth.NewLimiter(max, nil)
+	body, err := json.Marshal(Problem{Type: ErrorRateLimited.Type(), Title: ErrorRateLimited.Title(),
+		Status: http.StatusTooManyRequests, Code: ErrorRateLimited, Detail: "too many requests, retry later"})
+	if err != nil {
+		log.Printf("[ERROR] can not encode rate limit response, %v", err)
+		return lmt
+	}
+	return lmt.SetMessage(string(body)).SetMessageContentType(ContentTypeProblem)
+}
+
+//notFound sends problem details for unknown route
+func notFound(w http.ResponseWriter, r *http.Request) {
+	SendErrorJSON(w, r, http.StatusNotFound, errors.New("no route "+r.URL.Path), ErrorNotFound, "route is not found")
+}
+
+//methodNotAllowed sends problem details for known route requested with wrong method
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	SendErrorJSON(w, r, http.StatusMethodNotAllowed, errors.New("method "+r.Method+" is not allowed"),
+		ErrorMethodNotAllowed, "route doesn't support method")
 }
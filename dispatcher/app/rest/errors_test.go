@@ -0,0 +1,126 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSendErrorJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/job/5", nil)
+	req = req.WithContext(logging.WithRequestID(req.Context(), "req-1"))
+	SendErrorJSON(w, req, http.StatusNotFound, errors.New("no job with id: 5"), ErrorJobNotFound, "error during getting job")
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"type":"urn:problem:image-jobs-dispatcher:job-not-found","title":"Job is not found","status":404,
+		"detail":"error during getting job","instance":"/api/v1/job/5","code":"JOB_NOT_FOUND","error":"no job with id: 5",
+		"request_id":"req-1"}`, w.Body.String())
+}
+
+func TestEngineError(t *testing.T) {
+	tbl := []struct {
+		err    error
+		status int
+		code   ErrorCode
+	}{
+		{errors.Wrap(store.ErrNotFound, "no job with id: 1"), http.StatusNotFound, ErrorJobNotFound},
+		{errors.Wrap(engine.ErrJobFinished, "job 1 is SUCCESS"), http.StatusConflict, ErrorJobFinished},
+		{errors.Wrap(engine.ErrStaleReport, "progress 10 of job 1 is lower than current 40"), http.StatusConflict, ErrorValidation},
+		{errors.Wrap(engine.ErrUpstreamUnavailable, "can not dispatch job 1"), http.StatusServiceUnavailable, ErrorUpstreamUnavailable},
+		{errors.Wrap(utils.ErrBreakerOpen, "can not dispatch job 1"), http.StatusServiceUnavailable, ErrorUpstreamUnavailable},
+		{errors.Wrap(engine.ErrUpstreamFailed, "blob service responded with status 400"), http.StatusBadGateway, ErrorUpstreamFailed},
+		{errors.Wrap(&utils.StatusError{Code: http.StatusServiceUnavailable}, "can not get job 1"), http.StatusServiceUnavailable, ErrorUpstreamUnavailable},
+		{&utils.StatusError{Code: http.StatusInternalServerError}, http.StatusServiceUnavailable, ErrorUpstreamUnavailable},
+		{&utils.StatusError{Code: http.StatusBadRequest}, http.StatusBadGateway, ErrorUpstreamFailed},
+		{errors.New("failed"), http.StatusInternalServerError, ErrorServerInternal},
+	}
+	for i, tt := range tbl {
+		status, code := engineError(tt.err)
+		assert.Equal(t, tt.status, status, "test case #%d", i)
+		assert.Equal(t, tt.code, code, "test case #%d", i)
+	}
+}
+
+func TestRest_ErrorResponses(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	r.RemoteService = &engine.InterfaceMock{
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return nil, errors.Wrapf(store.ErrNotFound, "no job with id: %s", id)
+		},
+		SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+			return nil, errors.Wrap(engine.ErrUpstreamUnavailable, "connection refused")
+		},
+	}
+
+	tbl := []struct {
+		method, url, body string
+		auth              bool
+		status            int
+		code              ErrorCode
+	}{
+		{"GET", "/api/v1/job/5", "", true, http.StatusNotFound, ErrorJobNotFound},
+		{"GET", "/api/v1/job/5/status", "", true, http.StatusNotFound, ErrorJobNotFound},
+		{"GET", "/api/v1/job/5", "", false, http.StatusUnauthorized, ErrorJWTValidation},
+		{"GET", "/api/v1/job/5/status", "", false, http.StatusUnauthorized, ErrorJWTValidation},
+		{"POST", "/api/v1/job", validItem, false, http.StatusUnauthorized, ErrorJWTValidation},
+		{"POST", "/api/v1/job", validItem, true, http.StatusServiceUnavailable, ErrorUpstreamUnavailable},
+		{"POST", "/api/v1/job", "{", true, http.StatusBadRequest, ErrorJSONUnmarshal},
+		{"POST", "/api/v1/job", `{"content":"` + strings.Repeat("a", sizeBodyLimit) + `"}`, true,
+			http.StatusRequestEntityTooLarge, ErrorPayloadTooLarge},
+		{"GET", "/api/v1/unknown", "", true, http.StatusNotFound, ErrorNotFound},
+		{"DELETE", "/api/v1/job", "", true, http.StatusMethodNotAllowed, ErrorMethodNotAllowed},
+	}
+	for i, tt := range tbl {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.url, bytes.NewBufferString(tt.body))
+		require.NoError(t, err)
+		if tt.auth {
+			req.Header.Set("Authorization", "Bearer "+testToken)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.status, resp.StatusCode, "test case #%d", i)
+		assert.Equal(t, ContentTypeProblem, resp.Header.Get("Content-Type"), "test case #%d", i)
+		problem := Problem{}
+		require.NoError(t, json.Unmarshal(body, &problem), "test case #%d", i)
+		assert.Equal(t, tt.code, problem.Code, "test case #%d", i)
+		assert.Equal(t, tt.status, problem.Status, "test case #%d", i)
+		assert.Equal(t, tt.code.Title(), problem.Title, "test case #%d", i)
+		assert.NotEmpty(t, problem.RequestID, "test case #%d", i)
+	}
+}
+
+func TestRest_RateLimited(t *testing.T) {
+	ts, _, teardown := startHTTPServer()
+	defer teardown()
+
+	var resp *http.Response
+	for i := 0; i < 10; i++ {
+		var err error
+		resp, err = http.Get(ts.URL + "/ping")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		if resp.StatusCode == http.StatusTooManyRequests {
+			break
+		}
+	}
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, ContentTypeProblem, resp.Header.Get("Content-Type"))
+}
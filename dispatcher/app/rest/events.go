@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	maxStatusWait  = 60 * time.Second // limit of status long-polling
+	eventHeartbeat = 15 * time.Second // interval of comments keeping idle event stream alive
+)
+
+type jobEvent struct {
+	ID             string          `json:"id"`
+	Status         string          `json:"status"`
+	Progress       int             `json:"progress,omitempty"`
+	Stage          string          `json:"stage,omitempty"`
+	ResultLocation string          `json:"result_location,omitempty"`
+	Error          *model.JobError `json:"error,omitempty"`
+}
+
+//waitJobStatus responds with job status as soon as it differs from the status at request time,
+//or with the current status after wait duration
+func (r *Rest) waitJobStatus(w http.ResponseWriter, req *http.Request, jobID, wait string) {
+	d, err := time.ParseDuration(wait)
+	if err != nil || d < 0 {
+		SendErrorJSON(w, req, http.StatusBadRequest, errors.Errorf("invalid wait %q", wait), ErrorValidation, "wait must be duration like 30s")
+		return
+	}
+	if d > maxStatusWait {
+		d = maxStatusWait
+	}
+	job, err := r.nextJobState(req.Context(), jobID, d)
+	if err != nil {
+		sendEngineError(w, req, err, "error during getting job status")
+		return
+	}
+	render.JSON(w, req, map[string]string{"status": job.Status})
+}
+
+func (r *Rest) nextJobState(ctx context.Context, jobID string, wait time.Duration) (*model.Job, error) {
+	states, unsubscribe := r.Watcher.Subscribe(jobID)
+	defer unsubscribe()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	var first, last *model.Job
+	for {
+		select {
+		case job, ok := <-states:
+			if !ok {
+				if last == nil {
+					return r.RemoteService.GetJob(jobID)
+				}
+				return last, nil
+			}
+			last = &job
+			if first == nil {
+				first = &job
+				if !watcher.IsTerminal(job.Status) {
+					continue
+				}
+			}
+			return last, nil
+		case <-timer.C:
+			if last == nil {
+				return r.RemoteService.GetJob(jobID)
+			}
+			return last, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.drained():
+			if last == nil {
+				return r.RemoteService.GetJob(jobID)
+			}
+			return last, nil
+		}
+	}
+}
+
+//getJobEvents streams job status and progress changes as Server-Sent Events till job is finished
+func (r *Rest) getJobEvents(w http.ResponseWriter, req *http.Request) {
+	job, ok := r.tenantJob(w, req)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || r.Watcher == nil {
+		SendErrorJSON(w, req, http.StatusNotImplemented, errors.New("streaming is not supported"), ErrorFeatureDisabled, "can't stream events")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	r.extendWriteDeadline(req)
+	if _, err := fmt.Fprint(w, "retry: 3000\n\n"); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	states, unsubscribe := r.Watcher.Subscribe(job.ID)
+	defer unsubscribe()
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case state, ok := <-states:
+			r.extendWriteDeadline(req)
+			if !ok {
+				_, _ = fmt.Fprint(w, "event: end\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			data, err := json.Marshal(jobEvent{ID: state.ID, Status: state.Status, Progress: state.Progress, Stage: state.Stage,
+				ResultLocation: state.ResultLocation, Error: state.Error})
+			if err != nil {
+				log.Printf("[ERROR] can't marshal event of job %s, %v", state.ID, err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: status\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			r.extendWriteDeadline(req)
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		case <-r.drained():
+			//stream is ended without end event, so client reconnects to other instance
+			return
+		}
+	}
+}
+
+//extendWriteDeadline moves write deadline of connection by WriteTimeout of server before each write of stream,
+//so stream isn't cut by WriteTimeout counted from its request while stuck client is still dropped
+func (r *Rest) extendWriteDeadline(req *http.Request) {
+	conn, ok := req.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(durationOrDefault(r.WriteTimeout, defaultWriteTimeout))); err != nil {
+		log.Printf("[WARN] can't set write deadline of stream, %v", err)
+	}
+}
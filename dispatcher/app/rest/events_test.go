@@ -0,0 +1,178 @@
+package rest
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRest_GetJobEvents(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	var status atomic.Value
+	status.Store("RUNNING")
+	engineMock := &engine.InterfaceMock{
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 1, Status: status.Load().(string), ResultLocation: "/blob/1"}, nil
+		},
+	}
+	r.RemoteService = engineMock
+	r.Watcher = watcher.New(engineMock, 10*time.Millisecond)
+	defer r.Watcher.Close()
+
+	resp := doRequest(t, "GET", ts.URL+"/api/v1/job/1/events", nil, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			lines = append(lines, line)
+			if len(lines) == 1 {
+				status.Store("SUCCESS")
+			}
+		}
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{
+		`data: {"id":"1","status":"RUNNING","result_location":"/blob/1"}`,
+		`data: {"id":"1","status":"SUCCESS","result_location":"/blob/1"}`,
+		`data: {}`,
+	}, lines)
+}
+
+func TestRest_GetJobEventsLongerThanWriteTimeout(t *testing.T) {
+	var status atomic.Value
+	status.Store("RUNNING")
+	engineMock := &engine.InterfaceMock{
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 1, Status: status.Load().(string)}, nil
+		},
+	}
+	r := &Rest{Auth: auth.NewService(auth.Opts{}), RemoteService: engineMock, WriteTimeout: 200 * time.Millisecond,
+		Watcher: watcher.New(engineMock, 10*time.Millisecond)}
+	defer r.Watcher.Close()
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = r.buildHTTPServer(0, r.routes())
+	ts.Start()
+	defer ts.Close()
+
+	resp := doRequest(t, "GET", ts.URL+"/api/v1/job/1/events", nil, nil)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	go func() {
+		time.Sleep(600 * time.Millisecond)
+		status.Store("SUCCESS")
+	}()
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "data:") {
+			lines = append(lines, line)
+		}
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{
+		`data: {"id":"1","status":"RUNNING"}`,
+		`data: {"id":"1","status":"SUCCESS"}`,
+		`data: {}`,
+	}, lines, "stream is served after WriteTimeout of server")
+}
+
+func TestRest_StreamThrottle(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	engineMock := &engine.InterfaceMock{
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 1, Status: "RUNNING"}, nil
+		},
+	}
+	r.RemoteService = engineMock
+	r.Watcher = watcher.New(engineMock, 10*time.Millisecond)
+	defer r.Watcher.Close()
+	r.Throttle, r.StreamThrottle = 1, 1
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	done := make(chan int)
+	go func() {
+		_, code := getRequest(t, ts.URL+"/api/v1/job/2/status?wait=500ms")
+		done <- code
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	_, code := getRequest(t, ts.URL+"/api/v1/job/2")
+	assert.Equal(t, http.StatusOK, code, "long-poll doesn't hold slot of short requests")
+	_, code = getRequest(t, ts.URL+"/api/v1/job/2/status?wait=500ms")
+	assert.Equal(t, http.StatusTooManyRequests, code, "long-polls are limited by stream throttle")
+	assert.Equal(t, http.StatusOK, <-done)
+}
+
+func TestRest_GetJobStatusWait(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	var status atomic.Value
+	status.Store("RUNNING")
+	engineMock := &engine.InterfaceMock{
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 1, Status: status.Load().(string)}, nil
+		},
+	}
+	r.RemoteService = engineMock
+	r.Watcher = watcher.New(engineMock, 10*time.Millisecond)
+	defer r.Watcher.Close()
+
+	st := time.Now()
+	res, code := getRequest(t, ts.URL+"/api/v1/job/2/status?wait=100ms")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"status":"RUNNING"}`, strings.TrimSpace(res))
+	assert.True(t, time.Since(st) >= 100*time.Millisecond)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		status.Store("FAILED")
+	}()
+	res, code = getRequest(t, ts.URL+"/api/v1/job/2/status?wait=5s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"status":"FAILED"}`, strings.TrimSpace(res))
+
+	st = time.Now()
+	res, code = getRequest(t, ts.URL+"/api/v1/job/2/status?wait=5s")
+	assert.Equal(t, `{"status":"FAILED"}`, strings.TrimSpace(res), "finished job is returned at once")
+	assert.True(t, time.Since(st) < time.Second)
+
+	_, code = getRequest(t, ts.URL+"/api/v1/job/2/status?wait=abc")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, 0, len(engineMock.GetStatusJobCalls()))
+}
+
+func TestRest_GetJobStatusWaitOtherTenant(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	engineMock := &engine.InterfaceMock{
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 2, Status: "RUNNING"}, nil
+		},
+	}
+	r.RemoteService = engineMock
+	r.Watcher = watcher.New(engineMock, 10*time.Millisecond)
+	defer r.Watcher.Close()
+
+	st := time.Now()
+	_, code := getRequest(t, ts.URL+"/api/v1/job/2/status?wait=5s")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.True(t, time.Since(st) < time.Second, "job of other tenant is not watched")
+}
@@ -0,0 +1,31 @@
+package rest
+
+import (
+	"github.com/go-chi/render"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/health"
+	"net/http"
+	"time"
+)
+
+//getHealthz reports that process is alive, it doesn't check dependencies
+func (r *Rest) getHealthz(w http.ResponseWriter, req *http.Request) {
+	render.JSON(w, req, map[string]string{"status": "ok", "version": r.Version})
+}
+
+//getReadyz reports readiness with result of each dependency check, not ready service responds with 503.
+//Draining service is not ready regardless of checks
+func (r *Rest) getReadyz(w http.ResponseWriter, req *http.Request) {
+	report := r.Health.Report(req.Context())
+	if r.draining() {
+		checks := map[string]health.Result{"drain": {Status: health.StatusFail, Error: "service is draining", Latency: "0s",
+			CheckedAt: time.Now().UTC()}}
+		for name, res := range report.Checks {
+			checks[name] = res
+		}
+		report = health.Report{Ready: false, Checks: checks}
+	}
+	if !report.Ready {
+		render.Status(req, http.StatusServiceUnavailable)
+	}
+	render.JSON(w, req, report)
+}
@@ -0,0 +1,51 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/health"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRest_Healthz(t *testing.T) {
+	ts, _, teardown := startHTTPServer()
+	defer teardown()
+
+	body, code := getRequest(t, ts.URL+"/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status":"ok","version":"test"}`, body)
+	_, code = getRequest(t, ts.URL+"/readyz")
+	assert.Equal(t, http.StatusNotFound, code, "readyz is disabled without checks")
+}
+
+func TestRest_Readyz(t *testing.T) {
+	var workerErr error
+	r := &Rest{Version: "test", Health: health.NewChecker(0, time.Second)}
+	r.Health.Add("store", func(ctx context.Context) error { return nil })
+	r.Health.Add("worker", func(ctx context.Context) error { return workerErr })
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	body, code := getRequest(t, ts.URL+"/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	report := health.Report{}
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.True(t, report.Ready)
+	assert.Equal(t, health.StatusOK, report.Checks["worker"].Status)
+
+	workerErr = errors.New("connection refused")
+	body, code = getRequest(t, ts.URL+"/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	report = health.Report{}
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.False(t, report.Ready)
+	assert.Equal(t, health.StatusFail, report.Checks["worker"].Status)
+	assert.Equal(t, "connection refused", report.Checks["worker"].Error)
+	assert.Equal(t, health.StatusOK, report.Checks["store"].Status)
+}
@@ -0,0 +1,94 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/certs"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"io/ioutil"
+	"log"
+	"net/http"
+)
+
+const sizeReportLimit = 64 * 1024 // limit size of worker status report body
+
+//clientCertAuth passes only requests with client certificate verified by client CA bundle of server
+func (r *Rest) clientCertAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !certs.Verified(req) {
+			r.authFailure(authClientCert)
+			SendErrorJSON(w, req, http.StatusUnauthorized, errors.New("client certificate is not verified"),
+				ErrorClientCert, "client certificate is required")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+//pushJobStatus accepts job status pushed by worker service. Request must be signed by worker with shared secret,
+//response is signed by dispatcher with the same secret and request timestamp
+func (r *Rest) pushJobStatus(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, sizeReportLimit))
+	if err != nil {
+		status, code := readError(err)
+		SendErrorJSON(w, req, status, err, code, "can't read status report")
+		return
+	}
+	timestamp := req.Header.Get(auth.HeaderWorkerTimestamp)
+	err = r.WorkerSigner.VerifyRequest(timestamp, req.Header.Get(auth.HeaderWorkerSignature), req.Method, req.URL.Path, body)
+	if err != nil {
+		r.authFailure(authWorkerSignature)
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorWorkerAuth, "worker signature is invalid")
+		return
+	}
+
+	report := model.StatusReport{}
+	if err = json.Unmarshal(body, &report); err != nil {
+		r.sendSignedError(w, req, timestamp, http.StatusBadRequest, err, ErrorJSONUnmarshal, "can't unmarshal status report")
+		return
+	}
+	if err = report.Validate(); err != nil {
+		r.sendSignedError(w, req, timestamp, http.StatusBadRequest, err, ErrorValidation, "status report is invalid")
+		return
+	}
+
+	jobID := chi.URLParam(req, "id")
+	_, span := r.Tracer.Start(req.Context(), "store.update")
+	span.SetAttr("job_id", jobID)
+	span.SetAttr("status", report.Status)
+	job, err := r.RemoteService.ReportJobStatus(jobID, report)
+	span.Fail(err)
+	span.End()
+	if err != nil {
+		status, code := engineError(err)
+		r.sendSignedError(w, req, timestamp, status, err, code, "error during updating job status")
+		return
+	}
+	log.Printf("[DEBUG] worker reported status %s, progress %d%% job_id=%s tenant_id=%d request_id=%s", job.Status,
+		job.Progress, job.ID, job.TenantID, logging.RequestID(req.Context()))
+	r.sendSigned(w, req, timestamp, http.StatusOK, "application/json", map[string]interface{}{"id": job.ID, "status": job.Status, "progress": job.Progress})
+}
+
+//sendSignedError sends problem details of error signed for worker
+func (r *Rest) sendSignedError(w http.ResponseWriter, req *http.Request, timestamp string, httpStatusCode int, err error, errCode ErrorCode, details string) {
+	log.Printf("[DEBUG] %d, %+v, %s ", httpStatusCode, err, errCode)
+	r.sendSigned(w, req, timestamp, httpStatusCode, ContentTypeProblem, newProblem(req, httpStatusCode, err, errCode, details))
+}
+
+//sendSigned sends JSON response of content type with dispatcher signature header
+func (r *Rest) sendSigned(w http.ResponseWriter, req *http.Request, timestamp string, httpStatusCode int, contentType string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "error during marshal response")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set(auth.HeaderDispatcherSignature, r.WorkerSigner.SignResponse(timestamp, httpStatusCode, req.URL.Path, data))
+	w.WriteHeader(httpStatusCode)
+	if _, err = w.Write(data); err != nil {
+		log.Printf("[ERROR] cannot write response #%v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/certs"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRest_PushJobStatus(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	jobs := store.New(model.Job{ID: "1", TenantID: 1}, model.Job{ID: "2", Status: "FAILED"})
+	r.RemoteService = &engine.RestAPI{Store: jobs}
+	r.WorkerSigner = auth.NewRequestSigner("secret", time.Minute)
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	tbl := []struct {
+		id     string
+		body   string
+		code   int
+		status string
+	}{
+		{"1", `{"status":"RUNNING","progress":40}`, http.StatusOK, "RUNNING"},
+		{"1", `{"status":"SUCCESS","result_location":"/blob/1"}`, http.StatusOK, "SUCCESS"},
+		{"1", `{"status":"SUCCESS","result_location":"/blob/1"}`, http.StatusOK, "SUCCESS"},
+		{"1", `{"status":"FAILED"}`, http.StatusConflict, "SUCCESS"},
+		{"2", `{"status":"RUNNING"}`, http.StatusConflict, "FAILED"},
+		{"3", `{"status":"RUNNING"}`, http.StatusNotFound, ""},
+		{"1", `{"status":"DONE"}`, http.StatusBadRequest, "SUCCESS"},
+		{"1", `{"status":`, http.StatusBadRequest, "SUCCESS"},
+	}
+	for i, tt := range tbl {
+		resp := pushStatus(t, ts.URL, r.WorkerSigner, tt.id, tt.body)
+		assert.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		if job, err := jobs.Get(tt.id); err == nil {
+			assert.Equal(t, tt.status, job.Status, "test case #%d", i)
+		}
+	}
+
+	job, err := jobs.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "/blob/1", job.ResultLocation)
+	assert.Equal(t, 100, job.Progress)
+}
+
+func TestRest_PushJobStatusAuth(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.RemoteService = &engine.RestAPI{Store: store.New(model.Job{ID: "1"})}
+	ts := httptest.NewServer(r.routes())
+	resp, err := http.Post(ts.URL+"/internal/v1/job/1/status", "application/json", bytes.NewBufferString(`{"status":"RUNNING"}`))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "internal api is disabled without secret")
+	ts.Close()
+
+	r.WorkerSigner = auth.NewRequestSigner("secret", time.Minute)
+	ts = httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	resp = pushStatus(t, ts.URL, auth.NewRequestSigner("other", time.Minute), "1", `{"status":"RUNNING"}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "", resp.Header.Get(auth.HeaderDispatcherSignature))
+
+	body := []byte(`{"status":"RUNNING","progress":10}`)
+	path := "/internal/v1/job/1/status"
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	req, err := http.NewRequest("POST", ts.URL+path, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set(auth.HeaderWorkerTimestamp, old)
+	req.Header.Set(auth.HeaderWorkerSignature, r.WorkerSigner.SignRequest(old, "POST", path, body))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "replay of old request is rejected")
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for i, code := range []int{http.StatusOK, http.StatusUnauthorized} {
+		req, err = http.NewRequest("POST", ts.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set(auth.HeaderWorkerTimestamp, now)
+		req.Header.Set(auth.HeaderWorkerSignature, r.WorkerSigner.SignRequest(now, "POST", path, body))
+		resp, err = http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, code, resp.StatusCode, "test case #%d", i)
+	}
+}
+
+//pushSeq shifts timestamp of every push, so repeated reports are signed like worker retries
+var pushSeq int64
+
+func pushStatus(t *testing.T, url string, signer *auth.RequestSigner, id, body string) *http.Response {
+	path := "/internal/v1/job/" + id + "/status"
+	ts := strconv.FormatInt(time.Now().Unix()+atomic.AddInt64(&pushSeq, 1)%30, 10)
+	req, err := http.NewRequest("POST", url+path, bytes.NewBufferString(body))
+	require.NoError(t, err)
+	req.Header.Set(auth.HeaderWorkerTimestamp, ts)
+	req.Header.Set(auth.HeaderWorkerSignature, signer.SignRequest(ts, "POST", path, []byte(body)))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	respBody, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	if resp.StatusCode != http.StatusUnauthorized {
+		err = signer.VerifyResponse(ts, resp.Header.Get(auth.HeaderDispatcherSignature), resp.StatusCode, path, respBody)
+		assert.NoError(t, err, "response is signed by dispatcher")
+		res := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(respBody, &res))
+	}
+	return resp
+}
+
+func TestRest_PushJobStatusError(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.RemoteService = &engine.RestAPI{Store: store.New(model.Job{ID: "1", TenantID: 1})}
+	r.WorkerSigner = auth.NewRequestSigner("secret", time.Minute)
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	resp := pushStatus(t, ts.URL, r.WorkerSigner, "1", `{"status":"RUNNING","progress":20,"stage":"decode"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = pushStatus(t, ts.URL, r.WorkerSigner, "1",
+		`{"status":"FAILED","progress":20,"stage":"decode","error":{"code":"IMAGE_DECODE_FAILED","message":"corrupted image","retryable":false,"worker_node":"w1"}}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	res, code := getRequest(t, ts.URL+"/api/v1/job/1")
+	require.Equal(t, http.StatusOK, code)
+	job := model.Job{}
+	require.NoError(t, json.Unmarshal([]byte(res), &job))
+	assert.Equal(t, "FAILED", job.Status)
+	assert.Equal(t, 20, job.Progress)
+	assert.Equal(t, "decode", job.Stage)
+	assert.Equal(t, &model.JobError{Code: "IMAGE_DECODE_FAILED", Message: "corrupted image", WorkerNode: "w1"}, job.Error)
+	assert.Contains(t, res, `"error":{"code":"IMAGE_DECODE_FAILED","message":"corrupted image","retryable":false,"worker_node":"w1"}`)
+}
+
+func TestRest_PushJobStatusClientCert(t *testing.T) {
+	_, r, teardown := startHTTPServer()
+	defer teardown()
+	r.RemoteService = &engine.RestAPI{Store: store.New(model.Job{ID: "1", TenantID: 1})}
+	r.WorkerSigner = auth.NewRequestSigner("secret", time.Minute)
+
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	pairs := map[string]*certs.Reloader{}
+	for _, name := range []string{"server", "worker", "other"} {
+		certPEM, keyPEM, err := certs.SelfSigned(time.Hour, "127.0.0.1")
+		require.NoError(t, err)
+		certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+		require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+		require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+		pairs[name], err = certs.NewReloader(certFile, keyFile, 0)
+		require.NoError(t, err)
+	}
+	r.TLSConfig, err = certs.ServerConfig(pairs["server"], filepath.Join(dir, "worker.pem"))
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(r.routes())
+	ts.Listener = tls.NewListener(ts.Listener, r.TLSConfig)
+	ts.Start()
+	defer ts.Close()
+	url := "https://" + ts.Listener.Addr().String()
+
+	tbl := []struct {
+		client *certs.Reloader
+		code   int
+	}{
+		{pairs["worker"], http.StatusOK},
+		{nil, http.StatusUnauthorized},
+	}
+	for i, tt := range tbl {
+		cfg, err := certs.ClientConfig(filepath.Join(dir, "server.pem"), tt.client)
+		require.NoError(t, err)
+		client := &http.Client{Transport: certs.Transport(cfg)}
+		body := []byte(`{"status":"RUNNING"}`)
+		path := "/internal/v1/job/1/status"
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req, err := http.NewRequest("POST", url+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set(auth.HeaderWorkerTimestamp, timestamp)
+		req.Header.Set(auth.HeaderWorkerSignature, r.WorkerSigner.SignRequest(timestamp, "POST", path, body))
+		resp, err := client.Do(req)
+		require.NoError(t, err, "test case #%d", i)
+		respBody, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		if tt.code == http.StatusUnauthorized {
+			problem := Problem{}
+			require.NoError(t, json.Unmarshal(respBody, &problem))
+			assert.Equal(t, ErrorClientCert, problem.Code, "test case #%d", i)
+		}
+
+		resp, err = client.Get(url + "/ping")
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "public routes don't require client certificate, test case #%d", i)
+		client.CloseIdleConnections()
+	}
+
+	cfg, err := certs.ClientConfig(filepath.Join(dir, "server.pem"), pairs["other"])
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: certs.Transport(cfg)}).Get(url + "/ping")
+	assert.Error(t, err, "certificate which is not signed by client CA is rejected")
+}
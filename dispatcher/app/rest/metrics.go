@@ -0,0 +1,29 @@
+package rest
+
+import (
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+)
+
+//reasons of rejected requests counted by metrics
+const (
+	authMissingToken    = "missing_token"
+	authMalformedHeader = "malformed_header"
+	authInvalidToken    = "invalid_token"
+	authWorkerSignature = "worker_signature"
+	authAdminToken      = "admin_token"
+	authClientCert      = "client_cert"
+)
+
+//authFailure counts request rejected by reason
+func (r *Rest) authFailure(reason string) {
+	if r.Metrics != nil {
+		r.Metrics.AuthFailures.Inc(reason)
+	}
+}
+
+//observeSubmit counts job accepted from client
+func (r *Rest) observeSubmit(job model.Job) {
+	if r.Metrics != nil {
+		r.Metrics.ObserveSubmit(job)
+	}
+}
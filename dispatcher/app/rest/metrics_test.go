@@ -0,0 +1,55 @@
+package rest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/metrics"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRest_Metrics(t *testing.T) {
+	_, r, jobs := startWorkflowServer(t)
+	r.Metrics = metrics.New()
+	r.Metrics.WatchStore(jobs)
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+
+	body, code := postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(validItem))
+	require.Equal(t, http.StatusCreated, code, body)
+	_, code = getRequest(t, ts.URL+"/api/v1/job/1")
+	require.Equal(t, http.StatusOK, code)
+	for _, header := range []string{"", "Bearer", "Bearer bad"} {
+		req, err := http.NewRequest("GET", ts.URL+"/api/v1/job/1", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", header)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	for _, line := range []string{
+		`dispatcher_http_requests_total{route="/api/v1/job",method="POST",status="201"} 1`,
+		`dispatcher_http_requests_total{route="/api/v1/job/{id}",method="GET",status="200"} 1`,
+		`dispatcher_http_requests_total{route="/api/v1/job/{id}",method="GET",status="401"} 3`,
+		`dispatcher_jobs_submitted_total{tenant="1"} 1`,
+		`dispatcher_job_payload_bytes_count 1`,
+		`dispatcher_auth_failures_total{reason="invalid_token"} 1`,
+		`dispatcher_auth_failures_total{reason="malformed_header"} 1`,
+		`dispatcher_auth_failures_total{reason="missing_token"} 1`,
+		`dispatcher_jobs{status="RUNNING"} 1`,
+	} {
+		assert.Contains(t, string(data), line+"\n")
+	}
+}
@@ -0,0 +1,107 @@
+package rest
+
+import (
+	"github.com/didip/tollbooth_chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//reasons of requests rejected by quotas counted by metrics
+const (
+	limitRate  = "rate"
+	limitQuota = "quota"
+)
+
+//limiter returns rate limit middleware of api. Requests with valid token are limited by buckets of their tenant and
+//client if quotas are set up, other requests are limited by max requests per second from ip
+func (r *Rest) limiter() func(http.Handler) http.Handler {
+	ipLimiter := tollbooth_chi.LimitHandler(r.ipLimiter(false))
+	if r.Quotas == nil {
+		return ipLimiter
+	}
+	return func(next http.Handler) http.Handler {
+		limited := ipLimiter(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			claims := r.tokenClaims(req)
+			if claims == nil {
+				limited.ServeHTTP(w, req)
+				return
+			}
+			d := r.Quotas.Allow(claims.TenantID, claims.ClientID)
+			if d.Limit == 0 {
+				limited.ServeHTTP(w, req)
+				return
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			w.Header().Set("X-RateLimit-Reset", seconds(d.Reset))
+			if !d.Allowed {
+				r.rateLimited(claims.TenantID, limitRate)
+				w.Header().Set("Retry-After", seconds(d.RetryAfter))
+				SendErrorJSON(w, req, http.StatusTooManyRequests, errors.Errorf("tenant %d client %d exceeded %d requests",
+					claims.TenantID, claims.ClientID, d.Limit), ErrorRateLimited, "too many requests, retry later")
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+//tokenClaims returns claims of valid token of request or nil, handlers check token themselves, so it isn't
+//traced and counted as auth failure here
+func (r *Rest) tokenClaims(req *http.Request) *auth.Claims {
+	header := strings.Split(req.Header.Get("Authorization"), " ")
+	if r.Auth == nil || len(header) != 2 {
+		return nil
+	}
+	claims, err := r.Auth.Parse(header[1])
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+//getQuota returns daily usage and limits of tenant from token
+func (r *Rest) getQuota(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	render.JSON(w, req, r.Quotas.Usage(claims.TenantID))
+}
+
+//submit submits job to engine if it fits daily quota of its tenant, quota is refunded if job isn't submitted
+func (r *Rest) submit(job model.Job) (*model.Job, error) {
+	if r.Quotas == nil {
+		return r.RemoteService.SubmitJob(job)
+	}
+	if err := r.Quotas.Consume(job.TenantID, 1, int64(job.PayloadSize)); err != nil {
+		r.rateLimited(job.TenantID, limitQuota)
+		return nil, err
+	}
+	resJob, err := r.RemoteService.SubmitJob(job)
+	if err != nil {
+		r.Quotas.Refund(job.TenantID, 1, int64(job.PayloadSize))
+	}
+	return resJob, err
+}
+
+//rateLimited counts request of tenant rejected by rate limit or quota
+func (r *Rest) rateLimited(tenantID int, reason string) {
+	if r.Metrics != nil {
+		r.Metrics.RateLimited.Inc(strconv.Itoa(tenantID), reason)
+	}
+}
+
+//seconds formats duration as number of seconds rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
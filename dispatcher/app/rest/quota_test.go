@@ -0,0 +1,117 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/metrics"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/quota"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func startQuotaServer(cfg quota.Config) (*httptest.Server, *Rest) {
+	r := &Rest{
+		Version: "test",
+		Auth:    auth.NewService(auth.Opts{}),
+		Quotas:  quota.New(cfg),
+		Metrics: metrics.New(),
+		RemoteService: &engine.InterfaceMock{
+			GetJobFunc: func(id string) (*model.Job, error) {
+				return &model.Job{ID: id, TenantID: 1, Status: "SUCCESS"}, nil
+			},
+			SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+				job.ID = "1"
+				return &job, nil
+			},
+		},
+	}
+	return httptest.NewServer(r.routes()), r
+}
+
+func TestRest_TenantRateLimit(t *testing.T) {
+	ts, r := startQuotaServer(quota.Config{Tenants: map[int]quota.Tenant{1: {Limits: quota.Limits{Rate: quota.Rate{Rate: 0.1, Burst: 2}}}}})
+	defer ts.Close()
+
+	for i, remaining := range []string{"1", "0"} {
+		resp := doRequest(t, "GET", ts.URL+"/api/v1/job/5/status", nil, nil)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusOK, resp.StatusCode, "test case #%d", i)
+		assert.Equal(t, "2", resp.Header.Get("X-RateLimit-Limit"), "test case #%d", i)
+		assert.Equal(t, remaining, resp.Header.Get("X-RateLimit-Remaining"), "test case #%d", i)
+		assert.NotEmpty(t, resp.Header.Get("X-RateLimit-Reset"), "test case #%d", i)
+	}
+
+	resp := doRequest(t, "GET", ts.URL+"/api/v1/job/5", nil, nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "10", resp.Header.Get("Retry-After"))
+	problem := Problem{}
+	require.NoError(t, json.Unmarshal(body, &problem))
+	assert.Equal(t, ErrorRateLimited, problem.Code)
+	assert.Equal(t, float64(1), r.Metrics.RateLimited.Value("1", limitRate))
+
+	resp = doRequest(t, "GET", ts.URL+"/api/v1/job/5/status", nil, map[string]string{"Authorization": ""})
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "request without token is limited by ip")
+	assert.Empty(t, resp.Header.Get("X-RateLimit-Limit"))
+}
+
+func TestRest_DailyQuota(t *testing.T) {
+	ts, r := startQuotaServer(quota.Config{Default: quota.Limits{DailyJobs: 1}})
+	defer ts.Close()
+
+	resp := doRequest(t, "POST", ts.URL+"/api/v1/job", bytes.NewBufferString(validItem), nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("X-RateLimit-Limit"), "tenant without rate limit is limited by ip")
+
+	resp = doRequest(t, "POST", ts.URL+"/api/v1/job", bytes.NewBufferString(validItem), nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	problem := Problem{}
+	require.NoError(t, json.Unmarshal(body, &problem))
+	assert.Equal(t, ErrorQuotaExceeded, problem.Code)
+	assert.Equal(t, float64(1), r.Metrics.RateLimited.Value("1", limitQuota))
+
+	resp = doRequest(t, "GET", ts.URL+"/api/v1/quota", nil, nil)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	usage := quota.Usage{}
+	require.NoError(t, json.Unmarshal(body, &usage))
+	assert.Equal(t, 1, usage.TenantID)
+	assert.Equal(t, int64(1), usage.Limits.DailyJobs)
+	assert.Equal(t, quota.Counters{Jobs: 1, Bytes: int64(len("MQo="))}, usage.Used)
+	assert.False(t, usage.ResetsAt.IsZero())
+
+	resp = doRequest(t, "GET", ts.URL+"/api/v1/quota", nil, map[string]string{"Authorization": ""})
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestRest_SubmitRefundsQuota(t *testing.T) {
+	ts, r := startQuotaServer(quota.Config{Default: quota.Limits{DailyJobs: 1}})
+	defer ts.Close()
+	r.RemoteService.(*engine.InterfaceMock).SubmitJobFunc = func(job model.Job) (*model.Job, error) {
+		return nil, errors.Wrap(engine.ErrUpstreamUnavailable, "connection refused")
+	}
+
+	resp := doRequest(t, "POST", ts.URL+"/api/v1/job", bytes.NewBufferString(validItem), nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, quota.Counters{}, r.Quotas.Usage(1).Used, "job which isn't submitted is refunded")
+}
@@ -4,11 +4,12 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/didip/tollbooth/v6"
+	"github.com/didip/tollbooth/v6/limiter"
 	"github.com/didip/tollbooth_chi"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -16,9 +17,24 @@ import (
 	"github.com/go-chi/render"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/auth"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/health"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/janitor"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/metrics"
 	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/quota"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/retry"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/scheduler"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/tracing"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/upload"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/webhook"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/workflow"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -26,18 +42,62 @@ import (
 )
 
 type Rest struct {
-	Version          string
-	WorkerServiceURI string
-	RemoteService    engine.Interface
-	httpServer       *http.Server
-	Auth             *auth.Service
-	lock             sync.Mutex
+	Version           string
+	WorkerServiceURI  string
+	RemoteService     engine.Interface
+	httpServer        *http.Server
+	Auth              *auth.Service
+	URLSigner         *auth.URLSigner
+	BlobPublicURL     string
+	Uploads           *upload.Service
+	Webhooks          *webhook.Service
+	Watcher           *watcher.Watcher
+	WorkerSigner      *auth.RequestSigner
+	Retrier           *retry.Retrier
+	Janitor           *janitor.Janitor
+	AdminToken        string
+	Batches           *store.Batches
+	Workflow          *workflow.Workflow
+	Scheduler         *scheduler.Scheduler
+	Metrics           *metrics.Metrics
+	Tracer            *tracing.Tracer
+	Health            *health.Checker
+	Quotas            *quota.Service
+	Jobs              *store.Store              //jobs of all tenants, listed and failed by admin api
+	Breakers          map[string]*utils.Breaker //circuit breakers of upstreams by their names, state is shown by admin api
+	BatchMaxItems     int
+	BatchMaxSize      int64
+	BatchConcurrency  int
+	MaxBodySize       int64         //max size of submit request body, 3Mb by default
+	Throttle          int           //max number of short requests processed at once, 1000 by default
+	StreamThrottle    int           //max number of open event streams and status long-polls, 1000 by default
+	APIRate           float64       //max requests per second from ip to api, 50 by default
+	PingRate          float64       //max requests per second from ip to ping and health routes, 5 by default
+	ReadHeaderTimeout time.Duration //5s by default
+	WriteTimeout      time.Duration //120s by default
+	IdleTimeout       time.Duration //30s by default
+	TLSConfig         *tls.Config   //https is served if set, internal api requires client certificate verified by its ClientCAs
+	ShutdownTimeout   time.Duration //time of finishing in-flight requests on shutdown, 1s by default
+	lock              sync.Mutex
+	settingsLock      sync.RWMutex //guards settings replaced on reload
+	apiLimiters       []*limiter.Limiter
+	pingLimiters      []*limiter.Limiter
+	drain             chan struct{} //closed by Drain
+	drainOnce         sync.Once
+	maintenance       bool //new jobs are rejected, guarded by settingsLock
 }
 
 type inputMessage struct {
-	Encoding string `json:"encoding"`
-	MD5      string `json:"md5"`
-	Data     string `json:"content"`
+	Encoding    string             `json:"encoding"`
+	MD5         string             `json:"md5"`
+	Data        string             `json:"content"`
+	CallbackURL string             `json:"callback_url,omitempty"`
+	Retry       *model.RetryPolicy `json:"retry,omitempty"`
+	Operations  []model.Operation  `json:"operations,omitempty"`
+	DependsOn   []string           `json:"depends_on,omitempty"`
+	Ref         string             `json:"ref,omitempty"` //name of batch item used in depends_on of other items
+	NotBefore   *time.Time         `json:"not_before,omitempty"`
+	Cron        string             `json:"cron,omitempty"`
 }
 
 const sizeBodyLimit = 1024 * 1024 * 3 // limit size of inputMessage body
@@ -48,142 +108,357 @@ func (r *Rest) Run(port int) {
 	r.lock.Lock()
 	r.httpServer = r.buildHTTPServer(port, r.routes())
 	r.lock.Unlock()
-	err := r.httpServer.ListenAndServe()
+	var err error
+	if r.TLSConfig != nil {
+		//certificate is taken from GetCertificate of TLS config, so it can be reloaded without restart
+		err = r.httpServer.ListenAndServeTLS("", "")
+	} else {
+		err = r.httpServer.ListenAndServe()
+	}
 	log.Printf("[WARN] http server terminated, %s", err)
 }
 
-// Shutdown http server
+// Shutdown http server, connections which are not idle after ShutdownTimeout are closed
 func (r *Rest) Shutdown() {
 	log.Println("[WARN] shutdown http server")
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), durationOrDefault(r.ShutdownTimeout, defaultShutdownTimeout))
 	defer cancel()
 	r.lock.Lock()
 	if r.httpServer != nil {
 		if err := r.httpServer.Shutdown(ctx); err != nil {
-			log.Printf("[ERROR] http shutdown error, %s", err)
+			log.Printf("[ERROR] http shutdown error, in-flight requests are aborted, %s", err)
+			if err = r.httpServer.Close(); err != nil {
+				log.Printf("[ERROR] http close error, %s", err)
+			}
 		}
 		log.Println("[DEBUG] shutdown http server completed")
 	}
 	r.lock.Unlock()
 }
 
-//Default body size 10Mb from inputMessage.go
+//connKey is context key of connection of request, streams move its write deadline
+type connKey struct{}
+
+//Default body size 10Mb from inputMessage.go. HTTP/2 is disabled as its WriteTimeout limits the whole stream
+//and can't be moved by handler, event streams are served by HTTP/1.1 with write deadline moved by each event
 func (r *Rest) buildHTTPServer(port int, router http.Handler) *http.Server {
 	return &http.Server{
 		Addr:              fmt.Sprintf(":%d", port),
 		Handler:           router,
-		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      120 * time.Second,
-		IdleTimeout:       30 * time.Second,
+		ReadHeaderTimeout: durationOrDefault(r.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		WriteTimeout:      durationOrDefault(r.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       durationOrDefault(r.IdleTimeout, defaultIdleTimeout),
+		TLSConfig:         r.TLSConfig,
+		TLSNextProto:      map[string]func(*http.Server, *tls.Conn, http.Handler){},
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connKey{}, c)
+		},
 	}
 }
 
 func (r *Rest) routes() chi.Router {
 	router := chi.NewRouter()
-	router.Use(middleware.Throttle(1000), middleware.RealIP, middleware.Recoverer, middleware.Logger)
+	router.NotFound(notFound)
+	router.MethodNotAllowed(methodNotAllowed)
+	router.Use(middleware.RealIP, middleware.Recoverer, logging.Middleware)
+	if r.Metrics != nil {
+		router.Use(r.Metrics.Middleware)
+	}
+	if r.Tracer != nil {
+		router.Use(r.Tracer.Middleware)
+	}
 
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Content-Length", "X-XSRF-Token"},
-		ExposedHeaders:   []string{"Authorization"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Content-Length", "X-XSRF-Token", "Range", "If-None-Match", "Upload-Offset", logging.HeaderRequestID, tracing.HeaderTraceParent},
+		ExposedHeaders:   []string{"Authorization", "Content-Range", "ETag", "Location", "Upload-Offset", "Upload-Length", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After", logging.HeaderRequestID},
 		AllowCredentials: true,
 		MaxAge:           300,
 	})
 
+	//event streams and status long-polls are open for minutes, so they have own limit and don't hold slots of
+	//short requests
+	throttle := middleware.Throttle(r.throttle())
+
 	//health check api
 	router.Use(corsMiddleware.Handler)
 	router.Route("/", func(api chi.Router) {
-		api.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(5, nil)))
+		api.Use(throttle)
+		api.Use(tollbooth_chi.LimitHandler(r.ipLimiter(true)))
 		api.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 			_, err := w.Write([]byte(fmt.Sprintln("pong")))
 			if err != nil {
 				log.Printf("[ERROR] cannot write response #%v", err)
 			}
 		})
+		api.Get("/healthz", r.getHealthz)
+		if r.Health != nil {
+			api.Get("/readyz", r.getReadyz)
+		}
 	})
 
+	//metrics are scraped by prometheus
+	if r.Metrics != nil {
+		router.With(throttle).Get("/metrics", r.Metrics.Handler)
+	}
+
 	router.Route("/api/v1/", func(endpoints chi.Router) {
 		endpoints.Group(func(api chi.Router) {
+			api.Use(throttle)
 			api.Use(middleware.Timeout(30 * time.Second))
-			api.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(50, nil)))
+			api.Use(r.limiter())
 			api.Use(middleware.NoCache)
-			api.Post("/job", r.submitJob)
-			api.Get("/job/{id}/status", r.getJobStatus)
+			api.With(r.rejectSubmissions).Post("/job", r.submitJob)
 			api.Get("/job/{id}", r.getJob)
+			api.Get("/job/{id}/result/url", r.getJobResultURL)
+			api.With(r.rejectSubmissions).Post("/upload", r.createUpload)
+			api.Put("/upload/{id}", r.putUploadChunk)
+			api.Head("/upload/{id}", r.getUpload)
+			api.Get("/upload/{id}", r.getUpload)
+			api.Delete("/upload/{id}", r.deleteUpload)
+			//finalize creates job, but upload created before drain can still be finished, so only maintenance is checked
+			api.With(r.rejectInMaintenance).Post("/upload/{id}/finalize", r.finalizeUpload)
+			api.Get("/job/{id}/callbacks", r.getJobCallbacks)
+			api.Post("/job/{id}/callbacks/replay", r.replayJobCallback)
+			if r.Batches != nil {
+				api.Get("/batch/{id}", r.getBatch)
+			}
+			if r.Workflow != nil {
+				api.Get("/job/{id}/workflow", r.getJobWorkflow)
+			}
+			if r.Quotas != nil {
+				api.Get("/quota", r.getQuota)
+			}
+			if r.Scheduler != nil {
+				api.Get("/jobs/scheduled", r.getScheduledJobs)
+				api.Post("/job/{id}/cancel", r.cancelJob)
+			}
+		})
+
+		//batch carries many payloads, so it has longer timeout
+		if r.Batches != nil {
+			endpoints.Group(func(api chi.Router) {
+				api.Use(throttle)
+				api.Use(middleware.Timeout(120 * time.Second))
+				api.Use(r.limiter())
+				api.Use(middleware.NoCache)
+				api.With(r.rejectSubmissions).Post("/jobs:batch", r.submitBatch)
+			})
+		}
+
+		//status can be long-polled and events are streamed, so they have longer timeouts
+		endpoints.Group(func(api chi.Router) {
+			api.Use(middleware.Throttle(r.streamThrottle()))
+			api.Use(r.limiter())
+			api.Use(middleware.NoCache)
+			api.With(middleware.Timeout(maxStatusWait+10*time.Second)).Get("/job/{id}/status", r.getJobStatus)
+			api.Get("/job/{id}/events", r.getJobEvents)
+		})
+
+		//results are streamed, so the group skips NoCache which drops conditional request headers
+		endpoints.Group(func(api chi.Router) {
+			api.Use(throttle)
+			api.Use(middleware.Timeout(120 * time.Second))
+			api.Use(r.limiter())
+			api.Get("/job/{id}/result", r.getJobResult)
 		})
 	})
 
+	//internal api is called by worker service and authenticated by shared secret signature and client certificate
+	//if client CA is set up
+	if r.WorkerSigner != nil {
+		router.Route("/internal/v1/", func(api chi.Router) {
+			api.Use(throttle)
+			api.Use(middleware.Timeout(30 * time.Second))
+			api.Use(middleware.NoCache)
+			if r.TLSConfig != nil && r.TLSConfig.ClientCAs != nil {
+				api.Use(r.clientCertAuth)
+			}
+			api.Post("/job/{id}/status", r.pushJobStatus)
+		})
+	}
+
+	//admin api is authenticated by admin token
+	if r.AdminToken != "" {
+		router.Route("/admin/v1/", func(api chi.Router) {
+			api.Use(throttle)
+			api.Use(middleware.Timeout(30 * time.Second))
+			api.Use(middleware.NoCache)
+			api.Use(r.adminAuth)
+			if r.Retrier != nil {
+				api.Get("/jobs/dead-lettered", r.getDeadLetteredJobs)
+				api.Post("/job/{id}/requeue", r.requeueJob)
+			}
+			if r.Janitor != nil {
+				api.Get("/janitor", r.getJanitorStats)
+			}
+			if r.Jobs != nil {
+				api.Get("/jobs", r.getAllJobs)
+				api.Post("/job/{id}/fail", r.failJob)
+				api.Get("/status", r.getServiceStatus)
+			}
+			if r.Quotas != nil {
+				api.Get("/tenant/{id}/limits", r.getTenantLimits)
+				api.Put("/tenant/{id}/limits", r.setTenantLimits)
+			}
+			api.Post("/auth/secret", r.rotateSecret)
+			api.Get("/maintenance", r.getMaintenance)
+			api.Put("/maintenance", r.setMaintenance)
+		})
+	}
+
 	return router
 }
 
 func (r *Rest) getJobStatus(w http.ResponseWriter, req *http.Request) {
 	jobID := chi.URLParam(req, "id")
-	_, err := r.checkJWT(req.Header.Get("Authorization"))
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorMD5Validation, "JWT is invalid")
+	//long-poll subscribes to job status changes, so job of other tenant is not found before watching it
+	if wait := req.URL.Query().Get("wait"); wait != "" && r.Watcher != nil {
+		if _, ok := r.tenantJob(w, req); ok {
+			r.waitJobStatus(w, req, jobID, wait)
+		}
 		return
 	}
-	status, err := r.RemoteService.GetStatusJob(jobID)
+	_, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during getting job status in worker service")
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_, err = w.Write([]byte("{ \"status\":\"" + status.ToString() + "\"}"))
+	job, err := r.RemoteService.GetJob(jobID)
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during writing response")
+		sendEngineError(w, req, err, "error during getting job status")
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write([]byte("{ \"status\":\"" + job.Status + "\"}")); err != nil {
+		log.Printf("[ERROR] cannot write response #%v", err)
+	}
 }
 
 func (r *Rest) submitJob(w http.ResponseWriter, req *http.Request) {
 	msg := inputMessage{}
-	if err := render.DecodeJSON(http.MaxBytesReader(w, req.Body, sizeBodyLimit), &msg); err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorJSONUnmarshal, "can't unmarshal inputMessage message")
+	if err := render.DecodeJSON(http.MaxBytesReader(w, req.Body, r.maxBodySize()), &msg); err != nil {
+		status, code := readError(err)
+		SendErrorJSON(w, req, status, err, code, "can't unmarshal inputMessage message")
 		return
 	}
-	if err := msg.checkMd5Hash(); err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorMD5Validation, "Error during md5 validation")
+	if code, details, err := msg.validate(req.Context(), r.Tracer); err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, code, details)
 		return
 	}
-	claims, err := r.checkJWT(req.Header.Get("Authorization"))
+	if err := r.checkCallbackTarget(req.Context(), msg.CallbackURL); err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "callback_url is invalid")
+		return
+	}
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorMD5Validation, "JWT is invalid")
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	if err = r.checkDependencies(claims.TenantID, msg.DependsOn); err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorDependency, "depends_on is invalid")
+		return
+	}
+	job := r.newJob(req.Context(), msg, claims)
+	if err = r.scheduleJob(&job, msg); err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorSchedule, "schedule is invalid")
 		return
 	}
-	job := model.Job{ClientID: claims.ClientID,
-		TenantID:    claims.TenantID,
-		Payload:     msg.Data,
-		PayloadSize: len(msg.Data)}
 
-	resJob, err := r.RemoteService.SubmitJob(job)
+	resJob, err := r.submit(job)
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during submitting job in worker service")
+		sendEngineError(w, req, err, "error during submitting job in worker service")
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	r.observeSubmit(job)
+	r.wakeWorkflow(resJob)
+	if job.Cron == "" {
+		r.watchCallback(resJob.ID, job.CallbackURL)
+	}
 	body, err := json.Marshal(resJob)
 	if err != nil {
 		log.Printf("[ERROR] can not encode response body %#v", err)
-		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorMD5Validation, "error during encoding job response")
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "error during encoding job response")
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
 	if _, err = w.Write(body); err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during writing response")
-		return
+		log.Printf("[ERROR] cannot write response #%v", err)
 	}
 }
 
-func (r *Rest) checkJWT(authHeader string) (*auth.Claims, error) {
+//validate checks inputMessage and returns error code and details for invalid one, md5 check is traced by tracer
+func (msg *inputMessage) validate(ctx context.Context, tracer *tracing.Tracer) (ErrorCode, string, error) {
+	_, span := tracer.Start(ctx, "payload.md5")
+	span.SetAttr("payload_size", len(msg.Data))
+	err := msg.checkMd5Hash()
+	span.Fail(err)
+	span.End()
+	if err != nil {
+		return ErrorMD5Validation, "Error during md5 validation", err
+	}
+	return msg.validateOptions()
+}
+
+//validateOptions checks settings of job besides its payload and returns error code and details for invalid one
+func (msg *inputMessage) validateOptions() (ErrorCode, string, error) {
+	if err := checkCallbackURL(msg.CallbackURL); err != nil {
+		return ErrorValidation, "callback_url is invalid", err
+	}
+	if msg.Retry != nil {
+		if err := msg.Retry.Validate(); err != nil {
+			return ErrorValidation, "retry policy is invalid", err
+		}
+	}
+	if err := model.ValidateOperations(msg.Operations); err != nil {
+		return ErrorValidation, "operations are invalid", err
+	}
+	if msg.Cron != "" {
+		if _, err := scheduler.ParseCron(msg.Cron); err != nil {
+			return ErrorSchedule, "cron is invalid", err
+		}
+	}
+	return "", "", nil
+}
+
+//newJob makes job of validated inputMessage submitted by client from token, job keeps id of request
+func (r *Rest) newJob(ctx context.Context, msg inputMessage, claims *auth.Claims) model.Job {
+	return model.Job{ClientID: claims.ClientID,
+		RequestID:   logging.RequestID(ctx),
+		TraceParent: tracing.FromContext(ctx).TraceParent(),
+		TenantID:    claims.TenantID,
+		Payload:     msg.Data,
+		PayloadSize: len(msg.Data),
+		CallbackURL: r.callbackURL(claims.TenantID, msg.CallbackURL),
+		Retry:       msg.Retry,
+		Operations:  msg.Operations,
+		DependsOn:   msg.DependsOn}
+}
+
+func (r *Rest) checkJWT(ctx context.Context, authHeader string) (*auth.Claims, error) {
+	_, span := r.Tracer.Start(ctx, "auth.jwt")
+	defer span.End()
 	if authHeader == "" || len(strings.Split(authHeader, " ")) != 2 {
-		return nil, fmt.Errorf("can't parse header: Authorisation contains an invalid number of segments")
+		reason := authMalformedHeader
+		if authHeader == "" {
+			reason = authMissingToken
+		}
+		r.authFailure(reason)
+		err := fmt.Errorf("can't parse header: Authorisation contains an invalid number of segments")
+		span.Fail(err)
+		return nil, err
 	}
 	headerValue := strings.Split(authHeader, " ")
-	return r.Auth.Parse(headerValue[1])
+	claims, err := r.Auth.Parse(headerValue[1])
+	if err != nil {
+		r.authFailure(authInvalidToken)
+		span.Fail(err)
+		return claims, err
+	}
+	span.SetAttr("tenant_id", claims.TenantID)
+	return claims, nil
 }
 
 func (msg *inputMessage) checkMd5Hash() error {
@@ -227,26 +502,110 @@ func base64Decode(data []byte) ([]byte, error) {
 
 func (r *Rest) getJob(w http.ResponseWriter, req *http.Request) {
 	jobID := chi.URLParam(req, "id")
-	_, err := r.checkJWT(req.Header.Get("Authorization"))
+	_, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorMD5Validation, "JWT is invalid")
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
 		return
 	}
 	job, err := r.RemoteService.GetJob(jobID)
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during getting job")
+		sendEngineError(w, req, err, "error during getting job")
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
 	data, err := json.Marshal(job)
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during marshal response")
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "error during marshal response")
 		return
 	}
-	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Type", "application/json")
 	if _, err = w.Write(data); err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during writing response")
+		log.Printf("[ERROR] cannot write response #%v", err)
+	}
+}
+
+//resultHeaders are blob service response headers passed through to client
+var resultHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+func (r *Rest) getJobResult(w http.ResponseWriter, req *http.Request) {
+	job, ok := r.finishedJob(w, req)
+	if !ok {
+		return
+	}
+
+	res, err := r.RemoteService.GetJobResult(req.Context(), job.ResultLocation, req.Header)
+	if err != nil {
+		sendEngineError(w, req, err, "error during getting job result in blob service")
+		return
+	}
+	defer func() {
+		if errClose := res.Body.Close(); errClose != nil {
+			log.Printf("[ERROR] can not close result body %#v", errClose)
+		}
+	}()
+
+	for _, h := range resultHeaders {
+		if v := res.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	n, err := io.Copy(w, res.Body)
+	if err != nil {
+		log.Printf("[WARN] streaming result of job %s interrupted after %d bytes, %v", job.ID, n, err)
+	}
+}
+
+func (r *Rest) getJobResultURL(w http.ResponseWriter, req *http.Request) {
+	if r.URLSigner == nil {
+		SendErrorJSON(w, req, http.StatusNotImplemented, errors.New("signed urls are disabled"), ErrorFeatureDisabled,
+			"secret for signing urls is not set up")
+		return
+	}
+	job, ok := r.finishedJob(w, req)
+	if !ok {
 		return
 	}
+	q, expires := r.URLSigner.Sign(job.ResultLocation, job.TenantID)
+	render.JSON(w, req, map[string]interface{}{
+		"url":        r.BlobPublicURL + "/signed" + job.ResultLocation + "?" + q.Encode(),
+		"expires_at": expires.UTC(),
+	})
+}
+
+//tenantJob returns job from url if it belongs to the tenant from token, or sends error response
+func (r *Rest) tenantJob(w http.ResponseWriter, req *http.Request) (*model.Job, bool) {
+	jobID := chi.URLParam(req, "id")
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return nil, false
+	}
+	job, err := r.RemoteService.GetJob(jobID)
+	if err != nil || job.TenantID != claims.TenantID {
+		if err == nil {
+			err = fmt.Errorf("no job with id: %s", jobID)
+		}
+		SendErrorJSON(w, req, http.StatusNotFound, err, ErrorJobNotFound, "error during getting job")
+		return nil, false
+	}
+	return job, true
+}
+
+//finishedJob returns SUCCESS job of the tenant from token with known result location, or sends error response
+func (r *Rest) finishedJob(w http.ResponseWriter, req *http.Request) (*model.Job, bool) {
+	job, ok := r.tenantJob(w, req)
+	if !ok {
+		return nil, false
+	}
+	if job.Status != model.JobStatus(model.SUCCESS).ToString() {
+		SendErrorJSON(w, req, http.StatusConflict, fmt.Errorf("job %s is %s", job.ID, job.Status),
+			ErrorJobNotFinished, "result is available only for SUCCESS job")
+		return nil, false
+	}
+	if job.ResultLocation == "" {
+		SendErrorJSON(w, req, http.StatusNotFound, fmt.Errorf("job %s has no result location", job.ID),
+			ErrorJobNotFound, "error during getting job result")
+		return nil, false
+	}
+	return job, true
 }
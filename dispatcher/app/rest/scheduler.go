@@ -0,0 +1,71 @@
+package rest
+
+import (
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/scheduler"
+	"net/http"
+	"time"
+)
+
+type scheduledJob struct {
+	ID        string     `json:"id"`
+	Status    string     `json:"status"`
+	NotBefore *time.Time `json:"not_before"`
+	Cron      string     `json:"cron,omitempty"`
+	Runs      int        `json:"runs,omitempty"`
+	DependsOn []string   `json:"depends_on,omitempty"`
+}
+
+//getScheduledJobs returns SCHEDULED jobs of tenant ordered by time of the next run
+func (r *Rest) getScheduledJobs(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	jobs := r.Scheduler.Scheduled(claims.TenantID)
+	res := make([]scheduledJob, 0, len(jobs))
+	for _, j := range jobs {
+		res = append(res, scheduledJob{ID: j.ID, Status: j.Status, NotBefore: j.NotBefore, Cron: j.Cron,
+			Runs: j.Runs, DependsOn: j.DependsOn})
+	}
+	render.JSON(w, req, res)
+}
+
+//cancelJob cancels SCHEDULED or WAITING job of tenant, job which is dispatched to worker can't be canceled
+func (r *Rest) cancelJob(w http.ResponseWriter, req *http.Request) {
+	job, ok := r.tenantJob(w, req)
+	if !ok {
+		return
+	}
+	canceled, err := r.Scheduler.Cancel(job.ID)
+	if err != nil {
+		if errors.Is(err, scheduler.ErrNotCancelable) {
+			SendErrorJSON(w, req, http.StatusConflict, err, ErrorJobNotCancelable, "only SCHEDULED or WAITING job can be canceled")
+			return
+		}
+		SendErrorJSON(w, req, http.StatusNotFound, err, ErrorJobNotFound, "error during canceling job")
+		return
+	}
+	render.JSON(w, req, canceled)
+}
+
+//scheduleJob sets time of the first run of job scheduled by not_before and cron of inputMessage
+func (r *Rest) scheduleJob(job *model.Job, msg inputMessage) error {
+	if msg.NotBefore == nil && msg.Cron == "" {
+		return nil
+	}
+	if r.Scheduler == nil {
+		return errors.New("scheduled jobs are disabled")
+	}
+	next, err := scheduler.NextRun(msg.NotBefore, msg.Cron, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if !next.IsZero() {
+		job.NotBefore, job.Cron = &next, msg.Cron
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/scheduler"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRest_SubmitScheduledJob(t *testing.T) {
+	_, r, jobs := startWorkflowServer(t)
+	r.Scheduler = &scheduler.Scheduler{Store: jobs, Workflow: r.Workflow}
+	ts := httptest.NewServer(r.routes())
+	defer ts.Close()
+	item := func(schedule string) string {
+		return strings.TrimSuffix(validItem, "}") + `,` + schedule + `}`
+	}
+	notBefore := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+
+	body, code := postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(item(`"not_before":"`+notBefore.Format(time.RFC3339)+`"`)))
+	require.Equal(t, http.StatusCreated, code, body)
+	assert.JSONEq(t, `{"id":"1","status":"SCHEDULED"}`, body)
+	body, code = postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(item(`"cron":"@yearly"`)))
+	require.Equal(t, http.StatusCreated, code, body)
+	assert.JSONEq(t, `{"id":"2","status":"SCHEDULED"}`, body)
+	body, code = postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(item(`"not_before":"2020-01-01T00:00:00Z"`)))
+	require.Equal(t, http.StatusCreated, code, body)
+	assert.JSONEq(t, `{"id":"3"}`, body, "job with not_before in the past is dispatched at once")
+	body, code = postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(item(`"cron":"0 0 30 2 *"`)))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, `"code":"INVALID_SCHEDULE"`)
+
+	body, code = getRequest(t, ts.URL+"/api/v1/jobs/scheduled")
+	require.Equal(t, http.StatusOK, code, body)
+	scheduled := []scheduledJob{}
+	require.NoError(t, json.Unmarshal([]byte(body), &scheduled))
+	require.Len(t, scheduled, 2)
+	assert.Equal(t, scheduledJob{ID: "1", Status: "SCHEDULED", NotBefore: &notBefore}, scheduled[0])
+	assert.Equal(t, "2", scheduled[1].ID)
+	assert.Equal(t, "@yearly", scheduled[1].Cron)
+	next := time.Date(time.Now().UTC().Year()+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, next, *scheduled[1].NotBefore)
+
+	body, code = postRequest(t, ts.URL+"/api/v1/job/2/cancel", nil)
+	require.Equal(t, http.StatusOK, code, body)
+	job := model.Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, "CANCELED", job.Status)
+	assert.Equal(t, model.ErrorCodeCanceled, job.Error.Code)
+	body, code = postRequest(t, ts.URL+"/api/v1/job/2/cancel", nil)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Contains(t, body, `"code":"JOB_NOT_CANCELABLE"`)
+	_, code = postRequest(t, ts.URL+"/api/v1/job/3/cancel", nil)
+	assert.Equal(t, http.StatusConflict, code, "dispatched job can't be canceled")
+	_, code = postRequest(t, ts.URL+"/api/v1/job/100/cancel", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	body, code = getRequest(t, ts.URL+"/api/v1/jobs/scheduled")
+	require.Equal(t, http.StatusOK, code, body)
+	require.NoError(t, json.Unmarshal([]byte(body), &scheduled))
+	assert.Len(t, scheduled, 1)
+}
+
+func TestRest_SubmitScheduledJobDisabled(t *testing.T) {
+	ts, _, _ := startWorkflowServer(t)
+	body, code := postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(strings.TrimSuffix(validItem, "}")+`,"cron":"@daily"}`))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, `"code":"INVALID_SCHEDULE"`)
+	_, code = getRequest(t, ts.URL+"/api/v1/jobs/scheduled")
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,108 @@
+package rest
+
+import (
+	"github.com/didip/tollbooth/v6/limiter"
+	"time"
+)
+
+//default limits of server, used for zero settings of Rest
+const (
+	defaultThrottle          = 1000
+	defaultStreamThrottle    = 1000
+	defaultAPIRate           = 50
+	defaultPingRate          = 5
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = 120 * time.Second
+	defaultIdleTimeout       = 30 * time.Second
+	defaultShutdownTimeout   = time.Second
+)
+
+//SetAdminToken replaces token of admin api, admin api is not enabled by it if it was disabled at start
+func (r *Rest) SetAdminToken(token string) {
+	r.settingsLock.Lock()
+	defer r.settingsLock.Unlock()
+	r.AdminToken = token
+}
+
+//SetRates replaces max requests per second from ip to api and ping routes, zero rate sets default one
+func (r *Rest) SetRates(api, ping float64) {
+	r.settingsLock.Lock()
+	defer r.settingsLock.Unlock()
+	r.APIRate, r.PingRate = api, ping
+	for _, lmt := range r.apiLimiters {
+		lmt.SetMax(orDefault(api, defaultAPIRate))
+	}
+	for _, lmt := range r.pingLimiters {
+		lmt.SetMax(orDefault(ping, defaultPingRate))
+	}
+}
+
+//SetMaintenance turns maintenance mode on or off, new jobs are rejected in maintenance mode while reads are served
+func (r *Rest) SetMaintenance(on bool) {
+	r.settingsLock.Lock()
+	defer r.settingsLock.Unlock()
+	r.maintenance = on
+}
+
+//Maintenance returns true if maintenance mode is on
+func (r *Rest) Maintenance() bool {
+	r.settingsLock.RLock()
+	defer r.settingsLock.RUnlock()
+	return r.maintenance
+}
+
+func (r *Rest) adminToken() string {
+	r.settingsLock.RLock()
+	defer r.settingsLock.RUnlock()
+	return r.AdminToken
+}
+
+//ipLimiter makes limiter of requests per second from ip to api or ping routes, its rate is changed by SetRates
+func (r *Rest) ipLimiter(ping bool) *limiter.Limiter {
+	r.settingsLock.Lock()
+	defer r.settingsLock.Unlock()
+	if ping {
+		lmt := newLimiter(orDefault(r.PingRate, defaultPingRate))
+		r.pingLimiters = append(r.pingLimiters, lmt)
+		return lmt
+	}
+	lmt := newLimiter(orDefault(r.APIRate, defaultAPIRate))
+	r.apiLimiters = append(r.apiLimiters, lmt)
+	return lmt
+}
+
+//maxBodySize returns limit of size of submit request body
+func (r *Rest) maxBodySize() int64 {
+	if r.MaxBodySize > 0 {
+		return r.MaxBodySize
+	}
+	return sizeBodyLimit
+}
+
+func (r *Rest) throttle() int {
+	if r.Throttle > 0 {
+		return r.Throttle
+	}
+	return defaultThrottle
+}
+
+func (r *Rest) streamThrottle() int {
+	if r.StreamThrottle > 0 {
+		return r.StreamThrottle
+	}
+	return defaultStreamThrottle
+}
+
+func orDefault(v, def float64) float64 {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+func durationOrDefault(v, def time.Duration) time.Duration {
+	if v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,198 @@
+package rest
+
+import (
+	"encoding/base64"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/tracing"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/upload"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type uploadRequest struct {
+	Size int64  `json:"size"`
+	MD5  string `json:"md5"`
+}
+
+//finalizeRequest is optional body of finalize, md5 replaces hash declared on upload creation,
+//other fields are settings of job as in submit request
+type finalizeRequest struct {
+	MD5         string             `json:"md5,omitempty"`
+	CallbackURL string             `json:"callback_url,omitempty"`
+	Retry       *model.RetryPolicy `json:"retry,omitempty"`
+	Operations  []model.Operation  `json:"operations,omitempty"`
+	DependsOn   []string           `json:"depends_on,omitempty"`
+}
+
+type uploadResponse struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+//createUpload starts resumable upload session, the chunks are sent to Location from response
+func (r *Rest) createUpload(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	msg := uploadRequest{}
+	if err = render.DecodeJSON(http.MaxBytesReader(w, req.Body, r.maxBodySize()), &msg); err != nil {
+		status, code := readError(err)
+		SendErrorJSON(w, req, status, err, code, "can't unmarshal upload request")
+		return
+	}
+	sess, err := r.Uploads.Create(claims.TenantID, claims.ClientID, msg.Size, msg.MD5)
+	if errors.Is(err, upload.ErrTenantLimit) {
+		SendErrorJSON(w, req, http.StatusTooManyRequests, err, ErrorRateLimited, "too many open upload sessions, finish or delete some of them")
+		return
+	}
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorUpload, "can't create upload session")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/upload/"+sess.ID)
+	r.sendUploadState(w, req, http.StatusCreated, sess)
+}
+
+//putUploadChunk appends body to upload at offset from Upload-Offset header
+func (r *Rest) putUploadChunk(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "Upload-Offset header is invalid")
+		return
+	}
+	sess, err := r.Uploads.WriteChunk(chi.URLParam(req, "id"), claims.TenantID, offset,
+		http.MaxBytesReader(w, req.Body, r.maxBodySize()))
+	if err != nil {
+		r.sendUploadError(w, req, sess, err, "can't write upload chunk")
+		return
+	}
+	r.sendUploadState(w, req, http.StatusOK, sess)
+}
+
+//getUpload returns progress of upload, HEAD request gets only Upload-Offset and Upload-Length headers
+func (r *Rest) getUpload(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	sess, err := r.Uploads.Get(chi.URLParam(req, "id"), claims.TenantID)
+	if err != nil {
+		r.sendUploadError(w, req, sess, err, "can't get upload session")
+		return
+	}
+	r.sendUploadState(w, req, http.StatusOK, sess)
+}
+
+//finalizeUpload validates assembled payload and submits it as a new job. Upload is removed after job is submitted,
+//so finalize failed by checksum, quota or worker can be repeated without uploading payload again
+func (r *Rest) finalizeUpload(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	msg := finalizeRequest{}
+	if req.ContentLength != 0 {
+		if err = render.DecodeJSON(http.MaxBytesReader(w, req.Body, r.maxBodySize()), &msg); err != nil {
+			status, code := readError(err)
+			SendErrorJSON(w, req, status, err, code, "can't unmarshal finalize request")
+			return
+		}
+	}
+	opts := inputMessage{CallbackURL: msg.CallbackURL, Retry: msg.Retry, Operations: msg.Operations, DependsOn: msg.DependsOn}
+	if code, details, err := opts.validateOptions(); err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, code, details)
+		return
+	}
+	if err = r.checkCallbackTarget(req.Context(), msg.CallbackURL); err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorValidation, "callback_url is invalid")
+		return
+	}
+	if err = r.checkDependencies(claims.TenantID, msg.DependsOn); err != nil {
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorDependency, "depends_on is invalid")
+		return
+	}
+	data, sess, err := r.Uploads.Finalize(chi.URLParam(req, "id"), claims.TenantID, msg.MD5)
+	if err != nil {
+		r.sendUploadError(w, req, sess, err, "can't finalize upload")
+		return
+	}
+	payload := base64.StdEncoding.EncodeToString(data)
+	job := model.Job{ClientID: sess.ClientID,
+		TenantID:    sess.TenantID,
+		RequestID:   logging.RequestID(req.Context()),
+		TraceParent: tracing.FromContext(req.Context()).TraceParent(),
+		Payload:     payload,
+		PayloadSize: len(payload),
+		CallbackURL: r.callbackURL(sess.TenantID, msg.CallbackURL),
+		Retry:       msg.Retry,
+		Operations:  msg.Operations,
+		DependsOn:   msg.DependsOn}
+
+	resJob, err := r.submit(job)
+	if err != nil {
+		r.Uploads.Release(sess.ID)
+		sendEngineError(w, req, err, "error during submitting job in worker service")
+		return
+	}
+	r.Uploads.Complete(sess.ID)
+	r.observeSubmit(job)
+	r.wakeWorkflow(resJob)
+	r.watchCallback(resJob.ID, job.CallbackURL)
+	render.Status(req, http.StatusCreated)
+	render.JSON(w, req, resJob)
+}
+
+//deleteUpload aborts upload and drops received chunks
+func (r *Rest) deleteUpload(w http.ResponseWriter, req *http.Request) {
+	claims, err := r.checkJWT(req.Context(), req.Header.Get("Authorization"))
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusUnauthorized, err, ErrorJWTValidation, "JWT is invalid")
+		return
+	}
+	if err = r.Uploads.Delete(chi.URLParam(req, "id"), claims.TenantID); err != nil {
+		r.sendUploadError(w, req, upload.Session{}, err, "can't delete upload session")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Rest) sendUploadState(w http.ResponseWriter, req *http.Request, status int, sess upload.Session) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(sess.Size, 10))
+	render.Status(req, status)
+	render.JSON(w, req, uploadResponse{ID: sess.ID, Size: sess.Size, Offset: sess.Offset, ExpiresAt: sess.ExpiresAt})
+}
+
+func (r *Rest) sendUploadError(w http.ResponseWriter, req *http.Request, sess upload.Session, err error, details string) {
+	switch {
+	case errors.Is(err, upload.ErrNotFound):
+		SendErrorJSON(w, req, http.StatusNotFound, err, ErrorUpload, details)
+	case errors.Is(err, upload.ErrOffsetMismatch):
+		w.Header().Set("Upload-Offset", strconv.FormatInt(sess.Offset, 10))
+		SendErrorJSON(w, req, http.StatusConflict, err, ErrorUpload, details)
+	case errors.Is(err, upload.ErrTooLarge), bodyTooLarge(err):
+		SendErrorJSON(w, req, http.StatusRequestEntityTooLarge, err, ErrorPayloadTooLarge, details)
+	case errors.Is(err, upload.ErrIncomplete), errors.Is(err, upload.ErrFinalizing):
+		SendErrorJSON(w, req, http.StatusConflict, err, ErrorUpload, details)
+	case errors.Is(err, upload.ErrChecksum):
+		SendErrorJSON(w, req, http.StatusUnprocessableEntity, err, ErrorMD5Validation, details)
+	default:
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorUpload, details)
+	}
+}
@@ -0,0 +1,222 @@
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/webhook"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRest_Upload(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	engineMock := &engine.InterfaceMock{
+		SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+			return &model.Job{ID: "7"}, nil
+		},
+	}
+	r.RemoteService = engineMock
+
+	resp := doRequest(t, "POST", ts.URL+"/api/v1/upload",
+		strings.NewReader(`{"size":5,"md5":"5d41402abc4b2a76b9719d911017c592"}`), nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	sess := uploadResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&sess))
+	require.NoError(t, resp.Body.Close())
+	location := resp.Header.Get("Location")
+	assert.Equal(t, "/api/v1/upload/"+sess.ID, location)
+	assert.Equal(t, "0", resp.Header.Get("Upload-Offset"))
+
+	resp = doRequest(t, "PUT", ts.URL+location, strings.NewReader("hel"), map[string]string{"Upload-Offset": "0"})
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("Upload-Offset"))
+
+	resp = doRequest(t, "PUT", ts.URL+location, strings.NewReader("hel"), map[string]string{"Upload-Offset": "0"})
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("Upload-Offset"))
+
+	resp = doRequest(t, "HEAD", ts.URL+location, nil, nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("Upload-Offset"))
+	assert.Equal(t, "5", resp.Header.Get("Upload-Length"))
+
+	resp = doRequest(t, "POST", ts.URL+location+"/finalize", nil, nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = doRequest(t, "PUT", ts.URL+location, strings.NewReader("lo"), map[string]string{"Upload-Offset": "3"})
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "5", resp.Header.Get("Upload-Offset"))
+
+	resp = doRequest(t, "POST", ts.URL+location+"/finalize", nil, nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, `{"id":"7"}`, strings.TrimSpace(string(body)))
+	require.Equal(t, 1, len(engineMock.SubmitJobCalls()))
+	job := engineMock.SubmitJobCalls()[0].Job
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("hello")), job.Payload)
+	assert.Equal(t, 1, job.TenantID)
+
+	res, code := getRequest(t, ts.URL+location)
+	assert.Equal(t, http.StatusNotFound, code, res)
+}
+
+func TestRest_UploadChecksumMismatch(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	engineMock := &engine.InterfaceMock{}
+	r.RemoteService = engineMock
+
+	resp := doRequest(t, "POST", ts.URL+"/api/v1/upload",
+		strings.NewReader(`{"size":5,"md5":"5d41402abc4b2a76b9719d911017c592"}`), nil)
+	require.NoError(t, resp.Body.Close())
+	location := resp.Header.Get("Location")
+
+	resp = doRequest(t, "PUT", ts.URL+location, strings.NewReader("world"), map[string]string{"Upload-Offset": "0"})
+	require.NoError(t, resp.Body.Close())
+	resp = doRequest(t, "POST", ts.URL+location+"/finalize", nil, nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, 0, len(engineMock.SubmitJobCalls()))
+
+	failures := 1
+	engineMock.SubmitJobFunc = func(job model.Job) (*model.Job, error) {
+		if failures > 0 {
+			failures--
+			return nil, engine.ErrUpstreamUnavailable
+		}
+		return &model.Job{ID: "7"}, nil
+	}
+	body := `{"md5":"7d793037a0760186574b0282f2f435e7"}` //md5 of "world"
+	resp = doRequest(t, "POST", ts.URL+location+"/finalize", strings.NewReader(body), nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "upload is kept after failed submit")
+
+	resp = doRequest(t, "POST", ts.URL+location+"/finalize", strings.NewReader(body), nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "upload is finalized again with correct md5")
+	require.Equal(t, 2, len(engineMock.SubmitJobCalls()))
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("world")), engineMock.SubmitJobCalls()[1].Job.Payload)
+
+	_, code := getRequest(t, ts.URL+location)
+	assert.Equal(t, http.StatusNotFound, code, "upload is removed after job is submitted")
+}
+
+func TestRest_UploadJobOptions(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	engineMock := &engine.InterfaceMock{
+		SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+			return &model.Job{ID: "7"}, nil
+		},
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 1, Status: "RUNNING"}, nil
+		},
+	}
+	r.RemoteService = engineMock
+	r.Watcher = watcher.New(engineMock, 10*time.Millisecond)
+	defer r.Watcher.Close()
+	r.Webhooks = webhook.NewService(r.Watcher, webhook.Opts{AllowPrivate: true})
+	defer r.Webhooks.Close()
+
+	resp := doRequest(t, "POST", ts.URL+"/api/v1/upload",
+		strings.NewReader(`{"size":5,"md5":"5d41402abc4b2a76b9719d911017c592"}`), nil)
+	require.NoError(t, resp.Body.Close())
+	location := resp.Header.Get("Location")
+	resp = doRequest(t, "PUT", ts.URL+location, strings.NewReader("hello"), map[string]string{"Upload-Offset": "0"})
+	require.NoError(t, resp.Body.Close())
+
+	tbl := []struct {
+		body string
+		code int
+		err  string
+	}{
+		{`{"callback_url":"ftp://client/callback"}`, http.StatusBadRequest, "callback_url is invalid"},
+		{`{"operations":[{"type":"blur"}]}`, http.StatusBadRequest, `unknown operation type \"blur\"`},
+		{`{"retry":{"max_attempts":-1}}`, http.StatusBadRequest, "retry policy is invalid"},
+		{`{"depends_on":["1"]}`, http.StatusBadRequest, "DEPENDENCY"},
+		{`{"callback_url":"http://client/callback","retry":{"max_attempts":3},` +
+			`"operations":[{"type":"resize","params":{"width":800}}]}`, http.StatusCreated, `{"id":"7"}`},
+	}
+	for i, tt := range tbl {
+		resp = doRequest(t, "POST", ts.URL+location+"/finalize", strings.NewReader(tt.body), nil)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, tt.code, resp.StatusCode, "test case #%d", i)
+		assert.Contains(t, string(body), tt.err, "test case #%d", i)
+	}
+	require.Equal(t, 1, len(engineMock.SubmitJobCalls()), "upload is kept after invalid settings")
+	job := engineMock.SubmitJobCalls()[0].Job
+	assert.Equal(t, "http://client/callback", job.CallbackURL)
+	assert.Equal(t, 3, job.Retry.MaxAttempts)
+	assert.Equal(t, []model.Operation{{Type: "resize", Params: map[string]interface{}{"width": float64(800)}}},
+		job.Operations)
+}
+
+func TestRest_UploadTenantLimits(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	r.Uploads.MaxSessions = 1
+
+	resp := doRequest(t, "POST", ts.URL+"/api/v1/upload", strings.NewReader(`{"size":5,"md5":"5d41402abc4b2a76b9719d911017c592"}`), nil)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	location := resp.Header.Get("Location")
+
+	resp = doRequest(t, "POST", ts.URL+"/api/v1/upload", strings.NewReader(`{"size":5,"md5":"5d41402abc4b2a76b9719d911017c592"}`), nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Contains(t, string(body), string(ErrorRateLimited))
+
+	resp = doRequest(t, "DELETE", ts.URL+location, nil, nil)
+	require.NoError(t, resp.Body.Close())
+	resp = doRequest(t, "POST", ts.URL+"/api/v1/upload", strings.NewReader(`{"size":5,"md5":"5d41402abc4b2a76b9719d911017c592"}`), nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestRest_UploadErrors(t *testing.T) {
+	ts, _, teardown := startHTTPServer()
+	defer teardown()
+
+	resp := doRequest(t, "POST", ts.URL+"/api/v1/upload", strings.NewReader(`{"size":2048,"md5":"5d41402abc4b2a76b9719d911017c592"}`), nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = doRequest(t, "POST", ts.URL+"/api/v1/upload", strings.NewReader(`{"size":2,"md5":"5d41402abc4b2a76b9719d911017c592"}`), nil)
+	require.NoError(t, resp.Body.Close())
+	location := resp.Header.Get("Location")
+
+	resp = doRequest(t, "PUT", ts.URL+location, strings.NewReader("hello"), map[string]string{"Upload-Offset": "0"})
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	resp = doRequest(t, "PUT", ts.URL+location, strings.NewReader("he"), nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = doRequest(t, "DELETE", ts.URL+location, nil, nil)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp = doRequest(t, "PUT", ts.URL+location, strings.NewReader("he"), map[string]string{"Upload-Offset": "0"})
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,88 @@
+package rest
+
+import (
+	"context"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/webhook"
+	"net/http"
+	"net/url"
+)
+
+//getJobCallbacks returns delivery log of job callback
+func (r *Rest) getJobCallbacks(w http.ResponseWriter, req *http.Request) {
+	if r.Webhooks == nil {
+		SendErrorJSON(w, req, http.StatusNotFound, webhook.ErrNoCallback, ErrorFeatureDisabled, "callbacks are disabled")
+		return
+	}
+	job, ok := r.tenantJob(w, req)
+	if !ok {
+		return
+	}
+	deliveries, err := r.Webhooks.Deliveries(job.ID)
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusNotFound, err, ErrorCallback, "error during getting job callbacks")
+		return
+	}
+	render.JSON(w, req, map[string]interface{}{"id": job.ID, "deliveries": deliveries})
+}
+
+//replayJobCallback sends again failed callback of job
+func (r *Rest) replayJobCallback(w http.ResponseWriter, req *http.Request) {
+	if r.Webhooks == nil {
+		SendErrorJSON(w, req, http.StatusNotFound, webhook.ErrNoCallback, ErrorFeatureDisabled, "callbacks are disabled")
+		return
+	}
+	job, ok := r.tenantJob(w, req)
+	if !ok {
+		return
+	}
+	err := r.Webhooks.Replay(job.ID)
+	switch {
+	case errors.Is(err, webhook.ErrNoCallback):
+		SendErrorJSON(w, req, http.StatusNotFound, err, ErrorCallback, "error during replaying job callback")
+	case errors.Is(err, webhook.ErrNothingToReplay), errors.Is(err, webhook.ErrInProgress):
+		SendErrorJSON(w, req, http.StatusConflict, err, ErrorCallback, "error during replaying job callback")
+	case err != nil:
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "error during replaying job callback")
+	default:
+		render.Status(req, http.StatusAccepted)
+		render.JSON(w, req, map[string]string{"id": job.ID})
+	}
+}
+
+//callbackURL returns callback url from request or default url of tenant
+func (r *Rest) callbackURL(tenantID int, callbackURL string) string {
+	if r.Webhooks == nil {
+		return ""
+	}
+	return r.Webhooks.URLFor(tenantID, callbackURL)
+}
+
+func (r *Rest) watchCallback(jobID, callbackURL string) {
+	if r.Webhooks != nil {
+		r.Webhooks.Watch(jobID, callbackURL)
+	}
+}
+
+//checkCallbackTarget rejects callback url which points to internal address of dispatcher network
+func (r *Rest) checkCallbackTarget(ctx context.Context, callbackURL string) error {
+	if r.Webhooks == nil || callbackURL == "" {
+		return nil
+	}
+	return r.Webhooks.CheckURL(ctx, callbackURL)
+}
+
+func checkCallbackURL(callbackURL string) error {
+	if callbackURL == "" {
+		return nil
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("callback url %q must be absolute http(s) url", callbackURL)
+	}
+	return nil
+}
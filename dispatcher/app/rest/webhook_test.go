@@ -0,0 +1,97 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/webhook"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRest_SubmitJobWithCallback(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+
+	delivered := make(chan webhook.Event, 1)
+	cb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		e := webhook.Event{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&e))
+		delivered <- e
+	}))
+	defer cb.Close()
+
+	engineMock := &engine.InterfaceMock{
+		SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+			return &model.Job{ID: "4"}, nil
+		},
+		GetJobFunc: func(id string) (*model.Job, error) {
+			return &model.Job{ID: id, TenantID: 1, Status: "SUCCESS", CallbackURL: cb.URL}, nil
+		},
+	}
+	r.RemoteService = engineMock
+	r.Watcher = watcher.New(engineMock, 10*time.Millisecond)
+	defer r.Watcher.Close()
+	r.Webhooks = webhook.NewService(r.Watcher, webhook.Opts{AllowPrivate: true})
+	defer r.Webhooks.Close()
+
+	reqBody, err := json.Marshal(inputMessage{Encoding: "base64", Data: "MQo=", MD5: "b026324c6904b2a9cb4b88d6d61c81d1", CallbackURL: "ftp://host"})
+	require.NoError(t, err)
+	_, code := postRequest(t, ts.URL+"/api/v1/job", bytes.NewReader(reqBody))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	reqBody, err = json.Marshal(inputMessage{Encoding: "base64", Data: "MQo=", MD5: "b026324c6904b2a9cb4b88d6d61c81d1", CallbackURL: cb.URL})
+	require.NoError(t, err)
+	_, code = postRequest(t, ts.URL+"/api/v1/job", bytes.NewReader(reqBody))
+	assert.Equal(t, http.StatusCreated, code)
+	require.Equal(t, 1, len(engineMock.SubmitJobCalls()))
+	assert.Equal(t, cb.URL, engineMock.SubmitJobCalls()[0].Job.CallbackURL)
+
+	select {
+	case e := <-delivered:
+		assert.Equal(t, "4", e.Job.ID)
+	case <-time.After(time.Second):
+		t.Fatal("callback is not delivered")
+	}
+
+	require.Eventually(t, func() bool {
+		res, code := getRequest(t, ts.URL+"/api/v1/job/4/callbacks")
+		return code == http.StatusOK && bytes.Contains([]byte(res), []byte(`"success":true`))
+	}, time.Second, 10*time.Millisecond)
+
+	_, code = postRequest(t, ts.URL+"/api/v1/job/4/callbacks/replay", nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	_, code = getRequest(t, ts.URL+"/api/v1/job/5/callbacks")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestRest_SubmitJobWithInternalCallback(t *testing.T) {
+	ts, r, teardown := startHTTPServer()
+	defer teardown()
+	engineMock := &engine.InterfaceMock{
+		SubmitJobFunc: func(job model.Job) (*model.Job, error) {
+			return &model.Job{ID: "4"}, nil
+		},
+	}
+	r.RemoteService = engineMock
+	r.Webhooks = webhook.NewService(r.Watcher, webhook.Opts{})
+	defer r.Webhooks.Close()
+
+	for i, u := range []string{"http://127.0.0.1:9000/api/v1/job", "http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.5:8080/api/v1/job", "http://[::1]:8081/api/v1/blob", "http://localhost:8081/api/v1/blob"} {
+		reqBody, err := json.Marshal(inputMessage{Encoding: "base64", Data: "MQo=", MD5: "b026324c6904b2a9cb4b88d6d61c81d1",
+			CallbackURL: u})
+		require.NoError(t, err)
+		res, code := postRequest(t, ts.URL+"/api/v1/job", bytes.NewReader(reqBody))
+		assert.Equal(t, http.StatusBadRequest, code, "test case #%d", i)
+		assert.Contains(t, res, "callback url points to internal address", "test case #%d", i)
+	}
+	assert.Empty(t, engineMock.SubmitJobCalls())
+}
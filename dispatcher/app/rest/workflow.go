@@ -0,0 +1,60 @@
+package rest
+
+import (
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/workflow"
+	"net/http"
+)
+
+type workflowNode struct {
+	ID        string          `json:"id"`
+	Status    string          `json:"status"`
+	DependsOn []string        `json:"depends_on,omitempty"`
+	Error     *model.JobError `json:"error,omitempty"`
+}
+
+type workflowResponse struct {
+	Status string         `json:"status"`
+	Jobs   []workflowNode `json:"jobs"`
+}
+
+//getJobWorkflow returns all jobs connected to job by dependencies with aggregate status of them
+func (r *Rest) getJobWorkflow(w http.ResponseWriter, req *http.Request) {
+	job, ok := r.tenantJob(w, req)
+	if !ok {
+		return
+	}
+	jobs, err := r.Workflow.Graph(job.ID)
+	if err != nil {
+		SendErrorJSON(w, req, http.StatusNotFound, err, ErrorJobNotFound, "error during getting job workflow")
+		return
+	}
+	res := workflowResponse{Jobs: make([]workflowNode, 0, len(jobs))}
+	statuses := make([]string, 0, len(jobs))
+	for _, j := range jobs {
+		res.Jobs = append(res.Jobs, workflowNode{ID: j.ID, Status: j.Status, DependsOn: j.DependsOn, Error: j.Error})
+		statuses = append(statuses, j.Status)
+	}
+	res.Status = model.BatchStatus(statuses)
+	render.JSON(w, req, res)
+}
+
+//checkDependencies returns error if job of tenant can't depend on jobs with ids
+func (r *Rest) checkDependencies(tenantID int, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if r.Workflow == nil {
+		return errors.Wrap(workflow.ErrDependency, "job dependencies are disabled")
+	}
+	return r.Workflow.CheckDependencies(tenantID, ids)
+}
+
+//wakeWorkflow makes workflow check submitted WAITING job at once, as its dependencies can be already finished
+func (r *Rest) wakeWorkflow(job *model.Job) {
+	if r.Workflow != nil && job.Status == model.JobStatus(model.WAITING).ToString() {
+		r.Workflow.Wake()
+	}
+}
@@ -0,0 +1,107 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/engine"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/utils"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/workflow"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func startWorkflowServer(t *testing.T) (*httptest.Server, *Rest, *store.Store) {
+	_, r, teardown := startHTTPServer()
+	t.Cleanup(teardown)
+	jobs := store.New()
+	api := &engine.RestAPI{WorkerServiceURL: "http://localhost", Store: jobs, Client: &utils.RepeaterInterfaceMock{
+		MakeRequestFunc: func(httpMethod utils.Method, data io.Reader) ([]byte, error) {
+			return []byte(`{"payload_location":"/images/blob/1"}`), nil
+		},
+	}}
+	r.RemoteService = api
+	r.Workflow = &workflow.Workflow{Store: jobs, Dispatcher: api}
+	r.Batches = store.NewBatches()
+	ts := httptest.NewServer(r.routes())
+	t.Cleanup(ts.Close)
+	return ts, r, jobs
+}
+
+func TestRest_SubmitJobDependencies(t *testing.T) {
+	ts, r, jobs := startWorkflowServer(t)
+	item := func(dependsOn string) string {
+		return strings.TrimSuffix(validItem, "}") + `,"depends_on":` + dependsOn + `}`
+	}
+	body, code := postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(validItem))
+	require.Equal(t, http.StatusCreated, code, body)
+	body, code = postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(item(`["1"]`)))
+	require.Equal(t, http.StatusCreated, code, body)
+	assert.JSONEq(t, `{"id":"2","status":"WAITING"}`, body)
+	body, code = postRequest(t, ts.URL+"/api/v1/job", strings.NewReader(item(`["100"]`)))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, `"code":"INVALID_DEPENDENCY"`)
+
+	getWorkflow := func() workflowResponse {
+		body, code := getRequest(t, ts.URL+"/api/v1/job/1/workflow")
+		require.Equal(t, http.StatusOK, code, body)
+		res := workflowResponse{}
+		require.NoError(t, json.Unmarshal([]byte(body), &res))
+		return res
+	}
+	assert.Equal(t, workflowResponse{Status: "RUNNING", Jobs: []workflowNode{{ID: "1", Status: "RUNNING"},
+		{ID: "2", Status: "WAITING", DependsOn: []string{"1"}}}}, getWorkflow())
+
+	_, err := jobs.Update("1", func(job *model.Job) error {
+		job.Status = "SUCCESS"
+		return nil
+	})
+	require.NoError(t, err)
+	r.Workflow.Release()
+	res := getWorkflow()
+	assert.Equal(t, "RUNNING", res.Jobs[1].Status, "job is dispatched after its dependency")
+
+	_, code = getRequest(t, ts.URL+"/api/v1/job/100/workflow")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestRest_SubmitBatchDependencies(t *testing.T) {
+	ts, _, jobs := startWorkflowServer(t)
+	item := func(ref, dependsOn, md5 string) string {
+		return fmt.Sprintf(`{"encoding":"base64","content":"MQo=","md5":"%s","ref":"%s","depends_on":%s}`, md5, ref, dependsOn)
+	}
+	valid := "b026324c6904b2a9cb4b88d6d61c81d1"
+	items := []string{
+		item("thumb", `["master"]`, valid),
+		item("master", `[]`, valid),
+		item("a", `["b"]`, valid),
+		item("b", `["a"]`, valid),
+		item("c", `["bad"]`, valid),
+		item("bad", `[]`, "1"),
+		item("master", `[]`, valid),
+	}
+	body, code := postRequest(t, ts.URL+"/api/v1/jobs:batch", strings.NewReader(strings.Join(items, "\n")))
+	require.Equal(t, http.StatusMultiStatus, code, body)
+	res := batchResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.Equal(t, 2, res.Accepted)
+
+	codes := []ErrorCode{"", "", ErrorDependency, ErrorDependency, ErrorDependency, ErrorMD5Validation, ErrorDependency}
+	for i, code := range codes {
+		assert.Equal(t, code, res.Items[i].Code, "item %d, %s", i, res.Items[i].Error)
+	}
+	assert.Equal(t, "dependency cycle", res.Items[2].Error)
+	assert.Equal(t, "dependency item #5 is rejected", res.Items[4].Error)
+	assert.Equal(t, `ref "master" is duplicated`, res.Items[6].Error)
+
+	assert.Equal(t, "WAITING", res.Items[0].Status)
+	thumb, err := jobs.Get(res.Items[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{res.Items[1].ID}, thumb.DependsOn, "ref is replaced by job id")
+}
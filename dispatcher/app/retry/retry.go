@@ -0,0 +1,255 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrNotRequeueable returned on attempt to requeue job which is neither FAILED, TIMED_OUT nor DEAD_LETTERED
+var ErrNotRequeueable = errors.New("job can't be requeued")
+
+//Dispatcher sends existing job to worker service once more
+type Dispatcher interface {
+	DispatchJob(job model.Job) error
+}
+
+//Retrier dispatches FAILED and TIMED_OUT jobs again by retry policy. Failure with retryable error is
+//recorded in job attempts and job is moved to RETRYING till backoff is passed, job which exhausted
+//attempts is moved to DEAD_LETTERED. Policy of job overrides policy of tenant, which overrides Default
+type Retrier struct {
+	Store      *store.Store
+	Dispatcher Dispatcher
+	Default    model.RetryPolicy
+	Tenants    map[int]model.RetryPolicy
+	MaxBackoff time.Duration
+	Interval   time.Duration
+
+	lock sync.RWMutex //guards Default and Tenants replaced by SetPolicies
+	now  func() time.Time
+}
+
+//Policy returns retry policy of job
+func (r *Retrier) Policy(job model.Job) model.RetryPolicy {
+	r.lock.RLock()
+	policy := r.Default
+	tenant, ok := r.Tenants[job.TenantID]
+	r.lock.RUnlock()
+	if ok {
+		policy = tenant.Merge(policy)
+	}
+	if job.Retry != nil {
+		policy = job.Retry.Merge(policy)
+	}
+	return policy
+}
+
+//SetPolicies replaces default policy and policies of tenants, jobs waiting for retry get new policy
+//on their next failure
+func (r *Retrier) SetPolicies(def model.RetryPolicy, tenants map[int]model.RetryPolicy) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Default, r.Tenants = def, tenants
+}
+
+//Intercept is store hook which turns failure of running job into RETRYING or DEAD_LETTERED job.
+//Job is left FAILED if error is not retryable or retries are disabled by policy with less than 2 attempts
+func (r *Retrier) Intercept(prev model.Job, job *model.Job) {
+	if prev.Status != model.JobStatus(model.RUNNING).ToString() {
+		return
+	}
+	if job.Status != model.JobStatus(model.FAILED).ToString() && job.Status != model.JobStatus(model.TIMED_OUT).ToString() {
+		return
+	}
+	now := r.timeNow()
+	attempt := model.Attempt{Number: len(job.Attempts) + 1, Requeue: job.Requeues, Status: job.Status, FinishedAt: now}
+	if job.Error != nil {
+		e := *job.Error
+		attempt.Error = &e
+	}
+	job.Attempts = append(job.Attempts, attempt)
+
+	policy := r.Policy(*job)
+	if policy.MaxAttempts < 2 || !policy.Retryable(job.Error) {
+		return
+	}
+	round := 0
+	for _, a := range job.Attempts {
+		if a.Requeue == job.Requeues {
+			round++
+		}
+	}
+	if round >= policy.MaxAttempts {
+		log.Printf("[WARN] job %s exhausted %d attempts, last status %s", job.ID, round, job.Status)
+		job.Status = model.JobStatus(model.DEAD_LETTERED).ToString()
+		job.NextAttemptAt = nil
+		return
+	}
+	next := now.Add(r.backoff(policy, round))
+	job.Status = model.JobStatus(model.RETRYING).ToString()
+	job.NextAttemptAt = &next
+	log.Printf("[INFO] job %s attempt %d is %s, next attempt at %s", job.ID, attempt.Number, attempt.Status,
+		next.Format(time.RFC3339))
+}
+
+//Run dispatches due RETRYING jobs every Interval till context is canceled
+func (r *Retrier) Run(ctx context.Context) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	log.Printf("[INFO] start job retrier, interval=%s, policy=%+v", interval, r.Default)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] job retrier terminated")
+			return
+		case <-ticker.C:
+			r.Retry()
+		}
+	}
+}
+
+//Retry makes single pass over RETRYING jobs and dispatches ones with passed backoff
+func (r *Retrier) Retry() {
+	now := r.timeNow()
+	r.dispatchRetrying(func(job model.Job) bool {
+		return job.NextAttemptAt == nil || !job.NextAttemptAt.After(now)
+	})
+}
+
+//Flush dispatches all RETRYING jobs without waiting for backoff, it's used on shutdown as jobs of in-memory store
+//are lost after exit
+func (r *Retrier) Flush() {
+	r.dispatchRetrying(func(model.Job) bool { return true })
+}
+
+func (r *Retrier) dispatchRetrying(due func(job model.Job) bool) {
+	jobs := r.Store.Find(func(job model.Job) bool {
+		return job.Status == model.JobStatus(model.RETRYING).ToString() && due(job)
+	})
+	for _, job := range jobs {
+		r.dispatch(job)
+	}
+}
+
+//DeadLettered returns DEAD_LETTERED jobs ordered by creation time
+func (r *Retrier) DeadLettered() []model.Job {
+	return r.Store.Find(func(job model.Job) bool {
+		return job.Status == model.JobStatus(model.DEAD_LETTERED).ToString()
+	})
+}
+
+//Requeue moves FAILED, TIMED_OUT or DEAD_LETTERED job back to RETRYING, the job gets new round of attempts
+//by its policy. SUCCESS, CANCELED and unfinished jobs can't be requeued
+func (r *Retrier) Requeue(id string) (model.Job, error) {
+	return r.Store.Update(id, func(job *model.Job) error {
+		switch job.Status {
+		case model.JobStatus(model.FAILED).ToString(), model.JobStatus(model.TIMED_OUT).ToString(),
+			model.JobStatus(model.DEAD_LETTERED).ToString():
+		default:
+			return errors.Wrapf(ErrNotRequeueable, "job %s is %s", id, job.Status)
+		}
+		now := r.timeNow()
+		job.Requeues++
+		job.Status = model.JobStatus(model.RETRYING).ToString()
+		job.NextAttemptAt = &now
+		log.Printf("[INFO] job %s is requeued, requeues=%d", id, job.Requeues)
+		return nil
+	})
+}
+
+//dispatch moves job to RUNNING and sends it to worker, dispatch error is written as failed attempt
+func (r *Retrier) dispatch(job model.Job) {
+	running, err := r.Store.Update(job.ID, func(j *model.Job) error {
+		if j.Status != model.JobStatus(model.RETRYING).ToString() {
+			return errors.Errorf("job status was changed to %s before retry", j.Status)
+		}
+		j.Status = model.JobStatus(model.RUNNING).ToString()
+		j.Progress = 0
+		j.Stage = ""
+		j.Error = nil
+		j.NextAttemptAt = nil
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] can't retry job %s, %v", job.ID, err)
+		return
+	}
+	job = running
+	log.Printf("[INFO] dispatch job %s, attempt %d", job.ID, len(job.Attempts)+1)
+	dispatchErr := r.Dispatcher.DispatchJob(job)
+	if dispatchErr == nil {
+		return
+	}
+	log.Printf("[WARN] can't dispatch job %s, %v", job.ID, dispatchErr)
+	_, err = r.Store.Update(job.ID, func(j *model.Job) error {
+		if j.Status != model.JobStatus(model.RUNNING).ToString() {
+			return errors.Errorf("job status was changed to %s during dispatch", j.Status)
+		}
+		j.Status = model.JobStatus(model.FAILED).ToString()
+		j.Error = &model.JobError{Code: model.ErrorCodeWorkerUnreachable, Retryable: true,
+			Message: fmt.Sprintf("can't dispatch job to worker: %v", dispatchErr)}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] can't write dispatch failure of job %s, %v", job.ID, err)
+	}
+}
+
+//backoff returns delay before next attempt, it is doubled after each attempt of round and limited by MaxBackoff
+func (r *Retrier) backoff(policy model.RetryPolicy, round int) time.Duration {
+	delay := time.Duration(policy.BackoffSeconds) * time.Second
+	for i := 1; i < round; i++ {
+		delay *= 2
+		if r.MaxBackoff > 0 && delay >= r.MaxBackoff {
+			break
+		}
+	}
+	if r.MaxBackoff > 0 && delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	return delay
+}
+
+func (r *Retrier) timeNow() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+//ParsePolicy parses policy from maxAttempts/backoff/CODE|CODE string, backoff and codes are optional,
+//e.g. 5/2s/PROCESSING_FAILED|WORKER_UNREACHABLE
+func ParsePolicy(s string) (model.RetryPolicy, error) {
+	policy := model.RetryPolicy{}
+	parts := strings.Split(s, "/")
+	if len(parts) > 3 {
+		return policy, errors.Errorf("retry policy %q has too many parts", s)
+	}
+	attempts, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return policy, errors.Wrapf(err, "invalid max attempts in retry policy %q", s)
+	}
+	policy.MaxAttempts = attempts
+	if len(parts) > 1 && parts[1] != "" {
+		backoff, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return policy, errors.Wrapf(err, "invalid backoff in retry policy %q", s)
+		}
+		policy.BackoffSeconds = int((backoff + time.Second - 1) / time.Second)
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		policy.RetryableCodes = strings.Split(parts[2], "|")
+	}
+	return policy, policy.Validate()
+}
@@ -0,0 +1,263 @@
+package retry
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"sync"
+	"testing"
+	"time"
+)
+
+type dispatcherFunc func(job model.Job) error
+
+func (f dispatcherFunc) DispatchJob(job model.Job) error { return f(job) }
+
+func fail(s *store.Store, id string, jobErr *model.JobError) (model.Job, error) {
+	return s.Update(id, func(job *model.Job) error {
+		job.Status = "FAILED"
+		job.Error = jobErr
+		return nil
+	})
+}
+
+func TestRetrier_Policy(t *testing.T) {
+	r := Retrier{
+		Default: model.RetryPolicy{MaxAttempts: 3, BackoffSeconds: 2},
+		Tenants: map[int]model.RetryPolicy{2: {MaxAttempts: 5, RetryableCodes: []string{"A"}}},
+	}
+	tbl := []struct {
+		job model.Job
+		res model.RetryPolicy
+	}{
+		{model.Job{TenantID: 1}, model.RetryPolicy{MaxAttempts: 3, BackoffSeconds: 2}},
+		{model.Job{TenantID: 2}, model.RetryPolicy{MaxAttempts: 5, BackoffSeconds: 2, RetryableCodes: []string{"A"}}},
+		{model.Job{TenantID: 2, Retry: &model.RetryPolicy{BackoffSeconds: 10}},
+			model.RetryPolicy{MaxAttempts: 5, BackoffSeconds: 10, RetryableCodes: []string{"A"}}},
+		{model.Job{TenantID: 1, Retry: &model.RetryPolicy{MaxAttempts: 1}}, model.RetryPolicy{MaxAttempts: 1, BackoffSeconds: 2}},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, r.Policy(tt.job), "test case #%d", i)
+	}
+}
+
+func TestRetrier_Intercept(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	r := &Retrier{Default: model.RetryPolicy{MaxAttempts: 3, BackoffSeconds: 2}, MaxBackoff: 3 * time.Second,
+		now: func() time.Time { return now }}
+	s := store.New(model.Job{ID: "1"}, model.Job{ID: "2"}, model.Job{ID: "3", Retry: &model.RetryPolicy{MaxAttempts: 1}})
+	s.BeforeUpdate(r.Intercept)
+	retryable := &model.JobError{Code: "PROCESSING_FAILED", Retryable: true}
+
+	job, err := fail(s, "1", retryable)
+	require.NoError(t, err)
+	assert.Equal(t, "RETRYING", job.Status)
+	assert.Equal(t, now.Add(2*time.Second), *job.NextAttemptAt)
+	assert.Equal(t, []model.Attempt{{Number: 1, Status: "FAILED", Error: retryable, FinishedAt: now}}, job.Attempts)
+	assert.Equal(t, retryable, job.Error, "last error is kept")
+
+	_, err = s.Update("1", func(job *model.Job) error {
+		job.Status = "RUNNING"
+		return nil
+	})
+	require.NoError(t, err)
+	job, err = fail(s, "1", retryable)
+	require.NoError(t, err)
+	assert.Equal(t, "RETRYING", job.Status)
+	assert.Equal(t, now.Add(3*time.Second), *job.NextAttemptAt, "backoff is doubled and limited by max")
+	assert.Equal(t, 2, len(job.Attempts))
+
+	_, err = s.Update("1", func(job *model.Job) error {
+		job.Status = "RUNNING"
+		return nil
+	})
+	require.NoError(t, err)
+	job, err = s.Update("1", func(job *model.Job) error {
+		job.Status = "TIMED_OUT"
+		job.Error = &model.JobError{Code: model.ErrorCodeWorkerUnreachable, Retryable: true}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "DEAD_LETTERED", job.Status, "attempts are exhausted")
+	assert.Nil(t, job.NextAttemptAt)
+	assert.Equal(t, 3, len(job.Attempts))
+	assert.Equal(t, "TIMED_OUT", job.Attempts[2].Status)
+
+	job, err = fail(s, "2", &model.JobError{Code: "CORRUPTED_IMAGE"})
+	require.NoError(t, err)
+	assert.Equal(t, "FAILED", job.Status, "error is not retryable")
+	assert.Equal(t, 1, len(job.Attempts))
+
+	job, err = fail(s, "3", retryable)
+	require.NoError(t, err)
+	assert.Equal(t, "FAILED", job.Status, "retries are disabled by job policy")
+
+	job, err = s.Update("2", func(job *model.Job) error {
+		job.Stage = "process"
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(job.Attempts), "only transition from RUNNING is recorded")
+}
+
+func TestRetrier_Retry(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := store.New(model.Job{ID: "1"}, model.Job{ID: "2"}, model.Job{ID: "3"})
+	var lock sync.Mutex
+	var dispatched []string
+	r := &Retrier{Store: s, Default: model.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 10},
+		now: func() time.Time { return now }}
+	r.Dispatcher = dispatcherFunc(func(job model.Job) error {
+		lock.Lock()
+		defer lock.Unlock()
+		dispatched = append(dispatched, job.ID)
+		assert.Equal(t, "RUNNING", job.Status, "job is running during dispatch")
+		if job.ID == "2" {
+			return errors.New("worker is unreachable")
+		}
+		return nil
+	})
+	s.BeforeUpdate(r.Intercept)
+	retryable := &model.JobError{Code: "PROCESSING_FAILED", Retryable: true}
+	for _, id := range []string{"1", "2"} {
+		_, err := fail(s, id, retryable)
+		require.NoError(t, err)
+	}
+
+	r.Retry()
+	assert.Empty(t, dispatched, "backoff is not passed")
+
+	now = now.Add(10 * time.Second)
+	r.Retry()
+	assert.Equal(t, []string{"1", "2"}, dispatched)
+
+	job, err := s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "RUNNING", job.Status)
+	assert.Nil(t, job.Error)
+	assert.Nil(t, job.NextAttemptAt)
+
+	job, err = s.Get("2")
+	require.NoError(t, err)
+	assert.Equal(t, "DEAD_LETTERED", job.Status, "dispatch error is the last attempt")
+	require.Equal(t, 2, len(job.Attempts))
+	assert.Equal(t, model.ErrorCodeWorkerUnreachable, job.Attempts[1].Error.Code)
+	assert.Equal(t, "can't dispatch job to worker: worker is unreachable", job.Error.Message)
+	assert.Equal(t, []model.Job{job}, r.DeadLettered())
+}
+
+func TestRetrier_Flush(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := store.New(model.Job{ID: "1"}, model.Job{ID: "2", Status: "SCHEDULED"})
+	dispatched := []string{}
+	r := &Retrier{Store: s, Default: model.RetryPolicy{MaxAttempts: 2, BackoffSeconds: 10},
+		now: func() time.Time { return now }}
+	r.Dispatcher = dispatcherFunc(func(job model.Job) error {
+		dispatched = append(dispatched, job.ID)
+		return nil
+	})
+	s.BeforeUpdate(r.Intercept)
+	_, err := fail(s, "1", &model.JobError{Code: "PROCESSING_FAILED", Retryable: true})
+	require.NoError(t, err)
+
+	r.Retry()
+	assert.Empty(t, dispatched, "backoff is not passed")
+	r.Flush()
+	assert.Equal(t, []string{"1"}, dispatched, "retry is dispatched without backoff")
+	job, err := s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "RUNNING", job.Status)
+}
+
+func TestRetrier_Requeue(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s := store.New(model.Job{ID: "1"}, model.Job{ID: "2"})
+	r := &Retrier{Store: s, Default: model.RetryPolicy{MaxAttempts: 2},
+		now: func() time.Time { return now }}
+	r.Dispatcher = dispatcherFunc(func(job model.Job) error { return nil })
+	s.BeforeUpdate(r.Intercept)
+	retryable := &model.JobError{Code: "PROCESSING_FAILED", Retryable: true}
+	for i := 0; i < 2; i++ {
+		_, err := fail(s, "1", retryable)
+		require.NoError(t, err)
+		r.Retry()
+	}
+	job, err := s.Get("1")
+	require.NoError(t, err)
+	require.Equal(t, "DEAD_LETTERED", job.Status)
+
+	_, err = r.Requeue("2")
+	assert.True(t, errors.Is(err, ErrNotRequeueable))
+	assert.EqualError(t, err, "job 2 is RUNNING: job can't be requeued")
+	_, err = r.Requeue("3")
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+
+	job, err = r.Requeue("1")
+	require.NoError(t, err)
+	assert.Equal(t, "RETRYING", job.Status)
+	assert.Equal(t, 1, job.Requeues)
+	assert.Equal(t, now, *job.NextAttemptAt)
+	assert.Empty(t, r.DeadLettered())
+
+	r.Retry()
+	job, err = fail(s, "1", retryable)
+	require.NoError(t, err)
+	assert.Equal(t, "RETRYING", job.Status, "requeued job gets new round of attempts")
+	require.Equal(t, 3, len(job.Attempts))
+	assert.Equal(t, 1, job.Attempts[2].Requeue)
+}
+
+func TestRetrier_Run(t *testing.T) {
+	s := store.New(model.Job{ID: "1", Status: "RETRYING"})
+	dispatched := make(chan string, 1)
+	r := &Retrier{Store: s, Interval: 10 * time.Millisecond,
+		Dispatcher: dispatcherFunc(func(job model.Job) error {
+			dispatched <- job.ID
+			return nil
+		})}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	select {
+	case id := <-dispatched:
+		assert.Equal(t, "1", id)
+	case <-time.After(time.Second):
+		t.Fatal("job is not dispatched")
+	}
+	cancel()
+	<-done
+}
+
+func TestParsePolicy(t *testing.T) {
+	tbl := []struct {
+		str    string
+		policy model.RetryPolicy
+		err    string
+	}{
+		{"3", model.RetryPolicy{MaxAttempts: 3}, ""},
+		{"5/2s", model.RetryPolicy{MaxAttempts: 5, BackoffSeconds: 2}, ""},
+		{"5/1500ms/PROCESSING_FAILED|WORKER_UNREACHABLE", model.RetryPolicy{MaxAttempts: 5, BackoffSeconds: 2,
+			RetryableCodes: []string{"PROCESSING_FAILED", "WORKER_UNREACHABLE"}}, ""},
+		{"5//A", model.RetryPolicy{MaxAttempts: 5, RetryableCodes: []string{"A"}}, ""},
+		{"x/2s", model.RetryPolicy{}, `invalid max attempts in retry policy "x/2s": strconv.Atoi: parsing "x": invalid syntax`},
+		{"3/2x", model.RetryPolicy{}, `invalid backoff in retry policy "3/2x": time: unknown unit`},
+		{"3/2s/A/B", model.RetryPolicy{}, `retry policy "3/2s/A/B" has too many parts`},
+		{"-1", model.RetryPolicy{}, "max_attempts -1 is out of 0..100"},
+	}
+	for i, tt := range tbl {
+		policy, err := ParsePolicy(tt.str)
+		if tt.err != "" {
+			require.Error(t, err, "test case #%d", i)
+			assert.Contains(t, err.Error(), tt.err, "test case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "test case #%d", i)
+		assert.Equal(t, tt.policy, policy, "test case #%d", i)
+	}
+}
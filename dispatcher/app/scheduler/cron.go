@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Cron is parsed five fields cron expression: minute, hour, day of month, month and day of week.
+//Fields support `*`, numbers, lists, ranges and steps, e.g. `*/15 2-4 * * 1,3`. Times are in UTC
+type Cron struct {
+	minute, hour, dom, month, dow uint64 //bit sets of allowed values
+	domAny, dowAny                bool
+}
+
+//descriptors are shortcuts of common expressions
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+//maxCronSearch limits search of the next time, expression like `0 0 30 2 *` never matches
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+//ParseCron parses cron expression or descriptor like @daily
+func ParseCron(expr string) (Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if d, ok := descriptors[spec]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Cron{}, fmt.Errorf("cron %q must have 5 fields", expr)
+	}
+	c := Cron{}
+	var err error
+	bounds := []struct {
+		set      *uint64
+		min, max int
+		name     string
+	}{
+		{&c.minute, 0, 59, "minute"},
+		{&c.hour, 0, 23, "hour"},
+		{&c.dom, 1, 31, "day of month"},
+		{&c.month, 1, 12, "month"},
+		{&c.dow, 0, 7, "day of week"},
+	}
+	for i, b := range bounds {
+		if *b.set, err = parseField(fields[i], b.min, b.max); err != nil {
+			return Cron{}, fmt.Errorf("invalid %s in cron %q: %v", b.name, expr, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1 //7 is Sunday too
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	if _, err = c.Next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		return Cron{}, fmt.Errorf("cron %q never matches", expr)
+	}
+	return c, nil
+}
+
+//Next returns the first time matching cron after t
+func (c Cron) Next(t time.Time) (time.Time, error) {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxCronSearch)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("no time matches cron after %s", t.Format(time.RFC3339))
+}
+
+//dayMatches checks day of month and day of week, if both are restricted any of them is enough as in cron
+func (c Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+//parseField parses comma separated list of `*`, values, ranges with optional step
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			rng, step = part[:i], s
+		}
+		from, to := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			if to, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[1])
+			}
+		default:
+			v, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", rng)
+			}
+			from, to = v, v
+			if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q is out of %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
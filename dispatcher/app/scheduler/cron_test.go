@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	var tbl = []struct {
+		expr string
+		err  bool
+	}{
+		{"* * * * *", false},
+		{"*/15 2-4 * * 1,3", false},
+		{"0 3 1-31/2 * 7", false},
+		{"@daily", false},
+		{" @hourly ", false},
+		{"0 0 29 2 *", false},
+		{"", true},
+		{"* * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"a * * * *", true},
+		{"0 0 30 2 *", true},
+		{"@every 5m", true},
+	}
+	for i, tt := range tbl {
+		t.Run(fmt.Sprintf("test case #%d", i), func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			assert.Equal(t, tt.err, err != nil, "cron %q, error %v", tt.expr, err)
+		})
+	}
+}
+
+func TestCron_Next(t *testing.T) {
+	from := time.Date(2021, 3, 15, 10, 20, 30, 0, time.UTC) //Monday
+	var tbl = []struct {
+		expr string
+		from time.Time
+		next time.Time
+	}{
+		{"* * * * *", from, time.Date(2021, 3, 15, 10, 21, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2021, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 3 * * *", from, time.Date(2021, 3, 16, 3, 0, 0, 0, time.UTC)},
+		{"@daily", from, time.Date(2021, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", from, time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", from, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2021, 3, 21, 0, 0, 0, 0, time.UTC)},
+		{"30 10 * * 1-5", from, time.Date(2021, 3, 15, 10, 30, 0, 0, time.UTC)},
+		{"0 12 31 * *", from, time.Date(2021, 3, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 12 31 * *", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 5, 31, 12, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 5", from, time.Date(2021, 3, 19, 0, 0, 0, 0, time.UTC)}, //1st day or Friday
+		{"0 10 * * *", time.Date(2021, 3, 15, 12, 0, 0, 0, time.FixedZone("UTC+2", 2*3600)), time.Date(2021, 3, 16, 10, 0, 0, 0, time.UTC)},
+	}
+	for i, tt := range tbl {
+		t.Run(fmt.Sprintf("test case #%d", i), func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			require.NoError(t, err)
+			next, err := c.Next(tt.from)
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, next, "cron %q", tt.expr)
+		})
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2021, 3, 15, 10, 20, 30, 0, time.UTC)
+	past, future := now.Add(-time.Hour), time.Date(2021, 3, 20, 3, 0, 0, 0, time.UTC)
+	var tbl = []struct {
+		notBefore *time.Time
+		cron      string
+		next      time.Time
+		err       bool
+	}{
+		{nil, "", time.Time{}, false},
+		{&past, "", time.Time{}, false},
+		{&future, "", future, false},
+		{nil, "0 3 * * *", time.Date(2021, 3, 16, 3, 0, 0, 0, time.UTC), false},
+		{&past, "0 3 * * *", time.Date(2021, 3, 16, 3, 0, 0, 0, time.UTC), false},
+		{&future, "0 3 * * *", future, false},
+		{&future, "0 4 * * *", time.Date(2021, 3, 20, 4, 0, 0, 0, time.UTC), false},
+		{nil, "bad", time.Time{}, true},
+	}
+	for i, tt := range tbl {
+		t.Run(fmt.Sprintf("test case #%d", i), func(t *testing.T) {
+			next, err := NextRun(tt.notBefore, tt.cron, now)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, next)
+		})
+	}
+}
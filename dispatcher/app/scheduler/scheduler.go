@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"log"
+	"sort"
+	"time"
+)
+
+//ErrNotCancelable returned on attempt to cancel job which is already dispatched to worker
+var ErrNotCancelable = errors.New("job can't be canceled")
+
+//Waker is woken up when job is moved to WAITING, so it is dispatched as soon as its dependencies are finished
+type Waker interface {
+	Wake()
+}
+
+//Scheduler releases SCHEDULED jobs at their not_before time. One time job is moved to WAITING and dispatched
+//by workflow after its dependencies, recurring job with cron stays SCHEDULED and spawns a new WAITING job
+//on each run
+type Scheduler struct {
+	Store    *store.Store
+	Workflow Waker
+	OnSpawn  func(job model.Job)       //called for job spawned by recurring job
+	Quota    func(job model.Job) error //charges job spawned by recurring job to daily quota of its tenant
+	Interval time.Duration
+
+	now func() time.Time
+}
+
+//Run releases due jobs every Interval till context is canceled
+func (s *Scheduler) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	log.Printf("[INFO] start scheduler, interval=%s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] scheduler terminated")
+			return
+		case <-ticker.C:
+			s.Release()
+		}
+	}
+}
+
+//Release moves due SCHEDULED jobs to WAITING and spawns runs of due recurring jobs, missed runs
+//of recurring job are not repeated
+func (s *Scheduler) Release() {
+	now := s.timeNow()
+	due := s.Store.Find(func(job model.Job) bool {
+		return job.Status == model.JobStatus(model.SCHEDULED).ToString() && job.NotBefore != nil && !job.NotBefore.After(now)
+	})
+	released := false
+	for _, job := range due {
+		var err error
+		if job.Cron == "" {
+			err = s.release(job.ID)
+		} else {
+			err = s.spawn(job, now)
+		}
+		if err != nil {
+			log.Printf("[WARN] can't release scheduled job %s, %v", job.ID, err)
+			continue
+		}
+		released = true
+	}
+	if released && s.Workflow != nil {
+		s.Workflow.Wake()
+	}
+}
+
+//Scheduled returns SCHEDULED jobs of tenant ordered by time of the next run
+func (s *Scheduler) Scheduled(tenantID int) []model.Job {
+	res := s.Store.Find(func(job model.Job) bool {
+		return job.TenantID == tenantID && job.Status == model.JobStatus(model.SCHEDULED).ToString()
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].NotBefore.Before(*res[j].NotBefore) })
+	return res
+}
+
+//Cancel moves SCHEDULED or WAITING job to CANCELED, jobs which depend on it are canceled by workflow.
+//Canceled recurring job doesn't spawn runs anymore
+func (s *Scheduler) Cancel(id string) (model.Job, error) {
+	return s.Store.Update(id, func(job *model.Job) error {
+		if job.Status != model.JobStatus(model.SCHEDULED).ToString() && job.Status != model.JobStatus(model.WAITING).ToString() {
+			return errors.Wrapf(ErrNotCancelable, "job %s is %s", id, job.Status)
+		}
+		job.Status = model.JobStatus(model.CANCELED).ToString()
+		job.HeldPayload = ""
+		job.Error = &model.JobError{Code: model.ErrorCodeCanceled, Message: "job is canceled by client"}
+		return nil
+	})
+}
+
+//NextRun returns time of the first run of scheduled job submitted at now. Recurring job runs by cron after
+//not_before, zero time is returned for job which is not scheduled
+func NextRun(notBefore *time.Time, cron string, now time.Time) (time.Time, error) {
+	from := now
+	if notBefore != nil && notBefore.After(now) {
+		from = *notBefore
+	}
+	if cron == "" {
+		if from == now {
+			return time.Time{}, nil
+		}
+		return from, nil
+	}
+	c, err := ParseCron(cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return c.Next(from.Add(-time.Nanosecond))
+}
+
+func (s *Scheduler) release(id string) error {
+	_, err := s.Store.Update(id, func(job *model.Job) error {
+		if job.Status != model.JobStatus(model.SCHEDULED).ToString() {
+			return errors.Errorf("job status was changed to %s", job.Status)
+		}
+		job.Status = model.JobStatus(model.WAITING).ToString()
+		return nil
+	})
+	if err == nil {
+		log.Printf("[INFO] scheduled job %s is released", id)
+	}
+	return err
+}
+
+//spawn creates WAITING run of recurring job and moves recurring job to its next run
+func (s *Scheduler) spawn(job model.Job, now time.Time) error {
+	c, err := ParseCron(job.Cron)
+	if err != nil {
+		return err
+	}
+	next, err := c.Next(now)
+	if err != nil {
+		return err
+	}
+	_, err = s.Store.Update(job.ID, func(j *model.Job) error {
+		if j.Status != model.JobStatus(model.SCHEDULED).ToString() {
+			return errors.Errorf("job status was changed to %s", j.Status)
+		}
+		j.NotBefore = &next
+		j.Runs++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	run := model.Job{TenantID: job.TenantID, ClientID: job.ClientID, CallbackURL: job.CallbackURL,
+		PayloadSize: job.PayloadSize, Retry: job.Retry, Operations: job.Operations, DependsOn: job.DependsOn,
+		HeldPayload: job.HeldPayload, ScheduledBy: job.ID, RequestID: job.RequestID,
+		TraceParent: job.TraceParent, Status: model.JobStatus(model.WAITING).ToString()}
+	//run over quota is recorded as FAILED, so client sees skipped run in runs and gets its callback
+	if s.Quota != nil {
+		if err = s.Quota(run); err != nil {
+			run.Status, run.HeldPayload = model.JobStatus(model.FAILED).ToString(), ""
+			run.Error = &model.JobError{Code: model.ErrorCodeQuotaExceeded, Message: err.Error()}
+		}
+	}
+	run = s.Store.Create(run)
+	if run.Error != nil {
+		log.Printf("[WARN] run %s of recurring job %s is failed, %s, next run at %s", run.ID, job.ID, run.Error.Message,
+			next.Format(time.RFC3339))
+	} else {
+		log.Printf("[INFO] recurring job %s spawned job %s, next run at %s", job.ID, run.ID, next.Format(time.RFC3339))
+	}
+	if s.OnSpawn != nil {
+		s.OnSpawn(run)
+	}
+	return nil
+}
+
+func (s *Scheduler) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
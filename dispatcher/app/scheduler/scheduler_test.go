@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type wakerMock struct {
+	wakes int32
+}
+
+func (w *wakerMock) Wake() {
+	atomic.AddInt32(&w.wakes, 1)
+}
+
+func at(hour, minute int) *time.Time {
+	t := time.Date(2021, 3, 15, hour, minute, 0, 0, time.UTC)
+	return &t
+}
+
+func TestScheduler_Release(t *testing.T) {
+	s := store.New(
+		model.Job{ID: "1", TenantID: 1, Status: "SCHEDULED", NotBefore: at(10, 0), HeldPayload: "MQo="},
+		model.Job{ID: "2", TenantID: 1, Status: "SCHEDULED", NotBefore: at(11, 0)},
+		model.Job{ID: "3", TenantID: 1, Status: "SCHEDULED", NotBefore: at(10, 0), Cron: "0 * * * *",
+			HeldPayload: "Mgo=", CallbackURL: "http://example.com/cb", DependsOn: []string{"100"},
+			Operations: []model.Operation{{Type: model.OperationRotate, Params: map[string]interface{}{"angle": 90}}}},
+		model.Job{ID: "4", TenantID: 1, Status: "CANCELED", NotBefore: at(10, 0)},
+	)
+	waker := &wakerMock{}
+	var spawned []model.Job
+	sch := Scheduler{Store: s, Workflow: waker, OnSpawn: func(job model.Job) { spawned = append(spawned, job) },
+		now: func() time.Time { return *at(10, 30) }}
+
+	sch.Release()
+	job, err := s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "WAITING", job.Status, "due job is released")
+	assert.Equal(t, "MQo=", job.HeldPayload)
+	job, err = s.Get("2")
+	require.NoError(t, err)
+	assert.Equal(t, "SCHEDULED", job.Status, "job is not due yet")
+	job, err = s.Get("4")
+	require.NoError(t, err)
+	assert.Equal(t, "CANCELED", job.Status)
+
+	template, err := s.Get("3")
+	require.NoError(t, err)
+	assert.Equal(t, "SCHEDULED", template.Status, "recurring job stays scheduled")
+	assert.Equal(t, *at(11, 0), *template.NotBefore)
+	assert.Equal(t, 1, template.Runs)
+	require.Len(t, spawned, 1)
+	run, err := s.Get(spawned[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "WAITING", run.Status)
+	assert.Equal(t, "3", run.ScheduledBy)
+	assert.Equal(t, "Mgo=", run.HeldPayload)
+	assert.Equal(t, template.Operations, run.Operations)
+	assert.Equal(t, template.DependsOn, run.DependsOn)
+	assert.Equal(t, template.CallbackURL, run.CallbackURL)
+	assert.Empty(t, run.Cron)
+	assert.Nil(t, run.NotBefore)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&waker.wakes))
+
+	sch.Release()
+	assert.Len(t, spawned, 1, "recurring job is run once per cron time")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&waker.wakes), "nothing is released")
+
+	//missed runs are skipped
+	sch.now = func() time.Time { return *at(14, 10) }
+	sch.Release()
+	assert.Len(t, spawned, 2)
+	template, err = s.Get("3")
+	require.NoError(t, err)
+	assert.Equal(t, *at(15, 0), *template.NotBefore)
+	assert.Equal(t, 2, template.Runs)
+	job, err = s.Get("2")
+	require.NoError(t, err)
+	assert.Equal(t, "WAITING", job.Status)
+}
+
+func TestScheduler_ReleaseOverQuota(t *testing.T) {
+	s := store.New(model.Job{ID: "1", TenantID: 1, Status: "SCHEDULED", NotBefore: at(10, 0), Cron: "0 * * * *",
+		HeldPayload: "Mgo=", PayloadSize: 4})
+	var spawned []model.Job
+	left := 1
+	sch := Scheduler{Store: s, OnSpawn: func(job model.Job) { spawned = append(spawned, job) },
+		Quota: func(job model.Job) error {
+			assert.Equal(t, 1, job.TenantID)
+			assert.Equal(t, 4, job.PayloadSize)
+			if left == 0 {
+				return errors.New("daily quota is exceeded")
+			}
+			left--
+			return nil
+		},
+		now: func() time.Time { return *at(10, 30) }}
+
+	sch.Release()
+	sch.now = func() time.Time { return *at(11, 30) }
+	sch.Release()
+	require.Len(t, spawned, 2, "run over quota is recorded")
+	run, err := s.Get(spawned[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "WAITING", run.Status)
+	run, err = s.Get(spawned[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "FAILED", run.Status)
+	assert.Equal(t, &model.JobError{Code: model.ErrorCodeQuotaExceeded, Message: "daily quota is exceeded"}, run.Error)
+	assert.Empty(t, run.HeldPayload)
+
+	template, err := s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "SCHEDULED", template.Status, "recurring job isn't stopped by quota")
+	assert.Equal(t, *at(12, 0), *template.NotBefore)
+}
+
+func TestScheduler_Scheduled(t *testing.T) {
+	s := store.New(
+		model.Job{ID: "1", TenantID: 1, Status: "SCHEDULED", NotBefore: at(12, 0)},
+		model.Job{ID: "2", TenantID: 2, Status: "SCHEDULED", NotBefore: at(10, 0)},
+		model.Job{ID: "3", TenantID: 1, Status: "SCHEDULED", NotBefore: at(11, 0), Cron: "0 * * * *"},
+		model.Job{ID: "4", TenantID: 1, Status: "WAITING"},
+		model.Job{ID: "5", TenantID: 1, Status: "SCHEDULED", NotBefore: at(10, 0)},
+	)
+	sch := Scheduler{Store: s}
+	ids := []string{}
+	for _, job := range sch.Scheduled(1) {
+		ids = append(ids, job.ID)
+	}
+	assert.Equal(t, []string{"5", "3", "1"}, ids)
+	assert.Empty(t, sch.Scheduled(3))
+}
+
+func TestScheduler_Cancel(t *testing.T) {
+	s := store.New(
+		model.Job{ID: "1", Status: "SCHEDULED", NotBefore: at(12, 0), HeldPayload: "MQo="},
+		model.Job{ID: "2", Status: "WAITING", DependsOn: []string{"1"}, HeldPayload: "MQo="},
+		model.Job{ID: "3", Status: "RUNNING"},
+		model.Job{ID: "4", Status: "SUCCESS"},
+	)
+	sch := Scheduler{Store: s}
+
+	job, err := sch.Cancel("1")
+	require.NoError(t, err)
+	assert.Equal(t, "CANCELED", job.Status)
+	assert.Empty(t, job.HeldPayload)
+	require.NotNil(t, job.Error)
+	assert.Equal(t, model.ErrorCodeCanceled, job.Error.Code)
+
+	job, err = sch.Cancel("2")
+	require.NoError(t, err)
+	assert.Equal(t, "CANCELED", job.Status)
+
+	for _, id := range []string{"1", "3", "4"} {
+		_, err = sch.Cancel(id)
+		assert.True(t, errors.Is(err, ErrNotCancelable), "job %s", id)
+	}
+	_, err = sch.Cancel("100")
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+	job, err = s.Get("3")
+	require.NoError(t, err)
+	assert.Equal(t, "RUNNING", job.Status)
+}
+
+func TestScheduler_Run(t *testing.T) {
+	s := store.New(model.Job{ID: "1", Status: "SCHEDULED", NotBefore: at(10, 0)})
+	sch := Scheduler{Store: s, Interval: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sch.Run(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		job, err := s.Get("1")
+		return err == nil && job.Status == "WAITING"
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
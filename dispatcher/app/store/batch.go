@@ -0,0 +1,62 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//ErrBatchNotFound returned for unknown batch id
+var ErrBatchNotFound = errors.New("batch not found")
+
+//Batches is in-memory thread safe storage of job batches
+type Batches struct {
+	lock    sync.RWMutex
+	batches map[string]model.Batch
+	lastID  int
+	now     func() time.Time
+}
+
+//NewBatches makes empty batch storage
+func NewBatches() *Batches {
+	return &Batches{batches: map[string]model.Batch{}, now: time.Now}
+}
+
+//Create stores new batch with next id and creation time
+func (b *Batches) Create(batch model.Batch) model.Batch {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.lastID++
+	batch.ID = strconv.Itoa(b.lastID)
+	created := b.now()
+	batch.CreatedAt = &created
+	b.batches[batch.ID] = batch
+	return batch
+}
+
+//Get returns batch by id
+func (b *Batches) Get(id string) (model.Batch, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	batch, ok := b.batches[id]
+	if !ok {
+		return model.Batch{}, errors.Wrapf(ErrBatchNotFound, "no batch with id: %s", id)
+	}
+	return batch, nil
+}
+
+//DeleteFunc removes batches matched by fn and returns number of removed batches
+func (b *Batches) DeleteFunc(fn func(batch model.Batch) bool) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	removed := 0
+	for id, batch := range b.batches {
+		if fn(batch) {
+			delete(b.batches, id)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"testing"
+	"time"
+)
+
+func TestBatches(t *testing.T) {
+	b := NewBatches()
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	batch := b.Create(model.Batch{TenantID: 1, Items: 2, JobIDs: []string{"1"}})
+	assert.Equal(t, "1", batch.ID)
+	assert.Equal(t, now, *batch.CreatedAt)
+	assert.Equal(t, "2", b.Create(model.Batch{TenantID: 2}).ID)
+
+	res, err := b.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, batch, res)
+	_, err = b.Get("3")
+	assert.True(t, errors.Is(err, ErrBatchNotFound))
+	assert.EqualError(t, err, "no batch with id: 3: batch not found")
+
+	assert.Equal(t, 1, b.DeleteFunc(func(batch model.Batch) bool { return batch.TenantID == 2 }))
+	_, err = b.Get("2")
+	assert.True(t, errors.Is(err, ErrBatchNotFound))
+	_, err = b.Get("1")
+	assert.NoError(t, err)
+}
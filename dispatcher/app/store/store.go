@@ -0,0 +1,146 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//ErrNotFound returned for unknown job id
+var ErrNotFound = errors.New("job not found")
+
+//Store is in-memory thread safe storage of dispatcher jobs. It is the source of truth for job reads,
+//worker status is written into it by reconciler
+type Store struct {
+	lock      sync.RWMutex
+	jobs      map[string]model.Job
+	lastID    int
+	listeners []func(job model.Job)
+	hooks     []func(prev model.Job, job *model.Job)
+	now       func() time.Time
+}
+
+//New makes store with initial jobs
+func New(jobs ...model.Job) *Store {
+	s := &Store{jobs: map[string]model.Job{}, now: time.Now}
+	for _, job := range jobs {
+		s.put(job)
+	}
+	return s
+}
+
+//Create stores new job with next id, RUNNING status and creation time
+func (s *Store) Create(job model.Job) model.Job {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastID++
+	job.ID = strconv.Itoa(s.lastID)
+	return s.put(job)
+}
+
+//Get returns job by id
+func (s *Store) Get(id string) (model.Job, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return model.Job{}, errors.Wrapf(ErrNotFound, "no job with id: %s", id)
+	}
+	return job, nil
+}
+
+//Update changes job by fn and notifies listeners. Job is not changed if fn returns error. Job id can't be changed
+func (s *Store) Update(id string, fn func(job *model.Job) error) (model.Job, error) {
+	s.lock.Lock()
+	job, ok := s.jobs[id]
+	if !ok {
+		s.lock.Unlock()
+		return model.Job{}, errors.Wrapf(ErrNotFound, "no job with id: %s", id)
+	}
+	prev := job
+	if err := fn(&job); err != nil {
+		s.lock.Unlock()
+		return model.Job{}, err
+	}
+	job.ID = id
+	for _, hook := range s.hooks {
+		hook(prev, &job)
+	}
+	updated := s.now()
+	job.UpdatedAt = &updated
+	s.jobs[id] = job
+	listeners := s.listeners
+	s.lock.Unlock()
+
+	for _, fn := range listeners {
+		fn(job)
+	}
+	return job, nil
+}
+
+//Delete removes job by id
+func (s *Store) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.jobs[id]; !ok {
+		return errors.Wrapf(ErrNotFound, "no job with id: %s", id)
+	}
+	delete(s.jobs, id)
+	return nil
+}
+
+//Find returns jobs matched by filter ordered by creation time, all jobs if filter is nil
+func (s *Store) Find(filter func(job model.Job) bool) []model.Job {
+	s.lock.RLock()
+	res := []model.Job{}
+	for _, job := range s.jobs {
+		if filter == nil || filter(job) {
+			res = append(res, job)
+		}
+	}
+	s.lock.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(*res[j].CreatedAt) {
+			return res[i].CreatedAt.Before(*res[j].CreatedAt)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+//OnUpdate registers listener called with job after each update
+func (s *Store) OnUpdate(fn func(job model.Job)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+//BeforeUpdate registers hook which can change job updated by fn before it is saved. Hook gets job state
+//before the update and is called under store lock, so it must not call store
+func (s *Store) BeforeUpdate(hook func(prev model.Job, job *model.Job)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+//put saves job filling defaults, must be called under lock
+func (s *Store) put(job model.Job) model.Job {
+	if job.Status == "" {
+		job.Status = model.JobStatus(model.RUNNING).ToString()
+	}
+	if job.CreatedAt == nil {
+		created := s.now()
+		job.CreatedAt = &created
+	}
+	if job.UpdatedAt == nil {
+		job.UpdatedAt = job.CreatedAt
+	}
+	if id, err := strconv.Atoi(job.ID); err == nil && id > s.lastID {
+		s.lastID = id
+	}
+	s.jobs[job.ID] = job
+	return job
+}
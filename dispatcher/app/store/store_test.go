@@ -0,0 +1,144 @@
+package store
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore_CreateGet(t *testing.T) {
+	s := New(model.Job{ID: "1", TenantID: 1}, model.Job{ID: "7", TenantID: 2, Status: "SUCCESS"})
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	job := s.Create(model.Job{TenantID: 3, ClientID: 4})
+	assert.Equal(t, "8", job.ID, "id follows the max seeded id")
+	assert.Equal(t, "RUNNING", job.Status)
+	assert.Equal(t, now, *job.CreatedAt)
+	assert.Equal(t, now, *job.UpdatedAt)
+
+	res, err := s.Get("8")
+	require.NoError(t, err)
+	assert.Equal(t, job, res)
+	res, err = s.Get("7")
+	require.NoError(t, err)
+	assert.Equal(t, "SUCCESS", res.Status)
+
+	_, err = s.Get("9")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.EqualError(t, err, "no job with id: 9: job not found")
+}
+
+func TestStore_Update(t *testing.T) {
+	s := New(model.Job{ID: "1"})
+	var updates []model.Job
+	s.OnUpdate(func(job model.Job) { updates = append(updates, job) })
+	updated := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return updated }
+
+	job, err := s.Update("1", func(job *model.Job) error {
+		job.ID = "2"
+		job.Status = "SUCCESS"
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1", job.ID, "id is not changed")
+	assert.Equal(t, "SUCCESS", job.Status)
+	assert.Equal(t, updated, *job.UpdatedAt)
+	assert.NotEqual(t, updated, *job.CreatedAt)
+	assert.Equal(t, []model.Job{job}, updates)
+
+	_, err = s.Update("2", func(job *model.Job) error { return nil })
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, 1, len(updates))
+
+	_, err = s.Update("1", func(job *model.Job) error {
+		job.Status = "FAILED"
+		return errors.New("job is finished")
+	})
+	assert.EqualError(t, err, "job is finished")
+	job, err = s.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, "SUCCESS", job.Status, "job is not changed on error")
+	assert.Equal(t, 1, len(updates))
+}
+
+func TestStore_BeforeUpdate(t *testing.T) {
+	s := New(model.Job{ID: "1"})
+	var updates []model.Job
+	s.OnUpdate(func(job model.Job) { updates = append(updates, job) })
+	s.BeforeUpdate(func(prev model.Job, job *model.Job) {
+		if prev.Status == "RUNNING" && job.Status == "FAILED" {
+			job.Status = "RETRYING"
+		}
+	})
+
+	job, err := s.Update("1", func(job *model.Job) error {
+		job.Status = "FAILED"
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "RETRYING", job.Status, "hook changes job before save")
+	require.Equal(t, 1, len(updates))
+	assert.Equal(t, "RETRYING", updates[0].Status, "listeners get changed job")
+
+	job, err = s.Update("1", func(job *model.Job) error {
+		job.Status = "FAILED"
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "FAILED", job.Status, "hook gets previous state")
+}
+
+func TestStore_Delete(t *testing.T) {
+	s := New(model.Job{ID: "1"})
+	require.NoError(t, s.Delete("1"))
+	_, err := s.Get("1")
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.True(t, errors.Is(s.Delete("1"), ErrNotFound))
+	assert.Equal(t, "2", s.Create(model.Job{}).ID, "ids are not reused")
+}
+
+func TestStore_Find(t *testing.T) {
+	s := New()
+	tm := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		tm = tm.Add(-time.Minute)
+		return tm
+	}
+	for i := 0; i < 4; i++ {
+		s.Create(model.Job{TenantID: i % 2})
+	}
+
+	res := s.Find(nil)
+	require.Equal(t, 4, len(res))
+	assert.Equal(t, "4", res[0].ID, "ordered by creation time")
+
+	res = s.Find(func(job model.Job) bool { return job.TenantID == 1 })
+	require.Equal(t, 2, len(res))
+	assert.Equal(t, "4", res[0].ID)
+	assert.Equal(t, "2", res[1].ID)
+}
+
+func TestStore_Concurrent(t *testing.T) {
+	s := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job := s.Create(model.Job{})
+			_, err := s.Update(job.ID, func(job *model.Job) error {
+				job.Status = "SUCCESS"
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 50, len(s.Find(func(job model.Job) bool { return job.Status == "SUCCESS" })))
+}
@@ -0,0 +1,256 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//FileExporter writes each span as JSON line to Out, so traces can be checked without collector
+type FileExporter struct {
+	Out  io.Writer
+	lock sync.Mutex
+}
+
+//OpenFile makes exporter appending spans to file
+func OpenFile(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{Out: f}, nil
+}
+
+//Export writes span
+func (e *FileExporter) Export(span SpanData) {
+	line, err := json.Marshal(span)
+	if err != nil {
+		log.Printf("[WARN] can not encode span %s, %v", span.Name, err)
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if _, err = e.Out.Write(append(line, '\n')); err != nil {
+		log.Printf("[WARN] can not write span %s, %v", span.Name, err)
+	}
+}
+
+//MemoryExporter keeps exported spans in memory, it is used in tests
+type MemoryExporter struct {
+	lock  sync.Mutex
+	spans []SpanData
+}
+
+//Export keeps span
+func (e *MemoryExporter) Export(span SpanData) {
+	e.lock.Lock()
+	e.spans = append(e.spans, span)
+	e.lock.Unlock()
+}
+
+//Spans returns exported spans in order of their end
+func (e *MemoryExporter) Spans() []SpanData {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]SpanData{}, e.spans...)
+}
+
+//OTLPExporter posts spans in batches to OTLP/HTTP collector in JSON encoding. Spans are queued by Export and
+//sent by Run every Interval or when batch is full, spans which don't fit queue are dropped
+type OTLPExporter struct {
+	Endpoint  string //collector url, spans are posted to Endpoint/v1/traces
+	Client    *http.Client
+	Interval  time.Duration
+	BatchSize int
+	queue     chan SpanData
+	flush     chan struct{}
+	once      sync.Once
+}
+
+//NewOTLPExporter makes exporter to collector url with default batching
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{Endpoint: endpoint, Client: &http.Client{Timeout: 10 * time.Second},
+		Interval: 5 * time.Second, BatchSize: 512}
+}
+
+func (e *OTLPExporter) init() {
+	e.once.Do(func() {
+		if e.BatchSize <= 0 {
+			e.BatchSize = 512
+		}
+		e.queue = make(chan SpanData, e.BatchSize*4)
+		e.flush = make(chan struct{}, 1)
+	})
+}
+
+//Export queues span
+func (e *OTLPExporter) Export(span SpanData) {
+	e.init()
+	select {
+	case e.queue <- span:
+	default:
+		log.Printf("[WARN] span queue is full, span %s is dropped", span.Name)
+		return
+	}
+	if len(e.queue) >= e.BatchSize {
+		select {
+		case e.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//Run sends queued spans till context is canceled, queued spans are sent on exit
+func (e *OTLPExporter) Run(ctx context.Context) {
+	e.init()
+	interval := e.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			sendCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			e.send(sendCtx)
+			cancel()
+			return
+		case <-ticker.C:
+		case <-e.flush:
+		}
+		e.send(ctx)
+	}
+}
+
+//send posts all queued spans by batches
+func (e *OTLPExporter) send(ctx context.Context) {
+	for {
+		batch := make([]SpanData, 0, e.BatchSize)
+	fill:
+		for len(batch) < e.BatchSize {
+			select {
+			case span := <-e.queue:
+				batch = append(batch, span)
+			default:
+				break fill
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.post(ctx, batch); err != nil {
+			log.Printf("[WARN] can not export %d spans, %v", len(batch), err)
+			return
+		}
+	}
+}
+
+func (e *OTLPExporter) post(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.Endpoint, "/")+"/v1/traces",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector responded with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+//otlpRequest makes body of OTLP/HTTP JSON export request, spans are grouped by service
+func otlpRequest(spans []SpanData) map[string]interface{} {
+	var services []string
+	byService := map[string][]interface{}{}
+	for _, s := range spans {
+		if _, ok := byService[s.Service]; !ok {
+			services = append(services, s.Service)
+		}
+		byService[s.Service] = append(byService[s.Service], otlpSpan(s))
+	}
+	resourceSpans := make([]interface{}, 0, len(services))
+	for _, service := range services {
+		resourceSpans = append(resourceSpans, map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(map[string]interface{}{"service.name": service})},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "image-jobs-dispatcher"},
+				"spans": byService[service],
+			}},
+		})
+	}
+	return map[string]interface{}{"resourceSpans": resourceSpans}
+}
+
+func otlpSpan(s SpanData) map[string]interface{} {
+	res := map[string]interface{}{
+		"traceId":           s.TraceID,
+		"spanId":            s.SpanID,
+		"name":              s.Name,
+		"kind":              int(s.Kind),
+		"startTimeUnixNano": strconv.FormatInt(s.StartTime.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.Attributes),
+	}
+	if s.ParentSpanID != "" {
+		res["parentSpanId"] = s.ParentSpanID
+	}
+	if s.Error != "" {
+		res["status"] = map[string]interface{}{"code": 2, "message": s.Error}
+	}
+	return res
+}
+
+//otlpAttributes converts attributes to OTLP key values, not supported types are written as strings
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := make([]interface{}, 0, len(attrs))
+	for _, k := range keys {
+		var value map[string]interface{}
+		switch v := attrs[k].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		res = append(res, map[string]interface{}{"key": k, "value": value})
+	}
+	return res
+}
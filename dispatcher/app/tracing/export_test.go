@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traces")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.ndjson")
+	exporter, err := OpenFile(path)
+	require.NoError(t, err)
+	tracer := &Tracer{Service: "dispatcher", Exporter: exporter}
+
+	ctx, root := tracer.Start(context.Background(), "POST /api/v1/job")
+	_, child := tracer.Start(ctx, "auth.jwt")
+	child.SetAttr("tenant_id", 1)
+	child.End()
+	root.End()
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	span := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &span))
+	assert.Equal(t, "auth.jwt", span["name"])
+	assert.Equal(t, "internal", span["kind"])
+	assert.Equal(t, "dispatcher", span["service"])
+	assert.Equal(t, root.Context().TraceID, span["trace_id"])
+	assert.Equal(t, root.Context().SpanID, span["parent_span_id"])
+	assert.Equal(t, map[string]interface{}{"tenant_id": float64(1)}, span["attributes"])
+
+	_, err = OpenFile(filepath.Join(dir, "no", "traces.ndjson"))
+	assert.Error(t, err)
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var lock sync.Mutex
+	var requests []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		req := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		lock.Lock()
+		requests = append(requests, req)
+		lock.Unlock()
+	}))
+	defer ts.Close()
+	exporter := NewOTLPExporter(ts.URL + "/")
+	exporter.Interval = time.Hour
+	exporter.BatchSize = 2
+	tracer := &Tracer{Service: "dispatcher", Exporter: exporter}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tracer.Run(ctx)
+		close(done)
+	}()
+
+	spanCtx, root := tracer.Start(context.Background(), "root")
+	span := tracer.StartSpan(FromContext(spanCtx), "POST worker", KindClient)
+	span.SetAttr("attempt", 2)
+	span.SetAttr("http.url", "http://worker/job")
+	span.Fail(context.DeadlineExceeded)
+	span.End()
+	root.End()
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(requests) == 1
+	}, time.Second, 10*time.Millisecond, "full batch is sent at once")
+
+	_, last := tracer.Start(context.Background(), "last")
+	last.End()
+	cancel()
+	<-done
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, requests, 2, "queued spans are sent on exit")
+
+	resource := requests[0]["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"attributes": []interface{}{map[string]interface{}{"key": "service.name",
+		"value": map[string]interface{}{"stringValue": "dispatcher"}}}}, resource["resource"])
+	spans := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	require.Len(t, spans, 2)
+	client := spans[0].(map[string]interface{})
+	assert.Equal(t, "POST worker", client["name"])
+	assert.Equal(t, float64(KindClient), client["kind"])
+	assert.Equal(t, root.Context().TraceID, client["traceId"])
+	assert.Equal(t, span.Context().SpanID, client["spanId"])
+	assert.Equal(t, root.Context().SpanID, client["parentSpanId"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "attempt", "value": map[string]interface{}{"intValue": "2"}},
+		map[string]interface{}{"key": "http.url", "value": map[string]interface{}{"stringValue": "http://worker/job"}},
+	}, client["attributes"])
+	assert.Equal(t, map[string]interface{}{"code": float64(2), "message": "context deadline exceeded"}, client["status"])
+	start, err := strconv.ParseInt(client["startTimeUnixNano"].(string), 10, 64)
+	require.NoError(t, err)
+	end, err := strconv.ParseInt(client["endTimeUnixNano"].(string), 10, 64)
+	require.NoError(t, err)
+	assert.True(t, start > 0 && end >= start)
+	rootSpan := spans[1].(map[string]interface{})
+	assert.Equal(t, "root", rootSpan["name"])
+	assert.NotContains(t, rootSpan, "parentSpanId")
+	assert.NotContains(t, rootSpan, "status", "status of not failed span is unset")
+}
+
+func TestOTLPExporter_Error(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	exporter := NewOTLPExporter(ts.URL)
+	err := exporter.post(context.Background(), []SpanData{{TraceID: "1", SpanID: "2", Name: "span"}})
+	require.Error(t, err)
+	assert.Equal(t, "collector responded with status 503", err.Error())
+}
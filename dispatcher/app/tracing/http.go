@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
+	"net/http"
+)
+
+//Middleware starts server span of request, span is child of span from traceparent header of caller.
+//Span is named by route pattern, so ids in urls don't make new span names
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		parent, _ := ParseTraceParent(req.Header.Get(HeaderTraceParent))
+		span := t.StartSpan(parent, req.Method, KindServer)
+		if span == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		defer span.End()
+		ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+		next.ServeHTTP(ww, req.WithContext(WithSpanContext(req.Context(), span.Context())))
+		route := "unmatched"
+		if rctx := chi.RouteContext(req.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.rename(req.Method + " " + route)
+		span.SetAttr("http.method", req.Method)
+		span.SetAttr("http.route", route)
+		span.SetAttr("http.target", req.URL.Path)
+		span.SetAttr("http.status_code", status)
+		if id := logging.RequestID(req.Context()); id != "" {
+			span.SetAttr("request_id", id)
+		}
+		if status >= http.StatusInternalServerError {
+			span.Fail(fmt.Errorf("response status %d", status))
+		}
+	})
+}
+
+//Inject sets traceparent header of span context, header is not changed for invalid context
+func Inject(sc SpanContext, header http.Header) {
+	if sc.IsValid() {
+		header.Set(HeaderTraceParent, sc.TraceParent())
+	}
+}
+
+//Transport returns http transport making client span of each request with parent from request context and
+//passing it in traceparent header, default transport is used if next is nil
+func (t *Tracer) Transport(service string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		span := t.StartSpan(FromContext(req.Context()), req.Method+" "+service, KindClient)
+		if span == nil {
+			return next.RoundTrip(req)
+		}
+		defer span.End()
+		req = req.Clone(req.Context())
+		Inject(span.Context(), req.Header)
+		span.SetAttr("http.method", req.Method)
+		span.SetAttr("http.url", req.URL.String())
+		span.SetAttr("upstream", service)
+		resp, err := next.RoundTrip(req)
+		if err != nil {
+			span.Fail(err)
+			return resp, err
+		}
+		span.SetAttr("http.status_code", resp.StatusCode)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.Fail(fmt.Errorf("response status %d", resp.StatusCode))
+		}
+		return resp, nil
+	})
+}
+
+type roundTripper func(req *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracer_Middleware(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tracer := &Tracer{Service: "dispatcher", Exporter: exporter}
+	var handlerSpan SpanContext
+	router := chi.NewRouter()
+	router.Use(logging.Middleware, tracer.Middleware)
+	router.Get("/job/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = FromContext(r.Context())
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest("GET", "/job/1", nil)
+	req.Header.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set(logging.HeaderRequestID, "req-1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "GET /job/{id}", spans[0].Name)
+	assert.Equal(t, KindServer, spans[0].Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].ParentSpanID)
+	assert.Equal(t, spans[0].SpanID, handlerSpan.SpanID, "handler gets span of request")
+	assert.Equal(t, map[string]interface{}{"http.method": "GET", "http.route": "/job/{id}", "http.target": "/job/1",
+		"http.status_code": http.StatusBadGateway, "request_id": "req-1"}, spans[0].Attributes)
+	assert.Equal(t, "response status 502", spans[0].Error)
+
+	assert.Equal(t, "GET unmatched", spans[1].Name)
+	assert.Empty(t, spans[1].ParentSpanID, "request without traceparent starts new trace")
+	assert.NotEqual(t, spans[0].TraceID, spans[1].TraceID)
+	assert.Empty(t, spans[1].Error)
+}
+
+func TestTracer_Transport(t *testing.T) {
+	var traceParent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get(HeaderTraceParent)
+	}))
+	defer ts.Close()
+	exporter := &MemoryExporter{}
+	tracer := &Tracer{Exporter: exporter}
+	client := &http.Client{Transport: tracer.Transport("blob", nil)}
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	req, err := http.NewRequestWithContext(ctx, "HEAD", ts.URL+"/blob/1", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	root.End()
+	assert.Empty(t, req.Header.Get(HeaderTraceParent), "request of caller is not changed")
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "HEAD blob", spans[0].Name)
+	assert.Equal(t, KindClient, spans[0].Kind)
+	assert.Equal(t, root.Context().SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, "00-"+spans[0].TraceID+"-"+spans[0].SpanID+"-01", traceParent, "server gets span of request")
+	assert.Equal(t, http.StatusOK, spans[0].Attributes["http.status_code"])
+	assert.Equal(t, "blob", spans[0].Attributes["upstream"])
+
+	_, err = (&http.Client{Transport: tracer.Transport("blob", nil)}).Get("http://localhost:1/blob/1")
+	require.Error(t, err)
+	spans = exporter.Spans()
+	require.Len(t, spans, 3)
+	assert.NotEmpty(t, spans[2].Error, "failed request is failed span")
+
+	disabled := &http.Client{Transport: (&Tracer{}).Transport("blob", nil)}
+	resp, err = disabled.Get(ts.URL + "/blob/1")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Empty(t, traceParent)
+}
@@ -0,0 +1,230 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//HeaderTraceParent is W3C trace context header, span context is passed in it to worker and blob services
+const HeaderTraceParent = "traceparent"
+
+//Kind is kind of span, values are the same as in OTLP
+type Kind int
+
+//kinds of span
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+//MarshalText writes kind as its name
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+//String returns name of kind
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+var traceParent = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+//SpanContext identifies span in trace, it is passed between services in traceparent header
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+//IsValid checks both ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+//TraceParent returns value of traceparent header, empty for invalid context
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+//ParseTraceParent parses value of traceparent header, unknown versions are parsed by version 00 format
+func ParseTraceParent(s string) (SpanContext, error) {
+	m := traceParent.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || m[1] == "ff" || m[1] == "00" && m[5] != "" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if m[2] == strings.Repeat("0", 32) || m[3] == strings.Repeat("0", 16) {
+		return SpanContext{}, fmt.Errorf("traceparent %q has zero id", s)
+	}
+	flags, _ := hex.DecodeString(m[4])
+	return SpanContext{TraceID: m[2], SpanID: m[3], Sampled: flags[0]&1 == 1}, nil
+}
+
+type ctxKey struct{}
+
+//WithSpanContext returns context with span context, spans started from it are its children
+func WithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, ctxKey{}, sc)
+}
+
+//FromContext returns span context from context, it is not valid if not set
+func FromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(ctxKey{}).(SpanContext)
+	return sc
+}
+
+//Exporter sends finished spans to trace backend
+type Exporter interface {
+	Export(span SpanData)
+}
+
+//Tracer makes spans of Service and passes finished ones to Exporter. Tracer without exporter or nil one makes
+//no spans, so callers don't check it is enabled
+type Tracer struct {
+	Service  string
+	Exporter Exporter
+}
+
+//Start starts internal span with parent from context and returns context with the new span
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	span := t.StartSpan(FromContext(ctx), name, KindInternal)
+	if span == nil {
+		return ctx, nil
+	}
+	return WithSpanContext(ctx, span.Context()), span
+}
+
+//StartSpan starts span with parent, span is root of new trace if parent is not valid
+func (t *Tracer) StartSpan(parent SpanContext, name string, kind Kind) *Span {
+	if t == nil || t.Exporter == nil {
+		return nil
+	}
+	data := SpanData{Name: name, Kind: kind, Service: t.Service, SpanID: newID(8), StartTime: time.Now(),
+		Attributes: map[string]interface{}{}}
+	sampled := true
+	if parent.IsValid() {
+		data.TraceID, data.ParentSpanID, sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		data.TraceID = newID(16)
+	}
+	return &Span{exporter: t.Exporter, data: data, sampled: sampled}
+}
+
+//Run runs exporter which sends spans in background till context is canceled
+func (t *Tracer) Run(ctx context.Context) {
+	if t == nil {
+		return
+	}
+	if r, ok := t.Exporter.(interface{ Run(ctx context.Context) }); ok {
+		r.Run(ctx)
+	}
+}
+
+//SpanData is finished span passed to exporter
+type SpanData struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         Kind                   `json:"kind"`
+	Service      string                 `json:"service"`
+	StartTime    time.Time              `json:"start"`
+	EndTime      time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+//Span is operation in trace. All methods of nil span do nothing, it is returned by disabled tracer
+type Span struct {
+	exporter Exporter
+	sampled  bool
+	lock     sync.Mutex
+	data     SpanData
+	ended    bool
+}
+
+//Context returns span context of span to start its children or pass it to other service
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: s.sampled}
+}
+
+//SetAttr sets attribute of span, value is string, number or bool
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	s.data.Attributes[key] = value
+	s.lock.Unlock()
+}
+
+func (s *Span) rename(name string) {
+	s.lock.Lock()
+	s.data.Name = name
+	s.lock.Unlock()
+}
+
+//Fail marks span failed with error, nil error is ignored
+func (s *Span) Fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	s.data.Error = err.Error()
+	s.lock.Unlock()
+}
+
+//End finishes span and exports sampled one, repeated calls are ignored
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.lock.Unlock()
+	if s.sampled {
+		s.exporter.Export(data)
+	}
+}
+
+//newID makes random hex id of n bytes
+func newID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		b = []byte(fmt.Sprintf("%0*x", n, time.Now().UnixNano()))[:n]
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tbl := []struct {
+		s   string
+		sc  SpanContext
+		err bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-future",
+			SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", SpanContext{}, true},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", SpanContext{}, true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", SpanContext{}, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", SpanContext{}, true},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", SpanContext{}, true},
+		{"", SpanContext{}, true},
+	}
+	for i, tt := range tbl {
+		sc, err := ParseTraceParent(tt.s)
+		if tt.err {
+			assert.Error(t, err, "test case #%d", i)
+			continue
+		}
+		require.NoError(t, err, "test case #%d", i)
+		assert.Equal(t, tt.sc, sc, "test case #%d", i)
+	}
+	sc := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.TraceParent())
+	assert.Equal(t, "", SpanContext{}.TraceParent())
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tracer := &Tracer{Service: "test", Exporter: exporter}
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttr("job_id", "1")
+	child.Fail(errors.New("failed"))
+	child.Fail(nil)
+	child.End()
+	child.End()
+	root.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2, "repeated End exports span once")
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, "root", spans[1].Name)
+	assert.Len(t, spans[1].TraceID, 32)
+	assert.Len(t, spans[1].SpanID, 16)
+	assert.Empty(t, spans[1].ParentSpanID)
+	assert.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	assert.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	assert.Equal(t, map[string]interface{}{"job_id": "1"}, spans[0].Attributes)
+	assert.Equal(t, "failed", spans[0].Error)
+	assert.Equal(t, "test", spans[0].Service)
+	assert.False(t, spans[0].EndTime.Before(spans[0].StartTime))
+}
+
+func TestTracer_NotSampled(t *testing.T) {
+	exporter := &MemoryExporter{}
+	tracer := &Tracer{Exporter: exporter}
+	parent := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}
+	span := tracer.StartSpan(parent, "client", KindClient)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.Context().SpanID+"-00", span.Context().TraceParent(),
+		"not sampled flag is propagated")
+	span.End()
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestTracer_Disabled(t *testing.T) {
+	for i, tracer := range []*Tracer{nil, {Service: "test"}} {
+		ctx, span := tracer.Start(context.Background(), "root")
+		assert.Nil(t, span, "test case #%d", i)
+		assert.Equal(t, context.Background(), ctx, "test case #%d", i)
+		assert.False(t, span.Context().IsValid(), "test case #%d", i)
+		span.SetAttr("key", "value")
+		span.Fail(errors.New("failed"))
+		span.End()
+		tracer.Run(context.Background())
+	}
+}
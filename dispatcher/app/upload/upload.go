@@ -0,0 +1,236 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound       = errors.New("upload session not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrTooLarge       = errors.New("upload exceeds declared size")
+	ErrIncomplete     = errors.New("upload is not complete")
+	ErrChecksum       = errors.New("md5 checksum mismatch")
+	ErrFinalizing     = errors.New("upload is being finalized")
+	ErrTenantLimit    = errors.New("uploads of tenant exceed limit")
+)
+
+//Session is state of resumable upload, the assembled payload is kept in memory till finalize
+type Session struct {
+	ID         string    `json:"id"`
+	TenantID   int       `json:"tenant_id"`
+	ClientID   int       `json:"client_id"`
+	Size       int64     `json:"size"`
+	Offset     int64     `json:"offset"`
+	MD5        string    `json:"md5"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	data       bytes.Buffer
+	finalizing bool //payload is returned by Finalize and job is being submitted
+}
+
+//Service keeps upload sessions in memory
+type Service struct {
+	MaxSize       int64
+	TTL           time.Duration
+	MaxSessions   int   //max number of open sessions of tenant, unlimited if zero
+	MaxTenantSize int64 //max total declared size of open sessions of tenant, so memory they buffer, unlimited if zero
+	lock          sync.Mutex
+	sessions      map[string]*Session
+	now           func() time.Time
+}
+
+//NewService makes upload service with size limit of single upload and lifetime of inactive session
+func NewService(maxSize int64, ttl time.Duration) *Service {
+	return &Service{MaxSize: maxSize, TTL: ttl, sessions: map[string]*Session{}, now: time.Now}
+}
+
+//Create starts upload session of payload with declared size and md5 hash. Size is reserved at once,
+//so tenant can't open more sessions than fit its limits even if they are empty
+func (s *Service) Create(tenantID, clientID int, size int64, md5Hash string) (Session, error) {
+	if size <= 0 {
+		return Session{}, errors.Errorf("invalid upload size %d", size)
+	}
+	if size > s.MaxSize {
+		return Session{}, errors.Errorf("upload size %d is bigger than limit %d", size, s.MaxSize)
+	}
+	if err := validateMD5(md5Hash); err != nil {
+		return Session{}, err
+	}
+	id, err := newID()
+	if err != nil {
+		return Session{}, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cleanup()
+	if err = s.checkTenantLimits(tenantID, size); err != nil {
+		return Session{}, err
+	}
+	sess := &Session{ID: id, TenantID: tenantID, ClientID: clientID, Size: size, MD5: md5Hash, ExpiresAt: s.now().Add(s.TTL)}
+	s.sessions[id] = sess
+	return sess.snapshot(), nil
+}
+
+//Get returns upload session of tenant
+func (s *Service) Get(id string, tenantID int) (Session, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sess, err := s.session(id, tenantID)
+	if err != nil {
+		return Session{}, err
+	}
+	return sess.snapshot(), nil
+}
+
+//WriteChunk appends chunk to upload. Offset must be equal to already received size
+func (s *Service) WriteChunk(id string, tenantID int, offset int64, chunk io.Reader) (Session, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(chunk, s.MaxSize+1))
+	if err != nil {
+		return Session{}, errors.Wrap(err, "can't read upload chunk")
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sess, err := s.session(id, tenantID)
+	if err != nil {
+		return Session{}, err
+	}
+	if offset != sess.Offset {
+		return sess.snapshot(), errors.Wrapf(ErrOffsetMismatch, "expected offset %d, got %d", sess.Offset, offset)
+	}
+	if sess.Offset+int64(len(data)) > sess.Size {
+		return sess.snapshot(), ErrTooLarge
+	}
+	sess.data.Write(data)
+	sess.Offset += int64(len(data))
+	sess.ExpiresAt = s.now().Add(s.TTL)
+	return sess.snapshot(), nil
+}
+
+//Finalize validates complete upload against md5 hash and returns assembled payload. Hash passed to finalize replaces
+//declared one, so upload with mistyped hash can be finalized again. Session is kept till Complete, and the next
+//finalize is rejected till Release, so the same payload isn't submitted twice
+func (s *Service) Finalize(id string, tenantID int, md5Hash string) ([]byte, Session, error) {
+	if md5Hash != "" {
+		if err := validateMD5(md5Hash); err != nil {
+			return nil, Session{}, err
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sess, err := s.session(id, tenantID)
+	if err != nil {
+		return nil, Session{}, err
+	}
+	if sess.Offset != sess.Size {
+		return nil, sess.snapshot(), errors.Wrapf(ErrIncomplete, "received %d of %d bytes", sess.Offset, sess.Size)
+	}
+	if sess.finalizing {
+		return nil, sess.snapshot(), ErrFinalizing
+	}
+	if md5Hash != "" {
+		sess.MD5 = md5Hash
+	}
+	data := sess.data.Bytes()
+	if sum := fmt.Sprintf("%x", md5.Sum(data)); sum != sess.MD5 {
+		return nil, sess.snapshot(), errors.Wrapf(ErrChecksum, "passed: %s, calculated: %s", sess.MD5, sum)
+	}
+	sess.finalizing = true
+	sess.ExpiresAt = s.now().Add(s.TTL)
+	return data, sess.snapshot(), nil
+}
+
+//Complete removes finalized upload after its job is submitted
+func (s *Service) Complete(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, id)
+}
+
+//Release allows to finalize upload again after its job is not submitted, received payload is kept
+func (s *Service) Release(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sess, ok := s.sessions[id]; ok {
+		sess.finalizing = false
+	}
+}
+
+//Delete aborts upload
+func (s *Service) Delete(id string, tenantID int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.session(id, tenantID); err != nil {
+		return err
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *Service) session(id string, tenantID int) (*Session, error) {
+	sess, ok := s.sessions[id]
+	if !ok || sess.TenantID != tenantID {
+		return nil, ErrNotFound
+	}
+	if s.now().After(sess.ExpiresAt) {
+		delete(s.sessions, id)
+		return nil, ErrNotFound
+	}
+	return sess, nil
+}
+
+//checkTenantLimits checks that tenant can open one more session of size, must be called under lock
+func (s *Service) checkTenantLimits(tenantID int, size int64) error {
+	count, total := 0, size
+	for _, sess := range s.sessions {
+		if sess.TenantID == tenantID {
+			count++
+			total += sess.Size
+		}
+	}
+	if s.MaxSessions > 0 && count >= s.MaxSessions {
+		return errors.Wrapf(ErrTenantLimit, "tenant has %d open sessions, allowed %d", count, s.MaxSessions)
+	}
+	if s.MaxTenantSize > 0 && total > s.MaxTenantSize {
+		return errors.Wrapf(ErrTenantLimit, "sessions of tenant would reserve %d bytes, allowed %d", total, s.MaxTenantSize)
+	}
+	return nil
+}
+
+//cleanup drops expired sessions, must be called under lock
+func (s *Service) cleanup() {
+	for id, sess := range s.sessions {
+		if s.now().After(sess.ExpiresAt) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (sess *Session) snapshot() Session {
+	return Session{ID: sess.ID, TenantID: sess.TenantID, ClientID: sess.ClientID, Size: sess.Size,
+		Offset: sess.Offset, MD5: sess.MD5, ExpiresAt: sess.ExpiresAt}
+}
+
+func validateMD5(md5Hash string) error {
+	if _, err := hex.DecodeString(md5Hash); err != nil || len(md5Hash) != md5.Size*2 {
+		return errors.Errorf("invalid md5 %q", md5Hash)
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "can't generate upload id")
+	}
+	return hex.EncodeToString(b), nil
+}
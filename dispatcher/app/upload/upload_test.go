@@ -0,0 +1,141 @@
+package upload
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+const md5Hello = "5d41402abc4b2a76b9719d911017c592" //md5 of "hello"
+
+func TestService_Upload(t *testing.T) {
+	s := NewService(10, time.Minute)
+	sess, err := s.Create(1, 2, 5, md5Hello)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), sess.Offset)
+	assert.Equal(t, 32, len(sess.ID))
+
+	sess, err = s.WriteChunk(sess.ID, 1, 0, strings.NewReader("he"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), sess.Offset)
+
+	_, err = s.WriteChunk(sess.ID, 1, 0, strings.NewReader("he"))
+	assert.True(t, errors.Is(err, ErrOffsetMismatch))
+
+	_, _, err = s.Finalize(sess.ID, 1, "")
+	assert.True(t, errors.Is(err, ErrIncomplete))
+
+	_, err = s.WriteChunk(sess.ID, 1, 2, strings.NewReader("llo!"))
+	assert.Equal(t, ErrTooLarge, err)
+
+	sess, err = s.WriteChunk(sess.ID, 1, 2, strings.NewReader("llo"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), sess.Offset)
+
+	got, err := s.Get(sess.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, sess, got)
+
+	data, _, err := s.Finalize(sess.ID, 1, "")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	_, _, err = s.Finalize(sess.ID, 1, "")
+	assert.Equal(t, ErrFinalizing, err, "upload is finalized once at a time")
+
+	s.Release(sess.ID)
+	data, _, err = s.Finalize(sess.ID, 1, "")
+	require.NoError(t, err, "released upload is finalized again")
+	assert.Equal(t, "hello", string(data))
+
+	s.Complete(sess.ID)
+	_, err = s.Get(sess.ID, 1)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestService_FinalizeChecksum(t *testing.T) {
+	s := NewService(10, time.Minute)
+	sess, err := s.Create(1, 1, 5, md5Hello)
+	require.NoError(t, err)
+	_, err = s.WriteChunk(sess.ID, 1, 0, strings.NewReader("world"))
+	require.NoError(t, err)
+	_, _, err = s.Finalize(sess.ID, 1, "")
+	assert.True(t, errors.Is(err, ErrChecksum))
+	_, err = s.Get(sess.ID, 1)
+	assert.NoError(t, err, "session is kept")
+
+	_, _, err = s.Finalize(sess.ID, 1, "invalid")
+	assert.Contains(t, err.Error(), "invalid md5")
+	data, sess, err := s.Finalize(sess.ID, 1, "7d793037a0760186574b0282f2f435e7") //md5 of "world"
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+	assert.Equal(t, "7d793037a0760186574b0282f2f435e7", sess.MD5)
+}
+
+func TestService_CreateErrors(t *testing.T) {
+	s := NewService(10, time.Minute)
+	tbl := []struct {
+		size int64
+		md5  string
+	}{
+		{0, md5Hello},
+		{11, md5Hello},
+		{5, "abc"},
+		{5, "zz41402abc4b2a76b9719d911017c592"},
+	}
+	for i, tt := range tbl {
+		_, err := s.Create(1, 1, tt.size, tt.md5)
+		assert.Error(t, err, "test case #%d", i)
+	}
+}
+
+func TestService_TenantLimits(t *testing.T) {
+	s := NewService(10, time.Minute)
+	s.MaxSessions, s.MaxTenantSize = 2, 15
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	first, err := s.Create(1, 1, 10, md5Hello)
+	require.NoError(t, err)
+	_, err = s.Create(1, 1, 6, md5Hello)
+	assert.True(t, errors.Is(err, ErrTenantLimit), "size of sessions is limited, %v", err)
+	_, err = s.Create(1, 1, 5, md5Hello)
+	require.NoError(t, err)
+	_, err = s.Create(1, 1, 1, md5Hello)
+	assert.True(t, errors.Is(err, ErrTenantLimit), "number of sessions is limited, %v", err)
+
+	_, err = s.Create(2, 1, 10, md5Hello)
+	assert.NoError(t, err, "limits are per tenant")
+
+	require.NoError(t, s.Delete(first.ID, 1))
+	_, err = s.Create(1, 1, 10, md5Hello)
+	assert.NoError(t, err, "deleted session frees its slot")
+
+	now = now.Add(2 * time.Minute)
+	_, err = s.Create(1, 1, 10, md5Hello)
+	assert.NoError(t, err, "expired sessions are not counted")
+}
+
+func TestService_TenantAndExpiry(t *testing.T) {
+	s := NewService(10, time.Minute)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	sess, err := s.Create(1, 1, 5, md5Hello)
+	require.NoError(t, err)
+
+	_, err = s.Get(sess.ID, 2)
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, s.Delete(sess.ID, 2))
+
+	now = now.Add(2 * time.Minute)
+	_, err = s.WriteChunk(sess.ID, 1, 0, strings.NewReader("he"))
+	assert.Equal(t, ErrNotFound, err)
+
+	sess, err = s.Create(1, 1, 5, md5Hello)
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(sess.ID, 1))
+	_, err = s.Get(sess.ID, 1)
+	assert.Equal(t, ErrNotFound, err)
+}
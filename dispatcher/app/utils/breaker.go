@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//ErrBreakerOpen returned for request rejected by open circuit breaker
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+//states of circuit breaker
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+//Breaker is circuit breaker of upstream service. It is opened after Threshold failed requests in a row and rejects
+//requests for Cooldown, then one trial request is allowed and closes or opens breaker again. Nil breaker allows
+//all requests
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	lock      sync.Mutex
+	failures  int
+	state     string
+	openedAt  time.Time
+	trial     bool //trial request of half-open breaker is in flight
+	now       func() time.Time
+}
+
+//NewBreaker makes closed breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+//Allow returns ErrBreakerOpen if request must not be made, allowed request must be followed by Done
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.currentState() {
+	case BreakerOpen:
+		return ErrBreakerOpen
+	case BreakerHalfOpen:
+		if b.trial {
+			return ErrBreakerOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+//Done records result of allowed request, breaker is opened by Threshold failures in a row or failed trial request
+func (b *Breaker) Done(success bool) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	halfOpen := b.currentState() == BreakerHalfOpen
+	b.trial = false
+	if success {
+		b.failures = 0
+		b.state = BreakerClosed
+		return
+	}
+	b.failures++
+	if halfOpen || b.Threshold > 0 && b.failures >= b.Threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.time()
+	}
+}
+
+//State returns closed, open or half-open state, open breaker is half-open after Cooldown
+func (b *Breaker) State() string {
+	if b == nil {
+		return BreakerClosed
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.currentState()
+}
+
+func (b *Breaker) currentState() string {
+	if b.state == BreakerOpen && b.time().Sub(b.openedAt) >= b.Cooldown {
+		return BreakerHalfOpen
+	}
+	if b.state == "" {
+		return BreakerClosed
+	}
+	return b.state
+}
+
+func (b *Breaker) time() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+//Transport returns http transport which rejects requests by breaker, failed request and 5xx response are failures.
+//Default transport is used if next is nil
+func (b *Breaker) Transport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		if err := b.Allow(); err != nil {
+			return nil, err
+		}
+		resp, err := next.RoundTrip(req)
+		b.Done(err == nil && resp.StatusCode < http.StatusInternalServerError)
+		return resp, err
+	})
+}
+
+type roundTripper func(req *http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
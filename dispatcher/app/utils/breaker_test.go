@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	b := NewBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	require.NoError(t, b.Allow())
+	b.Done(false)
+	assert.Equal(t, BreakerClosed, b.State(), "breaker is closed till threshold")
+	require.NoError(t, b.Allow())
+	b.Done(true)
+	require.NoError(t, b.Allow())
+	b.Done(false)
+	assert.Equal(t, BreakerClosed, b.State(), "success resets failures")
+	require.NoError(t, b.Allow())
+	b.Done(false)
+	assert.Equal(t, BreakerOpen, b.State())
+	assert.Equal(t, ErrBreakerOpen, b.Allow())
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, b.State(), "breaker is half-open after cooldown")
+	require.NoError(t, b.Allow())
+	assert.Equal(t, ErrBreakerOpen, b.Allow(), "only one trial request is allowed")
+	b.Done(false)
+	assert.Equal(t, BreakerOpen, b.State(), "failed trial opens breaker again")
+
+	now = now.Add(time.Minute)
+	require.NoError(t, b.Allow())
+	b.Done(true)
+	assert.Equal(t, BreakerClosed, b.State(), "successful trial closes breaker")
+	require.NoError(t, b.Allow())
+
+	var disabled *Breaker
+	assert.NoError(t, disabled.Allow())
+	disabled.Done(false)
+	assert.Equal(t, BreakerClosed, disabled.State())
+}
+
+func TestBreaker_Transport(t *testing.T) {
+	status := http.StatusBadGateway
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	b := NewBreaker(2, time.Hour)
+	client := &http.Client{Transport: b.Transport(nil)}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	}
+	assert.Equal(t, BreakerOpen, b.State(), "5xx responses are failures")
+	status = http.StatusNotFound
+	_, err := client.Get(ts.URL)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ErrBreakerOpen.Error())
+
+	b = NewBreaker(2, time.Hour)
+	client = &http.Client{Transport: b.Transport(nil)}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ts.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	}
+	assert.Equal(t, BreakerClosed, b.State(), "4xx responses are not failures")
+}
+
+func TestRepeater_Breaker(t *testing.T) {
+	b := NewBreaker(1, time.Hour)
+	b.Done(false)
+	r := &Repeater{ClientTimeout: 1, Attempts: 1, URI: "http://localhost:1/job", Breaker: b}
+	_, err := r.MakeRequest(GET, nil)
+	assert.Equal(t, ErrBreakerOpen, err, "request is not made by open breaker")
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/tracing"
 	"io"
 	"io/ioutil"
 	"log"
@@ -11,12 +13,33 @@ import (
 )
 
 type Repeater struct {
-	ClientTimeout  time.Duration
-	Attempts	   time.Duration
-	URI string
-	Headers		   http.Header
-	Body		   string
-	Count          int
+	ClientTimeout time.Duration
+	Attempts      time.Duration
+	URI           string
+	Headers       http.Header
+	Body          string
+	Count         int
+	Observer      RequestObserver
+	Tracer        *tracing.Tracer
+	Trace         tracing.SpanContext //parent of spans of request attempts
+	Breaker       *Breaker            //rejects requests to upstream which fails, breaker is shared by repeaters of upstream
+	Transport     http.RoundTripper   //transport of requests, default transport is used if nil
+}
+
+//RequestObserver gets duration of each request made by Repeater and count of repeated requests
+type RequestObserver interface {
+	ObserveRequest(method string, status int, duration time.Duration)
+	ObserveRetry(method string)
+}
+
+//StatusError returned by Repeater for response with non 2xx status, 5xx response is returned after all attempts
+type StatusError struct {
+	Code int
+	Body []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("upstream responded with status %d", e.Code)
 }
 
 type RepeaterInterface interface {
@@ -29,6 +52,7 @@ const (
 	GET = iota
 	POST
 )
+
 func (m Method) ToString() string {
 	switch m {
 	case GET:
@@ -40,75 +64,173 @@ func (m Method) ToString() string {
 	}
 }
 
-//MakeRequest make request with supporting retry pattern
+//MakeRequest make request with supporting retry pattern, body is read once and sent by each attempt. Failed request
+//and 5xx response are repeated, response with non 2xx status is returned as StatusError
 func (r *Repeater) MakeRequest(httpMethod Method, data io.Reader) ([]byte, error) {
 	var res []byte
 	client := http.Client{
-		Timeout: r.ClientTimeout * time.Second,
+		Timeout:   r.ClientTimeout * time.Second,
+		Transport: r.Transport,
 	}
-	request, err := http.NewRequest(httpMethod.ToString(), r.URI, data)
-	request.Header.Set("Content-Type", "application/json")
+	var body []byte
+	if data != nil {
+		var err error
+		if body, err = ioutil.ReadAll(data); err != nil {
+			log.Printf("[ERROR] cannot read %s request body: %#v; URL: %s", httpMethod.ToString(), err, r.URI)
+			return nil, err
+		}
+	}
+	request, err := http.NewRequest(httpMethod.ToString(), r.URI, bodyReader(body))
 	if err != nil {
 		log.Printf("[ERROR] cannot create %s request: %#v; URL: %s", httpMethod.ToString(), err, r.URI)
 		return nil, err
 	}
+	for k, v := range r.Headers {
+		request.Header[k] = v
+	}
+	request.Header.Set("Content-Type", "application/json")
 
+	if err = r.Breaker.Allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	span := r.startSpan(httpMethod, request.Header, 1)
 	response, err := client.Do(request)
-	if err != nil {
-		log.Printf("[ERROR] can not make %s request: %#v", httpMethod.ToString(), err)
-		if errClose := response.Body.Close(); errClose != nil {
-			log.Printf("[ERROR] can not close response body %#v", errClose)
-			return nil, err
-		}
+	r.observe(httpMethod, response, start)
+	r.done(response, err)
+	endSpan(span, response, err)
+	if retryable(response, err) {
+		logAttempt(httpMethod, response, err)
 		sumTimeout := r.Attempts * time.Second
 		ticker := time.NewTicker(sumTimeout)
-		cancel := make(chan struct{})
-		go func() {
-			defer func() {
-				cancel <- struct{}{}
-			}()
-			time.Sleep(10 * sumTimeout)
-		}()
+		defer ticker.Stop()
+		cancel := time.NewTimer(10 * sumTimeout)
+		defer cancel.Stop()
+		attempt := 1
+	attempts:
 		for {
 			select {
 			case <-ticker.C:
+				if err = r.Breaker.Allow(); err != nil {
+					log.Printf("[WARN] %s request is not repeated, %v", httpMethod.ToString(), err)
+					break attempts
+				}
+				if r.Observer != nil {
+					r.Observer.ObserveRetry(httpMethod.ToString())
+				}
+				start = time.Now()
+				attempt++
+				span = r.startSpan(httpMethod, request.Header, attempt)
+				closeBody(response)
+				response = nil
 				switch httpMethod {
-				case GET:
-					response, err = client.Get(r.URI)
-				case POST:
-					response, err = client.Post(r.URI, "application/json", data)
+				case GET, POST:
+					//the same headers are sent on retry
+					var retry *http.Request
+					if retry, err = http.NewRequest(httpMethod.ToString(), r.URI, bodyReader(body)); err == nil {
+						retry.Header = request.Header
+						response, err = client.Do(retry)
+					}
 				default:
 					err = errors.New("can not detect http method")
 				}
-				if err != nil {
-					log.Printf("[ERROR] can not make %s request: %#v ", httpMethod.ToString(), err)
-					if errClose := response.Body.Close(); errClose != nil {
-						log.Printf("[ERROR] can not close response body %#v", errClose)
-					}
+				r.observe(httpMethod, response, start)
+				r.done(response, err)
+				endSpan(span, response, err)
+				if retryable(response, err) {
+					logAttempt(httpMethod, response, err)
 					continue
 				}
-				break
-			case <-cancel:
-				ticker.Stop()
+				break attempts
+			case <-cancel.C:
 				log.Printf("[WARN] completed repeater call. API is not reachible")
-				break
+				break attempts
 			}
 		}
-		return nil, err
+		if err != nil {
+			closeBody(response)
+			return nil, err
+		}
 	}
 
 	res, err = ioutil.ReadAll(response.Body)
-	if err != nil || response.StatusCode > 200 && response.StatusCode < 300 {
+	closeBody(response)
+	if err != nil {
 		log.Printf("[ERROR] can not read response body %#v", err)
-		if errClose := response.Body.Close(); errClose != nil {
-			log.Printf("[ERROR] can not close response body %#v", errClose)
-		}
 		return nil, err
 	}
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return nil, &StatusError{Code: response.StatusCode, Body: res}
+	}
+	return res, nil
+}
+
+//retryable returns true for failed request and 5xx response
+func retryable(response *http.Response, err error) bool {
+	return err != nil || response.StatusCode >= http.StatusInternalServerError
+}
+
+func logAttempt(httpMethod Method, response *http.Response, err error) {
+	if err != nil {
+		log.Printf("[ERROR] can not make %s request: %#v", httpMethod.ToString(), err)
+		return
+	}
+	log.Printf("[WARN] %s request failed with status %d", httpMethod.ToString(), response.StatusCode)
+}
 
+//closeBody closes body of response of attempt, response is nil for failed request
+func closeBody(response *http.Response) {
+	if response == nil {
+		return
+	}
 	if errClose := response.Body.Close(); errClose != nil {
 		log.Printf("[ERROR] can not close response body %#v", errClose)
 	}
+}
 
-	return res, nil
-}
\ No newline at end of file
+//bodyReader returns new reader of request body for each attempt, nil body makes request without body
+func bodyReader(body []byte) io.Reader {
+	if body == nil {
+		return nil
+	}
+	return bytes.NewReader(body)
+}
+
+//observe passes request to Observer, response is nil for failed request
+func (r *Repeater) observe(httpMethod Method, response *http.Response, start time.Time) {
+	if r.Observer == nil {
+		return
+	}
+	status := 0
+	if response != nil {
+		status = response.StatusCode
+	}
+	r.Observer.ObserveRequest(httpMethod.ToString(), status, time.Since(start))
+}
+
+//done passes result of request to Breaker, failed request and 5xx response are failures
+func (r *Repeater) done(response *http.Response, err error) {
+	r.Breaker.Done(err == nil && response.StatusCode < http.StatusInternalServerError)
+}
+
+//startSpan starts client span of request attempt and passes it in traceparent header
+func (r *Repeater) startSpan(httpMethod Method, header http.Header, attempt int) *tracing.Span {
+	span := r.Tracer.StartSpan(r.Trace, httpMethod.ToString(), tracing.KindClient)
+	if span == nil {
+		return nil
+	}
+	tracing.Inject(span.Context(), header)
+	span.SetAttr("http.method", httpMethod.ToString())
+	span.SetAttr("http.url", r.URI)
+	span.SetAttr("attempt", attempt)
+	return span
+}
+
+//endSpan finishes span of request attempt, response is nil for failed request
+func endSpan(span *tracing.Span, response *http.Response, err error) {
+	if response != nil {
+		span.SetAttr("http.status_code", response.StatusCode)
+	}
+	span.Fail(err)
+	span.End()
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRepeater_MakeRequestStatus(t *testing.T) {
+	var lock sync.Mutex
+	statuses := []int{http.StatusServiceUnavailable, http.StatusCreated, http.StatusNotFound}
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		status := statuses[calls]
+		calls++
+		lock.Unlock()
+		w.WriteHeader(status)
+		_, _ = fmt.Fprintf(w, `{"status":%d}`, status)
+	}))
+	defer ts.Close()
+
+	r := Repeater{ClientTimeout: 5, Attempts: 1, URI: ts.URL}
+	res, err := r.MakeRequest(GET, nil)
+	require.NoError(t, err, "5xx response is repeated")
+	assert.Equal(t, `{"status":201}`, string(res))
+	assert.Equal(t, 2, calls)
+
+	_, err = r.MakeRequest(GET, nil)
+	statusErr := &StatusError{}
+	require.True(t, errors.As(err, &statusErr), "%v", err)
+	assert.Equal(t, http.StatusNotFound, statusErr.Code)
+	assert.Equal(t, `{"status":404}`, string(statusErr.Body))
+	assert.Equal(t, 3, calls, "4xx response is not repeated")
+}
+
+func TestRepeater_MakeRequestRetryBody(t *testing.T) {
+	var lock sync.Mutex
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		lock.Lock()
+		bodies = append(bodies, string(body))
+		lock.Unlock()
+		_, _ = w.Write([]byte(`{"id":"1"}`))
+	}))
+	defer ts.Close()
+
+	attempts := 0
+	r := Repeater{ClientTimeout: 5, Attempts: 1, URI: ts.URL,
+		Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts == 1 {
+				_, _ = ioutil.ReadAll(req.Body) //the first attempt reads body before it fails
+				return nil, errors.New("connection reset")
+			}
+			return http.DefaultTransport.RoundTrip(req)
+		})}
+	res, err := r.MakeRequest(POST, strings.NewReader(`{"payload":"MQo="}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"1"}`, string(res))
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []string{`{"payload":"MQo="}`}, bodies, "retry sends the same body")
+}
@@ -0,0 +1,229 @@
+package watcher
+
+import (
+	"context"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"log"
+	"sync"
+	"time"
+)
+
+//JobGetter provides current state of job
+type JobGetter interface {
+	GetJob(id string) (*model.Job, error)
+}
+
+//Watcher keeps single status poller per job and fans out status changes to all subscribers of the job.
+//Poller is started by the first subscriber and stopped when job is finished or nobody listens
+type Watcher struct {
+	Jobs         JobGetter
+	PollInterval time.Duration
+
+	lock    sync.Mutex
+	watches map[string]*watch
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type watch struct {
+	subscribers map[int]chan model.Job
+	finishers   []finisher
+	nextID      int
+	last        *model.Job
+}
+
+//finisher is function called with terminal state of job, it is dropped if job isn't finished till deadline
+type finisher struct {
+	fn       func(model.Job)
+	deadline time.Time
+}
+
+//New makes watcher, Close must be called to stop pollers
+func New(jobs JobGetter, pollInterval time.Duration) *Watcher {
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Watcher{Jobs: jobs, PollInterval: pollInterval, watches: map[string]*watch{}, ctx: ctx, cancel: cancel}
+}
+
+//Subscribe returns channel of job states. The first value is the last known state (if any),
+//next ones are sent on status change. Channel is closed after terminal state or on Close.
+//Returned function unsubscribes and must be called when caller stops reading
+func (w *Watcher) Subscribe(jobID string) (<-chan model.Job, func()) {
+	ch := make(chan model.Job, 1)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.ctx.Err() != nil {
+		close(ch)
+		return ch, func() {}
+	}
+
+	wt := w.watchOf(jobID)
+	id := wt.nextID
+	wt.nextID++
+	wt.subscribers[id] = ch
+	if wt.last != nil {
+		ch <- *wt.last
+	}
+
+	return ch, func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		if c, ok := wt.subscribers[id]; ok {
+			delete(wt.subscribers, id)
+			close(c)
+		}
+	}
+}
+
+//OnFinish calls fn once with terminal state of job. Job is polled till then like for subscriber, but caller doesn't
+//keep goroutine waiting for it. fn is not called if job isn't finished till deadline or watcher is closed. fn is called
+//under lock of watcher, so it must not block and must not call watcher
+func (w *Watcher) OnFinish(jobID string, deadline time.Time, fn func(model.Job)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.ctx.Err() != nil {
+		return
+	}
+	wt := w.watchOf(jobID)
+	wt.finishers = append(wt.finishers, finisher{fn: fn, deadline: deadline})
+}
+
+//watchOf returns watch of job and starts its poller if job is not watched yet, must be called under lock
+func (w *Watcher) watchOf(jobID string) *watch {
+	wt, ok := w.watches[jobID]
+	if !ok {
+		wt = &watch{subscribers: map[int]chan model.Job{}}
+		w.watches[jobID] = wt
+		w.wg.Add(1)
+		go w.poll(jobID, wt)
+	}
+	return wt
+}
+
+//Watching returns number of jobs with active pollers
+func (w *Watcher) Watching() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.watches)
+}
+
+//Close stops all pollers and closes subscribers channels
+func (w *Watcher) Close() {
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (w *Watcher) poll(jobID string, wt *watch) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+	for {
+		if done := w.check(jobID, wt); done {
+			return
+		}
+		select {
+		case <-w.ctx.Done():
+			w.stop(jobID, wt)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//check gets job state and publishes it if status changed. Returns true when poller has to stop
+func (w *Watcher) check(jobID string, wt *watch) bool {
+	job, err := w.Jobs.GetJob(jobID)
+	if err != nil {
+		log.Printf("[WARN] can't get job %s for watchers, %v", jobID, err)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.dropExpired(jobID, wt)
+	if len(wt.subscribers) == 0 && len(wt.finishers) == 0 {
+		w.remove(jobID, wt)
+		return true
+	}
+	if job == nil {
+		return false
+	}
+	return w.publish(jobID, wt, *job)
+}
+
+//Notify publishes new state of job to its subscribers without waiting for the next poll.
+//It is called on job updates in dispatcher store
+func (w *Watcher) Notify(job model.Job) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if wt, ok := w.watches[job.ID]; ok {
+		w.publish(job.ID, wt, job)
+	}
+}
+
+//publish sends job to subscribers if its status or progress changed, must be called under lock.
+//Returns true when job is finished
+func (w *Watcher) publish(jobID string, wt *watch, job model.Job) bool {
+	if wt.last == nil || wt.last.Status != job.Status || wt.last.Progress != job.Progress || wt.last.Stage != job.Stage {
+		wt.last = &job
+		for _, ch := range wt.subscribers {
+			select {
+			case <-ch: //drop state which was not read yet, subscriber needs only the latest one
+			default:
+			}
+			ch <- job
+		}
+	}
+	if IsTerminal(job.Status) {
+		for _, f := range wt.finishers {
+			f.fn(job)
+		}
+		w.unsubscribeAll(jobID, wt)
+		return true
+	}
+	return false
+}
+
+//dropExpired drops finishers which deadline is passed, must be called under lock
+func (w *Watcher) dropExpired(jobID string, wt *watch) {
+	active := wt.finishers[:0]
+	for _, f := range wt.finishers {
+		if time.Now().After(f.deadline) {
+			log.Printf("[WARN] job %s is not finished till %s, stop waiting for it", jobID, f.deadline.Format(time.RFC3339))
+			continue
+		}
+		active = append(active, f)
+	}
+	wt.finishers = active
+}
+
+func (w *Watcher) stop(jobID string, wt *watch) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.unsubscribeAll(jobID, wt)
+}
+
+//unsubscribeAll closes channels of all subscribers and drops finishers, must be called under lock
+func (w *Watcher) unsubscribeAll(jobID string, wt *watch) {
+	for id, ch := range wt.subscribers {
+		delete(wt.subscribers, id)
+		close(ch)
+	}
+	wt.finishers = nil
+	w.remove(jobID, wt)
+}
+
+//remove drops watch of job unless it was replaced by a new one, must be called under lock
+func (w *Watcher) remove(jobID string, wt *watch) {
+	if w.watches[jobID] == wt {
+		delete(w.watches, jobID)
+	}
+}
+
+//IsTerminal returns true for status after which job is not changed anymore
+func IsTerminal(status string) bool {
+	return model.IsFinished(status)
+}
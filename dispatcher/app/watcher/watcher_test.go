@@ -0,0 +1,180 @@
+package watcher
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type jobGetterFunc func(id string) (*model.Job, error)
+
+func (f jobGetterFunc) GetJob(id string) (*model.Job, error) { return f(id) }
+
+func TestWatcher_SinglePollerPerJob(t *testing.T) {
+	var calls int32
+	var status atomic.Value
+	status.Store("RUNNING")
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		atomic.AddInt32(&calls, 1)
+		return &model.Job{ID: id, Status: status.Load().(string)}, nil
+	})
+	w := New(jobs, 20*time.Millisecond)
+	defer w.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		ch, unsubscribe := w.Subscribe("1")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer unsubscribe()
+			var states []string
+			for job := range ch {
+				states = append(states, job.Status)
+			}
+			assert.Equal(t, "SUCCESS", states[len(states)-1])
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, w.Watching())
+	polls := atomic.LoadInt32(&calls)
+	assert.True(t, polls >= 3 && polls <= 7, "one poller for all subscribers, polls=%d", polls)
+
+	status.Store("SUCCESS")
+	wg.Wait()
+	assert.Equal(t, 0, w.Watching())
+}
+
+func TestWatcher_Unsubscribe(t *testing.T) {
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		return &model.Job{ID: id, Status: "RUNNING"}, nil
+	})
+	w := New(jobs, 10*time.Millisecond)
+	defer w.Close()
+
+	ch, unsubscribe := w.Subscribe("2")
+	job := <-ch
+	assert.Equal(t, "RUNNING", job.Status)
+
+	ch2, unsubscribe2 := w.Subscribe("2")
+	job = <-ch2
+	assert.Equal(t, "RUNNING", job.Status, "new subscriber gets the last known state")
+
+	unsubscribe()
+	unsubscribe2()
+	unsubscribe2()
+	_, ok := <-ch
+	assert.False(t, ok)
+	require.Eventually(t, func() bool { return w.Watching() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestWatcher_ErrorsAndClose(t *testing.T) {
+	var fail atomic.Value
+	fail.Store(true)
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		if fail.Load().(bool) {
+			return nil, errors.New("worker is unreachable")
+		}
+		return &model.Job{ID: id, Status: "RUNNING"}, nil
+	})
+	w := New(jobs, 10*time.Millisecond)
+	ch, unsubscribe := w.Subscribe("3")
+	defer unsubscribe()
+
+	select {
+	case <-ch:
+		t.Fatal("no state is expected while worker is unreachable")
+	case <-time.After(50 * time.Millisecond):
+	}
+	fail.Store(false)
+	job := <-ch
+	assert.Equal(t, "RUNNING", job.Status)
+
+	w.Close()
+	_, ok := <-ch
+	assert.False(t, ok)
+
+	ch, _ = w.Subscribe("4")
+	_, ok = <-ch
+	assert.False(t, ok, "closed watcher returns closed channel")
+}
+
+func TestWatcher_Notify(t *testing.T) {
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		return &model.Job{ID: id, Status: "RUNNING"}, nil
+	})
+	w := New(jobs, time.Hour)
+	defer w.Close()
+
+	ch, unsubscribe := w.Subscribe("5")
+	defer unsubscribe()
+	job := <-ch
+	assert.Equal(t, "RUNNING", job.Status)
+
+	w.Notify(model.Job{ID: "6", Status: "SUCCESS"})
+	w.Notify(model.Job{ID: "5", Status: "RUNNING", Progress: 40, Stage: "resize"})
+	job = <-ch
+	assert.Equal(t, 40, job.Progress, "progress change is published")
+	assert.Equal(t, "resize", job.Stage)
+	w.Notify(model.Job{ID: "5", Status: "RUNNING", Progress: 40, Stage: "resize"})
+	w.Notify(model.Job{ID: "5", Status: "TIMED_OUT"})
+	job = <-ch
+	assert.Equal(t, "TIMED_OUT", job.Status, "state is published without waiting for poll")
+	_, ok := <-ch
+	assert.False(t, ok)
+	require.Eventually(t, func() bool { return w.Watching() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestWatcher_OnFinish(t *testing.T) {
+	var status atomic.Value
+	status.Store("RUNNING")
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		return &model.Job{ID: id, Status: status.Load().(string)}, nil
+	})
+	w := New(jobs, 10*time.Millisecond)
+
+	finished := make(chan model.Job, 2)
+	w.OnFinish("1", time.Now().Add(time.Minute), func(job model.Job) { finished <- job })
+	w.OnFinish("2", time.Now().Add(30*time.Millisecond), func(job model.Job) { finished <- job })
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, w.Watching(), "job not finished till deadline is not watched")
+
+	status.Store("SUCCESS")
+	select {
+	case job := <-finished:
+		assert.Equal(t, "1", job.ID)
+		assert.Equal(t, "SUCCESS", job.Status)
+	case <-time.After(time.Second):
+		t.Fatal("finisher is not called")
+	}
+	require.Eventually(t, func() bool { return w.Watching() == 0 }, time.Second, 5*time.Millisecond)
+
+	status.Store("RUNNING")
+	w.OnFinish("3", time.Now().Add(time.Minute), func(job model.Job) { finished <- job })
+	w.Close()
+	assert.Equal(t, 0, w.Watching())
+	w.OnFinish("4", time.Now().Add(time.Minute), func(job model.Job) { finished <- job })
+	assert.Equal(t, 0, w.Watching(), "closed watcher doesn't watch jobs")
+	assert.Empty(t, finished, "finishers are not called for jobs which aren't finished")
+}
+
+func TestIsTerminal(t *testing.T) {
+	tbl := []struct {
+		status string
+		res    bool
+	}{
+		{"RUNNING", false},
+		{"SUCCESS", true},
+		{"FAILED", true},
+		{"TIMED_OUT", true},
+		{"", false},
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, IsTerminal(tt.status), "test case #%d", i)
+	}
+}
@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+//ErrInternalAddress returned for callback url which resolves to loopback, private, link-local or unspecified address
+var ErrInternalAddress = errors.New("callback url points to internal address")
+
+//privateNets are private ranges of IPv4 (RFC 1918) and IPv6 (RFC 4193)
+var privateNets = []*net.IPNet{
+	mustParseCIDR("10.0.0.0/8"),
+	mustParseCIDR("172.16.0.0/12"),
+	mustParseCIDR("192.168.0.0/16"),
+	mustParseCIDR("fc00::/7"),
+}
+
+//CheckURL resolves host of callback url and rejects it if any address of host is internal, so clients can't make
+//dispatcher post signed events to its own network. Any address is allowed if private networks are allowed by opts
+func (s *Service) CheckURL(ctx context.Context, callbackURL string) error {
+	if s.AllowPrivate {
+		return nil
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return err
+	}
+	addrs, err := s.lookup(ctx, u.Hostname())
+	if err != nil {
+		return errors.Wrapf(err, "can't resolve host of callback url %q", callbackURL)
+	}
+	for _, addr := range addrs {
+		if internalIP(addr.IP) {
+			return errors.Wrapf(ErrInternalAddress, "host %s resolves to %s", u.Hostname(), addr.IP)
+		}
+	}
+	return nil
+}
+
+func (s *Service) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if s.resolve != nil {
+		return s.resolve(ctx, host)
+	}
+	return net.DefaultResolver.LookupIPAddr(ctx, host)
+}
+
+//guardedTransport checks address of each connection after host is resolved, so host which is changed to internal
+//address after CheckURL is rejected too. Proxy is not used, as address of proxy would be checked instead of host
+func guardedTransport(allowPrivate bool) http.RoundTripper {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || internalIP(ip) {
+				return errors.Wrapf(ErrInternalAddress, "can't connect to %s", host)
+			}
+			return nil
+		}
+	}
+	return &http.Transport{DialContext: dialer.DialContext, MaxIdleConns: 100, IdleConnTimeout: 90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second, ExpectContinueTimeout: time.Second}
+}
+
+func internalIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -0,0 +1,296 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderSignature = "X-Dispatcher-Signature"
+	HeaderTimestamp = "X-Dispatcher-Timestamp"
+	HeaderDelivery  = "X-Dispatcher-Delivery"
+	HeaderEvent     = "X-Dispatcher-Event"
+
+	EventJobCompleted = "job.completed"
+)
+
+var (
+	ErrNoCallback      = errors.New("job has no callback")
+	ErrNothingToReplay = errors.New("no failed delivery to replay")
+	ErrInProgress      = errors.New("callback delivery is in progress")
+)
+
+//Opts defines signing, retries and polling of callbacks
+type Opts struct {
+	Secret       string
+	TenantURLs   map[int]string //default callback url per tenant
+	MaxAttempts  int
+	Backoff      time.Duration //delay before second attempt, doubled for each next one
+	WatchTimeout time.Duration //job is not watched longer than that
+	AllowPrivate bool          //allows callbacks to loopback, private and link-local addresses
+	Client       *http.Client  //client of callbacks, default one doesn't connect to internal addresses
+}
+
+//Delivery is one attempt to call callback url
+type Delivery struct {
+	ID         string    `json:"id"`
+	Attempt    int       `json:"attempt"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Success    bool      `json:"success"`
+	Time       time.Time `json:"time"`
+}
+
+//Event is body of callback request
+type Event struct {
+	Event     string    `json:"event"`
+	Job       model.Job `json:"job"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+//Service watches jobs with callback and calls callback url with signed event when job reaches terminal state
+type Service struct {
+	Opts
+	Watcher *watcher.Watcher
+
+	optsLock   sync.RWMutex //guards Secret and TenantURLs replaced by Configure
+	lock       sync.Mutex
+	callbacks  map[string]string     //callback url by job id
+	events     map[string][]byte     //last sent event by job id
+	deliveries map[string][]Delivery //delivery log by job id
+	inFlight   map[string]bool       //jobs which callback is being delivered
+	closed     bool                  //no deliveries are started after Close
+	resolve    func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+//NewService makes webhook service, Close must be called to stop background deliveries
+func NewService(w *watcher.Watcher, opts Opts) *Service {
+	setDefault := func(opt *int, defValue int) {
+		if *opt <= 0 {
+			*opt = defValue
+		}
+	}
+	setDefaultDuration := func(opt *time.Duration, defValue time.Duration) {
+		if *opt <= 0 {
+			*opt = defValue
+		}
+	}
+	setDefault(&opts.MaxAttempts, 5)
+	setDefaultDuration(&opts.Backoff, time.Second)
+	setDefaultDuration(&opts.WatchTimeout, 24*time.Hour)
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second, Transport: guardedTransport(opts.AllowPrivate)}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Service{Opts: opts, Watcher: w, callbacks: map[string]string{}, events: map[string][]byte{},
+		deliveries: map[string][]Delivery{}, inFlight: map[string]bool{}, ctx: ctx, cancel: cancel}
+}
+
+//URLFor returns callback url passed in request or default url of tenant
+func (s *Service) URLFor(tenantID int, callbackURL string) string {
+	if callbackURL != "" {
+		return callbackURL
+	}
+	s.optsLock.RLock()
+	defer s.optsLock.RUnlock()
+	return s.TenantURLs[tenantID]
+}
+
+//Configure replaces signing secret and default callback urls of tenants, jobs which are already watched keep
+//their callback urls
+func (s *Service) Configure(secret string, tenantURLs map[int]string) {
+	s.optsLock.Lock()
+	defer s.optsLock.Unlock()
+	s.Secret, s.TenantURLs = secret, tenantURLs
+}
+
+//Watch calls callback url when watcher finds job in terminal state, job isn't waited for longer than WatchTimeout
+func (s *Service) Watch(jobID, callbackURL string) {
+	if callbackURL == "" {
+		return
+	}
+	s.lock.Lock()
+	s.callbacks[jobID] = callbackURL
+	s.lock.Unlock()
+	s.Watcher.OnFinish(jobID, time.Now().Add(s.WatchTimeout), s.send)
+}
+
+//Deliveries returns delivery log of job
+func (s *Service) Deliveries(jobID string) ([]Delivery, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.callbacks[jobID]; !ok {
+		return nil, ErrNoCallback
+	}
+	return append([]Delivery{}, s.deliveries[jobID]...), nil
+}
+
+//Forget drops callback url, last event and delivery log of job, it's called when job is removed
+func (s *Service) Forget(jobID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.callbacks, jobID)
+	delete(s.events, jobID)
+	delete(s.deliveries, jobID)
+}
+
+//Replay sends again the last event of job if its delivery failed, only one delivery of job runs at once
+func (s *Service) Replay(jobID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	url, ok := s.callbacks[jobID]
+	if !ok {
+		return ErrNoCallback
+	}
+	if s.inFlight[jobID] {
+		return ErrInProgress
+	}
+	body, history := s.events[jobID], s.deliveries[jobID]
+	if body == nil || len(history) == 0 || history[len(history)-1].Success {
+		return ErrNothingToReplay
+	}
+	s.startDelivery(jobID, url, body)
+	return nil
+}
+
+//Close stops delivering, deliveries of jobs finished after Close are not started
+func (s *Service) Close() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	s.cancel()
+	s.wg.Wait()
+}
+
+//send starts delivery of event of finished job, it is called by watcher and must not block
+func (s *Service) send(job model.Job) {
+	job.Payload = ""
+	body, err := json.Marshal(Event{Event: EventJobCompleted, Job: job, Timestamp: time.Now().UTC()})
+	if err != nil {
+		log.Printf("[ERROR] can't marshal callback event of job %s, %v", job.ID, err)
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	url, ok := s.callbacks[job.ID]
+	if !ok || s.inFlight[job.ID] {
+		return
+	}
+	s.events[job.ID] = body
+	s.startDelivery(job.ID, url, body)
+}
+
+//startDelivery marks job in flight and delivers event in background, must be called under lock
+func (s *Service) startDelivery(jobID, url string, body []byte) {
+	if s.closed {
+		return
+	}
+	s.inFlight[jobID] = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.deliver(jobID, url, body)
+		s.lock.Lock()
+		delete(s.inFlight, jobID)
+		s.lock.Unlock()
+	}()
+}
+
+//deliver calls url with retries and exponential backoff, each attempt is written to delivery log
+func (s *Service) deliver(jobID, url string, body []byte) {
+	deliveryID := newID()
+	backoff := s.Backoff
+	for attempt := 1; attempt <= s.MaxAttempts; attempt++ {
+		d := Delivery{ID: deliveryID, Attempt: attempt, URL: url, Time: time.Now().UTC()}
+		var err error
+		d.StatusCode, err = s.post(url, deliveryID, body)
+		if err != nil {
+			d.Error = err.Error()
+		}
+		d.Success = err == nil
+		s.lock.Lock()
+		if _, ok := s.callbacks[jobID]; ok { //job can be forgotten during delivery
+			s.deliveries[jobID] = append(s.deliveries[jobID], d)
+		}
+		s.lock.Unlock()
+		if d.Success {
+			log.Printf("[INFO] callback of job %s is delivered to %s", jobID, url)
+			return
+		}
+		log.Printf("[WARN] callback of job %s attempt %d failed, %s", jobID, attempt, d.Error)
+		if attempt == s.MaxAttempts {
+			break
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	log.Printf("[ERROR] callback of job %s is not delivered after %d attempts", jobID, s.MaxAttempts)
+}
+
+func (s *Service) post(url, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "can't make callback request")
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, EventJobCompleted)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	req.Header.Set(HeaderTimestamp, ts)
+	s.optsLock.RLock()
+	signature := Sign(s.Secret, ts, body)
+	s.optsLock.RUnlock()
+	req.Header.Set(HeaderSignature, "sha256="+signature)
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't call callback url")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.Errorf("callback url responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+//Sign returns hex HMAC-SHA256 of timestamp and body joined by dot
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s.", timestamp)
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
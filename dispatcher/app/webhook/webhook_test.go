@@ -0,0 +1,251 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/watcher"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type jobGetterFunc func(id string) (*model.Job, error)
+
+func (f jobGetterFunc) GetJob(id string) (*model.Job, error) { return f(id) }
+
+func newTestService(jobs jobGetterFunc, opts Opts) (*Service, func()) {
+	opts.AllowPrivate = true //callbacks are sent to local test servers
+	w := watcher.New(jobs, 10*time.Millisecond)
+	s := NewService(w, opts)
+	return s, func() {
+		s.Close()
+		w.Close()
+	}
+}
+
+func TestService_WatchDelivers(t *testing.T) {
+	var calls int32
+	received := make(chan Event, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "sha256="+Sign("secret", r.Header.Get(HeaderTimestamp), body), r.Header.Get(HeaderSignature))
+		assert.Equal(t, EventJobCompleted, r.Header.Get(HeaderEvent))
+		assert.NotEmpty(t, r.Header.Get(HeaderDelivery))
+		e := Event{}
+		require.NoError(t, json.Unmarshal(body, &e))
+		received <- e
+	}))
+	defer ts.Close()
+
+	var status atomic.Value
+	status.Store("RUNNING")
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		return &model.Job{ID: id, TenantID: 1, Payload: "abc", Status: status.Load().(string)}, nil
+	})
+	s, teardown := newTestService(jobs, Opts{Secret: "secret", Backoff: 10 * time.Millisecond})
+	defer teardown()
+
+	s.Watch("5", ts.URL)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls), "job is not finished yet")
+	status.Store("SUCCESS")
+
+	select {
+	case e := <-received:
+		assert.Equal(t, "5", e.Job.ID)
+		assert.Equal(t, "SUCCESS", e.Job.Status)
+		assert.Equal(t, "", e.Job.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("callback is not delivered")
+	}
+
+	var deliveries []Delivery
+	require.Eventually(t, func() bool {
+		var err error
+		deliveries, err = s.Deliveries("5")
+		require.NoError(t, err)
+		return len(deliveries) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, deliveries[0].Success)
+	assert.Equal(t, http.StatusServiceUnavailable, deliveries[0].StatusCode)
+	assert.True(t, deliveries[1].Success)
+	assert.Equal(t, 2, deliveries[1].Attempt)
+	assert.Equal(t, deliveries[0].ID, deliveries[1].ID)
+
+	assert.Equal(t, ErrNothingToReplay, s.Replay("5"))
+	_, err := s.Deliveries("6")
+	assert.Equal(t, ErrNoCallback, err)
+
+	s.Forget("5")
+	_, err = s.Deliveries("5")
+	assert.Equal(t, ErrNoCallback, err, "delivery log of removed job is dropped")
+	s.lock.Lock()
+	assert.Empty(t, s.callbacks)
+	assert.Empty(t, s.events)
+	assert.Empty(t, s.deliveries)
+	s.lock.Unlock()
+}
+
+func TestService_Replay(t *testing.T) {
+	var lock sync.Mutex
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		return &model.Job{ID: id, Status: "FAILED"}, nil
+	})
+	s, teardown := newTestService(jobs, Opts{MaxAttempts: 2, Backoff: time.Millisecond})
+	defer teardown()
+	assert.Equal(t, ErrNoCallback, s.Replay("1"))
+
+	s.Watch("1", ts.URL)
+	require.Eventually(t, func() bool {
+		d, _ := s.Deliveries("1")
+		return len(d) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	lock.Lock()
+	fail = false
+	lock.Unlock()
+	require.NoError(t, s.Replay("1"))
+	require.Eventually(t, func() bool {
+		d, _ := s.Deliveries("1")
+		return len(d) == 3 && d[2].Success && d[2].Attempt == 1
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestService_ReplayInProgress(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		<-release
+	}))
+	defer ts.Close()
+
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		return &model.Job{ID: id, Status: "SUCCESS"}, nil
+	})
+	s, teardown := newTestService(jobs, Opts{MaxAttempts: 2, Backoff: time.Millisecond})
+	defer teardown()
+
+	s.Watch("1", ts.URL)
+	s.Watch("1", ts.URL)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, ErrInProgress, s.Replay("1"), "second attempt is running")
+	close(release)
+	require.Eventually(t, func() bool {
+		d, _ := s.Deliveries("1")
+		return len(d) == 2 && d[1].Success
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "job is delivered once for both watches")
+	assert.Equal(t, ErrNothingToReplay, s.Replay("1"))
+}
+
+func TestService_CheckURL(t *testing.T) {
+	s := NewService(nil, Opts{})
+	s.resolve = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		hosts := map[string][]string{
+			"worker-blob-net": {"172.18.0.3"},
+			"mixed.example":   {"93.184.216.34", "192.168.1.10"},
+			"client.example":  {"93.184.216.34", "2606:2800:220:1::1"},
+		}
+		ips, ok := hosts[host]
+		if !ok {
+			if ip := net.ParseIP(host); ip != nil {
+				return []net.IPAddr{{IP: ip}}, nil
+			}
+			return nil, errors.Errorf("no such host %s", host)
+		}
+		res := []net.IPAddr{}
+		for _, ip := range ips {
+			res = append(res, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return res, nil
+	}
+	tbl := []struct {
+		url      string
+		internal bool
+	}{
+		{"http://127.0.0.1:9000/api/v1/job", true},
+		{"http://169.254.169.254/latest/meta-data", true},
+		{"http://10.0.0.5/cb", true},
+		{"http://[::1]:8080/cb", true},
+		{"http://[fd00::1]/cb", true},
+		{"http://0.0.0.0/cb", true},
+		{"http://worker-blob-net:8081/api/v1/blob", true},
+		{"https://mixed.example/cb", true},
+		{"https://client.example/cb", false},
+		{"https://93.184.216.34/cb", false},
+	}
+	for i, tt := range tbl {
+		err := s.CheckURL(context.Background(), tt.url)
+		if tt.internal {
+			assert.True(t, errors.Is(err, ErrInternalAddress), "test case #%d, %v", i, err)
+			continue
+		}
+		assert.NoError(t, err, "test case #%d", i)
+	}
+	assert.Error(t, s.CheckURL(context.Background(), "http://unknown.example/cb"), "unresolved host is rejected")
+
+	s.AllowPrivate = true
+	assert.NoError(t, s.CheckURL(context.Background(), "http://127.0.0.1:9000/api/v1/job"))
+}
+
+func TestService_DeliveryToInternalAddress(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer ts.Close()
+
+	jobs := jobGetterFunc(func(id string) (*model.Job, error) {
+		return &model.Job{ID: id, Status: "SUCCESS"}, nil
+	})
+	w := watcher.New(jobs, 10*time.Millisecond)
+	defer w.Close()
+	s := NewService(w, Opts{MaxAttempts: 1})
+	defer s.Close()
+
+	s.Watch("1", ts.URL)
+	var deliveries []Delivery
+	require.Eventually(t, func() bool {
+		deliveries, _ = s.Deliveries("1")
+		return len(deliveries) == 1
+	}, time.Second, 5*time.Millisecond)
+	assert.False(t, deliveries[0].Success)
+	assert.Contains(t, deliveries[0].Error, ErrInternalAddress.Error(), "address is checked on dial")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestService_URLFor(t *testing.T) {
+	s, teardown := newTestService(nil, Opts{TenantURLs: map[int]string{1: "http://tenant1/cb"}})
+	defer teardown()
+	assert.Equal(t, "http://job/cb", s.URLFor(1, "http://job/cb"))
+	assert.Equal(t, "http://tenant1/cb", s.URLFor(1, ""))
+	assert.Equal(t, "", s.URLFor(2, ""))
+}
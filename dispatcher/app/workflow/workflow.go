@@ -0,0 +1,266 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//MaxDependencies limits number of jobs which job depends on
+const MaxDependencies = 20
+
+//ErrDependency returned for dependency which job can't have
+var ErrDependency = errors.New("invalid dependency")
+
+//Dispatcher sends stored job to worker service
+type Dispatcher interface {
+	DispatchJob(job model.Job) error
+}
+
+//Workflow holds WAITING jobs till all jobs they depend on are SUCCESS, then dispatches them to worker.
+//WAITING job is CANCELED if any of its dependencies is finished unsuccessfully, so failure is cascaded
+//to all downstream jobs
+type Workflow struct {
+	Store      *store.Store
+	Dispatcher Dispatcher
+	Interval   time.Duration
+
+	once sync.Once
+	wake chan struct{}
+}
+
+//Notify wakes up workflow when job is finished, it is called on every store update
+func (w *Workflow) Notify(job model.Job) {
+	if model.IsFinished(job.Status) {
+		w.Wake()
+	}
+}
+
+//Wake makes workflow check WAITING jobs without waiting for the next tick
+func (w *Workflow) Wake() {
+	select {
+	case w.wakeCh() <- struct{}{}:
+	default:
+	}
+}
+
+//Run checks WAITING jobs every Interval or on wake up till context is canceled
+func (w *Workflow) Run(ctx context.Context) {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	log.Printf("[INFO] start workflow, interval=%s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("[INFO] workflow terminated")
+			return
+		case <-ticker.C:
+			w.Release()
+		case <-w.wakeCh():
+			w.Release()
+		}
+	}
+}
+
+//Release dispatches WAITING jobs whose dependencies are SUCCESS and cancels ones with failed dependency.
+//Jobs are checked again while anything is changed, so cancellation reaches the whole downstream at once
+func (w *Workflow) Release() {
+	waiting := func(job model.Job) bool { return job.Status == model.JobStatus(model.WAITING).ToString() }
+	for {
+		changed := false
+		for _, job := range w.Store.Find(waiting) {
+			if w.resolve(job) {
+				changed = true
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+}
+
+//CheckDependencies returns error if job of tenant can't depend on jobs with ids. Dependency must be known job
+//of the same tenant, so a new job can't make a cycle
+func (w *Workflow) CheckDependencies(tenantID int, ids []string) error {
+	if len(ids) > MaxDependencies {
+		return errors.Wrapf(ErrDependency, "job depends on %d jobs, allowed %d", len(ids), MaxDependencies)
+	}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			return errors.Wrapf(ErrDependency, "dependency job %s is duplicated", id)
+		}
+		seen[id] = true
+		job, err := w.Store.Get(id)
+		if err != nil || job.TenantID != tenantID {
+			return errors.Wrapf(ErrDependency, "dependency job %q is not found", id)
+		}
+		if job.Cron != "" {
+			return errors.Wrapf(ErrDependency, "dependency job %s is recurring and never finishes", id)
+		}
+	}
+	return nil
+}
+
+//Graph returns all jobs connected to job by dependencies in order of ids
+func (w *Workflow) Graph(id string) ([]model.Job, error) {
+	root, err := w.Store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	children := map[string][]string{}
+	for _, job := range w.Store.Find(func(job model.Job) bool { return len(job.DependsOn) > 0 }) {
+		for _, parent := range job.DependsOn {
+			children[parent] = append(children[parent], job.ID)
+		}
+	}
+
+	res := []model.Job{}
+	seen := map[string]bool{root.ID: true}
+	queue := []model.Job{root}
+	for len(queue) > 0 {
+		job := queue[0]
+		queue = queue[1:]
+		res = append(res, job)
+		for _, next := range append(append([]string{}, job.DependsOn...), children[job.ID]...) {
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			if nextJob, errGet := w.Store.Get(next); errGet == nil {
+				queue = append(queue, nextJob)
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, _ := strconv.Atoi(res[i].ID)
+		b, _ := strconv.Atoi(res[j].ID)
+		return a < b
+	})
+	return res, nil
+}
+
+//Levels sorts nodes of graph topologically, deps[i] are nodes which node i depends on. Nodes of each level depend
+//only on nodes of previous levels. Nodes in cycles and depending on them are returned as cyclic
+func Levels(deps [][]int) (levels [][]int, cyclic []int) {
+	pending := make([]int, len(deps))
+	children := make([][]int, len(deps))
+	level := []int{}
+	for i, parents := range deps {
+		pending[i] = len(parents)
+		for _, p := range parents {
+			children[p] = append(children[p], i)
+		}
+		if len(parents) == 0 {
+			level = append(level, i)
+		}
+	}
+	sorted := 0
+	for len(level) > 0 {
+		levels = append(levels, level)
+		sorted += len(level)
+		next := []int{}
+		for _, i := range level {
+			for _, c := range children[i] {
+				if pending[c]--; pending[c] == 0 {
+					next = append(next, c)
+				}
+			}
+		}
+		level = next
+	}
+	if sorted < len(deps) {
+		for i := range deps {
+			if pending[i] > 0 {
+				cyclic = append(cyclic, i)
+			}
+		}
+	}
+	return levels, cyclic
+}
+
+//resolve dispatches or cancels WAITING job if its dependencies are finished, returns true if job is changed
+func (w *Workflow) resolve(job model.Job) bool {
+	for _, id := range job.DependsOn {
+		parent, err := w.Store.Get(id)
+		switch {
+		case err != nil:
+			return w.cancel(job, fmt.Sprintf("dependency job %s is not found", id))
+		case parent.Status == model.JobStatus(model.SUCCESS).ToString():
+			continue
+		case model.IsFinished(parent.Status):
+			return w.cancel(job, fmt.Sprintf("dependency job %s is %s", id, parent.Status))
+		default:
+			return false
+		}
+	}
+	return w.dispatch(job)
+}
+
+func (w *Workflow) cancel(job model.Job, reason string) bool {
+	_, err := w.Store.Update(job.ID, func(j *model.Job) error {
+		if j.Status != model.JobStatus(model.WAITING).ToString() {
+			return errors.Errorf("job status was changed to %s", j.Status)
+		}
+		j.Status = model.JobStatus(model.CANCELED).ToString()
+		j.HeldPayload = ""
+		j.Error = &model.JobError{Code: model.ErrorCodeDependencyFailed, Message: reason}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] can't cancel job %s, %v", job.ID, err)
+		return false
+	}
+	log.Printf("[INFO] job %s is canceled, %s", job.ID, reason)
+	return true
+}
+
+//dispatch moves job to RUNNING and sends it to worker, dispatch failure is retried by job retry policy
+func (w *Workflow) dispatch(job model.Job) bool {
+	running, err := w.Store.Update(job.ID, func(j *model.Job) error {
+		if j.Status != model.JobStatus(model.WAITING).ToString() {
+			return errors.Errorf("job status was changed to %s", j.Status)
+		}
+		j.Status = model.JobStatus(model.RUNNING).ToString()
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] can't dispatch waiting job %s, %v", job.ID, err)
+		return false
+	}
+	log.Printf("[INFO] dependencies of job %s are finished, dispatch it", job.ID)
+	dispatchErr := w.Dispatcher.DispatchJob(running)
+	if dispatchErr == nil {
+		return true
+	}
+	log.Printf("[WARN] can't dispatch job %s, %v", job.ID, dispatchErr)
+	_, err = w.Store.Update(job.ID, func(j *model.Job) error {
+		if j.Status != model.JobStatus(model.RUNNING).ToString() {
+			return errors.Errorf("job status was changed to %s during dispatch", j.Status)
+		}
+		j.Status = model.JobStatus(model.FAILED).ToString()
+		j.Error = &model.JobError{Code: model.ErrorCodeWorkerUnreachable, Retryable: true,
+			Message: fmt.Sprintf("can't dispatch job to worker: %v", dispatchErr)}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] can't write dispatch failure of job %s, %v", job.ID, err)
+	}
+	return true
+}
+
+func (w *Workflow) wakeCh() chan struct{} {
+	w.once.Do(func() { w.wake = make(chan struct{}, 1) })
+	return w.wake
+}
@@ -0,0 +1,181 @@
+package workflow
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/model"
+	"github.com/theshamuel/image-jobs-dispatcher/dispatcher/app/store"
+	"sync"
+	"testing"
+	"time"
+)
+
+type dispatcherMock struct {
+	lock       sync.Mutex
+	dispatched []string
+	err        error
+}
+
+func (d *dispatcherMock) DispatchJob(job model.Job) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.dispatched = append(d.dispatched, job.ID)
+	return d.err
+}
+
+func (d *dispatcherMock) ids() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return append([]string{}, d.dispatched...)
+}
+
+func setStatus(t *testing.T, s *store.Store, id, status string) {
+	_, err := s.Update(id, func(job *model.Job) error {
+		job.Status = status
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func status(t *testing.T, s *store.Store, id string) string {
+	job, err := s.Get(id)
+	require.NoError(t, err)
+	return job.Status
+}
+
+func TestWorkflow_Release(t *testing.T) {
+	s := store.New(
+		model.Job{ID: "1", TenantID: 1},
+		model.Job{ID: "2", TenantID: 1},
+		model.Job{ID: "3", TenantID: 1, Status: "WAITING", DependsOn: []string{"1", "2"}, HeldPayload: "MQo="},
+		model.Job{ID: "4", TenantID: 1, Status: "WAITING", DependsOn: []string{"3"}},
+		model.Job{ID: "5", TenantID: 1, Status: "WAITING", DependsOn: []string{"4"}},
+		model.Job{ID: "6", TenantID: 1, Status: "WAITING", DependsOn: []string{"1"}},
+		model.Job{ID: "7", TenantID: 1, Status: "WAITING", DependsOn: []string{"100"}},
+	)
+	d := &dispatcherMock{}
+	w := Workflow{Store: s, Dispatcher: d}
+
+	w.Release()
+	assert.Empty(t, d.ids(), "dependencies are running")
+	assert.Equal(t, "CANCELED", status(t, s, "7"), "unknown dependency")
+
+	setStatus(t, s, "1", "SUCCESS")
+	w.Release()
+	assert.Equal(t, []string{"6"}, d.ids())
+	assert.Equal(t, "RUNNING", status(t, s, "6"))
+	assert.Equal(t, "WAITING", status(t, s, "3"), "one of dependencies is running")
+
+	setStatus(t, s, "2", "DEAD_LETTERED")
+	w.Release()
+	assert.Equal(t, []string{"6"}, d.ids())
+	for _, id := range []string{"3", "4", "5"} {
+		job, err := s.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, "CANCELED", job.Status, "failure is cascaded to job %s", id)
+		assert.Equal(t, model.ErrorCodeDependencyFailed, job.Error.Code)
+		assert.Empty(t, job.HeldPayload)
+	}
+	job, err := s.Get("4")
+	require.NoError(t, err)
+	assert.Equal(t, "dependency job 3 is CANCELED", job.Error.Message)
+}
+
+func TestWorkflow_ReleaseDispatchError(t *testing.T) {
+	s := store.New(model.Job{ID: "1", Status: "SUCCESS"}, model.Job{ID: "2", Status: "WAITING", DependsOn: []string{"1"}})
+	w := Workflow{Store: s, Dispatcher: &dispatcherMock{err: errors.New("connection refused")}}
+	w.Release()
+	job, err := s.Get("2")
+	require.NoError(t, err)
+	assert.Equal(t, "FAILED", job.Status)
+	assert.Equal(t, model.ErrorCodeWorkerUnreachable, job.Error.Code)
+	assert.True(t, job.Error.Retryable)
+}
+
+func TestWorkflow_Run(t *testing.T) {
+	s := store.New(model.Job{ID: "1"}, model.Job{ID: "2", Status: "WAITING", DependsOn: []string{"1"}})
+	d := &dispatcherMock{}
+	w := &Workflow{Store: s, Dispatcher: d, Interval: time.Hour}
+	s.OnUpdate(w.Notify)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	setStatus(t, s, "1", "SUCCESS")
+	require.Eventually(t, func() bool { return len(d.ids()) == 1 }, time.Second, 10*time.Millisecond,
+		"finished job wakes up workflow")
+	cancel()
+	<-done
+}
+
+func TestWorkflow_CheckDependencies(t *testing.T) {
+	s := store.New(model.Job{ID: "1", TenantID: 1}, model.Job{ID: "2", TenantID: 2})
+	w := Workflow{Store: s}
+	many := make([]string, MaxDependencies+1)
+	tbl := []struct {
+		ids []string
+		err string
+	}{
+		{[]string{"1"}, ""},
+		{[]string{"1", "1"}, "dependency job 1 is duplicated: invalid dependency"},
+		{[]string{"2"}, `dependency job "2" is not found: invalid dependency`},
+		{[]string{"3"}, `dependency job "3" is not found: invalid dependency`},
+		{many, "job depends on 21 jobs, allowed 20: invalid dependency"},
+	}
+	for i, tt := range tbl {
+		err := w.CheckDependencies(1, tt.ids)
+		if tt.err == "" {
+			assert.NoError(t, err, "test case #%d", i)
+			continue
+		}
+		assert.EqualError(t, err, tt.err, "test case #%d", i)
+		assert.True(t, errors.Is(err, ErrDependency), "test case #%d", i)
+	}
+}
+
+func TestWorkflow_Graph(t *testing.T) {
+	s := store.New(
+		model.Job{ID: "1"},
+		model.Job{ID: "2", DependsOn: []string{"1"}},
+		model.Job{ID: "10", DependsOn: []string{"2", "3"}},
+		model.Job{ID: "3"},
+		model.Job{ID: "4"},
+	)
+	w := Workflow{Store: s}
+	for _, id := range []string{"1", "2", "10", "3"} {
+		jobs, err := w.Graph(id)
+		require.NoError(t, err)
+		ids := []string{}
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		assert.Equal(t, []string{"1", "2", "3", "10"}, ids, "graph of job %s", id)
+	}
+	jobs, err := w.Graph("4")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(jobs))
+	_, err = w.Graph("5")
+	assert.True(t, errors.Is(err, store.ErrNotFound))
+}
+
+func TestLevels(t *testing.T) {
+	tbl := []struct {
+		deps   [][]int
+		levels [][]int
+		cyclic []int
+	}{
+		{[][]int{nil, nil}, [][]int{{0, 1}}, nil},
+		{[][]int{{1}, nil, {0, 1}}, [][]int{{1}, {0}, {2}}, nil},
+		{[][]int{{1}, {0}, nil, {1}}, [][]int{{2}}, []int{0, 1, 3}},
+		{[][]int{{0}}, nil, []int{0}},
+	}
+	for i, tt := range tbl {
+		levels, cyclic := Levels(tt.deps)
+		assert.Equal(t, tt.levels, levels, "test case #%d", i)
+		assert.Equal(t, tt.cyclic, cyclic, "test case #%d", i)
+	}
+}
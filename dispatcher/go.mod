@@ -14,4 +14,5 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.5.1
 	go.uber.org/goleak v1.1.10
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -2,17 +2,21 @@ package main
 
 import (
 	"context"
+	"github.com/theshamuel/image-jobs-dispatcher/worker-service-mock/app/logging"
 	"github.com/theshamuel/image-jobs-dispatcher/worker-service-mock/app/rest"
+	"github.com/theshamuel/image-jobs-dispatcher/worker-service-mock/app/tracing"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 var version = "unknown"
 
 type application struct {
 	rest        *rest.Rest
+	tracer      *tracing.Tracer
 	terminated  chan struct{}
 }
 
@@ -28,10 +32,29 @@ func (app *application) run(ctx context.Context) {
 }
 
 func main()  {
-	rest := &rest.Rest{}
+	if os.Getenv("LOG_FORMAT") == "json" {
+		log.SetFlags(0)
+		log.SetOutput(&logging.JSONWriter{Out: os.Stderr})
+	}
+	stepDelay, err := time.ParseDuration(os.Getenv("JOB_STEP_DELAY"))
+	if err != nil {
+		stepDelay = 2 * time.Second
+	}
+	tracer, err := tracing.FromEnv("worker-service-mock")
+	if err != nil {
+		log.Printf("[ERROR] can not set up tracing, %v", err)
+		os.Exit(1)
+	}
+	rest := &rest.Rest{
+		DispatcherURL: os.Getenv("DISPATCHER_URL"),
+		PushSecret:    os.Getenv("PUSH_SECRET"),
+		StepDelay:     stepDelay,
+		Tracer:        tracer,
+	}
 
 	app := &application{
 		rest:          rest,
+		tracer:        tracer,
 		terminated:    make(chan struct{}),
 	}
 	log.Printf("[INFO] starting Worker Service API mock server version %s", version)
@@ -1,17 +1,111 @@
 package rest
 
 import (
-	"github.com/go-chi/render"
+	"encoding/json"
+	"errors"
+	"github.com/didip/tollbooth/v6"
+	"github.com/didip/tollbooth/v6/limiter"
+	"github.com/theshamuel/image-jobs-dispatcher/worker-service-mock/app/logging"
 	"log"
 	"net/http"
+	"strings"
 )
 
+//ErrorCode is stable code of error response, the same codes are used by dispatcher
+type ErrorCode string
+
+//error catalogue of worker service
 const (
-	ErrorServerInternal = 0
+	ErrorServerInternal      ErrorCode = "INTERNAL"
+	ErrorJSONUnmarshal       ErrorCode = "MALFORMED_REQUEST"
+	ErrorNotFound            ErrorCode = "NOT_FOUND"
+	ErrorMethodNotAllowed    ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrorJobNotFound         ErrorCode = "JOB_NOT_FOUND"
+	ErrorRateLimited         ErrorCode = "RATE_LIMITED"
+	ErrorUpstreamUnavailable ErrorCode = "UPSTREAM_UNAVAILABLE"
+	ErrorUpstreamFailed      ErrorCode = "UPSTREAM_FAILED"
 )
 
-func SendErrorJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, err error, errCode int, details string) {
-	log.Printf("[DEBUG] %d, %+v, %d ", httpStatusCode, err, errCode)
-	render.Status(r, httpStatusCode)
-	render.JSON(w, r, map[string]interface{}{"error": err.Error(), "code": errCode, "details": details})
+var errorTitles = map[ErrorCode]string{
+	ErrorServerInternal:      "Internal server error",
+	ErrorJSONUnmarshal:       "Request body is malformed",
+	ErrorNotFound:            "Resource is not found",
+	ErrorMethodNotAllowed:    "Method is not allowed",
+	ErrorJobNotFound:         "Job is not found",
+	ErrorRateLimited:         "Rate limit is exceeded",
+	ErrorUpstreamUnavailable: "Upstream service is unavailable",
+	ErrorUpstreamFailed:      "Upstream service failed",
+}
+
+//Title returns short summary of error code
+func (c ErrorCode) Title() string {
+	if title, ok := errorTitles[c]; ok {
+		return title
+	}
+	return errorTitles[ErrorServerInternal]
+}
+
+//Type returns URI of problem type of error code
+func (c ErrorCode) Type() string {
+	return "urn:problem:image-jobs-dispatcher:" + strings.ReplaceAll(strings.ToLower(string(c)), "_", "-")
+}
+
+//ContentTypeProblem is content type of error responses
+const ContentTypeProblem = "application/problem+json"
+
+//Problem is RFC 7807 problem details of error response extended by error code, error message and request id
+type Problem struct {
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	Instance  string    `json:"instance,omitempty"`
+	Code      ErrorCode `json:"code"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+//SendErrorJSON sends problem details of error with http status
+func SendErrorJSON(w http.ResponseWriter, r *http.Request, httpStatusCode int, err error, errCode ErrorCode, details string) {
+	log.Printf("[DEBUG] %d, %+v, %s request_id=%s", httpStatusCode, err, errCode, logging.RequestID(r.Context()))
+	problem := Problem{
+		Type:      errCode.Type(),
+		Title:     errCode.Title(),
+		Status:    httpStatusCode,
+		Detail:    details,
+		Instance:  r.URL.Path,
+		Code:      errCode,
+		RequestID: logging.RequestID(r.Context()),
+	}
+	if err != nil {
+		problem.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.WriteHeader(httpStatusCode)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		log.Printf("[ERROR] can not write error response, %v", err)
+	}
+}
+
+//newLimiter makes limiter of max requests per second by ip which rejects requests with problem details
+func newLimiter(max float64) *limiter.Limiter {
+	lmt := tollbooth.NewLimiter(max, nil)
+	body, err := json.Marshal(Problem{Type: ErrorRateLimited.Type(), Title: ErrorRateLimited.Title(),
+		Status: http.StatusTooManyRequests, Code: ErrorRateLimited, Detail: "too many requests, retry later"})
+	if err != nil {
+		log.Printf("[ERROR] can not encode rate limit response, %v", err)
+		return lmt
+	}
+	return lmt.SetMessage(string(body)).SetMessageContentType(ContentTypeProblem)
+}
+
+//notFound sends problem details for unknown route
+func notFound(w http.ResponseWriter, r *http.Request) {
+	SendErrorJSON(w, r, http.StatusNotFound, errors.New("no route "+r.URL.Path), ErrorNotFound, "route is not found")
+}
+
+//methodNotAllowed sends problem details for known route requested with wrong method
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	SendErrorJSON(w, r, http.StatusMethodNotAllowed, errors.New("method "+r.Method+" is not allowed"),
+		ErrorMethodNotAllowed, "route doesn't support method")
 }
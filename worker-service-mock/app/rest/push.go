@@ -0,0 +1,230 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/theshamuel/image-jobs-dispatcher/worker-service-mock/app/logging"
+	"github.com/theshamuel/image-jobs-dispatcher/worker-service-mock/app/tracing"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//StatusReport is job state pushed to dispatcher
+type StatusReport struct {
+	Status         string    `json:"status"`
+	Progress       int       `json:"progress"`
+	Stage          string    `json:"stage,omitempty"`
+	ResultLocation string    `json:"result_location,omitempty"`
+	Error          *JobError `json:"error,omitempty"`
+}
+
+//JobError explains why job is failed
+type JobError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Retryable  bool   `json:"retryable"`
+	WorkerNode string `json:"worker_node,omitempty"`
+}
+
+//step of simulated job processing
+type step struct {
+	status   JobStatus
+	progress int
+	stage    string
+	err      *JobError
+}
+
+//errPushRejected is returned if dispatcher refused the report, such report is not retried
+type errPushRejected struct {
+	code int
+	body string
+}
+
+func (e errPushRejected) Error() string {
+	return fmt.Sprintf("dispatcher rejected report with status %d: %s", e.code, e.body)
+}
+
+//acceptJob stores submitted job and starts simulated processing of it, request id and span of submission are sent
+//with status reports to correlate logs and traces of dispatcher and worker
+func (r *Rest) acceptJob(ctx context.Context, body []byte, payloadLocation string) {
+	job := Job{}
+	if err := json.Unmarshal(body, &job); err != nil || job.ID == "" {
+		log.Printf("[WARN] job without id is submitted, status of it won't be pushed")
+		return
+	}
+	job.Payload = ""
+	job.PayloadLocation = payloadLocation
+	job.Status = RUNNING
+	job.RequestID = logging.RequestID(ctx)
+	job.TraceParent = tracing.FromContext(ctx).TraceParent()
+	storeLock.Lock()
+	store[job.ID] = job
+	storeLock.Unlock()
+
+	if r.PushSecret == "" || r.DispatcherURL == "" {
+		return
+	}
+	r.lock.Lock()
+	quit := r.quit
+	r.timelines.Add(1)
+	r.lock.Unlock()
+	log.Printf("[INFO] job is accepted job_id=%s tenant_id=%d request_id=%s", job.ID, job.TenantID, job.RequestID)
+	go r.runTimeline(job, timeline(job), quit)
+}
+
+//dispatchedPayload returns payload location of job dispatched again by dispatcher retry, such job has
+//no payload as it is already stored in blob service
+func dispatchedPayload(body []byte) (string, bool) {
+	job := Job{}
+	if err := json.Unmarshal(body, &job); err != nil || job.Payload != "" || job.PayloadLocation == "" {
+		return "", false
+	}
+	return job.PayloadLocation, true
+}
+
+//timeline returns simulated steps of job processing, each requested operation is a processing stage. Every 5th job
+//fails on the first processing stage of the first attempt and every 10th job fails on each attempt
+func timeline(job Job) []step {
+	stages := []string{"process"}
+	if len(job.Operations) > 0 {
+		stages = stages[:0]
+		for _, op := range job.Operations {
+			stages = append(stages, op.Type)
+		}
+	}
+	steps := []step{{RUNNING, 0, "download", nil}, {RUNNING, 50, stages[0], nil}}
+	if id, err := strconv.Atoi(job.ID); err == nil && (id%10 == 0 || id%5 == 0 && job.Attempt <= 1) {
+		node, _ := os.Hostname()
+		jobErr := &JobError{Code: "PROCESSING_FAILED", Message: "simulated processing failure", Retryable: true, WorkerNode: node}
+		return append(steps, step{FAILED, 50, stages[0], jobErr})
+	}
+	for i, stage := range stages[1:] {
+		steps = append(steps, step{RUNNING, 50 + 50*(i+1)/len(stages), stage, nil})
+	}
+	return append(steps, step{RUNNING, 100, "upload", nil}, step{SUCCESS, 100, "upload", nil})
+}
+
+//runTimeline moves job through steps with StepDelay between them and pushes each step to dispatcher
+func (r *Rest) runTimeline(accepted Job, steps []step, quit <-chan struct{}) {
+	defer r.timelines.Done()
+	delay := r.StepDelay
+	if delay <= 0 {
+		delay = 2 * time.Second
+	}
+	for _, st := range steps {
+		select {
+		case <-quit:
+			return
+		case <-time.After(delay):
+		}
+		storeLock.Lock()
+		job := store[accepted.ID]
+		job.Status = st.status
+		store[accepted.ID] = job
+		storeLock.Unlock()
+
+		report := StatusReport{Status: st.status.String(), Progress: st.progress, Stage: st.stage, Error: st.err}
+		if st.status == SUCCESS {
+			report.ResultLocation = mockResultLocation
+		}
+		if err := r.pushStatus(accepted, report, quit); err != nil {
+			log.Printf("[ERROR] can not push status of job, %v job_id=%s request_id=%s", err, accepted.ID, accepted.RequestID)
+			return
+		}
+	}
+}
+
+//pushStatus sends report to dispatcher with retries
+func (r *Rest) pushStatus(job Job, report StatusReport, quit <-chan struct{}) error {
+	var err error
+	for attempt := 1; attempt <= 3; attempt++ {
+		if err = r.sendReport(job, report); err == nil {
+			log.Printf("[DEBUG] pushed job status %s, progress %d%% job_id=%s request_id=%s", report.Status, report.Progress,
+				job.ID, job.RequestID)
+			return nil
+		}
+		if _, ok := err.(errPushRejected); ok {
+			return err
+		}
+		log.Printf("[WARN] push attempt %d of job failed, %v job_id=%s request_id=%s", attempt, err, job.ID, job.RequestID)
+		select {
+		case <-quit:
+			return err
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+	return err
+}
+
+//sendReport makes signed request to dispatcher internal api and checks signature of response, request is child
+//span of job submission
+func (r *Rest) sendReport(job Job, report StatusReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(strings.TrimSuffix(r.DispatcherURL, "/") + "/internal/v1/job/" + job.ID + "/status")
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	ctx := context.Background()
+	if parent, errParse := tracing.ParseTraceParent(job.TraceParent); errParse == nil {
+		ctx = tracing.WithSpanContext(ctx, parent)
+	}
+	request, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Worker-Timestamp", ts)
+	if job.RequestID != "" {
+		request.Header.Set(logging.HeaderRequestID, job.RequestID)
+	}
+	request.Header.Set("X-Worker-Signature", "sha256="+sign(r.PushSecret, ts, "POST", u.Path, body))
+
+	client := http.Client{Timeout: 10 * time.Second, Transport: r.Tracer.Transport("dispatcher", nil)}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	respBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusUnauthorized {
+		return errPushRejected{code: response.StatusCode, body: string(respBody)}
+	}
+	expected := "sha256=" + sign(r.PushSecret, ts, strconv.Itoa(response.StatusCode), u.Path, respBody)
+	if !hmac.Equal([]byte(expected), []byte(response.Header.Get("X-Dispatcher-Signature"))) {
+		return fmt.Errorf("dispatcher response signature is invalid")
+	}
+	switch {
+	case response.StatusCode == http.StatusOK:
+		return nil
+	case response.StatusCode >= 500:
+		return fmt.Errorf("dispatcher responded with status %d", response.StatusCode)
+	default:
+		return errPushRejected{code: response.StatusCode, body: string(respBody)}
+	}
+}
+
+//sign makes HMAC-SHA256 signature the same way as RequestSigner in dispatcher does
+func sign(secret, timestamp, method, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s\n%s\n%s\n", timestamp, method, path)
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
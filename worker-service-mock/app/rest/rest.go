@@ -5,11 +5,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/didip/tollbooth/v6"
 	"github.com/didip/tollbooth_chi"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
+	"github.com/theshamuel/image-jobs-dispatcher/worker-service-mock/app/logging"
+	"github.com/theshamuel/image-jobs-dispatcher/worker-service-mock/app/tracing"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -20,18 +21,34 @@ import (
 )
 
 type Rest struct {
-	httpServer *http.Server
-	lock       sync.Mutex
+	DispatcherURL string
+	PushSecret    string
+	StepDelay     time.Duration
+	Tracer        *tracing.Tracer
+	httpServer    *http.Server
+	lock          sync.Mutex
+	quit          chan struct{}
+	timelines     sync.WaitGroup
 }
 
 type Job struct {
-	ID              string    `json:"id"`
-	TenantID        int       `json:"tenant_id"`
-	ClientID        int       `json:"client_id"`
-	Payload         string    `json:"payload,omitempty"`
-	PayloadLocation string    `json:"payload_location,omitempty"`
-	PayloadSize     int       `json:"payload_size,omitempty"`
-	Status          JobStatus `json:"status,omitempty"`
+	ID              string      `json:"id"`
+	TenantID        int         `json:"tenant_id"`
+	ClientID        int         `json:"client_id"`
+	Payload         string      `json:"payload,omitempty"`
+	PayloadLocation string      `json:"payload_location,omitempty"`
+	PayloadSize     int         `json:"payload_size,omitempty"`
+	Status          JobStatus   `json:"status,omitempty"`
+	Attempt         int         `json:"attempt,omitempty"`
+	Operations      []Operation `json:"operations,omitempty"`
+	RequestID       string      `json:"-"`
+	TraceParent     string      `json:"-"`
+}
+
+//Operation is processing step requested by client, it is simulated as stage of job processing
+type Operation struct {
+	Type   string                 `json:"type"`
+	Params map[string]interface{} `json:"params,omitempty"`
 }
 
 type JobStatus int
@@ -55,8 +72,12 @@ func (j JobStatus) String() string {
 	}
 }
 
+//mockResultLocation is result image of every successful job
+const mockResultLocation = "/blob/1"
+
 type JobStatusResponse struct {
-	Status int `json:"status"`
+	Status         int    `json:"status"`
+	ResultLocation string `json:"result_location,omitempty"` //set for SUCCESS job, so dispatcher without pushes gets it
 }
 
 type PayloadLocation struct {
@@ -68,13 +89,16 @@ var store = map[string]Job{
 	"2": {ID: "2", TenantID: 2, ClientID: 2, Status: RUNNING},
 	"3": {ID: "3", TenantID: 3, ClientID: 3, Status: FAILED},
 }
+var storeLock sync.RWMutex
 
 var BlobURL string
+
 //Run http server
 func (r *Rest) Run() {
 	log.Printf("[INFO] run http server on port %d", 8080)
 	r.lock.Lock()
 	r.httpServer = r.buildHTTPServer(8080, r.routes())
+	r.quit = make(chan struct{})
 	r.lock.Unlock()
 	err := r.httpServer.ListenAndServe()
 	log.Printf("[WARN] http server terminated, %s", err)
@@ -92,7 +116,12 @@ func (r *Rest) Shutdown() {
 		}
 		log.Println("[DEBUG] shutdown http server completed")
 	}
+	if r.quit != nil {
+		close(r.quit)
+		r.quit = nil
+	}
 	r.lock.Unlock()
+	r.timelines.Wait()
 }
 
 func (r *Rest) buildHTTPServer(port int, router http.Handler) *http.Server {
@@ -111,13 +140,18 @@ func (r *Rest) buildHTTPServer(port int, router http.Handler) *http.Server {
 
 func (r *Rest) routes() chi.Router {
 	router := chi.NewRouter()
-	router.Use(middleware.Throttle(1000), middleware.RealIP, middleware.Recoverer, middleware.Logger)
+	router.NotFound(notFound)
+	router.MethodNotAllowed(methodNotAllowed)
+	router.Use(middleware.Throttle(1000), middleware.RealIP, middleware.Recoverer, logging.Middleware)
+	if r.Tracer != nil {
+		router.Use(r.Tracer.Middleware)
+	}
 
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Content-Length", "X-XSRF-Token"},
-		ExposedHeaders:   []string{"Authorization"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Content-Length", "X-XSRF-Token", logging.HeaderRequestID, tracing.HeaderTraceParent},
+		ExposedHeaders:   []string{"Authorization", logging.HeaderRequestID},
 		AllowCredentials: true,
 		MaxAge:           300,
 	})
@@ -125,7 +159,7 @@ func (r *Rest) routes() chi.Router {
 	//health check api
 	router.Use(corsMiddleware.Handler)
 	router.Route("/", func(api chi.Router) {
-		api.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(5, nil)))
+		api.Use(tollbooth_chi.LimitHandler(newLimiter(5)))
 		api.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
 			_, err := w.Write([]byte(fmt.Sprintln("pong")))
 			if err != nil {
@@ -137,7 +171,7 @@ func (r *Rest) routes() chi.Router {
 	router.Route("/api/v1/", func(endpoints chi.Router) {
 		endpoints.Group(func(api chi.Router) {
 			api.Use(middleware.Timeout(30 * time.Second))
-			api.Use(tollbooth_chi.LimitHandler(tollbooth.NewLimiter(50, nil)))
+			api.Use(tollbooth_chi.LimitHandler(newLimiter(50)))
 			api.Use(middleware.NoCache)
 			api.Post("/job", r.submitJob)
 			api.Get("/job/{id}/status", r.getJobStatus)
@@ -149,81 +183,84 @@ func (r *Rest) routes() chi.Router {
 
 func (r *Rest) getJobStatus(w http.ResponseWriter, req *http.Request) {
 	jobID := chi.URLParam(req, "id")
-	if jobID != "1" && jobID != "2" && jobID != "3" {
-		SendErrorJSON(w, req, http.StatusNotFound, fmt.Errorf("there is no job with id %s", jobID), ErrorServerInternal, "error getting job status")
+	storeLock.RLock()
+	job, ok := store[jobID]
+	storeLock.RUnlock()
+	if !ok {
+		SendErrorJSON(w, req, http.StatusNotFound, fmt.Errorf("there is no job with id %s", jobID), ErrorJobNotFound, "error getting job status")
 		return
 	}
-	if _, ok := store[jobID]; !ok {
-		SendErrorJSON(w, req, http.StatusNotFound, fmt.Errorf("there is no job with id %s", jobID), ErrorServerInternal, "error getting job status")
-		return
+	jsr := JobStatusResponse{Status: int(job.Status)}
+	if job.Status == SUCCESS {
+		jsr.ResultLocation = mockResultLocation
 	}
-	jsr := JobStatusResponse{Status: int(store[jobID].Status)}
-	w.Header().Set("Content-Type", "application/json")
 	data, err := json.Marshal(jsr)
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during marshal response")
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "error during marshal response")
 		return
 	}
-	_, err = w.Write(data)
-	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during writing response")
-		return
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(data); err != nil {
+		log.Printf("[ERROR] cannot write response #%v", err)
 	}
 }
 
 func (r *Rest) submitJob(w http.ResponseWriter, req *http.Request) {
 	client := http.Client{
-		Timeout: 10 * time.Second,
+		Timeout:   10 * time.Second,
+		Transport: r.Tracer.Transport("blob", nil),
 	}
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "cannot read request body")
-		log.Printf("[ERROR] cannot create POST request")
+		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorJSONUnmarshal, "cannot read request body")
+		return
+	}
+	if location, ok := dispatchedPayload(body); ok {
+		r.acceptJob(req.Context(), body, location)
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(PayloadLocation{PayloadLocation: location}); err != nil {
+			log.Printf("[ERROR] cannot write response #%v", err)
+		}
 		return
 	}
 
-	request, err := http.NewRequest("POST", BlobURL + "/blob", bytes.NewBuffer(body))
-	request.Header.Set("Content-Type", "image/png")
-	request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	request, err := http.NewRequestWithContext(req.Context(), "POST", BlobURL+"/blob", bytes.NewBuffer(body))
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "cannot create POST request")
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "cannot create POST request")
 		log.Printf("[ERROR] cannot create POST request")
 		return
 	}
+	request.Header.Set("Content-Type", "image/png")
+	request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	request.Header.Set(logging.HeaderRequestID, logging.RequestID(req.Context()))
 
 	response, err := client.Do(request)
-	if err != nil || response.StatusCode != http.StatusOK {
+	if err != nil {
 		log.Printf("[ERROR] can not make post request: %#v", err)
-		if errClose := response.Body.Close(); errClose != nil {
-			log.Printf("[ERROR] can not close response body %#v", errClose)
-		}
-		SendErrorJSON(w, req, response.StatusCode, err, ErrorServerInternal, "error during request to blob service")
+		SendErrorJSON(w, req, http.StatusServiceUnavailable, err, ErrorUpstreamUnavailable, "error during request to blob service")
 		return
 	}
-	if response == nil && err != nil {
-		if errClose := response.Body.Close(); errClose != nil {
-			log.Printf("[ERROR] can not close response body %#v", errClose)
-		}
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "cannot read response from blob service")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		SendErrorJSON(w, req, http.StatusBadGateway, fmt.Errorf("blob service responded with status %d", response.StatusCode),
+			ErrorUpstreamFailed, "error during request to blob service")
 		return
 	}
-	defer response.Body.Close()
 	payloadLocation := &PayloadLocation{}
 	if err = json.NewDecoder(response.Body).Decode(payloadLocation); err != nil {
 		log.Printf("[ERROR] can not decode response body %#v", err)
+		SendErrorJSON(w, req, http.StatusBadGateway, err, ErrorUpstreamFailed, "cannot read response from blob service")
 		return
 	}
-
-	w.Header().Set("Content-Type", "application/json")
+	r.acceptJob(req.Context(), body, payloadLocation.PayloadLocation)
 
 	data, err := json.Marshal(payloadLocation)
 	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during marshal response")
+		SendErrorJSON(w, req, http.StatusInternalServerError, err, ErrorServerInternal, "error during marshal response")
 		return
 	}
-	_, err = w.Write(data)
-	if err != nil {
-		SendErrorJSON(w, req, http.StatusBadRequest, err, ErrorServerInternal, "error during writing response")
-		return
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(data); err != nil {
+		log.Printf("[ERROR] cannot write response #%v", err)
 	}
 }
@@ -0,0 +1,213 @@
+//Package tracing is trimmed copy of dispatcher tracing used by worker mock: traceparent propagation to
+//dispatcher pushes and file exporter. Header and span record format is pinned by tracing_test
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//HeaderTraceParent is W3C trace context header, span context of caller is taken from it
+const HeaderTraceParent = "traceparent"
+
+//Kind is kind of span, values are the same as in OTLP
+type Kind int
+
+//kinds of span
+const (
+	KindServer Kind = 2
+	KindClient Kind = 3
+)
+
+//MarshalText writes kind as its name
+func (k Kind) MarshalText() ([]byte, error) {
+	if k == KindClient {
+		return []byte("client"), nil
+	}
+	return []byte("server"), nil
+}
+
+var traceParent = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-.*)?$`)
+
+//SpanContext identifies span in trace, it is passed between services in traceparent header
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+//IsValid checks both ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != "" && sc.SpanID != ""
+}
+
+//TraceParent returns value of traceparent header, empty for invalid context
+func (sc SpanContext) TraceParent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+//ParseTraceParent parses value of traceparent header, unknown versions are parsed by version 00 format
+func ParseTraceParent(s string) (SpanContext, error) {
+	m := traceParent.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || m[1] == "ff" || m[1] == "00" && m[5] != "" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if m[2] == strings.Repeat("0", 32) || m[3] == strings.Repeat("0", 16) {
+		return SpanContext{}, fmt.Errorf("traceparent %q has zero id", s)
+	}
+	flags, _ := hex.DecodeString(m[4])
+	return SpanContext{TraceID: m[2], SpanID: m[3], Sampled: flags[0]&1 == 1}, nil
+}
+
+type ctxKey struct{}
+
+//WithSpanContext returns context with span context, spans started from it are its children
+func WithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, ctxKey{}, sc)
+}
+
+//FromContext returns span context from context, it is not valid if not set
+func FromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(ctxKey{}).(SpanContext)
+	return sc
+}
+
+//Tracer makes spans of Service and writes finished ones as JSON lines to file. Tracer without file or nil one
+//makes no spans, so callers don't check it is enabled
+type Tracer struct {
+	Service string
+	file    *os.File
+	lock    sync.Mutex
+}
+
+//FromEnv makes tracer of service writing spans to TRACE_FILE (default traces.ndjson) if TRACE_EXPORTER is file,
+//tracer makes no spans if exporter is not set. OTLP exporter is supported only by dispatcher
+func FromEnv(service string) (*Tracer, error) {
+	tracer := &Tracer{Service: service}
+	if s := os.Getenv("TRACE_SERVICE"); s != "" {
+		tracer.Service = s
+	}
+	switch os.Getenv("TRACE_EXPORTER") {
+	case "", "none":
+	case "file":
+		path := os.Getenv("TRACE_FILE")
+		if path == "" {
+			path = "traces.ndjson"
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		tracer.file = f
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %s, mock writes spans only to file", os.Getenv("TRACE_EXPORTER"))
+	}
+	return tracer, nil
+}
+
+//StartSpan starts span with parent, span is root of new trace if parent is not valid
+func (t *Tracer) StartSpan(parent SpanContext, name string, kind Kind) *Span {
+	if t == nil || t.file == nil {
+		return nil
+	}
+	data := spanData{Name: name, Kind: kind, Service: t.Service, SpanID: newID(8), StartTime: time.Now(),
+		Attributes: map[string]interface{}{}}
+	sampled := true
+	if parent.IsValid() {
+		data.TraceID, data.ParentSpanID, sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		data.TraceID = newID(16)
+	}
+	return &Span{tracer: t, data: data, sampled: sampled}
+}
+
+func (t *Tracer) export(span spanData) {
+	line, err := json.Marshal(span)
+	if err != nil {
+		log.Printf("[WARN] can not encode span %s, %v", span.Name, err)
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, err = t.file.Write(append(line, '\n')); err != nil {
+		log.Printf("[WARN] can not write span %s, %v", span.Name, err)
+	}
+}
+
+//spanData is finished span written to file, fields are the same as in dispatcher
+type spanData struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         Kind                   `json:"kind"`
+	Service      string                 `json:"service"`
+	StartTime    time.Time              `json:"start"`
+	EndTime      time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+//Span is operation in trace, it is used by single goroutine. All methods of nil span do nothing,
+//it is returned by disabled tracer
+type Span struct {
+	tracer  *Tracer
+	sampled bool
+	data    spanData
+}
+
+//Context returns span context of span to pass it to other service
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: s.sampled}
+}
+
+//SetAttr sets attribute of span, value is string, number or bool
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s != nil {
+		s.data.Attributes[key] = value
+	}
+}
+
+//Fail marks span failed with error, nil error is ignored
+func (s *Span) Fail(err error) {
+	if s != nil && err != nil {
+		s.data.Error = err.Error()
+	}
+}
+
+//End finishes span and writes sampled one
+func (s *Span) End() {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.data.EndTime = time.Now()
+	s.tracer.export(s.data)
+}
+
+//newID makes random hex id of n bytes
+func newID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		b = []byte(fmt.Sprintf("%0*x", n, time.Now().UnixNano()))[:n]
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	tbl := []struct {
+		s   string
+		sc  SpanContext
+		err bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7"}, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-future",
+			SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", SpanContext{}, true},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", SpanContext{}, true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", SpanContext{}, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", SpanContext{}, true},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", SpanContext{}, true},
+		{"", SpanContext{}, true},
+	}
+	for i, tt := range tbl {
+		sc, err := ParseTraceParent(tt.s)
+		if tt.err != (err != nil) {
+			t.Errorf("test case #%d, unexpected error %v", i, err)
+			continue
+		}
+		if sc != tt.sc {
+			t.Errorf("test case #%d, expected %+v, got %+v", i, tt.sc, sc)
+		}
+	}
+	sc := SpanContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true}
+	if sc.TraceParent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("unexpected traceparent %s", sc.TraceParent())
+	}
+	if (SpanContext{}).TraceParent() != "" {
+		t.Error("traceparent of invalid context is not empty")
+	}
+}